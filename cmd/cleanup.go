@@ -3,8 +3,10 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/rundir"
 	"github.com/yxhpy/v2ray-subscription-manager/internal/utils"
 )
 
@@ -12,11 +14,25 @@ func main() {
 	fmt.Printf("🧹 手动清理所有临时文件和进程...\n")
 	fmt.Printf("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━\n")
 
-	// 显示当前工作目录
-	if wd, err := os.Getwd(); err == nil {
-		fmt.Printf("📁 当前目录: %s\n", wd)
+	// --runtime-dir=目录：清理指定的运行目录（与主程序的同名选项一致）
+	for _, arg := range os.Args[1:] {
+		if strings.HasPrefix(arg, "--runtime-dir=") {
+			if err := rundir.Set(strings.TrimPrefix(arg, "--runtime-dir=")); err != nil {
+				fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+				os.Exit(1)
+			}
+		}
 	}
 
+	// 进程登记来自运行目录，目录不安全时不能按其中的记录终止进程
+	if err := rundir.Ensure(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	// 显示运行目录
+	fmt.Printf("📁 运行目录: %s\n", rundir.Dir())
+
 	// 显示开始时间
 	startTime := time.Now()
 	fmt.Printf("⏰ 开始时间: %s\n", startTime.Format("2006-01-02 15:04:05"))
@@ -31,7 +47,7 @@ func main() {
 	fmt.Printf("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━\n")
 	fmt.Printf("✅ 清理完成！耗时: %.2f 秒\n", elapsed.Seconds())
 	fmt.Printf("🧹 所有临时文件和状态文件已删除\n")
-	fmt.Printf("💀 所有相关进程已终止\n")
+	fmt.Printf("💀 已回收遗留的核心进程（仅限本工具登记过的进程）\n")
 	fmt.Printf("🔍 清理结果已验证\n")
 	fmt.Printf("\n")
 	fmt.Printf("💡 提示：\n")
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/downloader"
+)
+
+func handleAssets() {
+	if len(os.Args) < 3 {
+		printAssetsUsage()
+		os.Exit(1)
+	}
+
+	switch os.Args[2] {
+	case "list":
+		handleAssetsList()
+	case "install", "update":
+		handleAssetsInstall()
+	case "categories":
+		handleAssetsCategories()
+	default:
+		fmt.Fprintf(os.Stderr, "未知的assets操作: %s\n", os.Args[2])
+		printAssetsUsage()
+		os.Exit(1)
+	}
+}
+
+func printAssetsUsage() {
+	fmt.Fprintf(os.Stderr, "使用方法: %s assets <操作> [参数]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  list                          - 查看已安装的数据文件\n")
+	fmt.Fprintf(os.Stderr, "  install [数据文件...]         - 下载并校验数据文件 (默认: 全部)，已安装时更新\n")
+	fmt.Fprintf(os.Stderr, "  categories <数据文件> [关键字] - 列出可用于 geoip:<分类> / geosite:<分类> 规则的分类\n")
+	fmt.Fprintf(os.Stderr, "  数据文件: %s\n", strings.Join(downloader.Assets, ", "))
+}
+
+// assetArgs 取出并校验参数中的数据文件名，没有参数时返回全部
+func assetArgs(args []string) []string {
+	if len(args) == 0 {
+		return downloader.Assets
+	}
+	for _, name := range args {
+		known := false
+		for _, asset := range downloader.Assets {
+			known = known || name == asset
+		}
+		if !known {
+			fmt.Fprintf(os.Stderr, "❌ 未知的数据文件: %s (可用: %s)\n", name, strings.Join(downloader.Assets, ", "))
+			os.Exit(1)
+		}
+	}
+	return args
+}
+
+func handleAssetsList() {
+	fmt.Printf("🗺️ 路由规则数据文件: %s\n", downloader.AssetDir())
+	for _, name := range downloader.Assets {
+		source := downloader.AssetSource(name)
+		if source == "" {
+			source = "默认 (按镜像顺序从GitHub发布页下载)"
+		}
+
+		fmt.Printf("\n%s.dat:\n", name)
+		info, ok := downloader.InstalledAsset(name)
+		if !ok {
+			fmt.Printf("  (未安装)\n")
+			fmt.Printf("  来源: %s\n", source)
+			continue
+		}
+		if info.Tag != "" {
+			fmt.Printf("  版本: %s\n", info.Tag)
+		}
+		fmt.Printf("  更新时间: %s (%s前)\n", info.UpdatedAt.Format("2006-01-02 15:04"), time.Since(info.UpdatedAt).Round(time.Minute))
+		fmt.Printf("  大小: %.2f MB\n", float64(info.Size)/1024/1024)
+		fmt.Printf("  SHA256: %s\n", info.SHA256)
+		fmt.Printf("  安装来源: %s\n", info.Source)
+		fmt.Printf("  配置来源: %s\n", source)
+	}
+}
+
+func handleAssetsInstall() {
+	failed := false
+	for _, name := range assetArgs(os.Args[3:]) {
+		if _, err := downloader.InstallAsset(name); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func handleAssetsCategories() {
+	if len(os.Args) < 4 {
+		printAssetsUsage()
+		os.Exit(1)
+	}
+	name := assetArgs(os.Args[3:4])[0]
+	keyword := ""
+	if len(os.Args) > 4 {
+		keyword = strings.ToLower(os.Args[4])
+	}
+
+	categories, err := downloader.AssetCategories(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		fmt.Fprintf(os.Stderr, "💡 运行 '%s assets install %s' 安装\n", os.Args[0], name)
+		os.Exit(1)
+	}
+
+	shown := 0
+	for _, category := range categories {
+		if keyword != "" && !strings.Contains(category.Name, keyword) {
+			continue
+		}
+		fmt.Printf("%s:%-40s %d\n", name, category.Name, category.Entries)
+		shown++
+	}
+	fmt.Printf("\n共 %d 个分类", len(categories))
+	if keyword != "" {
+		fmt.Printf("，匹配 %d 个", shown)
+	}
+	fmt.Println()
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/config"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/downloader"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/proxy"
+)
+
+// configPath, profileName 通过全局 --config=文件 和 --profile=名称 选项指定
+var (
+	configPath  string
+	profileName string
+)
+
+// activeProfile 当前命令使用的配置档案（已叠加环境变量），config 命令不加载
+var activeProfile *config.Profile
+
+// userArgs 用户给出的命令参数（不含全局选项和档案插入的选项），auto-proxy 重新加载配置时使用
+var userArgs []string
+
+// profileOptions 各命令从配置档案读取的键，档案中的值转换为命令行选项插入到用户选项之前，
+// 因此用户在命令行上给出的同名选项总是优先
+var profileOptions = map[string][]string{
+	"speed-test-custom": {"concurrency", "timeout", "test_url", "max_nodes", "history_file"},
+	"auto-proxy": {"http_port", "socks_port", "interval", "concurrency", "timeout", "test_url", "max_nodes", "min_nodes",
+		"auto_switch", "history_file", "blacklist_file", "switch_threshold", "min_dwell",
+		"schedule", "health_schedule", "quiet_hours"},
+	"mvp-tester": {"interval", "max_nodes", "concurrency", "state_file", "preflight", "history_file", "blacklist_file",
+		"schedule", "health_schedule"},
+	"proxy-server": {"http_port", "socks_port", "blacklist_file", "switch_threshold", "min_dwell", "quiet_hours"},
+	"dual-proxy":   {"http_port", "socks_port"},
+}
+
+// subscriptionCommands 第一个参数为订阅链接的命令，省略时使用档案中的默认订阅
+var subscriptionCommands = map[string]bool{
+	"parse":             true,
+	"list-nodes":        true,
+	"speed-test":        true,
+	"speed-test-custom": true,
+	"auto-proxy":        true,
+	"mvp-tester":        true,
+	"dual-proxy":        true,
+}
+
+// extractConfigOptions 从参数中取出 --config=文件 和 --profile=名称
+func extractConfigOptions() {
+	args := os.Args[:2]
+	for _, arg := range os.Args[2:] {
+		if strings.HasPrefix(arg, "--config=") {
+			configPath = strings.TrimPrefix(arg, "--config=")
+			continue
+		}
+		if strings.HasPrefix(arg, "--profile=") {
+			profileName = strings.TrimPrefix(arg, "--profile=")
+			continue
+		}
+		args = append(args, arg)
+	}
+	os.Args = args
+}
+
+// loadActiveProfile 加载配置文件中选定的档案
+func loadActiveProfile() error {
+	_, profile, err := config.Resolve(configPath, profileName)
+	if err != nil {
+		return err
+	}
+	activeProfile = profile
+
+	// 档案为核心指定的版本，键名如 v2ray_version、sing_box_version
+	for _, core := range downloader.Cores {
+		downloader.SetVersionOverride(core, profile.String(strings.ReplaceAll(core, "-", "_")+"_version"))
+	}
+
+	// 下载核心时依次尝试的镜像
+	if err := downloader.SetMirrors(profile.Strings("mirrors")); err != nil {
+		return err
+	}
+
+	// Hysteria2 的本地带宽，未设置时使用节点链接中的带宽
+	up, down := -1, -1
+	if profile.Has("hysteria2_up_mbps") {
+		up = profile.Int("hysteria2_up_mbps")
+	}
+	if profile.Has("hysteria2_down_mbps") {
+		down = profile.Int("hysteria2_down_mbps")
+	}
+	downloader.SetHysteria2Bandwidth(up, down)
+
+	// 没有安装时摘要记录的核心默认拒绝启动
+	downloader.SetAllowUnverified(profile.Bool("allow_unverified_cores"))
+
+	// 路由规则数据文件的来源和更新周期
+	for _, asset := range downloader.Assets {
+		if err := downloader.SetAssetSource(asset, profile.String(asset+"_source")); err != nil {
+			return err
+		}
+	}
+	if profile.Has("assets_refresh") {
+		downloader.SetAssetRefresh(profile.Duration("assets_refresh"))
+	}
+
+	// 代理链：匹配 chain_nodes 的节点经由 front_node 连接
+	chain, err := proxy.NewChain(profile.String("front_node"), profile.Strings("chain_nodes"))
+	if err != nil {
+		return err
+	}
+	proxy.SetChain(chain)
+
+	// 不需要Xray专有特性的节点使用的核心
+	return proxy.SetPreferredCore(profile.String("preferred_core"))
+}
+
+// filterPath 返回过滤规则文件路径：--filter 选项优先，其次是档案中的 filter
+func filterPath() string {
+	if nodeFilterFlag != "" || activeProfile == nil {
+		return nodeFilterFlag
+	}
+	return activeProfile.String("filter")
+}
+
+// applyProfile 将档案中的设置转换为命令行选项，插入到用户选项之前；
+// 命令需要订阅链接而用户未提供时，使用档案中的默认订阅
+func applyProfile(command string, args []string) []string {
+	if activeProfile == nil || activeProfile.Empty() {
+		return args
+	}
+
+	// 第一个选项的位置（位置参数之后）
+	insertAt := len(args)
+	for i := 2; i < len(args); i++ {
+		if strings.HasPrefix(args[i], "--") {
+			insertAt = i
+			break
+		}
+	}
+
+	var injected []string
+	if subscriptionCommands[command] && insertAt == 2 && activeProfile.Subscription() != "" {
+		injected = append(injected, activeProfile.Subscription())
+	}
+	// proxy-server 的配置文件即 mvp-tester 的状态文件
+	if command == "proxy-server" && insertAt == 2 && activeProfile.Has("state_file") {
+		injected = append(injected, activeProfile.String("state_file"))
+	}
+	for _, key := range profileOptions[command] {
+		if option, ok := profileOption(command, key); ok {
+			injected = append(injected, option)
+		}
+	}
+
+	result := make([]string, 0, len(args)+len(injected))
+	result = append(result, args[:insertAt]...)
+	result = append(result, injected...)
+	return append(result, args[insertAt:]...)
+}
+
+// profileOption 将档案中的一个键转换为对应命令的命令行选项
+func profileOption(command, key string) (string, bool) {
+	if !activeProfile.Has(key) {
+		return "", false
+	}
+	flag := "--" + strings.ReplaceAll(key, "_", "-")
+
+	switch key {
+	case "interval":
+		// 命令行的 --interval 以分钟为单位
+		return fmt.Sprintf("%s=%d", flag, int(activeProfile.Duration(key)/time.Minute)), true
+	case "timeout":
+		if command == "speed-test-custom" {
+			// speed-test-custom 的 --timeout 以秒为单位
+			return fmt.Sprintf("%s=%d", flag, int(activeProfile.Duration(key)/time.Second)), true
+		}
+		return flag + "=" + activeProfile.String(key), true
+	case "auto_switch", "preflight":
+		// 只有关闭时才有对应的选项
+		if activeProfile.Bool(key) {
+			return "", false
+		}
+		return "--no-" + strings.ReplaceAll(key, "_", "-"), true
+	case "switch_threshold":
+		return flag + "=" + strconv.FormatFloat(activeProfile.Float(key), 'f', -1, 64), true
+	case "http_port", "socks_port", "concurrency", "max_nodes", "min_nodes":
+		return fmt.Sprintf("%s=%d", flag, activeProfile.Int(key)), true
+	}
+	return flag + "=" + activeProfile.String(key), true
+}
+
+func handleConfig() {
+	if len(os.Args) < 3 {
+		fmt.Fprintf(os.Stderr, "使用方法: %s config <show|validate> [--config=文件] [--profile=名称]\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	switch os.Args[2] {
+	case "show":
+		handleConfigShow()
+	case "validate":
+		handleConfigValidate()
+	default:
+		fmt.Fprintf(os.Stderr, "未知的config操作: %s (可用: show, validate)\n", os.Args[2])
+		os.Exit(1)
+	}
+}
+
+// handleConfigShow 显示生效的档案（已叠加环境变量）及每个值的来源
+func handleConfigShow() {
+	file, profile, err := config.Resolve(configPath, profileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	if file.Path != "" {
+		fmt.Printf("# 配置文件: %s\n", file.Path)
+		fmt.Printf("# 可用档案: %s\n", strings.Join(file.ProfileNames(), ", "))
+	} else {
+		fmt.Printf("# 配置文件: 无 (默认路径 %s 不存在)\n", config.DefaultPath())
+	}
+	fmt.Printf("# 当前档案: %s\n", profile.Name)
+	fmt.Printf("# 优先级: 命令行选项 > 环境变量 (%s<键名>) > 档案 > 命令默认值\n", config.EnvPrefix)
+	if profile.Empty() {
+		fmt.Printf("# 档案中没有设置任何值，所有命令使用默认值\n")
+		return
+	}
+	fmt.Println()
+	profile.WriteTo(os.Stdout)
+}
+
+// activeConfigPath 返回使用的配置文件路径：--config > V2RAY_MANAGER_CONFIG > 默认路径（文件可以不存在）
+func activeConfigPath() string {
+	path := configPath
+	if path == "" {
+		path = os.Getenv(config.EnvPrefix + "CONFIG")
+	}
+	if path == "" {
+		path = config.DefaultPath()
+	}
+	return path
+}
+
+// handleConfigValidate 校验整个配置文件（所有档案）以及当前的环境变量
+func handleConfigValidate() {
+	path := activeConfigPath()
+
+	file, err := config.Load(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "❌ 配置文件不存在: %s\n", path)
+		} else {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		}
+		os.Exit(1)
+	}
+
+	if _, _, err := config.Resolve(path, profileName); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("✅ 配置文件有效: %s\n", file.Path)
+	fmt.Printf("📋 档案 (%d): %s\n", len(file.Profiles), strings.Join(file.ProfileNames(), ", "))
+	if file.DefaultProfile != "" {
+		fmt.Printf("⭐ 默认档案: %s\n", file.DefaultProfile)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/downloader"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/proxy"
+)
+
+func handleCore() {
+	if len(os.Args) < 3 {
+		printCoreUsage()
+		os.Exit(1)
+	}
+
+	switch os.Args[2] {
+	case "list":
+		handleCoreList()
+	case "install":
+		handleCoreInstall()
+	case "use":
+		handleCoreUse()
+	case "upgrade":
+		handleCoreUpgrade()
+	case "rollback":
+		handleCoreRollback()
+	case "prune":
+		handleCorePrune()
+	default:
+		fmt.Fprintf(os.Stderr, "未知的core操作: %s\n", os.Args[2])
+		printCoreUsage()
+		os.Exit(1)
+	}
+}
+
+func printCoreUsage() {
+	fmt.Fprintf(os.Stderr, "使用方法: %s core <操作> [参数]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  list [核心]                 - 列出已安装的版本\n")
+	fmt.Fprintf(os.Stderr, "  install <核心> [版本]       - 安装指定版本 (默认: 最新版本)\n")
+	fmt.Fprintf(os.Stderr, "          [--archive=文件]    从本地发布文件离线安装，版本默认从文件名识别\n")
+	fmt.Fprintf(os.Stderr, "  use <核心> <版本>           - 切换当前版本\n")
+	fmt.Fprintf(os.Stderr, "  upgrade <核心>              - 安装最新版本并切换，冒烟测试失败时自动回滚\n")
+	fmt.Fprintf(os.Stderr, "  rollback <核心>             - 切换回之前的版本\n")
+	fmt.Fprintf(os.Stderr, "  prune <核心> [--keep=数量]  - 删除当前版本和之前版本以外的旧版本，保留最新的若干个 (默认: 0)\n")
+	fmt.Fprintf(os.Stderr, "  核心: %s\n", strings.Join(downloader.Cores, ", "))
+}
+
+// coreArg 取出并校验第 index 个参数中的核心名
+func coreArg(index int) string {
+	if len(os.Args) <= index {
+		printCoreUsage()
+		os.Exit(1)
+	}
+	core := os.Args[index]
+	for _, known := range downloader.Cores {
+		if core == known {
+			return core
+		}
+	}
+	fmt.Fprintf(os.Stderr, "❌ 未知的核心: %s (可用: %s)\n", core, strings.Join(downloader.Cores, ", "))
+	os.Exit(1)
+	return ""
+}
+
+func handleCoreList() {
+	cores := downloader.Cores
+	if len(os.Args) > 3 {
+		cores = []string{coreArg(3)}
+	}
+
+	fmt.Printf("📦 核心版本仓库: %s\n", downloader.StoreRoot())
+	for _, core := range cores {
+		current := downloader.CurrentVersion(core)
+		previous := downloader.PreviousVersion(core)
+		pending := downloader.PendingVersion(core)
+		override := downloader.VersionOverride(core)
+
+		fmt.Printf("\n%s:\n", core)
+		versions := downloader.InstalledVersions(core)
+		if len(versions) == 0 {
+			fmt.Printf("  (仓库中没有已安装的版本)\n")
+		}
+		for _, version := range versions {
+			var marks []string
+			if version == current {
+				marks = append(marks, "当前")
+			}
+			if version == previous {
+				marks = append(marks, "之前")
+			}
+			if version == pending {
+				marks = append(marks, "待冒烟测试")
+			}
+			if version == override {
+				marks = append(marks, "档案指定")
+			}
+			prefix := "  "
+			if version == downloader.ActiveVersion(core) {
+				prefix = "* "
+			}
+			if len(marks) > 0 {
+				fmt.Printf("%s%s (%s)\n", prefix, version, strings.Join(marks, ", "))
+			} else {
+				fmt.Printf("%s%s\n", prefix, version)
+			}
+		}
+		if override != "" && !downloader.IsInstalled(core, override) {
+			fmt.Printf("  ⚠️ 档案指定的 %s 未安装，首次使用时自动下载\n", override)
+		}
+		if legacy, ok := downloader.LegacyInstalled(core); ok {
+			fmt.Printf("  旧版安装: %s\n", legacy)
+		}
+		fmt.Printf("  启动使用: %s\n", downloader.ExecutablePath(core))
+	}
+}
+
+func handleCoreInstall() {
+	core := coreArg(3)
+	archive, version := downloadOptions(os.Args[4:])
+	for _, arg := range os.Args[4:] {
+		if !strings.HasPrefix(arg, "--") {
+			version = arg
+		}
+	}
+	if version == "" {
+		version = "latest"
+	}
+	if version != "latest" {
+		if !downloader.ValidVersion(version) {
+			fmt.Fprintf(os.Stderr, "❌ 无效的版本号: %s\n", version)
+			os.Exit(1)
+		}
+		version = downloader.NormalizeVersion(version)
+	}
+
+	var (
+		installed string
+		err       error
+	)
+	if archive != "" {
+		installed, err = downloader.InstallArchive(core, archive, version)
+	} else if version != "latest" && downloader.IsInstalled(core, version) {
+		fmt.Printf("✅ %s %s 已安装\n", core, version)
+		return
+	} else {
+		installed, err = downloader.InstallVersion(core, version)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 安装失败: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("✅ 已安装 %s %s\n", core, installed)
+	if current := downloader.CurrentVersion(core); current != installed {
+		fmt.Printf("💡 当前版本仍为 %s，运行 '%s core use %s %s' 切换\n", current, os.Args[0], core, installed)
+	}
+}
+
+func handleCoreUse() {
+	core := coreArg(3)
+	if len(os.Args) < 5 {
+		printCoreUsage()
+		os.Exit(1)
+	}
+	version := downloader.NormalizeVersion(os.Args[4])
+
+	if err := downloader.UseVersion(core, version); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		fmt.Fprintf(os.Stderr, "💡 运行 '%s core install %s %s' 安装\n", os.Args[0], core, version)
+		os.Exit(1)
+	}
+	fmt.Printf("✅ %s 当前版本: %s\n", core, version)
+	if override := downloader.VersionOverride(core); override != "" && override != version {
+		fmt.Printf("⚠️ 当前档案指定了 %s，使用该档案时仍使用 %s\n", override, override)
+	}
+}
+
+func handleCoreUpgrade() {
+	core := coreArg(3)
+
+	latest, err := downloader.LatestVersion(core)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+	current := downloader.CurrentVersion(core)
+	if current != "" && downloader.CompareVersions(latest, current) <= 0 {
+		fmt.Printf("✅ %s 已是最新版本: %s\n", core, current)
+		return
+	}
+
+	if !downloader.IsInstalled(core, latest) {
+		if _, err := downloader.InstallVersion(core, latest); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ 安装 %s %s 失败: %v\n", core, latest, err)
+			os.Exit(1)
+		}
+	}
+	if err := downloader.UpgradeTo(core, latest); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 切换版本失败: %v\n", err)
+		os.Exit(1)
+	}
+	if current != "" {
+		fmt.Printf("⬆️ %s: %s -> %s\n", core, current, latest)
+	}
+
+	// 立即运行冒烟测试，失败时自动回滚
+	if err := proxy.EnsureCoreHealthy(context.Background(), core); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+	if downloader.CurrentVersion(core) != latest {
+		fmt.Fprintf(os.Stderr, "❌ %s %s 未通过冒烟测试，已回滚到 %s\n", core, latest, downloader.CurrentVersion(core))
+		os.Exit(1)
+	}
+	fmt.Printf("✅ %s 已升级到 %s\n", core, latest)
+}
+
+func handleCoreRollback() {
+	core := coreArg(3)
+	version, err := downloader.Rollback(core)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("↩️ %s 已回滚到 %s\n", core, version)
+}
+
+func handleCorePrune() {
+	core := coreArg(3)
+	keep := 0
+	for _, arg := range os.Args[4:] {
+		if strings.HasPrefix(arg, "--keep=") {
+			n, err := strconv.Atoi(strings.TrimPrefix(arg, "--keep="))
+			if err != nil || n < 0 {
+				fmt.Fprintf(os.Stderr, "❌ 无效的保留数量: %s\n", arg)
+				os.Exit(1)
+			}
+			keep = n
+		}
+	}
+
+	protected := map[string]bool{
+		downloader.CurrentVersion(core):  true,
+		downloader.PreviousVersion(core): true,
+		downloader.VersionOverride(core): true,
+	}
+
+	removed := 0
+	for _, version := range downloader.InstalledVersions(core) {
+		if protected[version] {
+			continue
+		}
+		if keep > 0 {
+			keep--
+			continue
+		}
+		if err := downloader.RemoveVersion(core, version); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️ 删除 %s %s 失败: %v\n", core, version, err)
+			continue
+		}
+		fmt.Printf("🗑️ 已删除 %s %s\n", core, version)
+		removed++
+	}
+	fmt.Printf("✅ 清理完成，删除了 %d 个版本\n", removed)
+}
+
+// downloadOptions 解析下载核心的选项: --archive=本地发布文件、--version=版本，
+// --mirror=镜像[,镜像] 覆盖档案中的镜像顺序
+func downloadOptions(args []string) (archive, version string) {
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--archive="):
+			archive = strings.TrimPrefix(arg, "--archive=")
+		case strings.HasPrefix(arg, "--version="):
+			version = strings.TrimPrefix(arg, "--version=")
+		case strings.HasPrefix(arg, "--mirror="):
+			var list []string
+			for _, mirror := range strings.Split(strings.TrimPrefix(arg, "--mirror="), ",") {
+				if mirror = strings.TrimSpace(mirror); mirror != "" {
+					list = append(list, mirror)
+				}
+			}
+			if err := downloader.SetMirrors(list); err != nil {
+				fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+	return archive, version
+}
+
+// downloadCore 处理 download-* 命令：没有选项时未安装才下载，
+// 指定了本地发布文件或版本时安装该版本
+func downloadCore(core string, autoDownload func() error) {
+	archive, version := downloadOptions(os.Args[2:])
+	if version != "" && version != "latest" && !downloader.ValidVersion(version) {
+		fmt.Fprintf(os.Stderr, "❌ 无效的版本号: %s\n", version)
+		os.Exit(1)
+	}
+
+	var err error
+	switch {
+	case archive != "":
+		var installed string
+		if installed, err = downloader.InstallArchive(core, archive, version); err == nil {
+			fmt.Printf("✅ 已从本地文件安装 %s %s\n", core, installed)
+		}
+	case version != "":
+		var installed string
+		if installed, err = downloader.InstallVersion(core, downloader.NormalizeVersion(version)); err == nil {
+			fmt.Printf("✅ 已安装 %s %s\n", core, installed)
+		}
+	default:
+		err = autoDownload()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 下载安装失败: %v\n", err)
+		os.Exit(1)
+	}
+}
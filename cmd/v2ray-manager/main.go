@@ -1,17 +1,27 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/blacklist"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/config"
 	"github.com/yxhpy/v2ray-subscription-manager/internal/core/downloader"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/filter"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/history"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/lifecycle"
 	"github.com/yxhpy/v2ray-subscription-manager/internal/core/parser"
 	"github.com/yxhpy/v2ray-subscription-manager/internal/core/proxy"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/report"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/rundir"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/schedule"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/statedir"
 	"github.com/yxhpy/v2ray-subscription-manager/internal/core/workflow"
 	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
 )
@@ -20,6 +30,12 @@ var proxyManager *proxy.ProxyManager
 var hysteria2Manager *proxy.Hysteria2ProxyManager
 var autoProxyManager *workflow.AutoProxyManager
 
+// nodeFilter 通过全局 --filter=文件 选项加载的节点过滤与重命名规则
+var nodeFilter *filter.Filter
+
+// nodeFilterFlag 通过 --filter 选项指定的过滤规则文件路径，auto-proxy 重新加载配置时使用
+var nodeFilterFlag string
+
 func init() {
 	proxyManager = proxy.NewProxyManager()
 	hysteria2Manager = proxy.NewHysteria2ProxyManager()
@@ -33,6 +49,39 @@ func main() {
 
 	command := os.Args[1]
 
+	// 全局选项: --config=文件 --profile=名称，从配置文件的命名档案读取选项
+	extractConfigOptions()
+	if command != "config" {
+		if err := loadActiveProfile(); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// 全局选项: --runtime-dir=目录，临时配置、状态文件和进程登记的存放位置
+	if err := extractRuntimeDirOption(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+	defer rundir.CleanupSession()
+
+	// 全局选项: --core-lock=文件，下载核心时使用的固定摘要文件
+	extractCoreLockOption()
+
+	// 全局选项: --filter=文件，对所有读取订阅的命令生效
+	if err := extractFilterOption(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	// 档案中的设置转换为命令选项，命令行上给出的选项优先
+	userArgs = os.Args
+	os.Args = applyProfile(command, userArgs)
+
+	// 回收上次崩溃遗留的核心进程和会话目录（只处理本程序登记过的进程）
+	lifecycle.DefaultRegistry().ReapOrphans(context.Background())
+	rundir.ReapSessions()
+
 	switch command {
 	case "parse":
 		handleParse()
@@ -62,6 +111,18 @@ func main() {
 		handleDownloadHysteria2()
 	case "check-hysteria2":
 		handleCheckHysteria2()
+	case "download-xray":
+		handleDownloadXray()
+	case "check-xray":
+		handleCheckXray()
+	case "download-sing-box":
+		handleDownloadSingBox()
+	case "check-sing-box":
+		handleCheckSingBox()
+	case "core":
+		handleCore()
+	case "assets":
+		handleAssets()
 	case "speed-test":
 		handleSpeedTest()
 	case "speed-test-custom":
@@ -74,6 +135,14 @@ func main() {
 		handleProxyServer()
 	case "dual-proxy":
 		handleDualProxy()
+	case "history":
+		handleHistory()
+	case "ctl":
+		handleCtl()
+	case "blacklist":
+		handleBlacklist()
+	case "config":
+		handleConfig()
 	default:
 		fmt.Fprintf(os.Stderr, "未知命令: %s\n", command)
 		fmt.Fprintf(os.Stderr, "运行 '%s' 不带参数查看可用命令\n", os.Args[0])
@@ -81,16 +150,110 @@ func main() {
 	}
 }
 
+// extractFilterOption 从参数中取出 --filter=文件 并加载规则，未指定时使用档案中的 filter
+func extractFilterOption() error {
+	args := os.Args[:2]
+	for _, arg := range os.Args[2:] {
+		if strings.HasPrefix(arg, "--filter=") {
+			nodeFilterFlag = strings.TrimPrefix(arg, "--filter=")
+			continue
+		}
+		args = append(args, arg)
+	}
+	os.Args = args
+
+	path := filterPath()
+	if path == "" {
+		return nil
+	}
+	f, err := filter.LoadFile(path)
+	if err != nil {
+		return err
+	}
+	nodeFilter = f
+	return nil
+}
+
+// extractRuntimeDirOption 从参数中取出 --runtime-dir=目录 并设置运行目录，未指定时使用档案中的 runtime_dir
+func extractRuntimeDirOption() error {
+	dir := ""
+	if activeProfile != nil {
+		dir = activeProfile.String("runtime_dir")
+	}
+
+	args := os.Args[:2]
+	for _, arg := range os.Args[2:] {
+		if strings.HasPrefix(arg, "--runtime-dir=") {
+			dir = strings.TrimPrefix(arg, "--runtime-dir=")
+			continue
+		}
+		args = append(args, arg)
+	}
+	os.Args = args
+
+	if err := rundir.Set(dir); err != nil {
+		return err
+	}
+	return rundir.Ensure()
+}
+
+// extractCoreLockOption 从参数中取出 --core-lock=文件 并设置固定摘要文件，
+// 未指定时使用配置文件所在目录下的 cores.lock，不随工作目录变化
+func extractCoreLockOption() {
+	lockfile := filepath.Join(filepath.Dir(activeConfigPath()), downloader.LockfileName)
+	args := os.Args[:2]
+	for _, arg := range os.Args[2:] {
+		if strings.HasPrefix(arg, "--core-lock=") {
+			lockfile = strings.TrimPrefix(arg, "--core-lock=")
+			continue
+		}
+		args = append(args, arg)
+	}
+	os.Args = args
+	downloader.SetLockfile(lockfile)
+
+	// 旧版本把固定摘要文件写在工作目录下
+	if legacy, err := filepath.Abs(downloader.LockfileName); err == nil && legacy != downloader.Lockfile() {
+		if _, err := os.Stat(legacy); err == nil {
+			if _, err := os.Stat(downloader.Lockfile()); os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "⚠️ 工作目录下的 %s 已不再读取，固定摘要文件现在位于 %s，请移动该文件或使用 --core-lock=%s\n",
+					downloader.LockfileName, downloader.Lockfile(), legacy)
+			}
+		}
+	}
+}
+
 func printUsage() {
 	fmt.Fprintf(os.Stderr, "使用方法: %s <命令> [参数]\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "\n订阅解析命令:\n")
 	fmt.Fprintf(os.Stderr, "  parse <订阅链接>                    - 解析订阅链接\n")
 	fmt.Fprintf(os.Stderr, "\nV2Ray核心管理:\n")
-	fmt.Fprintf(os.Stderr, "  download-v2ray                      - 下载V2Ray核心\n")
-	fmt.Fprintf(os.Stderr, "  check-v2ray                         - 检查V2Ray安装状态\n")
+	fmt.Fprintf(os.Stderr, "  download-v2ray                      - 下载V2Ray核心 (校验SHA256并写入固定摘要文件)\n")
+	fmt.Fprintf(os.Stderr, "  check-v2ray                         - 检查V2Ray安装状态及可执行文件摘要\n")
 	fmt.Fprintf(os.Stderr, "\nHysteria2管理:\n")
-	fmt.Fprintf(os.Stderr, "  download-hysteria2                  - 下载Hysteria2客户端\n")
-	fmt.Fprintf(os.Stderr, "  check-hysteria2                     - 检查Hysteria2安装状态\n")
+	fmt.Fprintf(os.Stderr, "  download-hysteria2                  - 下载Hysteria2客户端 (校验SHA256并写入固定摘要文件)\n")
+	fmt.Fprintf(os.Stderr, "  check-hysteria2                     - 检查Hysteria2安装状态及可执行文件摘要\n")
+	fmt.Fprintf(os.Stderr, "\nXray管理 (REALITY、XTLS Vision、xhttp 节点自动使用Xray，首次使用时自动下载):\n")
+	fmt.Fprintf(os.Stderr, "  download-xray                       - 下载Xray核心 (校验SHA256并写入固定摘要文件)\n")
+	fmt.Fprintf(os.Stderr, "  check-xray                          - 检查Xray安装状态及可执行文件摘要\n")
+	fmt.Fprintf(os.Stderr, "\nsing-box管理 (支持所有协议，TUIC 节点和档案 preferred_core = \"sing-box\" 时使用):\n")
+	fmt.Fprintf(os.Stderr, "  download-sing-box                   - 下载sing-box核心 (校验SHA256并写入固定摘要文件)\n")
+	fmt.Fprintf(os.Stderr, "  check-sing-box                      - 检查sing-box安装状态及可执行文件摘要\n")
+	fmt.Fprintf(os.Stderr, "\n核心版本管理 (版本仓库: %s/<核心>/<版本>/):\n", downloader.DefaultStoreRoot)
+	fmt.Fprintf(os.Stderr, "  core list [核心]                    - 列出已安装的版本\n")
+	fmt.Fprintf(os.Stderr, "  core install <核心> [版本] [--archive=文件] - 安装指定版本 (默认: 最新版本)，--archive 从本地发布文件离线安装\n")
+	fmt.Fprintf(os.Stderr, "  core use <核心> <版本>              - 切换当前版本\n")
+	fmt.Fprintf(os.Stderr, "  core upgrade <核心>                 - 升级到最新版本，冒烟测试失败时自动回滚\n")
+	fmt.Fprintf(os.Stderr, "  core rollback <核心>                - 切换回之前的版本\n")
+	fmt.Fprintf(os.Stderr, "  core prune <核心> [--keep=数量]     - 删除不再使用的旧版本\n")
+	fmt.Fprintf(os.Stderr, "    核心: %s；档案中的 v2ray_version / hysteria2_version 可为每个档案指定版本\n", strings.Join(downloader.Cores, ", "))
+	fmt.Fprintf(os.Stderr, "    download-* 命令选项: --archive=文件 从本地发布文件离线安装，--version=版本 指定版本，\n")
+	fmt.Fprintf(os.Stderr, "    --mirror=镜像[,镜像] 依次尝试的镜像 (github、ghproxy 或内部镜像地址，默认取档案中的 mirrors)\n")
+	fmt.Fprintf(os.Stderr, "\n路由规则数据文件 (geoip.dat / geosite.dat，位于 %s/):\n", downloader.AssetDir())
+	fmt.Fprintf(os.Stderr, "  assets list                         - 查看已安装的数据文件\n")
+	fmt.Fprintf(os.Stderr, "  assets install [geoip|geosite]      - 下载并校验数据文件 (默认: 全部)，已安装时更新\n")
+	fmt.Fprintf(os.Stderr, "  assets categories <geoip|geosite> [关键字] - 列出数据文件中可用于路由规则的分类\n")
+	fmt.Fprintf(os.Stderr, "    档案中的 geoip_source / geosite_source 指定来源 (地址或本地文件)，assets_refresh 指定更新周期\n")
 	fmt.Fprintf(os.Stderr, "\n代理管理命令:\n")
 	fmt.Fprintf(os.Stderr, "  start-proxy random <订阅链接>        - 随机启动代理\n")
 	fmt.Fprintf(os.Stderr, "  start-proxy index <订阅链接> <索引>  - 指定节点启动代理\n")
@@ -105,7 +268,7 @@ func printUsage() {
 	fmt.Fprintf(os.Stderr, "\n测速工作流命令:\n")
 	fmt.Fprintf(os.Stderr, "  speed-test <订阅链接>                - 测速工作流(默认配置)\n")
 	fmt.Fprintf(os.Stderr, "  speed-test-custom <订阅链接> [选项]   - 自定义测速工作流\n")
-	fmt.Fprintf(os.Stderr, "    选项格式: --concurrency=数量 --timeout=秒数 --output=文件名 --test-url=URL\n")
+	fmt.Fprintf(os.Stderr, "    选项格式: --concurrency=数量 --timeout=秒数 --output=文件名 --test-url=URL --format=csv,junit,markdown,html --history-file=路径\n")
 	fmt.Fprintf(os.Stderr, "\n自动代理管理命令:\n")
 	fmt.Fprintf(os.Stderr, "  auto-proxy <订阅链接> [选项]         - 启动自动代理管理器\n")
 	fmt.Fprintf(os.Stderr, "    选项格式:\n")
@@ -117,31 +280,101 @@ func printUsage() {
 	fmt.Fprintf(os.Stderr, "      --test-url=URL                  测试URL (默认: http://www.google.com)\n")
 	fmt.Fprintf(os.Stderr, "      --max-nodes=数量                 最大测试节点数 (默认: 100)\n")
 	fmt.Fprintf(os.Stderr, "      --min-nodes=数量                 最少通过节点数 (默认: 5)\n")
-	fmt.Fprintf(os.Stderr, "      --state-file=路径                状态文件路径 (默认: ./auto_proxy_state.json)\n")
-	fmt.Fprintf(os.Stderr, "      --valid-file=路径                有效节点文件路径 (默认: ./valid_nodes.json)\n")
+	fmt.Fprintf(os.Stderr, "      --state-file=路径                状态文件路径 (默认: 状态目录/auto_proxy_state.json)\n")
+	fmt.Fprintf(os.Stderr, "      --valid-file=路径                有效节点文件路径 (默认: 状态目录/valid_nodes.json)\n")
 	fmt.Fprintf(os.Stderr, "      --no-auto-switch                禁用自动切换\n")
+	fmt.Fprintf(os.Stderr, "      --history-file=路径              节点测试历史文件 (默认: 状态目录/node_history.jsonl)\n")
+	fmt.Fprintf(os.Stderr, "      --control-socket=路径            控制API socket (默认: 运行目录/auto_proxy_ctl.sock)\n")
+	fmt.Fprintf(os.Stderr, "      --control-addr=地址              控制API额外监听的TCP地址 (如: 127.0.0.1:7899)\n")
+	fmt.Fprintf(os.Stderr, "      --control-token=令牌             控制API TCP地址的访问令牌，监听非本机地址时必须设置 (也可用环境变量 V2RAY_MANAGER_CONTROL_TOKEN)\n")
+	fmt.Fprintf(os.Stderr, "      --blacklist-file=路径            节点黑名单文件 (默认: 状态目录/node_blacklist.json)\n")
+	fmt.Fprintf(os.Stderr, "      --switch-threshold=百分比        切换所需的最小分数提升 (默认: 20)\n")
+	fmt.Fprintf(os.Stderr, "      --min-dwell=时长                 切换后的最短停留时长 (默认: 10m)\n")
+	fmt.Fprintf(os.Stderr, "      --schedule=表达式                完整测试调度，覆盖 --interval (如: \"0 3 * * *\")\n")
+	fmt.Fprintf(os.Stderr, "      --health-schedule=表达式         当前节点健康检查调度 (如: \"*/2 9-18 * * 1-5\")\n")
+	fmt.Fprintf(os.Stderr, "      --quiet-hours=时段               静默时段内不自动切换 (如: 23:00-07:00,12:00-13:00)\n")
+	fmt.Fprintf(os.Stderr, "  ctl <操作> [参数] [选项]             - 控制运行中的auto-proxy\n")
+	fmt.Fprintf(os.Stderr, "    操作: status | nodes | blacklist | switches | schedule | retest | pin <节点> | unpin | ban <目标> [--duration=时长] | unban <目标> | allow <目标> | disallow <目标> | pause | resume | reload\n")
+	fmt.Fprintf(os.Stderr, "    <节点> 可以是节点名称或指纹(前缀)，<目标> 还可以是服务器地址或网段\n")
+	fmt.Fprintf(os.Stderr, "    选项格式: --socket=路径 --addr=地址 --token=令牌 --kind=fingerprint|server|cidr --reason=原因\n")
 	fmt.Fprintf(os.Stderr, "\nMVP模式命令 (轻量级双进程方案):\n")
 	fmt.Fprintf(os.Stderr, "  mvp-tester <订阅链接> [选项]         - 启动MVP节点测试器\n")
 	fmt.Fprintf(os.Stderr, "    选项格式:\n")
 	fmt.Fprintf(os.Stderr, "      --interval=分钟                  测试间隔分钟数 (默认: 5)\n")
 	fmt.Fprintf(os.Stderr, "      --max-nodes=数量                 最大测试节点数 (默认: 50)\n")
 	fmt.Fprintf(os.Stderr, "      --concurrency=数量               测试并发数 (默认: 5)\n")
-	fmt.Fprintf(os.Stderr, "      --state-file=路径                状态文件路径 (默认: mvp_best_node.json)\n")
-	fmt.Fprintf(os.Stderr, "  proxy-server <配置文件> [选项]       - 启动代理服务器\n")
+	fmt.Fprintf(os.Stderr, "      --state-file=路径                状态文件路径 (默认: 状态目录/mvp_best_node.json)\n")
+	fmt.Fprintf(os.Stderr, "      --no-preflight                  跳过直连预检，所有节点都启动核心测试\n")
+	fmt.Fprintf(os.Stderr, "      --history-file=路径              节点测试历史文件 (默认: 状态目录/node_history.jsonl)\n")
+	fmt.Fprintf(os.Stderr, "      --socket=路径                    控制通道socket (默认: 运行目录下由状态文件推导的 .sock)\n")
+	fmt.Fprintf(os.Stderr, "      --blacklist-file=路径            节点黑名单文件 (默认: 状态目录/node_blacklist.json)\n")
+	fmt.Fprintf(os.Stderr, "      --schedule=表达式                完整测试调度，覆盖 --interval\n")
+	fmt.Fprintf(os.Stderr, "      --health-schedule=表达式         当前节点健康检查调度\n")
+	fmt.Fprintf(os.Stderr, "  proxy-server [配置文件] [选项]       - 启动代理服务器 (默认: 状态目录/mvp_best_node.json)\n")
 	fmt.Fprintf(os.Stderr, "    选项格式:\n")
 	fmt.Fprintf(os.Stderr, "      --http-port=端口                 HTTP代理端口 (默认: 8080)\n")
 	fmt.Fprintf(os.Stderr, "      --socks-port=端口                SOCKS代理端口 (默认: 1080)\n")
+	fmt.Fprintf(os.Stderr, "      --socket=路径                    控制通道socket (默认: 运行目录下由配置文件推导的 .sock)\n")
+	fmt.Fprintf(os.Stderr, "      --blacklist-file=路径            节点黑名单文件 (默认: 状态目录/node_blacklist.json)\n")
+	fmt.Fprintf(os.Stderr, "      --switch-threshold=百分比        切换所需的最小分数提升 (默认: 20)\n")
+	fmt.Fprintf(os.Stderr, "      --min-dwell=时长                 切换后的最短停留时长 (默认: 10m)\n")
+	fmt.Fprintf(os.Stderr, "      --quiet-hours=时段               静默时段内不自动切换\n")
 	fmt.Fprintf(os.Stderr, "  dual-proxy <订阅链接> [选项]         - 启动双进程代理系统\n")
 	fmt.Fprintf(os.Stderr, "    选项格式:\n")
 	fmt.Fprintf(os.Stderr, "      --http-port=端口                 HTTP代理端口 (默认: 8080)\n")
 	fmt.Fprintf(os.Stderr, "      --socks-port=端口                SOCKS代理端口 (默认: 1080)\n")
+	fmt.Fprintf(os.Stderr, "\n配置文件命令:\n")
+	fmt.Fprintf(os.Stderr, "  config show                         - 显示当前档案生效的设置及来源\n")
+	fmt.Fprintf(os.Stderr, "  config validate                     - 校验配置文件中的所有档案\n")
+	fmt.Fprintf(os.Stderr, "    优先级: 命令行选项 > 环境变量 (%s<键名>，如 %sHTTP_PORT) > 档案 > 命令默认值\n", config.EnvPrefix, config.EnvPrefix)
+	fmt.Fprintf(os.Stderr, "    档案设置了 subscriptions 时，命令中的 <订阅链接> 可以省略\n")
+	fmt.Fprintf(os.Stderr, "    配置文件示例:\n")
+	for _, line := range strings.Split(config.Syntax, "\n") {
+		fmt.Fprintf(os.Stderr, "      %s\n", line)
+	}
+	fmt.Fprintf(os.Stderr, "\n全局选项:\n")
+	fmt.Fprintf(os.Stderr, "  --config=文件                       配置文件 (默认: %s，也可用 %sCONFIG 指定)\n", config.DefaultPath(), config.EnvPrefix)
+	fmt.Fprintf(os.Stderr, "  --profile=名称                      使用的档案 (默认: 配置文件的 default_profile，也可用 %sPROFILE 指定)\n", config.EnvPrefix)
+	fmt.Fprintf(os.Stderr, "  --runtime-dir=目录                  运行目录，存放临时配置、进程登记和控制socket (默认: %s)\n", rundir.Default())
+	fmt.Fprintf(os.Stderr, "  状态目录: %s（重启后保留的状态快照、有效节点列表、测试历史和黑名单，可用 XDG_STATE_HOME 修改）\n", statedir.Dir())
+	fmt.Fprintf(os.Stderr, "  --core-lock=文件                    核心下载的固定摘要文件 (默认: 配置文件所在目录下的 %s)\n", downloader.LockfileName)
+	fmt.Fprintf(os.Stderr, "  --filter=文件                       读取订阅后按规则过滤、重命名节点 (适用于所有读取订阅的命令)\n")
+	fmt.Fprintf(os.Stderr, "    规则示例:\n")
+	for _, line := range strings.Split(filter.Syntax, "\n") {
+		fmt.Fprintf(os.Stderr, "      %s\n", line)
+	}
+	fmt.Fprintf(os.Stderr, "  调度表达式示例:\n")
+	for _, line := range strings.Split(schedule.Syntax, "\n") {
+		fmt.Fprintf(os.Stderr, "      %s\n", line)
+	}
+	fmt.Fprintf(os.Stderr, "\n测试历史命令:\n")
+	fmt.Fprintf(os.Stderr, "  history [选项]                      - 查看节点历史排名\n")
+	fmt.Fprintf(os.Stderr, "    选项格式:\n")
+	fmt.Fprintf(os.Stderr, "      --file=路径                      历史文件 (默认: 状态目录/node_history.jsonl)\n")
+	fmt.Fprintf(os.Stderr, "      --since=时长                     统计最近时长内的记录 (默认: 168h)\n")
+	fmt.Fprintf(os.Stderr, "      --top=数量                       显示前N个节点 (默认: 20)\n")
+	fmt.Fprintf(os.Stderr, "      --protocol=协议                  只显示指定协议\n")
+	fmt.Fprintf(os.Stderr, "      --node=关键字                    显示名称或指纹匹配的节点的测试记录\n")
+	fmt.Fprintf(os.Stderr, "      --prune                         清理超过保留时长的记录\n")
+	fmt.Fprintf(os.Stderr, "      --retention=时长                 保留时长 (默认: 720h)\n")
+	fmt.Fprintf(os.Stderr, "\n节点黑名单命令:\n")
+	fmt.Fprintf(os.Stderr, "  blacklist <操作> [参数] [选项]       - 管理节点黑名单和白名单\n")
+	fmt.Fprintf(os.Stderr, "    操作: list | add <目标> | remove <目标> | allow <目标> | disallow <目标> | prune\n")
+	fmt.Fprintf(os.Stderr, "    <目标> 可以是节点指纹、服务器地址或网段 (如 1.2.3.0/24)\n")
+	fmt.Fprintf(os.Stderr, "    选项格式:\n")
+	fmt.Fprintf(os.Stderr, "      --file=路径                      黑名单文件 (默认: 状态目录/node_blacklist.json)\n")
+	fmt.Fprintf(os.Stderr, "      --kind=fingerprint|server|cidr   匹配方式 (默认: 自动识别)\n")
+	fmt.Fprintf(os.Stderr, "      --duration=时长                  封禁时长 (默认: 永久)\n")
+	fmt.Fprintf(os.Stderr, "      --reason=原因                    封禁原因\n")
+	fmt.Fprintf(os.Stderr, "      --all                           list 时包含已过期的条目\n")
 	fmt.Fprintf(os.Stderr, "\n示例:\n")
 	fmt.Fprintf(os.Stderr, "  %s parse https://raw.githubusercontent.com/aiboboxx/v2rayfree/main/v2\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "  %s start-proxy random https://raw.githubusercontent.com/aiboboxx/v2rayfree/main/v2\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "  %s start-proxy index https://raw.githubusercontent.com/aiboboxx/v2rayfree/main/v2 5\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "  %s auto-proxy https://example.com/sub --http-port=7890 --interval=15 --concurrency=10\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "  %s mvp-tester https://example.com/sub --interval=10 --max-nodes=30\n", os.Args[0])
-	fmt.Fprintf(os.Stderr, "  %s proxy-server mvp_best_node.json --http-port=8080\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s proxy-server --http-port=8080\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s auto-proxy --profile=home --http-port=8080\n", os.Args[0])
 	fmt.Fprintf(os.Stderr, "  %s auto-proxy https://example.com/sub --http-port=8080 --socks-port=1080\n", os.Args[0])
 }
 
@@ -151,10 +384,30 @@ func handleParse() {
 		fmt.Fprintf(os.Stderr, "示例: %s parse https://raw.githubusercontent.com/aiboboxx/v2rayfree/main/v2\n", os.Args[0])
 		os.Exit(1)
 	}
-	if err := parser.ParseSubscription(os.Args[2]); err != nil {
+	if nodeFilter.Empty() {
+		if err := parser.ParseSubscription(os.Args[2]); err != nil {
+			fmt.Fprintf(os.Stderr, "错误: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 使用过滤规则时输出过滤后的节点
+	nodes, err := getNodesFromSubscription(os.Args[2])
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "错误: %v\n", err)
 		os.Exit(1)
 	}
+	jsonOutput, err := json.MarshalIndent(map[string]interface{}{
+		"total": len(nodes),
+		"nodes": nodes,
+	}, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误: JSON序列化失败: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(jsonOutput))
+	fmt.Fprintf(os.Stderr, "解析完成，过滤后共 %d 个节点\n", len(nodes))
 }
 
 func handleStartProxy() {
@@ -204,10 +457,15 @@ func handleStartProxy() {
 		fmt.Fprintf(os.Stderr, "支持的模式: random, index\n")
 		os.Exit(1)
 	}
+
+	// 命令退出后代理继续在后台运行，由 stop-proxy 停止
+	if err := proxyManager.Detach(); err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ 登记后台代理失败: %v\n", err)
+	}
 }
 
 func handleStopProxy() {
-	if err := proxyManager.StopProxy(); err != nil {
+	if err := proxyManager.StopDetached(); err != nil {
 		fmt.Fprintf(os.Stderr, "❌ 停止代理失败: %v\n", err)
 		os.Exit(1)
 	}
@@ -221,16 +479,18 @@ func handleProxyStatus() {
 	if status.Running {
 		fmt.Fprintf(os.Stderr, "✅ 代理运行中\n")
 		fmt.Fprintf(os.Stderr, "📡 节点: %s (%s)\n", status.NodeName, status.Protocol)
+		if status.Core != "" {
+			fmt.Fprintf(os.Stderr, "🧩 核心: %s\n", status.Core)
+		}
 		fmt.Fprintf(os.Stderr, "🌐 HTTP代理: http://127.0.0.1:%d\n", status.HTTPPort)
 		fmt.Fprintf(os.Stderr, "🧦 SOCKS代理: socks5://127.0.0.1:%d\n", status.SOCKSPort)
 	} else {
 		fmt.Fprintf(os.Stderr, "❌ 代理未运行\n")
 
-		if err := exec.Command("lsof", "-i", ":8080").Run(); err == nil {
-			fmt.Fprintf(os.Stderr, "💡 检测到端口8080被占用，可能有V2Ray进程在后台运行\n")
-		}
-		if err := exec.Command("lsof", "-i", ":1080").Run(); err == nil {
-			fmt.Fprintf(os.Stderr, "💡 检测到端口1080被占用，可能有V2Ray进程在后台运行\n")
+		for _, port := range []int{8080, 1080} {
+			if lifecycle.Dialable(lifecycle.PortAddr(port)) {
+				fmt.Fprintf(os.Stderr, "💡 检测到端口%d被占用，可能有其他代理程序在运行\n", port)
+			}
 		}
 	}
 }
@@ -273,10 +533,15 @@ func handleStartHysteria2() {
 		fmt.Fprintf(os.Stderr, "❌ 启动Hysteria2代理失败: %v\n", err)
 		os.Exit(1)
 	}
+
+	// 命令退出后代理继续在后台运行，由 stop-hysteria2 停止
+	if err := hysteria2Manager.Detach(); err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ 登记后台代理失败: %v\n", err)
+	}
 }
 
 func handleStopHysteria2() {
-	if err := hysteria2Manager.StopHysteria2Proxy(); err != nil {
+	if err := hysteria2Manager.StopDetached(); err != nil {
 		fmt.Fprintf(os.Stderr, "❌ 停止Hysteria2代理失败: %v\n", err)
 		os.Exit(1)
 	}
@@ -328,10 +593,7 @@ func handleTestHysteria2() {
 
 func handleDownloadV2Ray() {
 	fmt.Println("=== V2Ray核心自动下载器 ===")
-	if err := downloader.AutoDownloadV2Ray(); err != nil {
-		fmt.Fprintf(os.Stderr, "❌ 下载安装失败: %v\n", err)
-		os.Exit(1)
-	}
+	downloadCore(downloader.CoreV2Ray, downloader.AutoDownloadV2Ray)
 }
 
 func handleCheckV2Ray() {
@@ -339,6 +601,10 @@ func handleCheckV2Ray() {
 	v2rayDownloader := downloader.NewV2RayDownloader()
 	if v2rayDownloader.CheckV2rayInstalled() {
 		fmt.Println("✅ V2Ray已安装")
+		if err := downloader.VerifyBinary(v2rayDownloader.ExecutablePath()); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			os.Exit(1)
+		}
 		v2rayDownloader.ShowV2rayVersion()
 	} else {
 		fmt.Println("❌ V2Ray未安装")
@@ -349,10 +615,7 @@ func handleCheckV2Ray() {
 
 func handleDownloadHysteria2() {
 	fmt.Println("=== Hysteria2客户端自动下载器 ===")
-	if err := downloader.AutoDownloadHysteria2(); err != nil {
-		fmt.Fprintf(os.Stderr, "❌ 下载安装失败: %v\n", err)
-		os.Exit(1)
-	}
+	downloadCore(downloader.CoreHysteria2, downloader.AutoDownloadHysteria2)
 }
 
 func handleCheckHysteria2() {
@@ -360,6 +623,10 @@ func handleCheckHysteria2() {
 	hysteria2Downloader := downloader.NewHysteria2Downloader()
 	if hysteria2Downloader.CheckHysteria2Installed() {
 		fmt.Println("✅ Hysteria2已安装")
+		if err := downloader.VerifyBinary(hysteria2Downloader.BinaryPath); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			os.Exit(1)
+		}
 		hysteria2Downloader.ShowHysteria2Version()
 	} else {
 		fmt.Println("❌ Hysteria2未安装")
@@ -368,13 +635,59 @@ func handleCheckHysteria2() {
 	}
 }
 
+func handleDownloadXray() {
+	fmt.Println("=== Xray核心自动下载器 ===")
+	downloadCore(downloader.CoreXray, downloader.AutoDownloadXray)
+}
+
+func handleCheckXray() {
+	fmt.Println("=== 检查Xray安装状态 ===")
+	xrayDownloader := downloader.NewXrayDownloader()
+	if xrayDownloader.CheckXrayInstalled() {
+		fmt.Println("✅ Xray已安装")
+		if err := downloader.VerifyBinary(xrayDownloader.ExecutablePath()); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			os.Exit(1)
+		}
+		xrayDownloader.ShowXrayVersion()
+	} else {
+		fmt.Println("❌ Xray未安装")
+		fmt.Printf("运行 '%s download-xray' 来安装\n", os.Args[0])
+		os.Exit(1)
+	}
+}
+
+func handleDownloadSingBox() {
+	fmt.Println("=== sing-box核心自动下载器 ===")
+	downloadCore(downloader.CoreSingBox, downloader.AutoDownloadSingBox)
+}
+
+func handleCheckSingBox() {
+	fmt.Println("=== 检查sing-box安装状态 ===")
+	singBoxDownloader := downloader.NewSingBoxDownloader()
+	if singBoxDownloader.CheckSingBoxInstalled() {
+		fmt.Println("✅ sing-box已安装")
+		if err := downloader.VerifyBinary(singBoxDownloader.ExecutablePath()); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			os.Exit(1)
+		}
+		singBoxDownloader.ShowSingBoxVersion()
+	} else {
+		fmt.Println("❌ sing-box未安装")
+		fmt.Printf("运行 '%s download-sing-box' 来安装\n", os.Args[0])
+		os.Exit(1)
+	}
+}
+
 func handleSpeedTest() {
 	if len(os.Args) != 3 {
 		fmt.Fprintf(os.Stderr, "使用方法: %s speed-test <订阅链接>\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "示例: %s speed-test https://raw.githubusercontent.com/aiboboxx/v2rayfree/main/v2\n", os.Args[0])
 		os.Exit(1)
 	}
-	if err := workflow.RunSpeedTestWorkflow(os.Args[2]); err != nil {
+	speedTest := workflow.NewSpeedTestWorkflow(os.Args[2])
+	speedTest.SetFilter(nodeFilter)
+	if err := speedTest.Run(); err != nil {
 		fmt.Fprintf(os.Stderr, "❌ 测速工作流失败: %v\n", err)
 		os.Exit(1)
 	}
@@ -389,6 +702,8 @@ func handleSpeedTestCustom() {
 		fmt.Fprintf(os.Stderr, "  --output=文件名       输出文件 (默认: speed_test_results.txt)\n")
 		fmt.Fprintf(os.Stderr, "  --test-url=URL       测试URL (默认: https://www.google.com)\n")
 		fmt.Fprintf(os.Stderr, "  --max-nodes=数量      最大测试节点数 (默认: 不限制)\n")
+		fmt.Fprintf(os.Stderr, "  --format=格式列表     额外输出的报告格式，逗号分隔: csv,junit,markdown,html,json\n")
+		fmt.Fprintf(os.Stderr, "  --history-file=路径   节点测试历史文件 (默认: 状态目录/node_history.jsonl)\n")
 		fmt.Fprintf(os.Stderr, "\n示例:\n")
 		fmt.Fprintf(os.Stderr, "  %s speed-test-custom https://example.com/sub --concurrency=5 --timeout=20\n", os.Args[0])
 		os.Exit(1)
@@ -401,6 +716,8 @@ func handleSpeedTestCustom() {
 	outputFile := ""
 	testURL := ""
 	maxNodes := 0
+	var formats []string
+	historyFile := ""
 
 	for i := 3; i < len(os.Args); i++ {
 		arg := os.Args[i]
@@ -420,13 +737,22 @@ func handleSpeedTestCustom() {
 			if val, err := strconv.Atoi(strings.TrimPrefix(arg, "--max-nodes=")); err == nil {
 				maxNodes = val
 			}
+		} else if strings.HasPrefix(arg, "--format=") {
+			parsed, err := report.ParseFormats(strings.TrimPrefix(arg, "--format="))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+				os.Exit(1)
+			}
+			formats = parsed
+		} else if strings.HasPrefix(arg, "--history-file=") {
+			historyFile = strings.TrimPrefix(arg, "--history-file=")
 		} else {
 			fmt.Fprintf(os.Stderr, "未知选项: %s\n", arg)
 			os.Exit(1)
 		}
 	}
 
-	if err := workflow.RunCustomSpeedTestWorkflow(subscriptionURL, concurrency, timeout, outputFile, testURL, maxNodes); err != nil {
+	if err := workflow.RunCustomSpeedTestWorkflow(subscriptionURL, concurrency, timeout, outputFile, testURL, maxNodes, formats, historyFile, nodeFilter); err != nil {
 		fmt.Fprintf(os.Stderr, "❌ 自定义测速工作流失败: %v\n", err)
 		os.Exit(1)
 	}
@@ -439,8 +765,71 @@ func handleAutoProxy() {
 		os.Exit(1)
 	}
 
-	subscriptionURL := os.Args[2]
+	config, err := parseAutoProxyConfig(os.Args[2], os.Args[3:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	// 显示最终配置
+	fmt.Printf("📋 配置预览:\n")
+	fmt.Printf("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━\n")
+	fmt.Printf("🌐 HTTP代理端口: %d\n", config.HTTPPort)
+	fmt.Printf("🧦 SOCKS代理端口: %d\n", config.SOCKSPort)
+	fmt.Printf("⏰ 更新间隔: %v\n", config.UpdateInterval)
+	fmt.Printf("🔧 测试并发数: %d\n", config.TestConcurrency)
+	fmt.Printf("⏱️ 测试超时: %v\n", config.TestTimeout)
+	fmt.Printf("🎯 测试URL: %s\n", config.TestURL)
+	fmt.Printf("📊 最大节点数: %d\n", config.MaxNodes)
+	fmt.Printf("📈 最少通过节点: %d\n", config.MinPassingNodes)
+	fmt.Printf("🔄 自动切换: %t\n", config.EnableAutoSwitch)
+	fmt.Printf("📁 状态文件: %s\n", config.StateFile)
+	fmt.Printf("📝 有效节点文件: %s\n", config.ValidNodesFile)
+	fmt.Printf("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━\n")
+	fmt.Printf("\n")
+
+	// 创建并启动自动代理管理器
+	autoProxyManager = workflow.NewAutoProxyManager(config)
+	autoProxyManager.SetFilter(nodeFilter)
+
+	// 控制API的 reload 命令：重新读取配置档案，按相同参数重新生成配置并重新读取过滤规则文件
+	autoProxyManager.SetReloader(func() (types.AutoProxyConfig, *filter.Filter, error) {
+		if err := loadActiveProfile(); err != nil {
+			return types.AutoProxyConfig{}, nil, err
+		}
+		args := applyProfile("auto-proxy", userArgs)
+		if len(args) < 3 {
+			return types.AutoProxyConfig{}, nil, fmt.Errorf("缺少订阅链接")
+		}
+		config, err := parseAutoProxyConfig(args[2], args[3:])
+		if err != nil {
+			return config, nil, err
+		}
+		path := filterPath()
+		if path == "" {
+			return config, nil, nil
+		}
+		f, err := filter.LoadFile(path)
+		return config, f, err
+	})
+
+	fmt.Printf("🚀 启动自动代理管理器...\n")
+	if err := autoProxyManager.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 启动自动代理失败: %v\n", err)
+		os.Exit(1)
+	}
+
+	// 保持程序运行
+	fmt.Printf("✅ 自动代理管理器已启动！\n")
+	fmt.Printf("📝 按 Ctrl+C 停止服务\n")
+	fmt.Printf("\n")
+
+	// 阻塞等待
+	select {}
+}
 
+// parseAutoProxyConfig 根据命令行选项生成自动代理配置
+func parseAutoProxyConfig(subscriptionURL string, args []string) (types.AutoProxyConfig, error) {
 	// 创建默认配置
 	config := types.AutoProxyConfig{
 		SubscriptionURL:  subscriptionURL,
@@ -452,14 +841,14 @@ func handleAutoProxy() {
 		TestURL:          "http://www.google.com",
 		MaxNodes:         100,
 		MinPassingNodes:  5,
-		StateFile:        "./auto_proxy_state.json",
-		ValidNodesFile:   "./valid_nodes.json",
+		StateFile:        statedir.File("auto_proxy_state.json"),
+		ValidNodesFile:   statedir.File("valid_nodes.json"),
 		EnableAutoSwitch: true,
+		ControlToken:     os.Getenv(config.EnvPrefix + "CONTROL_TOKEN"),
 	}
 
 	// 解析自定义选项
-	for i := 3; i < len(os.Args); i++ {
-		arg := os.Args[i]
+	for _, arg := range args {
 		if strings.HasPrefix(arg, "--http-port=") {
 			if port, err := strconv.Atoi(strings.TrimPrefix(arg, "--http-port=")); err == nil {
 				config.HTTPPort = port
@@ -481,8 +870,7 @@ func handleAutoProxy() {
 			if timeout, err := time.ParseDuration(timeoutStr); err == nil {
 				config.TestTimeout = timeout
 			} else {
-				fmt.Fprintf(os.Stderr, "❌ 无效的超时时间格式: %s (请使用如 30s, 2m 等格式)\n", timeoutStr)
-				os.Exit(1)
+				return config, fmt.Errorf("无效的超时时间格式: %s (请使用如 30s, 2m 等格式)", timeoutStr)
 			}
 		} else if strings.HasPrefix(arg, "--test-url=") {
 			config.TestURL = strings.TrimPrefix(arg, "--test-url=")
@@ -500,45 +888,60 @@ func handleAutoProxy() {
 			config.ValidNodesFile = strings.TrimPrefix(arg, "--valid-file=")
 		} else if arg == "--no-auto-switch" {
 			config.EnableAutoSwitch = false
+		} else if strings.HasPrefix(arg, "--history-file=") {
+			config.HistoryFile = strings.TrimPrefix(arg, "--history-file=")
+		} else if strings.HasPrefix(arg, "--control-socket=") {
+			config.ControlSocket = strings.TrimPrefix(arg, "--control-socket=")
+		} else if strings.HasPrefix(arg, "--control-addr=") {
+			config.ControlAddr = strings.TrimPrefix(arg, "--control-addr=")
+		} else if strings.HasPrefix(arg, "--control-token=") {
+			config.ControlToken = strings.TrimPrefix(arg, "--control-token=")
+		} else if strings.HasPrefix(arg, "--blacklist-file=") {
+			config.BlacklistFile = strings.TrimPrefix(arg, "--blacklist-file=")
+		} else if strings.HasPrefix(arg, "--switch-threshold=") {
+			threshold, err := parseSwitchThreshold(strings.TrimPrefix(arg, "--switch-threshold="))
+			if err != nil {
+				return config, err
+			}
+			config.SwitchThreshold = threshold
+		} else if strings.HasPrefix(arg, "--min-dwell=") {
+			dwellStr := strings.TrimPrefix(arg, "--min-dwell=")
+			dwell, err := time.ParseDuration(dwellStr)
+			if err != nil {
+				return config, fmt.Errorf("无效的停留时长: %s (请使用如 10m, 1h 等格式)", dwellStr)
+			}
+			config.MinDwell = dwell
+		} else if strings.HasPrefix(arg, "--schedule=") {
+			config.TestSchedule = strings.TrimPrefix(arg, "--schedule=")
+			if _, err := schedule.Parse(config.TestSchedule); err != nil {
+				return config, fmt.Errorf("无效的测试调度: %v", err)
+			}
+		} else if strings.HasPrefix(arg, "--health-schedule=") {
+			config.HealthSchedule = strings.TrimPrefix(arg, "--health-schedule=")
+			if _, err := schedule.Parse(config.HealthSchedule); err != nil {
+				return config, fmt.Errorf("无效的健康检查调度: %v", err)
+			}
+		} else if strings.HasPrefix(arg, "--quiet-hours=") {
+			config.QuietHours = strings.TrimPrefix(arg, "--quiet-hours=")
+			if _, err := schedule.ParseWindows(config.QuietHours); err != nil {
+				return config, fmt.Errorf("无效的静默时段: %v", err)
+			}
 		} else {
-			fmt.Fprintf(os.Stderr, "未知选项: %s\n", arg)
-			os.Exit(1)
+			return config, fmt.Errorf("未知选项: %s", arg)
 		}
 	}
 
-	// 显示最终配置
-	fmt.Printf("📋 配置预览:\n")
-	fmt.Printf("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━\n")
-	fmt.Printf("🌐 HTTP代理端口: %d\n", config.HTTPPort)
-	fmt.Printf("🧦 SOCKS代理端口: %d\n", config.SOCKSPort)
-	fmt.Printf("⏰ 更新间隔: %v\n", config.UpdateInterval)
-	fmt.Printf("🔧 测试并发数: %d\n", config.TestConcurrency)
-	fmt.Printf("⏱️ 测试超时: %v\n", config.TestTimeout)
-	fmt.Printf("🎯 测试URL: %s\n", config.TestURL)
-	fmt.Printf("📊 最大节点数: %d\n", config.MaxNodes)
-	fmt.Printf("📈 最少通过节点: %d\n", config.MinPassingNodes)
-	fmt.Printf("🔄 自动切换: %t\n", config.EnableAutoSwitch)
-	fmt.Printf("📁 状态文件: %s\n", config.StateFile)
-	fmt.Printf("📝 有效节点文件: %s\n", config.ValidNodesFile)
-	fmt.Printf("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━\n")
-	fmt.Printf("\n")
+	return config, nil
 
-	// 创建并启动自动代理管理器
-	autoProxyManager = workflow.NewAutoProxyManager(config)
+}
 
-	fmt.Printf("🚀 启动自动代理管理器...\n")
-	if err := autoProxyManager.Start(); err != nil {
-		fmt.Fprintf(os.Stderr, "❌ 启动自动代理失败: %v\n", err)
-		os.Exit(1)
+// parseSwitchThreshold 解析百分比形式的切换阈值，如 20 表示分数至少提升20%
+func parseSwitchThreshold(value string) (float64, error) {
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil || percent < 0 {
+		return 0, fmt.Errorf("无效的切换阈值: %s (请使用百分比，如 20)", value)
 	}
-
-	// 保持程序运行
-	fmt.Printf("✅ 自动代理管理器已启动！\n")
-	fmt.Printf("📝 按 Ctrl+C 停止服务\n")
-	fmt.Printf("\n")
-
-	// 阻塞等待
-	select {}
+	return percent / 100, nil
 }
 
 func handleMVPTester() {
@@ -550,8 +953,10 @@ func handleMVPTester() {
 
 	subscriptionURL := os.Args[2]
 	tester := workflow.NewMVPTester(subscriptionURL)
+	tester.SetFilter(nodeFilter)
 
-	// 解析选项
+	// 解析选项，--schedule 在所有选项解析后设置，以覆盖 --interval
+	scheduleSpec := ""
 	for i := 3; i < len(os.Args); i++ {
 		arg := os.Args[i]
 		if strings.HasPrefix(arg, "--interval=") {
@@ -568,12 +973,32 @@ func handleMVPTester() {
 			}
 		} else if strings.HasPrefix(arg, "--state-file=") {
 			tester.SetStateFile(strings.TrimPrefix(arg, "--state-file="))
+		} else if arg == "--no-preflight" {
+			tester.SetPreflight(false)
+		} else if strings.HasPrefix(arg, "--history-file=") {
+			tester.SetHistoryFile(strings.TrimPrefix(arg, "--history-file="))
+		} else if strings.HasPrefix(arg, "--socket=") {
+			tester.SetControlSocket(strings.TrimPrefix(arg, "--socket="))
+		} else if strings.HasPrefix(arg, "--blacklist-file=") {
+			tester.SetBlacklist(blacklist.New(strings.TrimPrefix(arg, "--blacklist-file=")))
+		} else if strings.HasPrefix(arg, "--schedule=") {
+			scheduleSpec = strings.TrimPrefix(arg, "--schedule=")
+		} else if strings.HasPrefix(arg, "--health-schedule=") {
+			if err := tester.SetHealthSchedule(strings.TrimPrefix(arg, "--health-schedule=")); err != nil {
+				fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+				os.Exit(1)
+			}
 		} else {
 			fmt.Fprintf(os.Stderr, "未知选项: %s\n", arg)
 			os.Exit(1)
 		}
 	}
 
+	if err := tester.SetSchedule(scheduleSpec); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+
 	if err := tester.Start(); err != nil {
 		fmt.Fprintf(os.Stderr, "❌ MVP测试器启动失败: %v\n", err)
 		os.Exit(1)
@@ -581,18 +1006,21 @@ func handleMVPTester() {
 }
 
 func handleProxyServer() {
-	if len(os.Args) < 3 {
-		fmt.Fprintf(os.Stderr, "使用方法: %s proxy-server <配置文件> [选项]\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "示例: %s proxy-server mvp_best_node.json --http-port=8080\n", os.Args[0])
-		os.Exit(1)
+	// 配置文件可省略，默认读取 mvp-tester 写入运行目录的最佳节点文件
+	configFile := statedir.File("mvp_best_node.json")
+	optionStart := 2
+	if len(os.Args) > 2 && !strings.HasPrefix(os.Args[2], "--") {
+		configFile = os.Args[2]
+		optionStart = 3
 	}
-
-	configFile := os.Args[2]
 	httpPort := 8080
 	socksPort := 1080
+	socketPath := ""
+	blacklistFile := ""
+	policy := workflow.DefaultSwitchPolicy()
 
 	// 解析选项
-	for i := 3; i < len(os.Args); i++ {
+	for i := optionStart; i < len(os.Args); i++ {
 		arg := os.Args[i]
 		if strings.HasPrefix(arg, "--http-port=") {
 			if port, err := strconv.Atoi(strings.TrimPrefix(arg, "--http-port=")); err == nil {
@@ -602,13 +1030,46 @@ func handleProxyServer() {
 			if port, err := strconv.Atoi(strings.TrimPrefix(arg, "--socks-port=")); err == nil {
 				socksPort = port
 			}
+		} else if strings.HasPrefix(arg, "--socket=") {
+			socketPath = strings.TrimPrefix(arg, "--socket=")
+		} else if strings.HasPrefix(arg, "--blacklist-file=") {
+			blacklistFile = strings.TrimPrefix(arg, "--blacklist-file=")
+		} else if strings.HasPrefix(arg, "--switch-threshold=") {
+			threshold, err := parseSwitchThreshold(strings.TrimPrefix(arg, "--switch-threshold="))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+				os.Exit(1)
+			}
+			policy.MinImprovement = threshold
+		} else if strings.HasPrefix(arg, "--min-dwell=") {
+			dwell, err := time.ParseDuration(strings.TrimPrefix(arg, "--min-dwell="))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "❌ 无效的停留时长: %v\n", err)
+				os.Exit(1)
+			}
+			policy.MinDwell = dwell
+		} else if strings.HasPrefix(arg, "--quiet-hours=") {
+			quiet, err := schedule.ParseWindows(strings.TrimPrefix(arg, "--quiet-hours="))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "❌ 无效的静默时段: %v\n", err)
+				os.Exit(1)
+			}
+			policy.QuietHours = quiet
 		} else {
 			fmt.Fprintf(os.Stderr, "未知选项: %s\n", arg)
 			os.Exit(1)
 		}
 	}
 
-	if err := workflow.RunProxyServer(configFile, httpPort, socksPort); err != nil {
+	server := workflow.NewProxyServer(configFile, httpPort, socksPort)
+	if socketPath != "" {
+		server.SetSocketPath(socketPath)
+	}
+	if blacklistFile != "" {
+		server.SetBlacklist(blacklist.New(blacklistFile))
+	}
+	server.SetSwitchPolicy(policy)
+	if err := server.Start(); err != nil {
 		fmt.Fprintf(os.Stderr, "❌ 代理服务器启动失败: %v\n", err)
 		os.Exit(1)
 	}
@@ -642,13 +1103,398 @@ func handleDualProxy() {
 		}
 	}
 
-	if err := workflow.RunDualProxySystem(subscriptionURL, httpPort, socksPort); err != nil {
+	if err := workflow.RunDualProxySystem(subscriptionURL, httpPort, socksPort, nodeFilter); err != nil {
 		fmt.Fprintf(os.Stderr, "❌ 双进程代理系统启动失败: %v\n", err)
 		os.Exit(1)
 	}
 }
 
-// getNodesFromSubscription 从订阅链接获取节点列表
+func handleHistory() {
+	historyFile := history.DefaultFile()
+	since := 7 * 24 * time.Hour
+	retention := history.DefaultRetention
+	top := 20
+	protocol := ""
+	nodeKeyword := ""
+	prune := false
+
+	for i := 2; i < len(os.Args); i++ {
+		arg := os.Args[i]
+		if strings.HasPrefix(arg, "--file=") {
+			historyFile = strings.TrimPrefix(arg, "--file=")
+		} else if strings.HasPrefix(arg, "--since=") {
+			value, err := time.ParseDuration(strings.TrimPrefix(arg, "--since="))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "❌ 无效的时长格式: %s (请使用如 24h, 168h 等格式)\n", arg)
+				os.Exit(1)
+			}
+			since = value
+		} else if strings.HasPrefix(arg, "--retention=") {
+			value, err := time.ParseDuration(strings.TrimPrefix(arg, "--retention="))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "❌ 无效的时长格式: %s (请使用如 720h 等格式)\n", arg)
+				os.Exit(1)
+			}
+			retention = value
+		} else if strings.HasPrefix(arg, "--top=") {
+			if val, err := strconv.Atoi(strings.TrimPrefix(arg, "--top=")); err == nil {
+				top = val
+			}
+		} else if strings.HasPrefix(arg, "--protocol=") {
+			protocol = strings.TrimPrefix(arg, "--protocol=")
+		} else if strings.HasPrefix(arg, "--node=") {
+			nodeKeyword = strings.TrimPrefix(arg, "--node=")
+		} else if arg == "--prune" {
+			prune = true
+		} else {
+			fmt.Fprintf(os.Stderr, "未知选项: %s\n", arg)
+			os.Exit(1)
+		}
+	}
+
+	store := history.NewStore(historyFile)
+	store.SetRetention(retention)
+
+	if prune {
+		removed, err := store.Prune()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ 清理测试历史失败: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("🧹 已清理 %d 条超过 %v 的测试历史\n", removed, retention)
+		return
+	}
+
+	records, err := store.Load(time.Now().Add(-since))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 读取测试历史失败: %v\n", err)
+		os.Exit(1)
+	}
+	if len(records) == 0 {
+		fmt.Printf("📄 %s 中没有最近 %v 的测试记录\n", historyFile, since)
+		return
+	}
+
+	// 查看单个节点的测试记录
+	if nodeKeyword != "" {
+		fmt.Printf("🔍 匹配 \"%s\" 的测试记录:\n", nodeKeyword)
+		count := 0
+		for i := len(records) - 1; i >= 0 && count < top; i-- {
+			record := records[i]
+			if !strings.HasPrefix(record.Fingerprint, nodeKeyword) && !strings.Contains(record.Name, nodeKeyword) {
+				continue
+			}
+			status := "✅"
+			detail := fmt.Sprintf("延迟: %dms, 速度: %.2fMbps", record.LatencyMs, record.SpeedMbps)
+			if !record.Success {
+				status = "❌"
+				detail = record.Error
+			}
+			fmt.Printf("%s %s [%s] %s (%s) %s\n",
+				status, record.TestTime.Format("2006-01-02 15:04:05"), record.Source, record.Name, record.Fingerprint, detail)
+			count++
+		}
+		if count == 0 {
+			fmt.Printf("📄 没有匹配的测试记录\n")
+		}
+		return
+	}
+
+	ranked := history.Rank(history.Summarize(records, time.Now()))
+	fmt.Printf("📊 最近 %v 的节点历史排名 (共 %d 条记录, %d 个节点):\n", since, len(records), len(ranked))
+	fmt.Printf("%s\n", strings.Repeat("━", 80))
+	count := 0
+	for _, stat := range ranked {
+		if protocol != "" && stat.Protocol != protocol {
+			continue
+		}
+		if count >= top {
+			break
+		}
+		count++
+		fmt.Printf("🏆 #%d %s [%s] %s:%s\n", count, stat.Name, stat.Protocol, stat.Server, stat.Port)
+		fmt.Printf("    指纹: %s | 可靠性: %.0f%% | 成功: %d/%d | 平均延迟: %dms | 平均速度: %.2fMbps\n",
+			stat.Fingerprint, stat.Reliability*100, stat.Successes, stat.Tests, stat.AvgLatencyMs, stat.AvgSpeedMbps)
+		if stat.LastError != "" {
+			fmt.Printf("    最近错误: %s\n", stat.LastError)
+		}
+	}
+	fmt.Printf("%s\n", strings.Repeat("━", 80))
+}
+
+func handleCtl() {
+	if len(os.Args) < 3 {
+		fmt.Fprintf(os.Stderr, "使用方法: %s ctl <操作> [参数] [选项]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "操作: status | nodes | blacklist | switches | schedule | retest | pin <节点> | unpin | ban <目标> [--duration=时长] | unban <目标> | allow <目标> | disallow <目标> | pause | resume | reload\n")
+		os.Exit(1)
+	}
+
+	action := os.Args[2]
+	socketPath := workflow.DefaultControlSocket()
+	addr := ""
+	token := os.Getenv(config.EnvPrefix + "CONTROL_TOKEN")
+	req := &workflow.ControlRequest{}
+
+	for i := 3; i < len(os.Args); i++ {
+		arg := os.Args[i]
+		if strings.HasPrefix(arg, "--socket=") {
+			socketPath = strings.TrimPrefix(arg, "--socket=")
+		} else if strings.HasPrefix(arg, "--addr=") {
+			addr = strings.TrimPrefix(arg, "--addr=")
+		} else if strings.HasPrefix(arg, "--token=") {
+			token = strings.TrimPrefix(arg, "--token=")
+		} else if strings.HasPrefix(arg, "--duration=") {
+			req.Duration = strings.TrimPrefix(arg, "--duration=")
+		} else if strings.HasPrefix(arg, "--kind=") {
+			req.Kind = strings.TrimPrefix(arg, "--kind=")
+		} else if strings.HasPrefix(arg, "--reason=") {
+			req.Reason = strings.TrimPrefix(arg, "--reason=")
+		} else if !strings.HasPrefix(arg, "--") && req.Node == "" {
+			req.Node = arg
+		} else {
+			fmt.Fprintf(os.Stderr, "未知选项: %s\n", arg)
+			os.Exit(1)
+		}
+	}
+
+	client := workflow.NewAutoProxyClient(socketPath, addr, token)
+
+	switch action {
+	case "status":
+		var state types.AutoProxyState
+		if _, err := client.Get("/status", &state); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("📊 自动代理状态:\n")
+		fmt.Printf("🔄 运行中: %t | 自动切换: %t\n", state.Running, state.AutoSwitch)
+		fmt.Printf("⏰ 启动时间: %s\n", state.StartTime.Format("2006-01-02 15:04:05"))
+		if state.CurrentNode != nil {
+			fmt.Printf("📡 当前节点: %s (%s) %s:%s\n", state.CurrentNode.Name, state.CurrentNode.Protocol, state.CurrentNode.Server, state.CurrentNode.Port)
+			if state.CurrentCore != "" {
+				fmt.Printf("🧩 核心: %s\n", state.CurrentCore)
+			}
+		} else {
+			fmt.Printf("📡 当前节点: 无\n")
+		}
+		if state.PinnedNode != nil {
+			fmt.Printf("📌 固定节点: %s\n", state.PinnedNode.Name)
+		}
+		fmt.Printf("✅ 有效节点: %d\n", len(state.ValidNodes))
+		fmt.Printf("🌐 HTTP代理: http://127.0.0.1:%d\n", state.Config.HTTPPort)
+		fmt.Printf("🧦 SOCKS代理: socks5://127.0.0.1:%d\n", state.Config.SOCKSPort)
+	case "nodes":
+		var nodes []workflow.RankedNode
+		if _, err := client.Get("/nodes", &nodes); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			os.Exit(1)
+		}
+		if len(nodes) == 0 {
+			fmt.Printf("📄 暂无测试结果\n")
+			return
+		}
+		for _, node := range nodes {
+			marks := ""
+			if node.Current {
+				marks += " 🟢当前"
+			}
+			if node.Pinned {
+				marks += " 📌固定"
+			}
+			if node.Banned {
+				marks += " 🚫封禁"
+			}
+			fmt.Printf("🏆 #%d %s [%s] %s (分数: %.2f, 延迟: %dms, 速度: %.2fMbps)%s\n",
+				node.Rank, node.Name, node.Protocol, node.Fingerprint, node.Score, node.LatencyMs, node.SpeedMbps, marks)
+		}
+	case "blacklist":
+		var lists struct {
+			Entries []blacklist.Entry `json:"entries"`
+			Allow   []blacklist.Entry `json:"allow"`
+		}
+		if _, err := client.Get("/blacklist", &lists); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			os.Exit(1)
+		}
+		printBlacklistEntries(lists.Entries, lists.Allow)
+	case "switches":
+		var decisions []workflow.SwitchDecision
+		if _, err := client.Get("/switches", &decisions); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			os.Exit(1)
+		}
+		if len(decisions) == 0 {
+			fmt.Printf("📄 暂无切换决策\n")
+			return
+		}
+		for _, decision := range decisions {
+			mark := "🛑 拒绝"
+			if decision.Accepted {
+				mark = "🔀 接受"
+			}
+			from := decision.From
+			if from == "" {
+				from = "无"
+			}
+			fmt.Printf("%s %s %s → %s (%.2f → %.2f) %s\n", decision.Time.Format("2006-01-02 15:04:05"), mark,
+				from, decision.To, decision.FromScore, decision.ToScore, decision.Reason)
+		}
+	case "schedule":
+		var jobs []schedule.JobInfo
+		if _, err := client.Get("/schedule", &jobs); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			os.Exit(1)
+		}
+		if len(jobs) == 0 {
+			fmt.Printf("📄 暂无调度任务\n")
+			return
+		}
+		for _, job := range jobs {
+			lastRun := "从未运行"
+			if !job.LastRun.IsZero() {
+				lastRun = job.LastRun.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("⏰ %s [%s] 下次: %s | 上次: %s\n", job.Name, job.Spec, job.NextRun.Format("2006-01-02 15:04:05"), lastRun)
+		}
+	case "retest", "unpin", "pause", "resume", "reload", "pin", "ban", "unban", "allow", "disallow":
+		if action != "retest" && action != "unpin" && action != "pause" && action != "resume" && action != "reload" && req.Node == "" {
+			fmt.Fprintf(os.Stderr, "❌ %s 需要指定节点名称或指纹\n", action)
+			os.Exit(1)
+		}
+		response, err := client.Post("/"+action, req, nil)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("✅ %s\n", response.Message)
+	default:
+		fmt.Fprintf(os.Stderr, "未知操作: %s\n", action)
+		os.Exit(1)
+	}
+}
+
+func handleBlacklist() {
+	if len(os.Args) < 3 {
+		fmt.Fprintf(os.Stderr, "使用方法: %s blacklist <操作> [参数] [选项]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "操作: list | add <目标> | remove <目标> | allow <目标> | disallow <目标> | prune\n")
+		os.Exit(1)
+	}
+
+	action := os.Args[2]
+	file := blacklist.DefaultFile()
+	kind := blacklist.Kind("")
+	target := ""
+	reason := ""
+	var duration time.Duration
+	showAll := false
+
+	for i := 3; i < len(os.Args); i++ {
+		arg := os.Args[i]
+		if strings.HasPrefix(arg, "--file=") {
+			file = strings.TrimPrefix(arg, "--file=")
+		} else if strings.HasPrefix(arg, "--kind=") {
+			kind = blacklist.Kind(strings.TrimPrefix(arg, "--kind="))
+		} else if strings.HasPrefix(arg, "--reason=") {
+			reason = strings.TrimPrefix(arg, "--reason=")
+		} else if strings.HasPrefix(arg, "--duration=") {
+			d, err := time.ParseDuration(strings.TrimPrefix(arg, "--duration="))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "❌ 无效的时长: %v\n", err)
+				os.Exit(1)
+			}
+			duration = d
+		} else if arg == "--all" {
+			showAll = true
+		} else if !strings.HasPrefix(arg, "--") && target == "" {
+			target = arg
+		} else {
+			fmt.Fprintf(os.Stderr, "未知选项: %s\n", arg)
+			os.Exit(1)
+		}
+	}
+
+	if action != "list" && action != "prune" && target == "" {
+		fmt.Fprintf(os.Stderr, "❌ %s 需要指定节点指纹、服务器地址或网段\n", action)
+		os.Exit(1)
+	}
+
+	list := blacklist.New(file)
+	var err error
+	switch action {
+	case "list":
+		printBlacklistEntries(list.Entries(!showAll), list.AllowEntries())
+	case "add":
+		var entry *blacklist.Entry
+		if entry, err = list.Ban(kind, target, reason, duration); err == nil {
+			fmt.Printf("🚫 已封禁 %s: %s\n", entry.Kind, entry.Value)
+		}
+	case "remove":
+		if err = list.Unban(kind, target); err == nil {
+			fmt.Printf("✅ 已解除封禁: %s\n", target)
+		}
+	case "allow":
+		var entry *blacklist.Entry
+		if entry, err = list.Allow(kind, target, reason); err == nil {
+			fmt.Printf("✅ 已加入白名单 %s: %s\n", entry.Kind, entry.Value)
+		}
+	case "disallow":
+		if err = list.Disallow(kind, target); err == nil {
+			fmt.Printf("✅ 已移出白名单: %s\n", target)
+		}
+	case "prune":
+		var removed int
+		if removed, err = list.Prune(); err == nil {
+			fmt.Printf("🧹 已清理 %d 个过期条目\n", removed)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "未知操作: %s\n", action)
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// printBlacklistEntries 打印黑名单和白名单条目
+func printBlacklistEntries(entries, allow []blacklist.Entry) {
+	if len(entries) == 0 && len(allow) == 0 {
+		fmt.Printf("📄 黑名单为空\n")
+		return
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		expires := "永久"
+		if !entry.Permanent {
+			expires = "到期: " + entry.ExpiresAt.Format("2006-01-02 15:04:05")
+			if !entry.Active(now) {
+				expires += " (已过期)"
+			}
+		}
+		line := fmt.Sprintf("🚫 [%s] %s %s", entry.Kind, entry.Value, expires)
+		if entry.Name != "" {
+			line += " 节点: " + entry.Name
+		}
+		if entry.Strikes > 0 {
+			line += fmt.Sprintf(" 失败次数: %d", entry.Strikes)
+		}
+		if entry.Reason != "" {
+			line += " 原因: " + entry.Reason
+		}
+		fmt.Println(line)
+	}
+	for _, entry := range allow {
+		line := fmt.Sprintf("✅ [%s] %s 白名单", entry.Kind, entry.Value)
+		if entry.Reason != "" {
+			line += " 原因: " + entry.Reason
+		}
+		fmt.Println(line)
+	}
+}
+
+// getNodesFromSubscription 从订阅链接获取节点列表（已应用 --filter 规则）
 func getNodesFromSubscription(subscriptionURL string) ([]*types.Node, error) {
 	content, err := parser.FetchSubscription(subscriptionURL)
 	if err != nil {
@@ -665,5 +1511,5 @@ func getNodesFromSubscription(subscriptionURL string) ([]*types.Node, error) {
 		return nil, fmt.Errorf("解析失败: %v", err)
 	}
 
-	return nodes, nil
+	return nodeFilter.Apply(nodes), nil
 }
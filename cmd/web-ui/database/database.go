@@ -72,6 +72,7 @@ func (d *Database) initTables() error {
 		last_update TEXT DEFAULT '',
 		status TEXT DEFAULT 'inactive',
 		create_time TEXT NOT NULL,
+		filter_rules TEXT DEFAULT '',
 		updated_at TEXT DEFAULT CURRENT_TIMESTAMP
 	);`
 
@@ -221,6 +222,22 @@ func (d *Database) initTables() error {
 		created_at TEXT DEFAULT CURRENT_TIMESTAMP
 	);`
 
+	// 批量测试记录表
+	batchTestRunsTable := `
+	CREATE TABLE IF NOT EXISTS batch_test_runs (
+		id TEXT PRIMARY KEY,
+		subscription_id TEXT NOT NULL,
+		subscription_name TEXT DEFAULT '',
+		status TEXT DEFAULT 'completed',
+		start_time TEXT NOT NULL,
+		end_time TEXT NOT NULL,
+		total_count INTEGER DEFAULT 0,
+		success_count INTEGER DEFAULT 0,
+		failure_count INTEGER DEFAULT 0,
+		results TEXT DEFAULT '[]',
+		created_at TEXT DEFAULT CURRENT_TIMESTAMP
+	);`
+
 	// 创建索引
 	indexes := []string{
 		"CREATE INDEX IF NOT EXISTS idx_nodes_subscription_id ON nodes(subscription_id);",
@@ -233,6 +250,7 @@ func (d *Database) initTables() error {
 		"CREATE INDEX IF NOT EXISTS idx_intelligent_proxy_test_history_subscription_id ON intelligent_proxy_test_history(subscription_id);",
 		"CREATE INDEX IF NOT EXISTS idx_intelligent_proxy_test_history_test_time ON intelligent_proxy_test_history(test_time);",
 		"CREATE INDEX IF NOT EXISTS idx_intelligent_proxy_switch_log_switch_time ON intelligent_proxy_switch_log(switch_time);",
+		"CREATE INDEX IF NOT EXISTS idx_batch_test_runs_start_time ON batch_test_runs(start_time);",
 	}
 
 	// 执行表创建
@@ -246,6 +264,7 @@ func (d *Database) initTables() error {
 		intelligentProxyQueueTable,
 		intelligentProxyTestHistoryTable,
 		intelligentProxySwitchLogTable,
+		batchTestRunsTable,
 	}
 
 	for _, table := range tables {
@@ -254,6 +273,11 @@ func (d *Database) initTables() error {
 		}
 	}
 
+	// 为旧版本数据库补充新增的列
+	if err := d.ensureColumn("subscriptions", "filter_rules", "TEXT DEFAULT ''"); err != nil {
+		return fmt.Errorf("升级表结构失败: %v", err)
+	}
+
 	// 创建索引
 	for _, index := range indexes {
 		if _, err := d.DB.Exec(index); err != nil {
@@ -269,6 +293,33 @@ func (d *Database) initTables() error {
 	return nil
 }
 
+// ensureColumn 列不存在时添加列
+func (d *Database) ensureColumn(table, column, definition string) error {
+	rows, err := d.DB.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = d.DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
 // initDefaultData 初始化默认数据
 func (d *Database) initDefaultData() error {
 	// 初始化代理状态记录
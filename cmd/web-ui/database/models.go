@@ -31,6 +31,11 @@ type ProxyStatusDB struct {
 	db *Database
 }
 
+// BatchTestRunDB 批量测试记录数据库操作
+type BatchTestRunDB struct {
+	db *Database
+}
+
 // NewSubscriptionDB 创建订阅数据库操作实例
 func NewSubscriptionDB(db *Database) *SubscriptionDB {
 	return &SubscriptionDB{db: db}
@@ -51,13 +56,18 @@ func NewProxyStatusDB(db *Database) *ProxyStatusDB {
 	return &ProxyStatusDB{db: db}
 }
 
+// NewBatchTestRunDB 创建批量测试记录数据库操作实例
+func NewBatchTestRunDB(db *Database) *BatchTestRunDB {
+	return &BatchTestRunDB{db: db}
+}
+
 // SubscriptionDB 方法
 
 // Create 创建订阅
 func (s *SubscriptionDB) Create(subscription *models.Subscription) error {
 	query := `
-	INSERT INTO subscriptions (id, name, url, node_count, last_update, status, create_time)
-	VALUES (?, ?, ?, ?, ?, ?, ?)`
+	INSERT INTO subscriptions (id, name, url, node_count, last_update, status, create_time, filter_rules)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
 
 	_, err := s.db.DB.Exec(query,
 		subscription.ID,
@@ -67,13 +77,14 @@ func (s *SubscriptionDB) Create(subscription *models.Subscription) error {
 		subscription.LastUpdate,
 		subscription.Status,
 		subscription.CreateTime,
+		subscription.FilterRules,
 	)
 	return err
 }
 
 // GetAll 获取所有订阅
 func (s *SubscriptionDB) GetAll() ([]*models.Subscription, error) {
-	query := `SELECT id, name, url, node_count, last_update, status, create_time FROM subscriptions ORDER BY create_time DESC`
+	query := `SELECT id, name, url, node_count, last_update, status, create_time, filter_rules FROM subscriptions ORDER BY create_time DESC`
 	
 	rows, err := s.db.DB.Query(query)
 	if err != nil {
@@ -92,6 +103,7 @@ func (s *SubscriptionDB) GetAll() ([]*models.Subscription, error) {
 			&sub.LastUpdate,
 			&sub.Status,
 			&sub.CreateTime,
+			&sub.FilterRules,
 		)
 		if err != nil {
 			return nil, err
@@ -113,7 +125,7 @@ func (s *SubscriptionDB) GetAll() ([]*models.Subscription, error) {
 
 // GetByID 根据ID获取订阅
 func (s *SubscriptionDB) GetByID(id string) (*models.Subscription, error) {
-	query := `SELECT id, name, url, node_count, last_update, status, create_time FROM subscriptions WHERE id = ?`
+	query := `SELECT id, name, url, node_count, last_update, status, create_time, filter_rules FROM subscriptions WHERE id = ?`
 	
 	sub := &models.Subscription{}
 	err := s.db.DB.QueryRow(query, id).Scan(
@@ -124,6 +136,7 @@ func (s *SubscriptionDB) GetByID(id string) (*models.Subscription, error) {
 		&sub.LastUpdate,
 		&sub.Status,
 		&sub.CreateTime,
+		&sub.FilterRules,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -147,7 +160,7 @@ func (s *SubscriptionDB) GetByID(id string) (*models.Subscription, error) {
 func (s *SubscriptionDB) Update(subscription *models.Subscription) error {
 	query := `
 	UPDATE subscriptions 
-	SET name = ?, url = ?, node_count = ?, last_update = ?, status = ?, updated_at = CURRENT_TIMESTAMP
+	SET name = ?, url = ?, node_count = ?, last_update = ?, status = ?, filter_rules = ?, updated_at = CURRENT_TIMESTAMP
 	WHERE id = ?`
 
 	result, err := s.db.DB.Exec(query,
@@ -156,6 +169,7 @@ func (s *SubscriptionDB) Update(subscription *models.Subscription) error {
 		subscription.NodeCount,
 		subscription.LastUpdate,
 		subscription.Status,
+		subscription.FilterRules,
 		subscription.ID,
 	)
 	if err != nil {
@@ -524,4 +538,107 @@ func (n *NodeDB) GetNodeIDBySubscriptionAndIndex(subscriptionID string, nodeInde
 	}
 	
 	return nodeID, nil
-}
\ No newline at end of file
+}
+
+// BatchTestRunDB 方法
+
+// Create 保存批量测试记录
+func (b *BatchTestRunDB) Create(run *models.BatchTestRun) error {
+	resultsJSON, err := json.Marshal(run.Results)
+	if err != nil {
+		return fmt.Errorf("序列化测试结果失败: %v", err)
+	}
+
+	query := `
+	INSERT INTO batch_test_runs (id, subscription_id, subscription_name, status, start_time, end_time, total_count, success_count, failure_count, results)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err = b.db.DB.Exec(query,
+		run.ID,
+		run.SubscriptionID,
+		run.SubscriptionName,
+		run.Status,
+		run.StartTime.Format(time.RFC3339),
+		run.EndTime.Format(time.RFC3339),
+		run.TotalCount,
+		run.SuccessCount,
+		run.FailureCount,
+		string(resultsJSON),
+	)
+	return err
+}
+
+// GetByID 根据ID获取批量测试记录（包含详细结果）
+func (b *BatchTestRunDB) GetByID(id string) (*models.BatchTestRun, error) {
+	query := `
+	SELECT id, subscription_id, subscription_name, status, start_time, end_time, total_count, success_count, failure_count, results
+	FROM batch_test_runs WHERE id = ?`
+
+	run := &models.BatchTestRun{}
+	var startTimeStr, endTimeStr, resultsJSON string
+	err := b.db.DB.QueryRow(query, id).Scan(
+		&run.ID,
+		&run.SubscriptionID,
+		&run.SubscriptionName,
+		&run.Status,
+		&startTimeStr,
+		&endTimeStr,
+		&run.TotalCount,
+		&run.SuccessCount,
+		&run.FailureCount,
+		&resultsJSON,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("批量测试记录不存在")
+		}
+		return nil, err
+	}
+
+	run.StartTime, _ = time.Parse(time.RFC3339, startTimeStr)
+	run.EndTime, _ = time.Parse(time.RFC3339, endTimeStr)
+	if err := json.Unmarshal([]byte(resultsJSON), &run.Results); err != nil {
+		return nil, fmt.Errorf("解析测试结果失败: %v", err)
+	}
+
+	return run, nil
+}
+
+// List 获取最近的批量测试记录（不包含详细结果）
+func (b *BatchTestRunDB) List(limit int) ([]*models.BatchTestRun, error) {
+	query := `
+	SELECT id, subscription_id, subscription_name, status, start_time, end_time, total_count, success_count, failure_count
+	FROM batch_test_runs
+	ORDER BY start_time DESC
+	LIMIT ?`
+
+	rows, err := b.db.DB.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []*models.BatchTestRun
+	for rows.Next() {
+		run := &models.BatchTestRun{}
+		var startTimeStr, endTimeStr string
+		if err := rows.Scan(
+			&run.ID,
+			&run.SubscriptionID,
+			&run.SubscriptionName,
+			&run.Status,
+			&startTimeStr,
+			&endTimeStr,
+			&run.TotalCount,
+			&run.SuccessCount,
+			&run.FailureCount,
+		); err != nil {
+			return nil, err
+		}
+		run.StartTime, _ = time.Parse(time.RFC3339, startTimeStr)
+		run.EndTime, _ = time.Parse(time.RFC3339, endTimeStr)
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
@@ -1,15 +1,18 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/yxhpy/v2ray-subscription-manager/cmd/web-ui/models"
 	"github.com/yxhpy/v2ray-subscription-manager/cmd/web-ui/services"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/report"
 )
 
 // BatchTestManager 批量测试管理器
@@ -231,8 +234,12 @@ func (h *NodeHandler) BatchTestNodesSSE(w http.ResponseWriter, r *http.Request)
 	clientCtx := r.Context()
 	clientConnected := true
 
-	// 进度回调函数
+	// 进度回调函数（同时记录完成事件中的批量测试记录ID）
+	var runID string
 	progressCallback := func(progress *models.BatchTestProgress) {
+		if progress.Type == "complete" {
+			runID = progress.RunID
+		}
 		select {
 		case progressChan <- progress:
 			// 成功发送进度
@@ -295,6 +302,7 @@ func (h *NodeHandler) BatchTestNodesSSE(w http.ResponseWriter, r *http.Request)
 			TotalCount:   len(results),
 			SuccessCount: 0,
 			FailureCount: 0,
+			RunID:        runID,
 		}
 
 		for _, result := range results {
@@ -554,3 +562,61 @@ func (h *NodeHandler) CheckPortConflict(w http.ResponseWriter, r *http.Request)
 	response.SetSuccess(conflictInfo, "端口冲突检查完成")
 	h.writeJSONResponse(w, response)
 }
+
+// GetBatchTestRuns 获取批量测试记录列表
+func (h *NodeHandler) GetBatchTestRuns(w http.ResponseWriter, r *http.Request) {
+	response := models.NewAPIResponse()
+
+	limit := 20
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if value, err := strconv.Atoi(limitStr); err == nil && value > 0 {
+			limit = value
+		}
+	}
+
+	runs, err := h.nodeService.GetBatchTestRuns(limit)
+	if err != nil {
+		response.SetError(err, "获取批量测试记录失败")
+		h.writeJSONResponse(w, response)
+		return
+	}
+
+	response.SetSuccess(runs, "获取批量测试记录成功")
+	h.writeJSONResponse(w, response)
+}
+
+// DownloadBatchTestReport 下载批量测试报告（支持csv/junit/markdown/html/json）
+func (h *NodeHandler) DownloadBatchTestReport(w http.ResponseWriter, r *http.Request) {
+	runID := r.URL.Query().Get("id")
+	if runID == "" {
+		http.Error(w, "缺少批量测试记录ID", http.StatusBadRequest)
+		return
+	}
+
+	formatValue := r.URL.Query().Get("format")
+	if formatValue == "" {
+		formatValue = report.FormatHTML
+	}
+	format, err := report.NormalizeFormat(formatValue)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rep, err := h.nodeService.GetBatchTestReport(runID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := report.Write(&buf, format, rep); err != nil {
+		http.Error(w, fmt.Sprintf("生成报告失败: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("batch_test_%s%s", runID, report.Extension(format))
+	w.Header().Set("Content-Type", report.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Write(buf.Bytes())
+}
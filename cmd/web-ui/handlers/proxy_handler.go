@@ -86,6 +86,13 @@ func (h *ProxyHandler) StopAllConnections(w http.ResponseWriter, r *http.Request
 	h.writeJSONResponse(w, response)
 }
 
+// GetPortLeases 获取端口租约列表
+func (h *ProxyHandler) GetPortLeases(w http.ResponseWriter, r *http.Request) {
+	response := models.NewAPIResponse()
+	response.SetSuccess(h.proxyService.GetPortLeases(), "获取端口租约成功")
+	h.writeJSONResponse(w, response)
+}
+
 // writeJSONResponse 写入JSON响应
 func (h *ProxyHandler) writeJSONResponse(w http.ResponseWriter, response *models.APIResponse) {
 	w.Header().Set("Content-Type", "application/json")
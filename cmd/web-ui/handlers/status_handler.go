@@ -173,3 +173,31 @@ func (h *StatusHandler) SaveSettings(w http.ResponseWriter, r *http.Request) {
 	response.SetSuccess(settings, "系统设置保存成功")
 	h.writeJSONResponse(w, response)
 }
+
+// GetAssetCategories 获取路由规则数据文件中的分类，type 为 geoip 或 geosite（默认）
+func (h *StatusHandler) GetAssetCategories(w http.ResponseWriter, r *http.Request) {
+	response := models.NewAPIResponse()
+
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	asset := r.URL.Query().Get("type")
+	if asset == "" {
+		asset = "geosite"
+	}
+
+	categories, err := h.systemService.GetAssetCategories(asset)
+	if err != nil {
+		response.SetError(err, "获取路由规则分类失败")
+		h.writeJSONResponse(w, response)
+		return
+	}
+
+	response.SetSuccess(map[string]interface{}{
+		"type":       asset,
+		"categories": categories,
+	}, "获取路由规则分类成功")
+	h.writeJSONResponse(w, response)
+}
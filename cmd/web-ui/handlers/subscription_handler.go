@@ -126,6 +126,28 @@ func (h *SubscriptionHandler) TestSubscription(w http.ResponseWriter, r *http.Re
 	h.writeJSONResponse(w, response)
 }
 
+// UpdateFilterRules 更新订阅过滤规则
+func (h *SubscriptionHandler) UpdateFilterRules(w http.ResponseWriter, r *http.Request) {
+	response := models.NewAPIResponse()
+
+	var req models.UpdateFilterRulesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.SetError(err, "请求参数错误")
+		h.writeJSONResponse(w, response)
+		return
+	}
+
+	subscription, err := h.subscriptionService.UpdateFilterRules(req.ID, req.Rules)
+	if err != nil {
+		response.SetError(err, "更新过滤规则失败")
+		h.writeJSONResponse(w, response)
+		return
+	}
+
+	response.SetSuccess(subscription, "过滤规则已保存，重新解析订阅后生效")
+	h.writeJSONResponse(w, response)
+}
+
 // GetSubscriptionNodes 获取订阅的节点列表
 func (h *SubscriptionHandler) GetSubscriptionNodes(w http.ResponseWriter, r *http.Request) {
 	fmt.Printf("DEBUG: GetSubscriptionNodes called with path: %s\n", r.URL.Path)
@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -15,6 +16,9 @@ import (
 	"github.com/yxhpy/v2ray-subscription-manager/cmd/web-ui/database"
 	"github.com/yxhpy/v2ray-subscription-manager/cmd/web-ui/handlers"
 	"github.com/yxhpy/v2ray-subscription-manager/cmd/web-ui/services"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/downloader"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/lifecycle"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/rundir"
 )
 
 // WebUIServer Web UI服务器
@@ -26,6 +30,7 @@ type WebUIServer struct {
 	systemService          services.SystemService
 	templateService        services.TemplateService
 	intelligentProxyService services.IntelligentProxyService
+	subscriptionRefresher   *services.SubscriptionRefresher
 
 	// 处理器层
 	subscriptionHandler      *handlers.SubscriptionHandler
@@ -84,6 +89,10 @@ func (s *WebUIServer) initServices() {
 	// 设置系统服务的服务依赖（用于设置变更时重启）
 	if systemServiceImpl, ok := s.systemService.(*services.SystemServiceImpl); ok {
 		systemServiceImpl.SetServiceDependencies(s.proxyService, s.nodeService)
+
+		// 订阅自动更新，设置变更时重新调度
+		s.subscriptionRefresher = services.NewSubscriptionRefresher(s.subscriptionService)
+		systemServiceImpl.SetSubscriptionRefresher(s.subscriptionRefresher)
 	}
 }
 
@@ -123,11 +132,13 @@ func (s *WebUIServer) setupRoutes() {
 	// API路由 - 最具体的路径先注册
 	http.HandleFunc("/api/status", s.statusHandler.GetStatus)
 	http.HandleFunc("/api/settings", s.handleSettings)
+	http.HandleFunc("/api/assets/categories", s.statusHandler.GetAssetCategories)
 
 	// 订阅管理API - 更具体的路径先注册
 	http.HandleFunc("/api/subscriptions/parse", s.subscriptionHandler.ParseSubscription)
 	http.HandleFunc("/api/subscriptions/delete", s.subscriptionHandler.DeleteSubscription)
 	http.HandleFunc("/api/subscriptions/test", s.subscriptionHandler.TestSubscription)
+	http.HandleFunc("/api/subscriptions/filter", s.subscriptionHandler.UpdateFilterRules)
 	http.HandleFunc("/api/subscriptions/", s.handleSubscriptionDetails)
 	http.HandleFunc("/api/subscriptions", s.handleSubscriptions)
 
@@ -135,6 +146,8 @@ func (s *WebUIServer) setupRoutes() {
 	http.HandleFunc("/api/nodes/batch-test-sse", s.nodeHandler.BatchTestNodesSSE)
 	http.HandleFunc("/api/nodes/batch-test", s.nodeHandler.BatchTestNodes)
 	http.HandleFunc("/api/nodes/cancel-batch-test", s.nodeHandler.CancelBatchTest)
+	http.HandleFunc("/api/nodes/batch-test-runs", s.nodeHandler.GetBatchTestRuns)
+	http.HandleFunc("/api/nodes/batch-test-report", s.nodeHandler.DownloadBatchTestReport)
 	http.HandleFunc("/api/nodes/delete", s.nodeHandler.DeleteNodes)
 	http.HandleFunc("/api/nodes/connect", s.nodeHandler.ConnectNode)
 	http.HandleFunc("/api/nodes/test", s.nodeHandler.TestNode)
@@ -146,6 +159,7 @@ func (s *WebUIServer) setupRoutes() {
 	http.HandleFunc("/api/proxy/stop", s.proxyHandler.StopProxy)
 	http.HandleFunc("/api/proxy/connections", s.proxyHandler.GetActiveConnections)
 	http.HandleFunc("/api/proxy/stop-all", s.proxyHandler.StopAllConnections)
+	http.HandleFunc("/api/proxy/ports", s.proxyHandler.GetPortLeases)
 
 	// 智能代理API - 注册智能代理路由
 	s.intelligentProxyHandler.RegisterRoutes(http.DefaultServeMux)
@@ -204,6 +218,10 @@ func (s *WebUIServer) handleSettings(w http.ResponseWriter, r *http.Request) {
 func (s *WebUIServer) Start() error {
 	s.setupRoutes()
 
+	if s.subscriptionRefresher != nil {
+		s.subscriptionRefresher.Start()
+	}
+
 	fmt.Printf("🚀 Web UI服务器启动成功！\n")
 	fmt.Printf("📱 访问地址: http://localhost%s\n", s.port)
 	fmt.Printf("📝 管理界面: http://localhost%s\n", s.port)
@@ -233,6 +251,11 @@ func (s *WebUIServer) Shutdown(ctx context.Context) error {
 func (s *WebUIServer) cleanup() {
 	fmt.Printf("🧹 正在清理系统资源...\n")
 	
+	// 停止订阅自动更新
+	if s.subscriptionRefresher != nil {
+		s.subscriptionRefresher.Stop()
+	}
+	
 	// 停止智能代理服务
 	if s.intelligentProxyService != nil {
 		fmt.Printf("🤖 停止智能代理服务...\n")
@@ -268,52 +291,13 @@ func (s *WebUIServer) cleanup() {
 	fmt.Printf("✅ 资源清理完成\n")
 }
 
-// cleanupTempFiles 清理临时文件
+// cleanupTempFiles 清理临时文件：测试用的核心配置都写在本进程的会话目录中，整个删除即可
 func (s *WebUIServer) cleanupTempFiles() {
-	fmt.Printf("🗑️ 开始清理临时文件...\n")
-	
-	// 清理V2Ray临时配置文件
-	tempFiles := []string{
-		"temp_v2ray_config_*.json",
-		"test_v2ray_config_*.json", 
-		"temp_*.yaml",
-		"test_config_*.yaml",
-		"temp_*.json",
-		"test_*.json",
-		"*.tmp",
-		"*.temp",
-		"auto_proxy_*.json",
-		"proxy_state.json",
-		"valid_nodes.json",
-		"mvp_best_node.json",
-	}
-	
-	totalCleaned := 0
-	for _, pattern := range tempFiles {
-		matches, err := filepath.Glob(pattern)
-		if err != nil {
-			fmt.Printf("⚠️ 匹配模式失败 %s: %v\n", pattern, err)
-			continue
-		}
-		
-		for _, file := range matches {
-			// 跳过测试脚本和正常文件
-			if strings.Contains(file, "test_all_features.sh") || 
-			   strings.Contains(file, "test_batch_cancel") ||
-			   strings.Contains(file, "test_frontend.html") {
-				continue
-			}
-			
-			if err := os.Remove(file); err == nil {
-				fmt.Printf("🗑️ 已删除临时文件: %s\n", file)
-				totalCleaned++
-			} else {
-				fmt.Printf("⚠️ 删除文件失败 %s: %v\n", file, err)
-			}
-		}
+	if err := rundir.CleanupSession(); err != nil {
+		fmt.Printf("⚠️ 删除会话目录失败: %v\n", err)
+		return
 	}
-	
-	fmt.Printf("✅ 临时文件清理完成，共清理 %d 个文件\n", totalCleaned)
+	fmt.Printf("✅ 临时文件清理完成\n")
 }
 
 // setupSignalHandler 设置信号处理器
@@ -353,6 +337,10 @@ func (s *WebUIServer) setupSignalHandler() {
 func (s *WebUIServer) validateAndCleanupOnStartup() {
 	fmt.Printf("🔍 正在验证系统状态...\n")
 	
+	// 回收上次崩溃遗留的核心进程（只处理本程序登记过的进程）
+	lifecycle.DefaultRegistry().ReapOrphans(context.Background())
+	rundir.ReapSessions()
+	
 	// 检查实际进程状态
 	v2rayRunning := s.checkV2RayProcess()
 	hysteria2Running := s.checkHysteria2Process()
@@ -371,16 +359,23 @@ func (s *WebUIServer) validateAndCleanupOnStartup() {
 	fmt.Printf("✅ 系统状态验证完成\n")
 }
 
-// checkV2RayProcess 检查V2Ray进程是否真实运行
+// checkV2RayProcess 检查进程登记表中是否有仍在运行的V2Ray进程
 func (s *WebUIServer) checkV2RayProcess() bool {
-	// TODO: 实现进程检查逻辑
-	// 这里可以通过检查进程名、PID文件或端口占用来判断
-	return false
+	return hasRegisteredProcess("v2ray")
 }
 
-// checkHysteria2Process 检查Hysteria2进程是否真实运行
+// checkHysteria2Process 检查进程登记表中是否有仍在运行的Hysteria2进程
 func (s *WebUIServer) checkHysteria2Process() bool {
-	// TODO: 实现进程检查逻辑
+	return hasRegisteredProcess("hysteria")
+}
+
+// hasRegisteredProcess 进程登记表中是否有指定核心的存活进程
+func hasRegisteredProcess(name string) bool {
+	for _, record := range lifecycle.DefaultRegistry().Records() {
+		if record.Name() == name && record.Alive() {
+			return true
+		}
+	}
 	return false
 }
 
@@ -463,7 +458,25 @@ func main() {
 		log.Fatalf("获取工作目录失败: %v", err)
 	}
 
+	// --runtime-dir=目录：临时配置和进程登记的存放位置，与命令行工具的同名选项一致
+	for _, arg := range os.Args[1:] {
+		if strings.HasPrefix(arg, "--runtime-dir=") {
+			if err := rundir.Set(strings.TrimPrefix(arg, "--runtime-dir=")); err != nil {
+				log.Fatalf("%v", err)
+			}
+		}
+	}
+	if err := rundir.Ensure(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// 与命令行工具的档案键 allow_unverified_cores 对应：允许启动没有安装时摘要记录的核心
+	if allow, err := strconv.ParseBool(os.Getenv("V2RAY_MANAGER_ALLOW_UNVERIFIED_CORES")); err == nil {
+		downloader.SetAllowUnverified(allow)
+	}
+
 	fmt.Printf("📁 工作目录: %s\n", workDir)
+	fmt.Printf("📂 运行目录: %s\n", rundir.Dir())
 	fmt.Printf("🌟 V2Ray 订阅管理器 Web UI\n")
 	fmt.Printf("🔧 版本: v1.0.0\n")
 
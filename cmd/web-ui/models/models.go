@@ -25,6 +25,8 @@ type Subscription struct {
 	LastUpdate string      `json:"last_update"`
 	Status     string      `json:"status"`
 	CreateTime string      `json:"create_time"`
+
+	FilterRules string `json:"filter_rules"` // 节点过滤与重命名规则
 }
 
 // NodeInfo 包含状态信息的节点
@@ -49,6 +51,29 @@ type NodeTestResult struct {
 	Error    string    `json:"error,omitempty"`
 	TestTime time.Time `json:"test_time"`
 	TestType string    `json:"test_type"` // tcp, http, full
+
+	// 批量测试时记录的节点信息，用于生成报告
+	NodeIndex int    `json:"node_index,omitempty"`
+	Protocol  string `json:"protocol,omitempty"`
+	Server    string `json:"server,omitempty"`
+	Port      string `json:"port,omitempty"`
+
+	// 代理链节点每一跳的状态（前置节点、出口节点）
+	Hops []types.HopResult `json:"hops,omitempty"`
+}
+
+// BatchTestRun 批量测试记录
+type BatchTestRun struct {
+	ID               string            `json:"id"`
+	SubscriptionID   string            `json:"subscription_id"`
+	SubscriptionName string            `json:"subscription_name"`
+	Status           string            `json:"status"` // completed, cancelled
+	StartTime        time.Time         `json:"start_time"`
+	EndTime          time.Time         `json:"end_time"`
+	TotalCount       int               `json:"total_count"`
+	SuccessCount     int               `json:"success_count"`
+	FailureCount     int               `json:"failure_count"`
+	Results          []*NodeTestResult `json:"results,omitempty"`
 }
 
 // SpeedTestResult 速度测试结果
@@ -94,6 +119,12 @@ type ParseSubscriptionRequest struct {
 	ID string `json:"id"`
 }
 
+// UpdateFilterRulesRequest 更新订阅过滤规则请求
+type UpdateFilterRulesRequest struct {
+	ID    string `json:"id"`
+	Rules string `json:"rules"`
+}
+
 // DeleteSubscriptionRequest 删除订阅请求
 type DeleteSubscriptionRequest struct {
 	ID string `json:"id"`
@@ -143,6 +174,7 @@ type BatchTestResponse struct {
 	SuccessCount int               `json:"success_count"`
 	FailureCount int               `json:"failure_count"`
 	TotalCount   int               `json:"total_count"`
+	RunID        string            `json:"run_id,omitempty"` // 批量测试记录ID，可用于下载报告
 }
 
 // Settings 系统设置
@@ -160,9 +192,14 @@ type Settings struct {
 	
 	// 订阅设置
 	UpdateInterval   int    `json:"update_interval"`
+	UpdateSchedule   string `json:"update_schedule"` // 调度表达式（如 0 3 * * *），设置后覆盖更新间隔
 	UserAgent        string `json:"user_agent"`
 	AutoTestNewNodes bool   `json:"auto_test_new_nodes"`
 	
+	// 代理链设置
+	FrontNode  string `json:"front_node"`  // 前置节点分享链接，为空时不使用代理链
+	ChainNodes string `json:"chain_nodes"` // 经由前置节点的出口节点规则（每行一个正则，匹配名称或服务器），为空时所有节点
+	
 	// 安全设置
 	EnableLogs    bool   `json:"enable_logs"`
 	LogLevel      string `json:"log_level"`
@@ -185,17 +222,18 @@ type ActiveConnection struct {
 
 // BatchTestProgress 批量测试进度
 type BatchTestProgress struct {
-	Type          string          `json:"type"`           // 事件类型: start, progress, complete, error
-	Message       string          `json:"message"`        // 状态消息
-	NodeIndex     int             `json:"node_index"`     // 当前测试的节点索引
-	NodeName      string          `json:"node_name"`      // 节点名称
-	Progress      int             `json:"progress"`       // 进度百分比
-	Total         int             `json:"total"`          // 总节点数
-	Completed     int             `json:"completed"`      // 已完成数
-	SuccessCount  int             `json:"success_count"`  // 成功数
-	FailureCount  int             `json:"failure_count"`  // 失败数
-	CurrentResult *NodeTestResult `json:"current_result"` // 当前节点测试结果
-	Timestamp     string          `json:"timestamp"`      // 时间戳
+	Type          string          `json:"type"`             // 事件类型: start, progress, complete, error
+	Message       string          `json:"message"`          // 状态消息
+	NodeIndex     int             `json:"node_index"`       // 当前测试的节点索引
+	NodeName      string          `json:"node_name"`        // 节点名称
+	Progress      int             `json:"progress"`         // 进度百分比
+	Total         int             `json:"total"`            // 总节点数
+	Completed     int             `json:"completed"`        // 已完成数
+	SuccessCount  int             `json:"success_count"`    // 成功数
+	FailureCount  int             `json:"failure_count"`    // 失败数
+	CurrentResult *NodeTestResult `json:"current_result"`   // 当前节点测试结果
+	Timestamp     string          `json:"timestamp"`        // 时间戳
+	RunID         string          `json:"run_id,omitempty"` // 批量测试记录ID（complete/cancelled事件）
 }
 
 // NewAPIResponse 创建API响应
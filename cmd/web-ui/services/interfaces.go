@@ -4,6 +4,9 @@ import (
 	"context"
 
 	"github.com/yxhpy/v2ray-subscription-manager/cmd/web-ui/models"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/downloader"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/proxy"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/report"
 	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
 )
 
@@ -26,6 +29,8 @@ type SubscriptionService interface {
 	UpdateSubscription(subscription *models.Subscription) error
 	// 测试订阅
 	TestSubscription(id string) ([]*models.NodeTestResult, error)
+	// 更新订阅过滤规则
+	UpdateFilterRules(id, rules string) (*models.Subscription, error)
 	// 关闭服务，释放资源
 	Close() error
 }
@@ -50,6 +55,10 @@ type NodeService interface {
 	StopAllNodeConnections() error
 	// 检查端口冲突
 	CheckPortConflict(port int) (*models.PortConflictInfo, error)
+	// 获取批量测试记录
+	GetBatchTestRuns(limit int) ([]*models.BatchTestRun, error)
+	// 获取批量测试报告
+	GetBatchTestReport(runID string) (*report.Report, error)
 }
 
 // ProxyService 代理服务接口
@@ -70,6 +79,8 @@ type ProxyService interface {
 	SetFixedPorts(httpPort, socksPort int)
 	// 停止所有连接
 	StopAllConnections() error
+	// 获取端口租约
+	GetPortLeases() []proxy.PortLease
 }
 
 // SystemService 系统服务接口
@@ -80,6 +91,8 @@ type SystemService interface {
 	GetSettings() (*models.Settings, error)
 	// 保存设置
 	SaveSettings(settings *models.Settings) error
+	// 获取路由规则数据文件（geoip/geosite）中的分类
+	GetAssetCategories(asset string) ([]downloader.AssetCategory, error)
 }
 
 // TemplateService 模板服务接口
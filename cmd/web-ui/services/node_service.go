@@ -5,18 +5,18 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/yxhpy/v2ray-subscription-manager/cmd/web-ui/database"
 	"github.com/yxhpy/v2ray-subscription-manager/cmd/web-ui/models"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/blacklist"
 	"github.com/yxhpy/v2ray-subscription-manager/internal/core/proxy"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/report"
 	"github.com/yxhpy/v2ray-subscription-manager/internal/core/workflow"
 	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
 )
@@ -30,6 +30,7 @@ type NodeServiceImpl struct {
 	// 数据库操作
 	nodeDB         *database.NodeDB
 	testResultDB   *database.TestResultDB
+	batchTestRunDB *database.BatchTestRunDB
 
 	// 节点连接管理 - 每个连接独立的代理管理器
 	nodeConnections map[string]*NodeConnection // key: subscriptionID_nodeIndex
@@ -42,9 +43,12 @@ type NodeServiceImpl struct {
 	// MVP测试器
 	mvpTester *workflow.MVPTester
 
-	// 端口分配计数器（用于批量测试时避免端口冲突）
-	portCounter int64
-	
+	// 节点黑名单（与命令行工具共用）
+	blacklist *blacklist.Blacklist
+
+	// 代理链前置节点的健康检查，区分链式节点每一跳的状态
+	frontProbe *workflow.FrontProbe
+
 	// 测试配置缓存
 	testTimeout   time.Duration
 	maxConcurrent int
@@ -72,9 +76,11 @@ func NewNodeService(subscriptionService SubscriptionService, proxyService ProxyS
 		proxyService:        proxyService,
 		nodeDB:              database.NewNodeDB(db),
 		testResultDB:        database.NewTestResultDB(db),
+		batchTestRunDB:      database.NewBatchTestRunDB(db),
 		nodeConnections:     make(map[string]*NodeConnection),
 		nodeStates:          make(map[string]*models.NodeInfo),
-		portCounter:         9000, // 测试端口从9000开始
+		blacklist:           blacklist.New(blacklist.DefaultFile()),
+		frontProbe:          workflow.NewFrontProbe(),
 		// 默认测试配置
 		testTimeout:   30 * time.Second,
 		maxConcurrent: 3,
@@ -99,9 +105,11 @@ func NewNodeServiceWithSystemService(subscriptionService SubscriptionService, pr
 		systemService:       systemService,
 		nodeDB:              database.NewNodeDB(db),
 		testResultDB:        database.NewTestResultDB(db),
+		batchTestRunDB:      database.NewBatchTestRunDB(db),
 		nodeConnections:     make(map[string]*NodeConnection),
 		nodeStates:          make(map[string]*models.NodeInfo),
-		portCounter:         9000, // 测试端口从9000开始
+		blacklist:           blacklist.New(blacklist.DefaultFile()),
+		frontProbe:          workflow.NewFrontProbe(),
 		// 默认测试配置
 		testTimeout:   30 * time.Second,
 		maxConcurrent: 3,
@@ -207,6 +215,11 @@ func (n *NodeServiceImpl) ConnectNode(subscriptionID string, nodeIndex int, oper
 	}
 
 	nodeInfo := subscription.Nodes[nodeIndex]
+	if operation != "disable" {
+		if err := n.checkBlacklist(nodeInfo.Node); err != nil {
+			return nil, err
+		}
+	}
 	response := &models.ConnectNodeResponse{}
 
 	// 确保节点状态存在
@@ -311,6 +324,9 @@ func (n *NodeServiceImpl) TestNode(subscriptionID string, nodeIndex int) (*model
 	}
 
 	nodeInfo := subscription.Nodes[nodeIndex]
+	if err := n.checkBlacklist(nodeInfo.Node); err != nil {
+		return nil, err
+	}
 
 	// 确保节点状态存在
 	n.ensureNodeState(subscriptionID, nodeIndex, nodeInfo)
@@ -337,13 +353,7 @@ func (n *NodeServiceImpl) TestNode(subscriptionID string, nodeIndex int) (*model
 			time.Sleep(1 * time.Second) // 重试间隔
 		}
 		
-		if nodeInfo.Protocol == "hysteria2" {
-			// 测试Hysteria2节点
-			testErr = n.testHysteria2Node(nodeInfo.Node)
-		} else {
-			// 测试V2Ray节点
-			testErr = n.testV2RayNode(nodeInfo.Node)
-		}
+		testErr = n.testNodeConnection(nodeInfo.Node)
 		
 		// 如果测试成功，跳出重试循环
 		if testErr == nil {
@@ -356,9 +366,16 @@ func (n *NodeServiceImpl) TestNode(subscriptionID string, nodeIndex int) (*model
 
 	latency := time.Since(startTime)
 
+	// 代理链节点测试的是完整路径，另外测试前置节点以区分每一跳的状态
+	errMsg := ""
+	if testErr != nil {
+		errMsg = testErr.Error()
+	}
+	result.Hops, errMsg = n.frontProbe.Hops(nodeInfo.Node, testErr == nil, latency.Milliseconds(), errMsg, n.probeFrontNode)
+
 	if testErr != nil {
 		result.Success = false
-		result.Error = testErr.Error()
+		result.Error = errMsg
 		n.updateNodeStatus(subscriptionID, nodeIndex, "error")
 	} else {
 		result.Success = true
@@ -391,6 +408,9 @@ func (n *NodeServiceImpl) SpeedTestNode(subscriptionID string, nodeIndex int) (*
 	}
 
 	nodeInfo := subscription.Nodes[nodeIndex]
+	if err := n.checkBlacklist(nodeInfo.Node); err != nil {
+		return nil, err
+	}
 
 	// 确保节点状态存在
 	n.ensureNodeState(subscriptionID, nodeIndex, nodeInfo)
@@ -410,7 +430,7 @@ func (n *NodeServiceImpl) SpeedTestNode(subscriptionID string, nodeIndex int) (*
 	var testErr error
 	var downloadSpeed, uploadSpeed, latency float64
 
-	if nodeInfo.Protocol == "hysteria2" {
+	if proxy.UsesHysteria2Client(nodeInfo.Node) {
 		downloadSpeed, uploadSpeed, latency, testErr = n.speedTestHysteria2Node(nodeInfo.Node)
 	} else {
 		downloadSpeed, uploadSpeed, latency, testErr = n.speedTestV2RayNode(nodeInfo.Node)
@@ -470,6 +490,7 @@ func (n *NodeServiceImpl) BatchTestNodesWithProgress(subscriptionID string, node
 	results := make([]*models.NodeTestResult, 0, total)
 	successCount := 0
 	failureCount := 0
+	startTime := time.Now()
 
 	// 发送开始事件
 	if callback != nil {
@@ -483,8 +504,8 @@ func (n *NodeServiceImpl) BatchTestNodesWithProgress(subscriptionID string, node
 		})
 	}
 
-	// 使用信号量控制并发数（使用系统设置）
-	semaphore := make(chan struct{}, n.maxConcurrent)
+	// 自适应并发控制（系统设置的并发数为上限）
+	limiter := workflow.NewAdaptiveLimiter(0, n.maxConcurrent)
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 
@@ -499,11 +520,12 @@ func (n *NodeServiceImpl) BatchTestNodesWithProgress(subscriptionID string, node
 					fmt.Printf("ERROR: 节点测试 goroutine panic: %v\n", r)
 					mu.Lock()
 					result := &models.NodeTestResult{
-						NodeName: fmt.Sprintf("节点 %d", nodeIndex),
-						Success:  false,
-						Error:    fmt.Sprintf("测试过程中发生内部错误: %v", r),
-						TestTime: time.Now(),
-						TestType: "batch",
+						NodeName:  fmt.Sprintf("节点 %d", nodeIndex),
+						NodeIndex: nodeIndex,
+						Success:   false,
+						Error:     fmt.Sprintf("测试过程中发生内部错误: %v", r),
+						TestTime:  time.Now(),
+						TestType:  "batch",
 					}
 					results = append(results, result)
 					completed++
@@ -512,9 +534,14 @@ func (n *NodeServiceImpl) BatchTestNodesWithProgress(subscriptionID string, node
 				}
 			}()
 
-			// 获取信号量
-			semaphore <- struct{}{}
-			defer func() { <-semaphore }()
+			// 获取并发槽位
+			if err := limiter.Acquire(context.Background()); err != nil {
+				fmt.Printf("ERROR: 节点 %d 获取并发槽位失败: %v\n", nodeIndex, err)
+				return
+			}
+			var latencySample time.Duration
+			var timedOut bool
+			defer func() { limiter.Release(latencySample, timedOut) }()
 
 			// 任务间延迟
 			if idx > 0 {
@@ -525,11 +552,12 @@ func (n *NodeServiceImpl) BatchTestNodesWithProgress(subscriptionID string, node
 			if nodeIndex < 0 || nodeIndex >= len(subscription.Nodes) {
 				mu.Lock()
 				result := &models.NodeTestResult{
-					NodeName: fmt.Sprintf("节点 %d", nodeIndex),
-					Success:  false,
-					Error:    "节点索引无效",
-					TestTime: time.Now(),
-					TestType: "batch",
+					NodeName:  fmt.Sprintf("节点 %d", nodeIndex),
+					NodeIndex: nodeIndex,
+					Success:   false,
+					Error:     "节点索引无效",
+					TestTime:  time.Now(),
+					TestType:  "batch",
 				}
 				results = append(results, result)
 				completed++
@@ -589,6 +617,7 @@ func (n *NodeServiceImpl) BatchTestNodesWithProgress(subscriptionID string, node
 			var result *models.NodeTestResult
 			var err error
 
+			testStart := time.Now()
 			testDone := make(chan struct{})
 			go func() {
 				defer func() {
@@ -610,6 +639,14 @@ func (n *NodeServiceImpl) BatchTestNodesWithProgress(subscriptionID string, node
 				fmt.Printf("WARNING: 节点 %d 测试超时\n", nodeIndex)
 			}
 
+			if err != nil {
+				timedOut = workflow.IsTimeoutMessage(err.Error())
+			} else if result.Success {
+				latencySample = time.Since(testStart)
+			} else {
+				timedOut = workflow.IsTimeoutMessage(result.Error)
+			}
+
 			mu.Lock()
 			defer mu.Unlock()
 
@@ -630,6 +667,7 @@ func (n *NodeServiceImpl) BatchTestNodesWithProgress(subscriptionID string, node
 				}
 			}
 
+			annotateBatchResult(result, nodeIndex, node.Node)
 			results = append(results, result)
 			completed++
 
@@ -668,6 +706,8 @@ func (n *NodeServiceImpl) BatchTestNodesWithProgress(subscriptionID string, node
 
 	wg.Wait()
 
+	runID := n.saveBatchTestRun(subscription, "completed", startTime, results, successCount, failureCount)
+
 	// 发送完成事件
 	if callback != nil {
 		func() {
@@ -684,6 +724,7 @@ func (n *NodeServiceImpl) BatchTestNodesWithProgress(subscriptionID string, node
 				Completed:    completed,
 				SuccessCount: successCount,
 				FailureCount: failureCount,
+				RunID:        runID,
 				Timestamp:    time.Now().Format("2006-01-02 15:04:05"),
 			})
 		}()
@@ -722,6 +763,7 @@ func (n *NodeServiceImpl) BatchTestNodesWithProgressAndContext(ctx context.Conte
 	results := make([]*models.NodeTestResult, 0, total)
 	successCount := 0
 	failureCount := 0
+	startTime := time.Now()
 
 	// 发送开始事件
 	if callback != nil {
@@ -742,8 +784,8 @@ func (n *NodeServiceImpl) BatchTestNodesWithProgressAndContext(ctx context.Conte
 	default:
 	}
 
-	// 使用信号量控制并发数（使用系统设置）
-	semaphore := make(chan struct{}, n.maxConcurrent)
+	// 自适应并发控制（系统设置的并发数为上限）
+	limiter := workflow.NewAdaptiveLimiter(0, n.maxConcurrent)
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 
@@ -774,11 +816,12 @@ func (n *NodeServiceImpl) BatchTestNodesWithProgressAndContext(ctx context.Conte
 					fmt.Printf("ERROR: 节点测试 goroutine panic: %v\n", r)
 					mu.Lock()
 					result := &models.NodeTestResult{
-						NodeName: fmt.Sprintf("节点 %d", nodeIndex),
-						Success:  false,
-						Error:    fmt.Sprintf("测试过程中发生内部错误: %v", r),
-						TestTime: time.Now(),
-						TestType: "batch",
+						NodeName:  fmt.Sprintf("节点 %d", nodeIndex),
+						NodeIndex: nodeIndex,
+						Success:   false,
+						Error:     fmt.Sprintf("测试过程中发生内部错误: %v", r),
+						TestTime:  time.Now(),
+						TestType:  "batch",
 					}
 					results = append(results, result)
 					completed++
@@ -787,14 +830,14 @@ func (n *NodeServiceImpl) BatchTestNodesWithProgressAndContext(ctx context.Conte
 				}
 			}()
 
-			// 获取信号量
-			select {
-			case semaphore <- struct{}{}:
-			case <-ctx.Done():
+			// 获取并发槽位
+			if err := limiter.Acquire(ctx); err != nil {
 				fmt.Printf("DEBUG: 节点 %d 测试被取消（获取信号量时）\n", nodeIndex)
 				return
 			}
-			defer func() { <-semaphore }()
+			var latencySample time.Duration
+			var timedOut bool
+			defer func() { limiter.Release(latencySample, timedOut) }()
 
 			// 任务间延迟
 			if idx > 0 {
@@ -810,11 +853,12 @@ func (n *NodeServiceImpl) BatchTestNodesWithProgressAndContext(ctx context.Conte
 			if nodeIndex < 0 || nodeIndex >= len(subscription.Nodes) {
 				mu.Lock()
 				result := &models.NodeTestResult{
-					NodeName: fmt.Sprintf("节点 %d", nodeIndex),
-					Success:  false,
-					Error:    "节点索引无效",
-					TestTime: time.Now(),
-					TestType: "batch",
+					NodeName:  fmt.Sprintf("节点 %d", nodeIndex),
+					NodeIndex: nodeIndex,
+					Success:   false,
+					Error:     "节点索引无效",
+					TestTime:  time.Now(),
+					TestType:  "batch",
 				}
 				results = append(results, result)
 				completed++
@@ -882,6 +926,7 @@ func (n *NodeServiceImpl) BatchTestNodesWithProgressAndContext(ctx context.Conte
 			var result *models.NodeTestResult
 			var err error
 
+			testStart := time.Now()
 			testDone := make(chan struct{})
 			go func() {
 				defer func() {
@@ -906,6 +951,14 @@ func (n *NodeServiceImpl) BatchTestNodesWithProgressAndContext(ctx context.Conte
 				fmt.Printf("DEBUG: 节点 %d 测试被取消\n", nodeIndex)
 			}
 
+			if err != nil {
+				timedOut = workflow.IsTimeoutMessage(err.Error())
+			} else if result.Success {
+				latencySample = time.Since(testStart)
+			} else {
+				timedOut = workflow.IsTimeoutMessage(result.Error)
+			}
+
 			mu.Lock()
 			defer mu.Unlock()
 
@@ -926,6 +979,7 @@ func (n *NodeServiceImpl) BatchTestNodesWithProgressAndContext(ctx context.Conte
 				}
 			}
 
+			annotateBatchResult(result, nodeIndex, node.Node)
 			results = append(results, result)
 			completed++
 
@@ -968,6 +1022,7 @@ func (n *NodeServiceImpl) BatchTestNodesWithProgressAndContext(ctx context.Conte
 	select {
 	case <-ctx.Done():
 		fmt.Printf("DEBUG: 批量测试被取消，但已完成的测试结果仍会返回\n")
+		runID := n.saveBatchTestRun(subscription, "cancelled", startTime, results, successCount, failureCount)
 		// 发送取消事件
 		if callback != nil {
 			func() {
@@ -984,12 +1039,15 @@ func (n *NodeServiceImpl) BatchTestNodesWithProgressAndContext(ctx context.Conte
 					Completed:    completed,
 					SuccessCount: successCount,
 					FailureCount: failureCount,
+					RunID:        runID,
 					Timestamp:    time.Now().Format("2006-01-02 15:04:05"),
 				})
 			}()
 		}
 		return results, fmt.Errorf("批量测试被取消")
 	default:
+		runID := n.saveBatchTestRun(subscription, "completed", startTime, results, successCount, failureCount)
+
 		// 发送完成事件
 		if callback != nil {
 			func() {
@@ -1006,6 +1064,7 @@ func (n *NodeServiceImpl) BatchTestNodesWithProgressAndContext(ctx context.Conte
 					Completed:    completed,
 					SuccessCount: successCount,
 					FailureCount: failureCount,
+					RunID:        runID,
 					Timestamp:    time.Now().Format("2006-01-02 15:04:05"),
 				})
 			}()
@@ -1015,6 +1074,96 @@ func (n *NodeServiceImpl) BatchTestNodesWithProgressAndContext(ctx context.Conte
 	return results, nil
 }
 
+// checkBlacklist 检查节点是否被黑名单封禁
+func (n *NodeServiceImpl) checkBlacklist(node *types.Node) error {
+	if blocked, entry := n.blacklist.Check(node); blocked {
+		return fmt.Errorf("节点在黑名单中: %s (%s)", entry.Value, entry.Reason)
+	}
+	return nil
+}
+
+// annotateBatchResult 为批量测试结果补充节点信息，便于生成报告
+func annotateBatchResult(result *models.NodeTestResult, nodeIndex int, node *types.Node) {
+	result.NodeIndex = nodeIndex
+	result.Protocol = node.Protocol
+	result.Server = node.Server
+	result.Port = node.Port
+}
+
+// saveBatchTestRun 保存批量测试记录，返回记录ID（保存失败时返回空字符串）
+func (n *NodeServiceImpl) saveBatchTestRun(subscription *models.Subscription, status string, startTime time.Time, results []*models.NodeTestResult, successCount, failureCount int) string {
+	if n.batchTestRunDB == nil || len(results) == 0 {
+		return ""
+	}
+
+	run := &models.BatchTestRun{
+		ID:               fmt.Sprintf("%d", startTime.UnixNano()),
+		SubscriptionID:   subscription.ID,
+		SubscriptionName: subscription.Name,
+		Status:           status,
+		StartTime:        startTime,
+		EndTime:          time.Now(),
+		TotalCount:       len(results),
+		SuccessCount:     successCount,
+		FailureCount:     failureCount,
+		Results:          results,
+	}
+
+	if err := n.batchTestRunDB.Create(run); err != nil {
+		fmt.Printf("⚠️ 保存批量测试记录失败: %v\n", err)
+		return ""
+	}
+	return run.ID
+}
+
+// GetBatchTestRuns 获取最近的批量测试记录
+func (n *NodeServiceImpl) GetBatchTestRuns(limit int) ([]*models.BatchTestRun, error) {
+	if limit <= 0 {
+		limit = 20
+	}
+	return n.batchTestRunDB.List(limit)
+}
+
+// GetBatchTestReport 根据批量测试记录生成报告数据
+func (n *NodeServiceImpl) GetBatchTestReport(runID string) (*report.Report, error) {
+	run, err := n.batchTestRunDB.GetByID(runID)
+	if err != nil {
+		return nil, fmt.Errorf("获取批量测试记录失败: %v", err)
+	}
+
+	rep := &report.Report{
+		Title:     fmt.Sprintf("批量测试报告 - %s", run.SubscriptionName),
+		StartTime: run.StartTime,
+		EndTime:   run.EndTime,
+	}
+	for _, result := range run.Results {
+		entry := report.Entry{
+			Name:     result.NodeName,
+			Protocol: result.Protocol,
+			Server:   result.Server,
+			Port:     result.Port,
+			Success:  result.Success,
+			Error:    result.Error,
+			TestTime: result.TestTime,
+			Hops:     result.Hops,
+		}
+		if result.Success {
+			entry.LatencyMs = parseLatencyMs(result.Latency)
+		}
+		rep.Entries = append(rep.Entries, entry)
+	}
+	return rep, nil
+}
+
+// parseLatencyMs 解析形如"123ms"或"1.2s"的延迟字符串为毫秒
+func parseLatencyMs(latency string) int64 {
+	duration, err := time.ParseDuration(strings.TrimSpace(latency))
+	if err != nil {
+		return 0
+	}
+	return duration.Milliseconds()
+}
+
 // startProxyForNode 为节点启动代理
 func (n *NodeServiceImpl) startProxyForNode(node *types.Node, httpPort, socksPort int) (int, int, error) {
 	// 为每个连接创建新的代理管理器实例，确保端口独立分配
@@ -1032,7 +1181,7 @@ func (n *NodeServiceImpl) startProxyForNode(node *types.Node, httpPort, socksPor
 	var err error
 	var actualHTTPPort, actualSOCKSPort int
 
-	if node.Protocol == "hysteria2" {
+	if proxy.UsesHysteria2Client(node) {
 		// 启动Hysteria2代理
 		err = hysteria2Manager.StartHysteria2Proxy(node)
 		if err != nil {
@@ -1082,7 +1231,7 @@ func (n *NodeServiceImpl) startProxyForNodeWithConnection(subscriptionID string,
 	var actualHTTPPort, actualSOCKSPort int
 
 	fmt.Printf("DEBUG: 启动%s代理\n", node.Protocol)
-	if node.Protocol == "hysteria2" {
+	if proxy.UsesHysteria2Client(node) {
 		// 启动Hysteria2代理
 		err = hysteria2Manager.StartHysteria2Proxy(node)
 		if err != nil {
@@ -1253,22 +1402,31 @@ func (n *NodeServiceImpl) stopProxyForNode(node *types.Node) error {
 	return nil
 }
 
+// testNodeConnection 按节点使用的核心选择测试方式
+func (n *NodeServiceImpl) testNodeConnection(node *types.Node) error {
+	if proxy.UsesHysteria2Client(node) {
+		return n.testHysteria2Node(node)
+	}
+	return n.testV2RayNode(node)
+}
+
+// probeFrontNode 测试代理链的前置节点，返回延迟（毫秒）
+func (n *NodeServiceImpl) probeFrontNode(front *types.Node) (int64, error) {
+	start := time.Now()
+	if err := n.testNodeConnection(front); err != nil {
+		return 0, err
+	}
+	return time.Since(start).Milliseconds(), nil
+}
+
 // testV2RayNode 测试V2Ray节点
 func (n *NodeServiceImpl) testV2RayNode(node *types.Node) error {
-	// 获取唯一端口号，增加更大的间隔避免冲突
-	portBase := int(atomic.AddInt64(&n.portCounter, 20))
-	httpPort := portBase
-	socksPort := portBase + 1
-
-	// 确保端口可用
-	for i := 0; i < 10; i++ {
-		if n.isPortAvailable(httpPort) && n.isPortAvailable(socksPort) {
-			break
-		}
-		portBase = int(atomic.AddInt64(&n.portCounter, 20))
-		httpPort = portBase
-		socksPort = portBase + 1
+	// 从共享端口分配器租用测试端口，代理进程退出时租约自动释放
+	httpPort, socksPort, release, err := acquireTestPorts()
+	if err != nil {
+		return err
 	}
+	defer release()
 
 	// 创建临时测试专用代理管理器，确保配置文件独立
 	tempManager := proxy.NewTestProxyManager()
@@ -1276,19 +1434,14 @@ func (n *NodeServiceImpl) testV2RayNode(node *types.Node) error {
 	defer func() {
 		// 确保清理代理
 		tempManager.StopProxy()
-		// 给清理一些时间
-		time.Sleep(1 * time.Second)
 	}()
 
 	// 启动代理
-	err := tempManager.StartProxy(node)
+	err = tempManager.StartProxy(node)
 	if err != nil {
 		return fmt.Errorf("启动代理失败: %v", err)
 	}
 
-	// 等待代理启动，增加等待时间确保稳定
-	time.Sleep(5 * time.Second)
-
 	// 验证代理是否真正运行
 	if !tempManager.IsRunning() {
 		return fmt.Errorf("代理启动后未能正常运行")
@@ -1300,20 +1453,12 @@ func (n *NodeServiceImpl) testV2RayNode(node *types.Node) error {
 
 // testHysteria2Node 测试Hysteria2节点
 func (n *NodeServiceImpl) testHysteria2Node(node *types.Node) error {
-	// 获取唯一端口号，增加更大的间隔避免冲突
-	portBase := int(atomic.AddInt64(&n.portCounter, 20))
-	httpPort := portBase
-	socksPort := portBase + 1
-
-	// 确保端口可用
-	for i := 0; i < 10; i++ {
-		if n.isPortAvailable(httpPort) && n.isPortAvailable(socksPort) {
-			break
-		}
-		portBase = int(atomic.AddInt64(&n.portCounter, 20))
-		httpPort = portBase
-		socksPort = portBase + 1
+	// 从共享端口分配器租用测试端口，代理进程退出时租约自动释放
+	httpPort, socksPort, release, err := acquireTestPorts()
+	if err != nil {
+		return err
 	}
+	defer release()
 
 	// 创建临时测试专用代理管理器，确保配置文件独立
 	tempManager := proxy.NewTestHysteria2ProxyManager()
@@ -1321,19 +1466,14 @@ func (n *NodeServiceImpl) testHysteria2Node(node *types.Node) error {
 	defer func() {
 		// 确保清理代理
 		tempManager.StopHysteria2Proxy()
-		// 给清理一些时间
-		time.Sleep(1 * time.Second)
 	}()
 
 	// 启动代理
-	err := tempManager.StartHysteria2Proxy(node)
+	err = tempManager.StartHysteria2Proxy(node)
 	if err != nil {
 		return fmt.Errorf("启动代理失败: %v", err)
 	}
 
-	// 等待代理启动，增加等待时间确保稳定
-	time.Sleep(5 * time.Second)
-
 	// 验证代理是否真正运行
 	if !tempManager.IsHysteria2Running() {
 		return fmt.Errorf("代理启动后未能正常运行")
@@ -1345,38 +1485,26 @@ func (n *NodeServiceImpl) testHysteria2Node(node *types.Node) error {
 
 // speedTestV2RayNode V2Ray节点速度测试
 func (n *NodeServiceImpl) speedTestV2RayNode(node *types.Node) (float64, float64, float64, error) {
-	// 获取唯一端口号，增加更大的间隔避免冲突
-	portBase := int(atomic.AddInt64(&n.portCounter, 20))
-	httpPort := portBase
-	socksPort := portBase + 1
-
-	// 确保端口可用
-	for i := 0; i < 10; i++ {
-		if n.isPortAvailable(httpPort) && n.isPortAvailable(socksPort) {
-			break
-		}
-		portBase = int(atomic.AddInt64(&n.portCounter, 20))
-		httpPort = portBase
-		socksPort = portBase + 1
+	// 从共享端口分配器租用测试端口，代理进程退出时租约自动释放
+	httpPort, socksPort, release, err := acquireTestPorts()
+	if err != nil {
+		return 0, 0, 0, err
 	}
+	defer release()
 
 	// 创建临时测试专用代理管理器，确保配置文件独立
 	tempManager := proxy.NewTestProxyManager()
 	tempManager.SetFixedPorts(httpPort, socksPort)
 	defer func() {
 		tempManager.StopProxy()
-		time.Sleep(1 * time.Second)
 	}()
 
 	// 启动代理
-	err := tempManager.StartProxy(node)
+	err = tempManager.StartProxy(node)
 	if err != nil {
 		return 0, 0, 0, fmt.Errorf("启动代理失败: %v", err)
 	}
 
-	// 等待代理启动，增加等待时间确保稳定
-	time.Sleep(5 * time.Second)
-
 	// 验证代理是否真正运行
 	if !tempManager.IsRunning() {
 		return 0, 0, 0, fmt.Errorf("代理启动后未能正常运行")
@@ -1394,38 +1522,26 @@ func (n *NodeServiceImpl) speedTestV2RayNode(node *types.Node) (float64, float64
 
 // speedTestHysteria2Node Hysteria2节点速度测试
 func (n *NodeServiceImpl) speedTestHysteria2Node(node *types.Node) (float64, float64, float64, error) {
-	// 获取唯一端口号，增加更大的间隔避免冲突
-	portBase := int(atomic.AddInt64(&n.portCounter, 20))
-	httpPort := portBase
-	socksPort := portBase + 1
-
-	// 确保端口可用
-	for i := 0; i < 10; i++ {
-		if n.isPortAvailable(httpPort) && n.isPortAvailable(socksPort) {
-			break
-		}
-		portBase = int(atomic.AddInt64(&n.portCounter, 20))
-		httpPort = portBase
-		socksPort = portBase + 1
+	// 从共享端口分配器租用测试端口，代理进程退出时租约自动释放
+	httpPort, socksPort, release, err := acquireTestPorts()
+	if err != nil {
+		return 0, 0, 0, err
 	}
+	defer release()
 
 	// 创建临时测试专用代理管理器，确保配置文件独立
 	tempManager := proxy.NewTestHysteria2ProxyManager()
 	tempManager.SetFixedPorts(httpPort, socksPort)
 	defer func() {
 		tempManager.StopHysteria2Proxy()
-		time.Sleep(1 * time.Second)
 	}()
 
 	// 启动代理
-	err := tempManager.StartHysteria2Proxy(node)
+	err = tempManager.StartHysteria2Proxy(node)
 	if err != nil {
 		return 0, 0, 0, fmt.Errorf("启动代理失败: %v", err)
 	}
 
-	// 等待代理启动，增加等待时间确保稳定
-	time.Sleep(5 * time.Second)
-
 	// 验证代理是否真正运行
 	if !tempManager.IsHysteria2Running() {
 		return 0, 0, 0, fmt.Errorf("代理启动后未能正常运行")
@@ -1725,14 +1841,20 @@ func (n *NodeServiceImpl) CheckPortConflict(port int) (*models.PortConflictInfo,
 	return n.GetPortConflictInfo(port), nil
 }
 
-// isPortAvailable 检查端口是否可用
-func (n *NodeServiceImpl) isPortAvailable(port int) bool {
-	conn, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+// portOwnerWebUITest Web UI测试节点时测试端口租约的占用者名称
+const portOwnerWebUITest = "web-ui-test"
+
+// acquireTestPorts 从共享端口分配器租用一对测试端口，返回的 release 用于测试结束后释放租约
+func acquireTestPorts() (httpPort, socksPort int, release func(), err error) {
+	httpLease, socksLease, err := proxy.Ports().AcquirePair(portOwnerWebUITest, 0)
 	if err != nil {
-		return false
+		return 0, 0, nil, fmt.Errorf("分配测试端口失败: %v", err)
+	}
+	release = func() {
+		proxy.Ports().Release(httpLease)
+		proxy.Ports().Release(socksLease)
 	}
-	conn.Close()
-	return true
+	return httpLease.Port, socksLease.Port, release, nil
 }
 
 // DeleteNodes 删除节点
@@ -197,6 +197,11 @@ func (p *ProxyServiceImpl) SetFixedPorts(httpPort, socksPort int) {
 	p.hysteria2Manager.SetFixedPorts(p.httpPort, p.socksPort)
 }
 
+// GetPortLeases 获取共享端口分配器中的所有租约（主代理、测试代理等）
+func (p *ProxyServiceImpl) GetPortLeases() []proxy.PortLease {
+	return proxy.Ports().Leases()
+}
+
 // StopAllConnections 停止所有连接
 func (p *ProxyServiceImpl) StopAllConnections() error {
 	p.mutex.Lock()
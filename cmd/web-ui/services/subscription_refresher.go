@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yxhpy/v2ray-subscription-manager/cmd/web-ui/models"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/schedule"
+)
+
+// jobSubscriptionRefresh 订阅自动更新的调度任务
+const jobSubscriptionRefresh = "subscription-refresh"
+
+// SubscriptionRefresher 订阅自动更新，与命令行工作流使用同一个调度引擎
+type SubscriptionRefresher struct {
+	subscriptionService SubscriptionService
+	scheduler           *schedule.Scheduler
+	cancel              context.CancelFunc
+}
+
+// NewSubscriptionRefresher 创建订阅自动更新器
+func NewSubscriptionRefresher(subscriptionService SubscriptionService) *SubscriptionRefresher {
+	return &SubscriptionRefresher{
+		subscriptionService: subscriptionService,
+		scheduler:           schedule.NewScheduler(),
+	}
+}
+
+// Apply 根据系统设置更新调度：优先使用调度表达式，否则按更新间隔（小时），间隔为0时禁用
+func (r *SubscriptionRefresher) Apply(settings *models.Settings) error {
+	var plan schedule.Schedule
+	switch {
+	case settings.UpdateSchedule != "":
+		parsed, err := schedule.Parse(settings.UpdateSchedule)
+		if err != nil {
+			return fmt.Errorf("无效的订阅更新调度: %v", err)
+		}
+		plan = parsed
+	case settings.UpdateInterval > 0:
+		plan = schedule.Every(time.Duration(settings.UpdateInterval) * time.Hour)
+	}
+
+	if plan == nil {
+		if r.scheduler.Spec(jobSubscriptionRefresh) != "" {
+			r.scheduler.Remove(jobSubscriptionRefresh)
+			fmt.Printf("⏰ 订阅自动更新已禁用\n")
+		}
+		return nil
+	}
+	if r.scheduler.Spec(jobSubscriptionRefresh) != plan.String() {
+		r.scheduler.SetSchedule(jobSubscriptionRefresh, plan, r.refreshAll)
+		fmt.Printf("⏰ 订阅自动更新调度: %s\n", plan)
+	}
+	return nil
+}
+
+// Start 在后台运行调度
+func (r *SubscriptionRefresher) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	go r.scheduler.Run(ctx)
+}
+
+// Stop 停止调度
+func (r *SubscriptionRefresher) Stop() {
+	if r.cancel != nil {
+		r.cancel()
+		r.cancel = nil
+	}
+}
+
+// refreshAll 依次重新解析所有订阅
+func (r *SubscriptionRefresher) refreshAll() {
+	subscriptions := r.subscriptionService.GetAllSubscriptions()
+	fmt.Printf("\n⏰ 开始自动更新 %d 个订阅 [%s]\n", len(subscriptions), time.Now().Format("2006-01-02 15:04:05"))
+
+	updated := 0
+	for _, subscription := range subscriptions {
+		if _, err := r.subscriptionService.ParseSubscription(subscription.ID); err != nil {
+			fmt.Printf("❌ 自动更新订阅 %s 失败: %v\n", subscription.Name, err)
+			continue
+		}
+		updated++
+	}
+	fmt.Printf("✅ 订阅自动更新完成: %d/%d\n", updated, len(subscriptions))
+}
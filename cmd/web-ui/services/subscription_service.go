@@ -8,6 +8,7 @@ import (
 
 	"github.com/yxhpy/v2ray-subscription-manager/cmd/web-ui/database"
 	"github.com/yxhpy/v2ray-subscription-manager/cmd/web-ui/models"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/filter"
 	"github.com/yxhpy/v2ray-subscription-manager/internal/core/parser"
 )
 
@@ -127,6 +128,17 @@ func (s *SubscriptionServiceImpl) ParseSubscription(id string) (*models.Subscrip
 		return nil, fmt.Errorf("解析订阅失败: %v", err)
 	}
 
+	// 应用订阅的过滤与重命名规则
+	if subscription.FilterRules != "" {
+		nodeFilter, err := filter.Parse(subscription.FilterRules)
+		if err != nil {
+			return nil, fmt.Errorf("过滤规则无效: %v", err)
+		}
+		total := len(nodes)
+		nodes = nodeFilter.Apply(nodes)
+		fmt.Printf("🔎 订阅 %s 过滤规则保留 %d/%d 个节点\n", subscription.Name, len(nodes), total)
+	}
+
 	// 先清空旧节点
 	// TODO: 实现删除旧节点的逻辑
 
@@ -158,6 +170,27 @@ func (s *SubscriptionServiceImpl) ParseSubscription(id string) (*models.Subscrip
 	return subscription, nil
 }
 
+// UpdateFilterRules 更新订阅的过滤与重命名规则（下次解析订阅时生效）
+func (s *SubscriptionServiceImpl) UpdateFilterRules(id, rules string) (*models.Subscription, error) {
+	if _, err := filter.Parse(rules); err != nil {
+		return nil, fmt.Errorf("过滤规则无效: %v", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	subscription, err := s.subscriptionDB.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	subscription.FilterRules = rules
+	if err := s.subscriptionDB.Update(subscription); err != nil {
+		return nil, fmt.Errorf("保存过滤规则失败: %v", err)
+	}
+	return subscription, nil
+}
+
 // DeleteSubscription 删除订阅
 func (s *SubscriptionServiceImpl) DeleteSubscription(id string) error {
 	s.mutex.Lock()
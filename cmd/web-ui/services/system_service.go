@@ -2,10 +2,14 @@ package services
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/yxhpy/v2ray-subscription-manager/cmd/web-ui/database"
 	"github.com/yxhpy/v2ray-subscription-manager/cmd/web-ui/models"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/downloader"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/proxy"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/schedule"
 )
 
 // SystemServiceImpl 系统服务实现
@@ -14,6 +18,7 @@ type SystemServiceImpl struct {
 	db            *database.Database
 	proxyService  ProxyService  // 添加代理服务依赖，用于重启
 	nodeService   NodeService   // 添加节点服务依赖，用于重新加载配置
+	refresher     *SubscriptionRefresher // 订阅自动更新，设置变更时重新调度
 }
 
 // NewSystemService 创建系统服务
@@ -48,6 +53,11 @@ func NewSystemService() SystemService {
 	// 从数据库加载设置
 	service.loadSettingsFromDB()
 	
+	// 应用代理链设置，数据库中的设置无效时不使用代理链
+	if err := applyChainSettings(service.settings); err != nil {
+		fmt.Printf("⚠️ 代理链设置无效: %v\n", err)
+	}
+	
 	return service
 }
 
@@ -57,6 +67,14 @@ func (s *SystemServiceImpl) SetServiceDependencies(proxyService ProxyService, no
 	s.nodeService = nodeService
 }
 
+// SetSubscriptionRefresher 设置订阅自动更新器，并按当前设置调度
+func (s *SystemServiceImpl) SetSubscriptionRefresher(refresher *SubscriptionRefresher) {
+	s.refresher = refresher
+	if err := refresher.Apply(s.settings); err != nil {
+		fmt.Printf("⚠️ %v\n", err)
+	}
+}
+
 // GetSystemStatus 获取系统状态
 func (s *SystemServiceImpl) GetSystemStatus() (*models.SystemStatus, error) {
 	status := &models.SystemStatus{
@@ -76,6 +94,16 @@ func (s *SystemServiceImpl) GetSettings() (*models.Settings, error) {
 	return s.settings, nil
 }
 
+// GetAssetCategories 获取路由规则数据文件中的分类，供构建路由规则时选择；未安装时先下载
+func (s *SystemServiceImpl) GetAssetCategories(asset string) ([]downloader.AssetCategory, error) {
+	if _, ok := downloader.InstalledAsset(asset); !ok {
+		if _, err := downloader.InstallAsset(asset); err != nil {
+			return nil, err
+		}
+	}
+	return downloader.AssetCategories(asset)
+}
+
 // SaveSettings 保存设置
 func (s *SystemServiceImpl) SaveSettings(settings *models.Settings) error {
 	// 验证设置参数
@@ -90,6 +118,11 @@ func (s *SystemServiceImpl) SaveSettings(settings *models.Settings) error {
 	// 更新内存中的设置
 	s.settings = settings
 	
+	// 之后启动的代理和测试使用新的代理链
+	if err := applyChainSettings(settings); err != nil {
+		return err
+	}
+	
 	// 保存到数据库
 	if err := s.saveSettingsToDB(); err != nil {
 		return err
@@ -105,6 +138,13 @@ func (s *SystemServiceImpl) SaveSettings(settings *models.Settings) error {
 		fmt.Printf("✅ 新的端口配置已应用: HTTP:%d, SOCKS:%d\n", settings.HTTPPort, settings.SOCKSPort)
 	}
 	
+	// 重新调度订阅自动更新
+	if s.refresher != nil {
+		if err := s.refresher.Apply(settings); err != nil {
+			fmt.Printf("⚠️ %v\n", err)
+		}
+	}
+	
 	// 通知其他服务重新加载配置（如果实现了重新加载方法）
 	if s.nodeService != nil {
 		// 这里可以添加重新加载节点服务配置的逻辑
@@ -128,12 +168,43 @@ func (s *SystemServiceImpl) validateSettings(settings *models.Settings) error {
 	if settings.TestTimeout < 5 || settings.TestTimeout > 300 {
 		return fmt.Errorf("测试超时时间必须在5-300秒范围内")
 	}
+	// 该值是批量测试自适应并发的上限，运行时在此范围内根据延迟和超时自动调整
 	if settings.MaxConcurrent < 1 || settings.MaxConcurrent > 10 {
 		return fmt.Errorf("最大并发数必须在1-10范围内")
 	}
 	if settings.TestURL == "" {
 		return fmt.Errorf("测试URL不能为空")
 	}
+	if settings.UpdateSchedule != "" {
+		if _, err := schedule.Parse(settings.UpdateSchedule); err != nil {
+			return fmt.Errorf("订阅更新调度无效: %v", err)
+		}
+	}
+	if _, err := proxy.NewChain(settings.FrontNode, chainPatterns(settings.ChainNodes)); err != nil {
+		return fmt.Errorf("代理链设置无效: %v", err)
+	}
+	return nil
+}
+
+// chainPatterns 出口节点规则，每行一个
+func chainPatterns(text string) []string {
+	var patterns []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			patterns = append(patterns, line)
+		}
+	}
+	return patterns
+}
+
+// applyChainSettings 把代理链设置应用到代理核心
+func applyChainSettings(settings *models.Settings) error {
+	chain, err := proxy.NewChain(settings.FrontNode, chainPatterns(settings.ChainNodes))
+	if err != nil {
+		proxy.SetChain(nil)
+		return err
+	}
+	proxy.SetChain(chain)
 	return nil
 }
 
@@ -153,11 +224,14 @@ func (s *SystemServiceImpl) loadSettingsFromDB() {
 		"max_concurrent":     &s.settings.MaxConcurrent,
 		"retry_count":        &s.settings.RetryCount,
 		"update_interval":    &s.settings.UpdateInterval,
+		"update_schedule":    &s.settings.UpdateSchedule,
 		"user_agent":         &s.settings.UserAgent,
 		"auto_test_nodes":    &s.settings.AutoTestNewNodes,
 		"enable_logs":        &s.settings.EnableLogs,
 		"log_level":          &s.settings.LogLevel,
 		"data_retention":     &s.settings.DataRetention,
+		"front_node":         &s.settings.FrontNode,
+		"chain_nodes":        &s.settings.ChainNodes,
 	}
 	
 	for key, ptr := range settingsMap {
@@ -198,11 +272,14 @@ func (s *SystemServiceImpl) saveSettingsToDB() error {
 		"max_concurrent":     s.settings.MaxConcurrent,
 		"retry_count":        s.settings.RetryCount,
 		"update_interval":    s.settings.UpdateInterval,
+		"update_schedule":    s.settings.UpdateSchedule,
 		"user_agent":         s.settings.UserAgent,
 		"auto_test_nodes":    s.settings.AutoTestNewNodes,
 		"enable_logs":        s.settings.EnableLogs,
 		"log_level":          s.settings.LogLevel,
 		"data_retention":     s.settings.DataRetention,
+		"front_node":         s.settings.FrontNode,
+		"chain_nodes":        s.settings.ChainNodes,
 	}
 	
 	// 保存每个设置
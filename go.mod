@@ -2,9 +2,4 @@ module github.com/yxhpy/v2ray-subscription-manager
 
 go 1.21
 
-require (
-	github.com/fsnotify/fsnotify v1.9.0
-	github.com/mattn/go-sqlite3 v1.14.18
-)
-
-require golang.org/x/sys v0.13.0 // indirect
+require github.com/mattn/go-sqlite3 v1.14.18
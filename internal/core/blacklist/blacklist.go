@@ -0,0 +1,683 @@
+package blacklist
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/statedir"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/platform"
+	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
+)
+
+// DefaultFile 默认黑名单文件，位于状态目录下，tester、proxy-server、speed-test 和 Web UI 共用
+func DefaultFile() string {
+	return statedir.File("node_blacklist.json")
+}
+
+// Kind 规则匹配方式
+type Kind string
+
+const (
+	// KindFingerprint 按节点指纹匹配
+	KindFingerprint Kind = "fingerprint"
+	// KindServer 按服务器地址匹配（不区分大小写）
+	KindServer Kind = "server"
+	// KindCIDR 按网段匹配，只对IP形式的服务器地址生效（不做DNS解析）
+	KindCIDR Kind = "cidr"
+)
+
+// errUnchanged 修改没有产生变化，不需要写文件
+var errUnchanged = errors.New("黑名单没有变化")
+
+// saveDelay 自动封禁变更的合并写入延迟：一轮测试中的多次失败/成功只写一次文件
+const saveDelay = 2 * time.Second
+
+// fingerprintPattern 节点指纹格式（types.Node.Fingerprint）
+var fingerprintPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// Entry 黑名单或白名单条目
+type Entry struct {
+	Kind       Kind      `json:"kind"`
+	Value      string    `json:"value"`
+	Name       string    `json:"name,omitempty"` // 节点名称，仅用于展示
+	Reason     string    `json:"reason,omitempty"`
+	Manual     bool      `json:"manual"`               // 手动添加，测试成功不会解除
+	Permanent  bool      `json:"permanent"`            // 永久封禁
+	ExpiresAt  time.Time `json:"expires_at,omitempty"` // 非永久封禁的到期时间
+	Strikes    int       `json:"strikes,omitempty"`    // 累计失败次数，用于递增封禁时长
+	LastStrike time.Time `json:"last_strike,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Active 条目当前是否生效
+func (e *Entry) Active(now time.Time) bool {
+	return e.Permanent || now.Before(e.ExpiresAt)
+}
+
+// Policy 自动封禁策略：第n次失败封禁 BaseDuration*Multiplier^(n-1)，不超过 MaxDuration
+type Policy struct {
+	BaseDuration time.Duration `json:"base_duration"`
+	Multiplier   float64       `json:"multiplier"`
+	MaxDuration  time.Duration `json:"max_duration"`
+	StrikeDecay  time.Duration `json:"strike_decay"` // 超过该时长没有再失败则清零失败次数
+}
+
+// DefaultPolicy 默认自动封禁策略
+func DefaultPolicy() Policy {
+	return Policy{
+		BaseDuration: 10 * time.Minute,
+		Multiplier:   2,
+		MaxDuration:  24 * time.Hour,
+		StrikeDecay:  7 * 24 * time.Hour,
+	}
+}
+
+// banDuration 第strikes次失败对应的封禁时长
+func (p Policy) banDuration(strikes int) time.Duration {
+	duration := float64(p.BaseDuration)
+	for i := 1; i < strikes; i++ {
+		duration *= p.Multiplier
+		if duration >= float64(p.MaxDuration) {
+			return p.MaxDuration
+		}
+	}
+	return time.Duration(duration)
+}
+
+// fileData 黑名单文件格式
+type fileData struct {
+	Policy  Policy   `json:"policy"`
+	Entries []*Entry `json:"entries"`
+	Allow   []*Entry `json:"allow"`
+}
+
+// pendingOp 尚未写入文件的一次自动封禁变更（测试失败或成功）
+type pendingOp struct {
+	node        *types.Node
+	fingerprint string
+	reason      string
+	at          time.Time
+	forgive     bool
+}
+
+// Blacklist 持久化的节点黑名单
+// 每次查询前检查文件修改时间，其他进程（如 ctl 或 Web UI）的修改会被自动加载。
+// 所有写入都在 .lock 文件锁下先重新读取文件再修改，不会覆盖其他进程的变更；
+// Penalize/Forgive 的变更先记在内存中，延迟 saveDelay 合并写入，也可调用 Save 立即写入
+type Blacklist struct {
+	path    string
+	data    fileData
+	modTime time.Time
+	pending []pendingOp
+	timer   *time.Timer
+	mutex   sync.Mutex
+}
+
+// New 创建黑名单，文件不存在时为空
+func New(path string) *Blacklist {
+	b := &Blacklist{
+		path: path,
+		data: fileData{Policy: DefaultPolicy()},
+	}
+	b.mutex.Lock()
+	if err := b.refresh(); err != nil {
+		fmt.Printf("⚠️ 加载黑名单失败: %v\n", err)
+	}
+	b.mutex.Unlock()
+	return b
+}
+
+// Path 返回黑名单文件路径
+func (b *Blacklist) Path() string {
+	return b.path
+}
+
+// SetPolicy 设置自动封禁策略
+func (b *Blacklist) SetPolicy(policy Policy) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.update(func() error {
+		b.data.Policy = policy
+		return nil
+	})
+}
+
+// Policy 返回自动封禁策略
+func (b *Blacklist) Policy() Policy {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.refresh()
+	return b.data.Policy
+}
+
+// Check 检查节点是否被封禁，白名单优先；返回命中的黑名单条目
+func (b *Blacklist) Check(node *types.Node) (bool, *Entry) {
+	if b == nil {
+		return false, nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refresh()
+
+	if b.allowed(node) {
+		return false, nil
+	}
+
+	now := time.Now()
+	for _, entry := range b.data.Entries {
+		if entry.Active(now) && entry.matches(node) {
+			copied := *entry
+			return true, &copied
+		}
+	}
+	return false, nil
+}
+
+// Blocked 节点是否被封禁
+func (b *Blacklist) Blocked(node *types.Node) bool {
+	blocked, _ := b.Check(node)
+	return blocked
+}
+
+// Filter 过滤掉被封禁的节点，返回保留的节点和被跳过的数量
+func (b *Blacklist) Filter(nodes []*types.Node) ([]*types.Node, int) {
+	if b == nil {
+		return nodes, 0
+	}
+
+	kept := make([]*types.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if !b.Blocked(node) {
+			kept = append(kept, node)
+		}
+	}
+	return kept, len(nodes) - len(kept)
+}
+
+// Penalize 记录一次测试失败，按策略递增封禁时长；白名单中的节点不受影响
+// 变更立即对查询生效，文件延迟合并写入
+func (b *Blacklist) Penalize(node *types.Node, reason string) (*Entry, error) {
+	if b == nil {
+		return nil, nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refresh()
+
+	op := pendingOp{node: node, fingerprint: node.Fingerprint(), reason: reason, at: time.Now()}
+	if b.allowed(node) {
+		return nil, nil
+	}
+	// 已永久封禁（通常是手动添加）的节点不再累计失败次数，没有变更也不需要写文件
+	if existing := b.find(b.data.Entries, KindFingerprint, op.fingerprint); existing != nil && existing.Permanent {
+		copied := *existing
+		return &copied, nil
+	}
+
+	entry := b.apply(op)
+	if entry == nil {
+		return nil, nil
+	}
+	b.enqueue(op)
+
+	copied := *entry
+	return &copied, nil
+}
+
+// Forgive 节点测试成功，清除自动封禁记录（手动封禁不受影响）；文件延迟合并写入
+func (b *Blacklist) Forgive(node *types.Node) error {
+	if b == nil {
+		return nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refresh()
+
+	op := pendingOp{node: node, fingerprint: node.Fingerprint(), at: time.Now(), forgive: true}
+	if b.apply(op) != nil {
+		b.enqueue(op)
+	}
+	return nil
+}
+
+// Save 立即写入尚未保存的自动封禁变更；一轮测试结束和程序退出前调用
+func (b *Blacklist) Save() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if len(b.pending) == 0 {
+		return nil
+	}
+	return b.update(nil)
+}
+
+// apply 将一次自动封禁变更应用到内存数据，返回受影响的条目；没有变化时返回nil（调用方需持有锁）
+func (b *Blacklist) apply(op pendingOp) *Entry {
+	if op.forgive {
+		var removed *Entry
+		kept := b.data.Entries[:0]
+		for _, entry := range b.data.Entries {
+			if entry.Kind == KindFingerprint && entry.Value == op.fingerprint && !entry.Manual {
+				removed = entry
+				continue
+			}
+			kept = append(kept, entry)
+		}
+		b.data.Entries = kept
+		return removed
+	}
+
+	if b.allowed(op.node) {
+		return nil
+	}
+
+	entry := b.find(b.data.Entries, KindFingerprint, op.fingerprint)
+	if entry == nil {
+		entry = &Entry{Kind: KindFingerprint, Value: op.fingerprint, CreatedAt: op.at}
+		b.data.Entries = append(b.data.Entries, entry)
+	}
+	if entry.Permanent {
+		return entry
+	}
+
+	if b.data.Policy.StrikeDecay > 0 && op.at.Sub(entry.LastStrike) > b.data.Policy.StrikeDecay {
+		entry.Strikes = 0
+	}
+	entry.Strikes++
+	entry.LastStrike = op.at
+	entry.Name = op.node.Name
+	entry.Reason = op.reason
+
+	expiresAt := op.at.Add(b.data.Policy.banDuration(entry.Strikes))
+	if expiresAt.After(entry.ExpiresAt) {
+		entry.ExpiresAt = expiresAt
+	}
+	return entry
+}
+
+// enqueue 记录待写入的变更，并在 saveDelay 后合并写入（调用方需持有锁）
+func (b *Blacklist) enqueue(op pendingOp) {
+	b.pending = append(b.pending, op)
+	if b.timer != nil {
+		return
+	}
+	b.timer = time.AfterFunc(saveDelay, func() {
+		// 先清除定时器，写入失败时下一次变更会重新安排写入
+		b.mutex.Lock()
+		b.timer = nil
+		b.mutex.Unlock()
+		if err := b.Save(); err != nil {
+			fmt.Printf("⚠️ 保存黑名单失败: %v\n", err)
+		}
+	})
+}
+
+// Ban 手动封禁，duration为0时永久封禁
+func (b *Blacklist) Ban(kind Kind, value, reason string, duration time.Duration) (*Entry, error) {
+	kind, value, err := normalize(kind, value)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var copied Entry
+	err = b.update(func() error {
+		now := time.Now()
+		entry := b.find(b.data.Entries, kind, value)
+		if entry == nil {
+			entry = &Entry{Kind: kind, Value: value, CreatedAt: now}
+			b.data.Entries = append(b.data.Entries, entry)
+		}
+		entry.Manual = true
+		entry.Reason = reason
+		entry.Permanent = duration <= 0
+		if !entry.Permanent {
+			entry.ExpiresAt = now.Add(duration)
+		}
+		copied = *entry
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &copied, nil
+}
+
+// Unban 移除黑名单条目
+func (b *Blacklist) Unban(kind Kind, value string) error {
+	kind, value, err := normalize(kind, value)
+	if err != nil {
+		return err
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.update(func() error {
+		entries, removed := remove(b.data.Entries, kind, value)
+		if !removed {
+			return fmt.Errorf("黑名单中没有该条目: %s %s", kind, value)
+		}
+		b.data.Entries = entries
+		return nil
+	})
+}
+
+// Allow 加入白名单，白名单中的节点不会被封禁
+func (b *Blacklist) Allow(kind Kind, value, reason string) (*Entry, error) {
+	kind, value, err := normalize(kind, value)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var copied Entry
+	err = b.update(func() error {
+		entry := b.find(b.data.Allow, kind, value)
+		if entry == nil {
+			entry = &Entry{Kind: kind, Value: value, Manual: true, Permanent: true, CreatedAt: time.Now()}
+			b.data.Allow = append(b.data.Allow, entry)
+		}
+		entry.Reason = reason
+		copied = *entry
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &copied, nil
+}
+
+// Disallow 移出白名单
+func (b *Blacklist) Disallow(kind Kind, value string) error {
+	kind, value, err := normalize(kind, value)
+	if err != nil {
+		return err
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.update(func() error {
+		allow, removed := remove(b.data.Allow, kind, value)
+		if !removed {
+			return fmt.Errorf("白名单中没有该条目: %s %s", kind, value)
+		}
+		b.data.Allow = allow
+		return nil
+	})
+}
+
+// Entries 返回黑名单条目，activeOnly为true时只返回生效中的条目
+func (b *Blacklist) Entries(activeOnly bool) []Entry {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refresh()
+
+	now := time.Now()
+	result := make([]Entry, 0, len(b.data.Entries))
+	for _, entry := range b.data.Entries {
+		if activeOnly && !entry.Active(now) {
+			continue
+		}
+		result = append(result, *entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result
+}
+
+// AllowEntries 返回白名单条目
+func (b *Blacklist) AllowEntries() []Entry {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refresh()
+
+	result := make([]Entry, 0, len(b.data.Allow))
+	for _, entry := range b.data.Allow {
+		result = append(result, *entry)
+	}
+	return result
+}
+
+// Prune 清理已过期且失败次数已衰减的自动封禁条目，返回清理数量
+func (b *Blacklist) Prune() (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	removed := 0
+	err := b.update(func() error {
+		now := time.Now()
+		kept := b.data.Entries[:0]
+		for _, entry := range b.data.Entries {
+			expired := !entry.Active(now)
+			decayed := entry.Manual || b.data.Policy.StrikeDecay <= 0 || now.Sub(entry.LastStrike) > b.data.Policy.StrikeDecay
+			if expired && decayed {
+				continue
+			}
+			kept = append(kept, entry)
+		}
+		removed = len(b.data.Entries) - len(kept)
+		b.data.Entries = kept
+		if removed == 0 {
+			return errUnchanged
+		}
+		return nil
+	})
+	if err == errUnchanged {
+		return 0, nil
+	}
+	return removed, err
+}
+
+// refresh 文件被修改时重新加载（调用方需持有锁）
+func (b *Blacklist) refresh() error {
+	info, err := os.Stat(b.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !info.ModTime().After(b.modTime) {
+		return nil
+	}
+	return b.load()
+}
+
+// load 从文件重新加载，并重放尚未写入的自动封禁变更，避免其他进程的修改覆盖本进程的变更（调用方需持有锁）
+func (b *Blacklist) load() error {
+	data := fileData{Policy: DefaultPolicy()}
+	var modTime time.Time
+
+	content, err := os.ReadFile(b.path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(content, &data); err != nil {
+			return fmt.Errorf("解析黑名单文件失败: %v", err)
+		}
+		if info, err := os.Stat(b.path); err == nil {
+			modTime = info.ModTime()
+		}
+	case os.IsNotExist(err):
+		// 文件被删除时以空黑名单为准
+	default:
+		return fmt.Errorf("读取黑名单文件失败: %v", err)
+	}
+
+	b.data = data
+	b.modTime = modTime
+	for _, op := range b.pending {
+		b.apply(op)
+	}
+	return nil
+}
+
+// update 在文件锁下重新加载文件、执行修改并连同未写入的自动封禁变更一起写回（调用方需持有锁）
+// modify 为nil时只写入未保存的变更；modify 返回错误时不写文件
+func (b *Blacklist) update(modify func() error) error {
+	if dir := filepath.Dir(b.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("创建黑名单目录失败: %v", err)
+		}
+	}
+	unlock, err := platform.LockFile(b.path + ".lock")
+	if err != nil {
+		return fmt.Errorf("锁定黑名单文件失败: %v", err)
+	}
+	defer unlock()
+
+	if err := b.load(); err != nil {
+		return err
+	}
+	if modify != nil {
+		if err := modify(); err != nil {
+			return err
+		}
+	}
+	if err := b.save(); err != nil {
+		return err
+	}
+
+	b.pending = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	return nil
+}
+
+// save 原子写入黑名单文件（调用方需持有锁和文件锁）
+func (b *Blacklist) save() error {
+	content, err := json.MarshalIndent(b.data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("编码黑名单失败: %v", err)
+	}
+
+	tmpFile := b.path + ".tmp"
+	if err := os.WriteFile(tmpFile, content, 0644); err != nil {
+		return fmt.Errorf("写入黑名单失败: %v", err)
+	}
+	if err := os.Rename(tmpFile, b.path); err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("保存黑名单失败: %v", err)
+	}
+
+	if info, err := os.Stat(b.path); err == nil {
+		b.modTime = info.ModTime()
+	}
+	return nil
+}
+
+// allowed 节点是否命中白名单（调用方需持有锁）
+func (b *Blacklist) allowed(node *types.Node) bool {
+	for _, entry := range b.data.Allow {
+		if entry.matches(node) {
+			return true
+		}
+	}
+	return false
+}
+
+// find 查找条目
+func (b *Blacklist) find(entries []*Entry, kind Kind, value string) *Entry {
+	for _, entry := range entries {
+		if entry.Kind == kind && entry.Value == value {
+			return entry
+		}
+	}
+	return nil
+}
+
+// remove 移除条目
+func remove(entries []*Entry, kind Kind, value string) ([]*Entry, bool) {
+	for i, entry := range entries {
+		if entry.Kind == kind && entry.Value == value {
+			return append(entries[:i], entries[i+1:]...), true
+		}
+	}
+	return entries, false
+}
+
+// matches 条目是否匹配节点
+func (e *Entry) matches(node *types.Node) bool {
+	switch e.Kind {
+	case KindFingerprint:
+		return node.Fingerprint() == e.Value
+	case KindServer:
+		return strings.EqualFold(node.Server, e.Value)
+	case KindCIDR:
+		_, network, err := net.ParseCIDR(e.Value)
+		if err != nil {
+			return false
+		}
+		ip := net.ParseIP(strings.Trim(node.Server, "[]"))
+		return ip != nil && network.Contains(ip)
+	}
+	return false
+}
+
+// DetectKind 根据值的格式推断匹配方式: 含"/"为网段，16位十六进制为指纹，其他为服务器地址
+func DetectKind(value string) Kind {
+	value = strings.TrimSpace(value)
+	switch {
+	case strings.Contains(value, "/"):
+		return KindCIDR
+	case fingerprintPattern.MatchString(strings.ToLower(value)):
+		return KindFingerprint
+	default:
+		return KindServer
+	}
+}
+
+// normalize 校验并规范化条目
+func normalize(kind Kind, value string) (Kind, string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return kind, value, fmt.Errorf("条目值不能为空")
+	}
+	if kind == "" {
+		kind = DetectKind(value)
+	}
+
+	switch kind {
+	case KindFingerprint:
+		value = strings.ToLower(value)
+		if !fingerprintPattern.MatchString(value) {
+			return kind, value, fmt.Errorf("无效的节点指纹: %s", value)
+		}
+	case KindServer:
+		value = strings.ToLower(value)
+	case KindCIDR:
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return kind, value, fmt.Errorf("无效的网段: %v", err)
+		}
+		value = network.String()
+	default:
+		return kind, value, fmt.Errorf("未知的匹配方式: %s (支持 fingerprint/server/cidr)", kind)
+	}
+	return kind, value, nil
+}
@@ -0,0 +1,687 @@
+// Package config 加载 v2ray-manager 的配置文件和命名档案（profile）
+//
+// 每个档案保存订阅、端口、测试参数、过滤规则和切换策略，命令行使用时的优先级为：
+// 命令行选项 > 环境变量（V2RAY_MANAGER_<键名>） > 档案 > 各命令的默认值
+package config
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/downloader"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/filter"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/proxy"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/schedule"
+)
+
+// EnvPrefix 环境变量前缀，如 V2RAY_MANAGER_HTTP_PORT 对应键 http_port
+const EnvPrefix = "V2RAY_MANAGER_"
+
+// DefaultProfileName 未指定档案时使用的档案名
+const DefaultProfileName = "default"
+
+// Syntax 配置文件示例（CLI帮助和文档共用）
+const Syntax = `default_profile = "home"        # 未指定 --profile 时使用的档案
+
+[profiles.home]
+subscriptions = ["https://example.com/sub"]   # 第一个为默认订阅
+http_port = 7890
+socks_port = 7891
+interval = "10m"                # 测试间隔（整分钟）
+concurrency = 20
+timeout = "30s"
+test_url = "http://www.google.com"
+max_nodes = 100
+min_nodes = 5
+filter = "rules.txt"            # 相对路径相对于配置文件所在目录
+switch_threshold = 20           # 百分比
+min_dwell = "10m"
+quiet_hours = "23:00-07:00"`
+
+// valueKind 配置值类型
+type valueKind int
+
+const (
+	kindString valueKind = iota
+	kindStrings
+	kindInt
+	kindFloat
+	kindBool
+	kindPath // 字符串，文件中的相对路径相对于配置文件所在目录
+)
+
+// keySpec 档案中一个键的定义
+type keySpec struct {
+	name  string
+	kind  valueKind
+	check func(Value) error
+}
+
+// specs 档案支持的键，顺序即 config show 的输出顺序
+var specs = []keySpec{
+	{"subscriptions", kindStrings, checkSubscriptions},
+	{"http_port", kindInt, checkPort},
+	{"socks_port", kindInt, checkPort},
+	{"interval", kindString, checkInterval},
+	{"concurrency", kindInt, checkPositive},
+	{"timeout", kindString, checkPositiveDuration},
+	{"test_url", kindString, checkURL},
+	{"max_nodes", kindInt, checkPositive},
+	{"min_nodes", kindInt, checkPositive},
+	{"auto_switch", kindBool, nil},
+	{"preflight", kindBool, nil},
+	{"switch_threshold", kindFloat, checkNonNegative},
+	{"min_dwell", kindString, checkDuration},
+	{"schedule", kindString, checkSchedule},
+	{"health_schedule", kindString, checkSchedule},
+	{"quiet_hours", kindString, checkQuietHours},
+	{"filter", kindPath, checkFilter},
+	{"state_file", kindPath, nil},
+	{"history_file", kindPath, nil},
+	{"blacklist_file", kindPath, nil},
+	{"runtime_dir", kindPath, nil},
+	{"v2ray_version", kindString, checkVersion},
+	{"hysteria2_version", kindString, checkVersion},
+	{"xray_version", kindString, checkVersion},
+	{"sing_box_version", kindString, checkVersion},
+	{"preferred_core", kindString, checkPreferredCore},
+	{"hysteria2_up_mbps", kindInt, checkMbps},
+	{"hysteria2_down_mbps", kindInt, checkMbps},
+	{"mirrors", kindStrings, checkMirrors},
+	{"geoip_source", kindString, checkAssetSource},
+	{"geosite_source", kindString, checkAssetSource},
+	{"assets_refresh", kindString, checkDuration},
+	{"allow_unverified_cores", kindBool, nil},
+	{"front_node", kindString, checkFrontNode},
+	{"chain_nodes", kindStrings, checkChainNodes},
+}
+
+// lookupSpec 查找键定义
+func lookupSpec(name string) (keySpec, bool) {
+	for _, spec := range specs {
+		if spec.name == name {
+			return spec, true
+		}
+	}
+	return keySpec{}, false
+}
+
+// Keys 返回档案支持的所有键名
+func Keys() []string {
+	names := make([]string, len(specs))
+	for i, spec := range specs {
+		names[i] = spec.name
+	}
+	return names
+}
+
+// Value 档案中的一个配置值
+type Value struct {
+	Key    string
+	Raw    interface{} // string / []string / int64 / float64 / bool
+	Source string      // 来源，如 "config.toml:12" 或 "环境变量 V2RAY_MANAGER_HTTP_PORT"
+}
+
+// Profile 命名档案
+type Profile struct {
+	Name   string
+	values map[string]Value
+}
+
+// newProfile 创建空档案
+func newProfile(name string) *Profile {
+	return &Profile{Name: name, values: make(map[string]Value)}
+}
+
+// Has 是否设置了指定键
+func (p *Profile) Has(key string) bool {
+	_, ok := p.values[key]
+	return ok
+}
+
+// Value 返回指定键的值
+func (p *Profile) Value(key string) (Value, bool) {
+	v, ok := p.values[key]
+	return v, ok
+}
+
+// String 返回字符串值，未设置时返回空字符串
+func (p *Profile) String(key string) string {
+	s, _ := p.values[key].Raw.(string)
+	return s
+}
+
+// Strings 返回字符串数组值
+func (p *Profile) Strings(key string) []string {
+	s, _ := p.values[key].Raw.([]string)
+	return s
+}
+
+// Int 返回整数值
+func (p *Profile) Int(key string) int {
+	n, _ := p.values[key].Raw.(int64)
+	return int(n)
+}
+
+// Float 返回浮点数值（整数值也可以读取）
+func (p *Profile) Float(key string) float64 {
+	switch v := p.values[key].Raw.(type) {
+	case float64:
+		return v
+	case int64:
+		return float64(v)
+	}
+	return 0
+}
+
+// Bool 返回布尔值
+func (p *Profile) Bool(key string) bool {
+	b, _ := p.values[key].Raw.(bool)
+	return b
+}
+
+// Duration 返回时长值，值已在加载时校验过
+func (p *Profile) Duration(key string) time.Duration {
+	d, _ := time.ParseDuration(p.String(key))
+	return d
+}
+
+// Subscription 返回默认订阅（订阅列表中的第一个），未设置时返回空字符串
+func (p *Profile) Subscription() string {
+	if subs := p.Strings("subscriptions"); len(subs) > 0 {
+		return subs[0]
+	}
+	return ""
+}
+
+// Empty 档案是否没有设置任何键
+func (p *Profile) Empty() bool {
+	return len(p.values) == 0
+}
+
+// WriteTo 按配置文件语法输出档案，每个值附带来源注释
+func (p *Profile) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[profiles.%s]\n", formatTableName(p.Name))
+	for _, spec := range specs {
+		v, ok := p.values[spec.name]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&b, "%s = %s  # %s\n", spec.name, formatValue(v.Raw), v.Source)
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// set 校验并设置一个值
+func (p *Profile) set(spec keySpec, raw interface{}, source string) error {
+	v := Value{Key: spec.name, Raw: raw, Source: source}
+	if spec.check != nil {
+		if err := spec.check(v); err != nil {
+			return fmt.Errorf("%s: %v", spec.name, err)
+		}
+	}
+	p.values[spec.name] = v
+	return nil
+}
+
+// validate 检查键之间的约束
+func (p *Profile) validate() []string {
+	var problems []string
+	if p.Has("http_port") && p.Has("socks_port") && p.Int("http_port") == p.Int("socks_port") {
+		problems = append(problems, fmt.Sprintf("http_port 和 socks_port 不能相同 (%d)", p.Int("http_port")))
+	}
+	return problems
+}
+
+// File 配置文件
+type File struct {
+	Path           string
+	DefaultProfile string
+	Profiles       map[string]*Profile
+}
+
+// ProfileNames 返回所有档案名（按名称排序）
+func (f *File) ProfileNames() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Error 配置错误，一次列出所有问题
+type Error struct {
+	Source   string // 如 "配置文件 ~/.config/v2ray-manager/config.toml" 或 "环境变量"
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: 共 %d 处错误:\n  - %s", e.Source, len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// DefaultPath 默认配置文件路径（用户配置目录下的 v2ray-manager/config.toml）
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "v2ray-manager.toml"
+	}
+	return filepath.Join(dir, "v2ray-manager", "config.toml")
+}
+
+// Load 读取并校验配置文件
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(path, string(data))
+}
+
+// Parse 解析并校验配置文本，path 用于错误信息和解析相对路径
+func Parse(path, text string) (*File, error) {
+	file := &File{Path: path, Profiles: make(map[string]*Profile)}
+	entries, problems := parseTOML(text)
+	base := filepath.Base(path)
+
+	for _, entry := range entries {
+		at := fmt.Sprintf("第%d行", entry.line)
+
+		switch {
+		case len(entry.table) == 0:
+			if entry.key != "default_profile" {
+				problems = append(problems, fmt.Sprintf("%s: 未知的顶层键 %s（档案设置应写在 [profiles.名称] 下）", at, entry.key))
+				continue
+			}
+			name, ok := entry.value.(string)
+			if !ok || name == "" {
+				problems = append(problems, fmt.Sprintf("%s: default_profile 应为档案名字符串", at))
+				continue
+			}
+			file.DefaultProfile = name
+
+		case len(entry.table) == 2 && entry.table[0] == "profiles":
+			name := entry.table[1]
+			profile, ok := file.Profiles[name]
+			if !ok {
+				profile = newProfile(name)
+				file.Profiles[name] = profile
+			}
+			spec, ok := lookupSpec(entry.key)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: 档案 %s 中未知的键 %s（可用: %s）", at, name, entry.key, strings.Join(Keys(), ", ")))
+				continue
+			}
+			if profile.Has(entry.key) {
+				problems = append(problems, fmt.Sprintf("%s: 档案 %s 中重复的键 %s", at, name, entry.key))
+				continue
+			}
+			raw, err := convertValue(spec, entry.value)
+			if err == nil && spec.kind == kindPath {
+				raw = resolvePath(path, raw.(string))
+			}
+			if err == nil {
+				err = profile.set(spec, raw, fmt.Sprintf("%s:%d", base, entry.line))
+			}
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: 档案 %s: %v", at, name, err))
+			}
+
+		default:
+			problems = append(problems, fmt.Sprintf("%s: 键 %s 所在的表 [%s] 无效（档案应写在 [profiles.名称] 下）", at, entry.key, strings.Join(entry.table, ".")))
+		}
+	}
+
+	for _, name := range file.ProfileNames() {
+		for _, problem := range file.Profiles[name].validate() {
+			problems = append(problems, fmt.Sprintf("档案 %s: %s", name, problem))
+		}
+	}
+	if file.DefaultProfile != "" {
+		if _, ok := file.Profiles[file.DefaultProfile]; !ok {
+			problems = append(problems, fmt.Sprintf("default_profile 指定的档案 %s 不存在", file.DefaultProfile))
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, &Error{Source: "配置文件 " + path, Problems: problems}
+	}
+	return file, nil
+}
+
+// Resolve 确定要使用的配置文件和档案，并叠加环境变量
+// path 为空时依次使用 V2RAY_MANAGER_CONFIG 和 DefaultPath()，默认路径不存在时视为没有配置文件；
+// name 为空时依次使用 V2RAY_MANAGER_PROFILE、文件的 default_profile 和 default 档案
+func Resolve(path, name string) (*File, *Profile, error) {
+	explicit := path != ""
+	if !explicit {
+		path = os.Getenv(EnvPrefix + "CONFIG")
+		explicit = path != ""
+	}
+	if path == "" {
+		path = DefaultPath()
+	}
+
+	file, err := Load(path)
+	if err != nil {
+		if !os.IsNotExist(err) || explicit {
+			if os.IsNotExist(err) {
+				return nil, nil, fmt.Errorf("配置文件不存在: %s", path)
+			}
+			return nil, nil, err
+		}
+		file = &File{Profiles: make(map[string]*Profile)}
+	}
+
+	if name == "" {
+		name = os.Getenv(EnvPrefix + "PROFILE")
+	}
+	if name == "" {
+		name = file.DefaultProfile
+	}
+
+	profile, ok := file.Profiles[name]
+	switch {
+	case ok:
+		// 复制一份，叠加环境变量时不修改文件中的档案
+		copied := newProfile(profile.Name)
+		for key, v := range profile.values {
+			copied.values[key] = v
+		}
+		profile = copied
+	case name == "" || name == DefaultProfileName:
+		profile = newProfile(DefaultProfileName)
+		if p, ok := file.Profiles[DefaultProfileName]; ok {
+			for key, v := range p.values {
+				profile.values[key] = v
+			}
+		}
+	default:
+		available := strings.Join(file.ProfileNames(), ", ")
+		if available == "" {
+			available = "无"
+		}
+		return nil, nil, fmt.Errorf("档案 %s 不存在（可用: %s）", name, available)
+	}
+
+	if err := applyEnv(profile); err != nil {
+		return nil, nil, err
+	}
+	return file, profile, nil
+}
+
+// applyEnv 用 V2RAY_MANAGER_<键名> 环境变量覆盖档案中的值
+func applyEnv(profile *Profile) error {
+	var problems []string
+	for _, spec := range specs {
+		envName := EnvPrefix + strings.ToUpper(spec.name)
+		text, ok := os.LookupEnv(envName)
+		if !ok || text == "" {
+			continue
+		}
+		raw, err := parseEnvValue(spec, text)
+		if err == nil {
+			err = profile.set(spec, raw, "环境变量 "+envName)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", envName, err))
+		}
+	}
+	problems = append(problems, profile.validate()...)
+
+	if len(problems) > 0 {
+		return &Error{Source: "环境变量", Problems: problems}
+	}
+	return nil
+}
+
+// convertValue 将配置文件中的值转换为键要求的类型
+func convertValue(spec keySpec, value interface{}) (interface{}, error) {
+	switch spec.kind {
+	case kindString, kindPath:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("%s 应为字符串", spec.name)
+	case kindStrings:
+		switch v := value.(type) {
+		case []string:
+			return v, nil
+		case string:
+			return []string{v}, nil
+		}
+		return nil, fmt.Errorf("%s 应为字符串数组", spec.name)
+	case kindInt:
+		if n, ok := value.(int64); ok {
+			return n, nil
+		}
+		return nil, fmt.Errorf("%s 应为整数", spec.name)
+	case kindFloat:
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case int64:
+			return float64(v), nil
+		}
+		return nil, fmt.Errorf("%s 应为数字", spec.name)
+	case kindBool:
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+		return nil, fmt.Errorf("%s 应为 true 或 false", spec.name)
+	}
+	return nil, fmt.Errorf("%s 的类型未知", spec.name)
+}
+
+// parseEnvValue 将环境变量文本转换为键要求的类型，数组用逗号分隔
+func parseEnvValue(spec keySpec, text string) (interface{}, error) {
+	switch spec.kind {
+	case kindStrings:
+		var values []string
+		for _, part := range strings.Split(text, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				values = append(values, part)
+			}
+		}
+		return values, nil
+	case kindInt:
+		n, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("应为整数: %s", text)
+		}
+		return n, nil
+	case kindFloat:
+		f, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(text), "%"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("应为数字: %s", text)
+		}
+		return f, nil
+	case kindBool:
+		b, err := strconv.ParseBool(strings.TrimSpace(text))
+		if err != nil {
+			return nil, fmt.Errorf("应为 true 或 false: %s", text)
+		}
+		return b, nil
+	}
+	return text, nil
+}
+
+// resolvePath 将配置文件中的相对路径解析为相对于配置文件所在目录的路径
+func resolvePath(configPath, path string) string {
+	if path == "" || filepath.IsAbs(path) || strings.HasPrefix(path, "~") {
+		return path
+	}
+	return filepath.Join(filepath.Dir(configPath), path)
+}
+
+// formatTableName 档案名不是裸键时加引号
+func formatTableName(name string) string {
+	if validBareKey(name) {
+		return name
+	}
+	return strconv.Quote(name)
+}
+
+func checkSubscriptions(v Value) error {
+	subs, _ := v.Raw.([]string)
+	if len(subs) == 0 {
+		return fmt.Errorf("订阅列表为空")
+	}
+	for _, sub := range subs {
+		if err := checkURL(Value{Raw: sub}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkURL(v Value) error {
+	s, _ := v.Raw.(string)
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("无效的URL %q（需要 http:// 或 https:// 开头）", s)
+	}
+	return nil
+}
+
+func checkPort(v Value) error {
+	n, _ := v.Raw.(int64)
+	if n < 1 || n > 65535 {
+		return fmt.Errorf("端口 %d 超出范围 (1-65535)", n)
+	}
+	return nil
+}
+
+func checkPositive(v Value) error {
+	n, _ := v.Raw.(int64)
+	if n < 1 {
+		return fmt.Errorf("应为正整数，实际为 %d", n)
+	}
+	return nil
+}
+
+func checkNonNegative(v Value) error {
+	f, _ := v.Raw.(float64)
+	if f < 0 {
+		return fmt.Errorf("不能为负数")
+	}
+	return nil
+}
+
+func checkMbps(v Value) error {
+	n, _ := v.Raw.(int64)
+	if n < 0 {
+		return fmt.Errorf("带宽不能为负数（0 表示使用BBR拥塞控制）")
+	}
+	return nil
+}
+
+func checkDuration(v Value) error {
+	s, _ := v.Raw.(string)
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("无效的时长 %q（请使用如 30s, 10m, 1h 等格式）", s)
+	}
+	if d < 0 {
+		return fmt.Errorf("时长不能为负数")
+	}
+	return nil
+}
+
+func checkPositiveDuration(v Value) error {
+	if err := checkDuration(v); err != nil {
+		return err
+	}
+	if d, _ := time.ParseDuration(v.Raw.(string)); d == 0 {
+		return fmt.Errorf("时长必须大于0")
+	}
+	return nil
+}
+
+func checkInterval(v Value) error {
+	if err := checkPositiveDuration(v); err != nil {
+		return err
+	}
+	if d, _ := time.ParseDuration(v.Raw.(string)); d%time.Minute != 0 {
+		return fmt.Errorf("测试间隔必须是整分钟，如 5m 或 1h")
+	}
+	return nil
+}
+
+func checkSchedule(v Value) error {
+	if _, err := schedule.Parse(v.Raw.(string)); err != nil {
+		return fmt.Errorf("无效的调度表达式: %v", err)
+	}
+	return nil
+}
+
+func checkQuietHours(v Value) error {
+	if _, err := schedule.ParseWindows(v.Raw.(string)); err != nil {
+		return fmt.Errorf("无效的静默时段: %v", err)
+	}
+	return nil
+}
+
+func checkFilter(v Value) error {
+	_, err := filter.LoadFile(v.Raw.(string))
+	return err
+}
+
+func checkVersion(v Value) error {
+	s, _ := v.Raw.(string)
+	if !downloader.ValidVersion(s) {
+		return fmt.Errorf("无效的版本号 %q（如 v5.33.0）", s)
+	}
+	return nil
+}
+
+func checkPreferredCore(v Value) error {
+	s, _ := v.Raw.(string)
+	switch s {
+	case downloader.CoreV2Ray, downloader.CoreXray, downloader.CoreSingBox:
+	default:
+		return fmt.Errorf("无效的首选核心 %q（可用: v2ray, xray, sing-box）", s)
+	}
+	return nil
+}
+
+func checkMirrors(v Value) error {
+	mirrors, _ := v.Raw.([]string)
+	for _, mirror := range mirrors {
+		if err := downloader.ValidMirror(mirror); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkAssetSource(v Value) error {
+	s, _ := v.Raw.(string)
+	return downloader.ValidAssetSource(s)
+}
+
+func checkFrontNode(v Value) error {
+	s, _ := v.Raw.(string)
+	_, err := proxy.NewChain(s, nil)
+	return err
+}
+
+func checkChainNodes(v Value) error {
+	patterns, _ := v.Raw.([]string)
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("无效的出口节点规则 %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,378 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 配置文件使用TOML的一个子集：
+//   - # 注释、[表名] 和 [表名.子表] 表头（表名可加引号）
+//   - key = value，值可以是字符串（"..." 或 '...'）、整数、浮点数、true/false、字符串数组
+//   - "..." 中支持TOML定义的转义（\b \t \n \f \r \e \" \\ \uXXXX \UXXXXXXXX），'...' 不处理转义
+//   - 字符串数组可以跨多行书写
+// 不支持内联表、日期时间和多行字符串。
+
+// tomlEntry 解析出的一个键值对
+type tomlEntry struct {
+	table []string // 所在的表，顶层为空
+	key   string
+	value interface{} // string / []string / int64 / float64 / bool
+	line  int
+}
+
+// parseTOML 解析配置文本，返回所有键值对和语法错误（每个错误带行号）
+func parseTOML(text string) ([]tomlEntry, []string) {
+	var (
+		entries  []tomlEntry
+		problems []string
+		table    []string
+	)
+
+	lines := strings.Split(text, "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(stripComment(lines[i]))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				problems = append(problems, fmt.Sprintf("第%d行: 表头缺少 ]", lineNo))
+				continue
+			}
+			parts, err := splitTableName(strings.TrimSpace(line[1 : len(line)-1]))
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("第%d行: %v", lineNo, err))
+				continue
+			}
+			table = parts
+			continue
+		}
+
+		eq := indexOutsideQuotes(line, '=')
+		if eq < 0 {
+			problems = append(problems, fmt.Sprintf("第%d行: 应为 key = value 格式", lineNo))
+			continue
+		}
+		key := strings.TrimSpace(line[:eq])
+		raw := strings.TrimSpace(line[eq+1:])
+		if !validBareKey(key) {
+			problems = append(problems, fmt.Sprintf("第%d行: 无效的键名 %q", lineNo, key))
+			continue
+		}
+
+		// 跨多行的数组：一直读到括号闭合
+		if strings.HasPrefix(raw, "[") {
+			for bracketDepth(raw) > 0 && i+1 < len(lines) {
+				i++
+				raw += " " + strings.TrimSpace(stripComment(lines[i]))
+			}
+		}
+
+		value, err := parseValue(raw)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("第%d行: %s: %v", lineNo, key, err))
+			continue
+		}
+		entries = append(entries, tomlEntry{table: table, key: key, value: value, line: lineNo})
+	}
+
+	return entries, problems
+}
+
+// parseValue 解析单个值
+func parseValue(raw string) (interface{}, error) {
+	switch {
+	case raw == "":
+		return nil, fmt.Errorf("缺少值")
+	case raw == "true":
+		return true, nil
+	case raw == "false":
+		return false, nil
+	case strings.HasPrefix(raw, "\""), strings.HasPrefix(raw, "'"):
+		return parseString(raw)
+	case strings.HasPrefix(raw, "["):
+		return parseArray(raw)
+	}
+
+	number := strings.ReplaceAll(raw, "_", "")
+	if n, err := strconv.ParseInt(number, 10, 64); err == nil {
+		return n, nil
+	}
+	if f, err := strconv.ParseFloat(number, 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("无法识别的值 %s（字符串需要加引号）", raw)
+}
+
+// parseString 解析 "基本字符串" 或 '字面字符串'
+// 基本字符串按TOML规则处理转义，不接受 \x41、\a 等Go特有的转义；字面字符串原样保留，不能包含单引号
+func parseString(raw string) (string, error) {
+	if len(raw) < 2 || raw[len(raw)-1] != raw[0] {
+		return "", fmt.Errorf("字符串缺少结束引号")
+	}
+	body := raw[1 : len(raw)-1]
+	if raw[0] == '\'' {
+		for _, r := range body {
+			if r == '\'' {
+				return "", fmt.Errorf("字面字符串中不能包含单引号: %s", raw)
+			}
+			if isControl(r) {
+				return "", fmt.Errorf("字符串中包含控制字符 %U，需要使用基本字符串转义", r)
+			}
+		}
+		return body, nil
+	}
+	return unescapeBasic(body)
+}
+
+// unescapeBasic 处理基本字符串的转义序列
+func unescapeBasic(body string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(body); {
+		r, size := utf8.DecodeRuneInString(body[i:])
+		if r == utf8.RuneError && size == 1 {
+			return "", fmt.Errorf("字符串不是有效的UTF-8")
+		}
+		switch {
+		case r == '"':
+			return "", fmt.Errorf("字符串中的双引号需要转义为 \\\"")
+		case isControl(r):
+			return "", fmt.Errorf("字符串中包含控制字符 %U，需要转义", r)
+		case r != '\\':
+			b.WriteRune(r)
+			i += size
+			continue
+		}
+
+		if i+1 >= len(body) {
+			return "", fmt.Errorf("字符串以不完整的转义结尾")
+		}
+		esc := body[i+1]
+		i += 2
+		switch esc {
+		case 'b':
+			b.WriteByte('\b')
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'f':
+			b.WriteByte('\f')
+		case 'r':
+			b.WriteByte('\r')
+		case 'e':
+			b.WriteByte(0x1b)
+		case '"':
+			b.WriteByte('"')
+		case '\\':
+			b.WriteByte('\\')
+		case 'u', 'U':
+			digits := 4
+			if esc == 'U' {
+				digits = 8
+			}
+			if i+digits > len(body) {
+				return "", fmt.Errorf("\\%c 转义需要 %d 位十六进制数", esc, digits)
+			}
+			code, err := strconv.ParseUint(body[i:i+digits], 16, 32)
+			if err != nil {
+				return "", fmt.Errorf("无效的转义 \\%c%s", esc, body[i:i+digits])
+			}
+			if !utf8.ValidRune(rune(code)) {
+				return "", fmt.Errorf("转义 \\%c%s 不是有效的Unicode字符", esc, body[i:i+digits])
+			}
+			b.WriteRune(rune(code))
+			i += digits
+		default:
+			return "", fmt.Errorf("不支持的转义 \\%c", esc)
+		}
+	}
+	return b.String(), nil
+}
+
+// isControl 字符串中不允许直接出现的控制字符（制表符除外）
+func isControl(r rune) bool {
+	return r < 0x20 && r != '\t' || r == 0x7f
+}
+
+// parseArray 解析字符串数组，允许末尾逗号
+func parseArray(raw string) ([]string, error) {
+	if bracketDepth(raw) != 0 || !strings.HasSuffix(raw, "]") {
+		return nil, fmt.Errorf("数组缺少 ]")
+	}
+	body := strings.TrimSpace(raw[1 : len(raw)-1])
+
+	values := []string{}
+	for body != "" {
+		end := indexOutsideQuotes(body, ',')
+		item := body
+		if end >= 0 {
+			item, body = body[:end], body[end+1:]
+		} else {
+			body = ""
+		}
+		item = strings.TrimSpace(item)
+		body = strings.TrimSpace(body)
+		if item == "" {
+			if body == "" {
+				break // 末尾逗号
+			}
+			return nil, fmt.Errorf("数组中有空元素")
+		}
+		s, err := parseString(item)
+		if err != nil {
+			return nil, fmt.Errorf("数组元素必须是字符串: %s", item)
+		}
+		values = append(values, s)
+	}
+	return values, nil
+}
+
+// splitTableName 拆分表名，如 profiles."家里" -> [profiles 家里]
+func splitTableName(name string) ([]string, error) {
+	if name == "" {
+		return nil, fmt.Errorf("表名为空")
+	}
+
+	var parts []string
+	for name != "" {
+		end := indexOutsideQuotes(name, '.')
+		part := name
+		if end >= 0 {
+			part, name = name[:end], name[end+1:]
+		} else {
+			name = ""
+		}
+		part = strings.TrimSpace(part)
+		if strings.HasPrefix(part, "\"") || strings.HasPrefix(part, "'") {
+			s, err := parseString(part)
+			if err != nil {
+				return nil, err
+			}
+			part = s
+		} else if !validBareKey(part) {
+			return nil, fmt.Errorf("无效的表名 %q", part)
+		}
+		if part == "" {
+			return nil, fmt.Errorf("表名中有空的部分")
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// stripComment 去掉引号外的 # 注释
+func stripComment(line string) string {
+	if i := indexOutsideQuotes(line, '#'); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
+
+// indexOutsideQuotes 返回引号外第一个 c 的位置，不存在时返回-1
+func indexOutsideQuotes(s string, c byte) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++ // 跳过转义字符
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '"' || s[i] == '\'':
+			quote = s[i]
+		case s[i] == c:
+			return i
+		}
+	}
+	return -1
+}
+
+// bracketDepth 返回引号外未闭合的 [ 数量
+func bracketDepth(s string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '"' || s[i] == '\'':
+			quote = s[i]
+		case s[i] == '[':
+			depth++
+		case s[i] == ']':
+			depth--
+		}
+	}
+	return depth
+}
+
+// validBareKey 是否为合法的裸键名（字母、数字、下划线和短横线）
+func validBareKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// formatValue 按配置文件语法输出值
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return quoteBasic(v)
+	case []string:
+		quoted := make([]string, len(v))
+		for i, s := range v {
+			quoted[i] = quoteBasic(s)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// quoteBasic 输出TOML基本字符串，只使用TOML定义的转义（strconv.Quote 会产生 \x00、\a 等TOML不认识的转义）
+func quoteBasic(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if isControl(r) {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseStringEscapes(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{name: "plain", raw: `"hello"`, want: "hello"},
+		{name: "empty", raw: `""`, want: ""},
+		{name: "short_escapes", raw: `"a\tb\nc\rd\be\ff"`, want: "a\tb\nc\rd\be\ff"},
+		{name: "quote_backslash", raw: `"say \"hi\" C:\\dir"`, want: `say "hi" C:\dir`},
+		{name: "escape_char", raw: `"\e[0m"`, want: "\x1b[0m"},
+		{name: "unicode_short", raw: `"\u4E2D\u6587"`, want: "中文"},
+		{name: "unicode_long", raw: `"\U0001F600"`, want: "😀"},
+		{name: "literal_tab", raw: "\"a\tb\"", want: "a\tb"},
+		{name: "non_ascii", raw: `"家里的节点"`, want: "家里的节点"},
+		{name: "literal_string", raw: `'C:\Users\x41\n'`, want: `C:\Users\x41\n`},
+		{name: "literal_double_quote", raw: `'say "hi"'`, want: `say "hi"`},
+		{name: "literal_tab_in_literal", raw: "'a\tb'", want: "a\tb"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseString(tt.raw)
+			if err != nil {
+				t.Fatalf("parseString(%s) 出错: %v", tt.raw, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseString(%s) = %q，期望 %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseStringRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		// Go 特有的转义在TOML中无效
+		{name: "hex_escape", raw: `"\x41"`},
+		{name: "bell_escape", raw: `"\a"`},
+		{name: "vertical_tab_escape", raw: `"\v"`},
+		{name: "octal_escape", raw: `"\101"`},
+		{name: "single_quote_escape", raw: `"\'"`},
+		{name: "short_unicode", raw: `"\u41"`},
+		{name: "short_long_unicode", raw: `"\U0001F6"`},
+		{name: "non_hex_unicode", raw: `"\u00G1"`},
+		{name: "surrogate", raw: `"\uD800"`},
+		{name: "out_of_range", raw: `"\U00110000"`},
+		{name: "trailing_backslash", raw: `"abc\"`},
+		{name: "unescaped_quote", raw: `"a"b"`},
+		{name: "raw_newline_char", raw: "\"a\x01b\""},
+		{name: "delete_char", raw: "\"a\x7fb\""},
+		{name: "missing_close", raw: `"abc`},
+		{name: "mismatched_quotes", raw: `"abc'`},
+		{name: "literal_with_quote", raw: `'it's'`},
+		{name: "literal_control", raw: "'a\x01b'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := parseString(tt.raw); err == nil {
+				t.Errorf("parseString(%s) = %q，期望返回错误", tt.raw, got)
+			}
+		})
+	}
+}
+
+func TestParseTOML(t *testing.T) {
+	text := `# 顶层注释
+subscription_url = "https://example.com/sub?token=a#b"  # 行尾注释，引号内的 # 保留
+http_port = 8080 # 端口
+ratio = 0.25
+enabled = true
+path = 'C:\v2ray\#notcomment'
+
+[profiles."家里"]
+test_urls = [
+  "https://www.google.com",   # 第一个
+  'https://cp.cloudflare.com', # 字面字符串
+  "https://a.com/[x]",
+]
+empty = []
+
+[ "quoted" . 'literal.dot' ]
+name = "a\tb"
+`
+	entries, problems := parseTOML(text)
+	if len(problems) > 0 {
+		t.Fatalf("解析出错: %v", problems)
+	}
+
+	type kv struct {
+		table string
+		key   string
+		value interface{}
+		line  int
+	}
+	var got []kv
+	for _, e := range entries {
+		got = append(got, kv{strings.Join(e.table, "|"), e.key, e.value, e.line})
+	}
+	want := []kv{
+		{"", "subscription_url", "https://example.com/sub?token=a#b", 2},
+		{"", "http_port", int64(8080), 3},
+		{"", "ratio", 0.25, 4},
+		{"", "enabled", true, 5},
+		{"", "path", `C:\v2ray\#notcomment`, 6},
+		{"profiles|家里", "test_urls", []string{"https://www.google.com", "https://cp.cloudflare.com", "https://a.com/[x]"}, 9},
+		{"profiles|家里", "empty", []string{}, 14},
+		{"quoted|literal.dot", "name", "a\tb", 17},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("解析结果:\n%#v\n期望:\n%#v", got, want)
+	}
+}
+
+func TestParseTOMLProblems(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "go_escape", text: `url = "\x41"`, want: "第1行"},
+		{name: "unclosed_table", text: "[profiles", want: "缺少 ]"},
+		{name: "unclosed_array", text: "urls = [\n  \"a\",\n", want: "数组缺少 ]"},
+		{name: "non_string_array", text: "urls = [1, 2]", want: "数组元素必须是字符串"},
+		{name: "bad_key", text: "a b = 1", want: "无效的键名"},
+		{name: "bare_string", text: "url = https://example.com", want: "字符串需要加引号"},
+		{name: "empty_table_part", text: `[profiles.""]`, want: "表名中有空的部分"},
+		{name: "line_number", text: "a = 1\n\n# 注释\nb = \"\\a\"", want: "第4行"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, problems := parseTOML(tt.text)
+			if len(problems) == 0 {
+				t.Fatalf("期望解析错误")
+			}
+			if !strings.Contains(strings.Join(problems, "\n"), tt.want) {
+				t.Errorf("错误 %v 中应包含 %q", problems, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatValueRoundTrip(t *testing.T) {
+	for _, s := range []string{
+		"plain",
+		`quote " and backslash \`,
+		"tab\tnewline\ncr\r",
+		"bell\a vtab\v nul\x00 esc\x1b del\x7f",
+		"中文 😀",
+	} {
+		formatted := formatValue(s)
+		got, err := parseString(formatted)
+		if err != nil {
+			t.Errorf("formatValue(%q) = %s 无法解析: %v", s, formatted, err)
+			continue
+		}
+		if got != s {
+			t.Errorf("往返结果 %q，期望 %q（中间值 %s）", got, s, formatted)
+		}
+	}
+
+	if got := formatValue([]string{"a", `b"c`}); got != `["a", "b\"c"]` {
+		t.Errorf("formatValue(数组) = %s", got)
+	}
+}
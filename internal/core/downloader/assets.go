@@ -0,0 +1,476 @@
+package downloader
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 路由规则使用的数据文件（geoip:cn、geosite:google 等规则需要）
+const (
+	AssetGeoIP   = "geoip"
+	AssetGeoSite = "geosite"
+)
+
+// Assets 支持的数据文件
+var Assets = []string{AssetGeoIP, AssetGeoSite}
+
+// assetsRepo 默认的数据文件来源，每天发布，带 <文件名>.sha256sum 校验文件
+const assetsRepo = "Loyalsoldier/v2ray-rules-dat"
+
+// DefaultAssetRefresh 数据文件的默认更新周期
+const DefaultAssetRefresh = 7 * 24 * time.Hour
+
+// assetRetryInterval 更新失败后再次尝试的间隔，避免离线时反复下载
+const assetRetryInterval = time.Hour
+
+// assetsStateFile 数据文件目录中记录来源、版本和摘要的文件
+const assetsStateFile = "assets.json"
+
+// AssetInfo 已安装的数据文件
+type AssetInfo struct {
+	Name      string    `json:"name"`
+	Source    string    `json:"source"`
+	Tag       string    `json:"tag,omitempty"` // 发布标签（默认来源为发布日期）
+	SHA256    string    `json:"sha256"`
+	Size      int64     `json:"size"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AssetCategory 数据文件中的分类，如 geosite 的 google、geoip 的 cn
+type AssetCategory struct {
+	Name    string `json:"name"`
+	Entries int    `json:"entries"` // 分类中的域名或IP段数量
+}
+
+var (
+	assetMutex   sync.Mutex
+	installMutex sync.Mutex // 串行化数据文件的下载安装
+	assetSources = make(map[string]string)
+	assetRefresh = DefaultAssetRefresh
+
+	// assetAttempts 本进程内每个数据文件最近一次尝试更新的时间
+	assetAttempts = make(map[string]time.Time)
+)
+
+// AssetDir 数据文件目录，所有核心版本共用，启动V2Ray/Xray时通过环境变量指定
+func AssetDir() string {
+	return filepath.Join(StoreRoot(), "assets")
+}
+
+// AssetPath 数据文件路径，如 cores/assets/geoip.dat
+func AssetPath(name string) string {
+	return filepath.Join(AssetDir(), name+".dat")
+}
+
+// AssetEnv 启动V2Ray/Xray时设置的环境变量，让核心从数据文件目录读取 geoip.dat、geosite.dat
+func AssetEnv() []string {
+	dir, err := filepath.Abs(AssetDir())
+	if err != nil {
+		dir = AssetDir()
+	}
+	return []string{
+		"V2RAY_LOCATION_ASSET=" + dir,
+		"XRAY_LOCATION_ASSET=" + dir,
+	}
+}
+
+// validAsset 检查数据文件名
+func validAsset(name string) error {
+	for _, asset := range Assets {
+		if name == asset {
+			return nil
+		}
+	}
+	return fmt.Errorf("未知的数据文件: %s (可用: %s)", name, strings.Join(Assets, ", "))
+}
+
+// ValidAssetSource 检查数据文件来源：http(s) 地址或本地文件路径
+func ValidAssetSource(source string) error {
+	if isLocalSource(source) {
+		if strings.TrimSpace(source) == "" {
+			return fmt.Errorf("数据文件来源为空")
+		}
+		return nil
+	}
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("无效的数据文件地址 %q", source)
+	}
+	return nil
+}
+
+// SetAssetSource 设置数据文件的来源（来自档案的 geoip_source / geosite_source），空字符串恢复默认来源
+func SetAssetSource(name, source string) error {
+	if err := validAsset(name); err != nil {
+		return err
+	}
+	if source != "" {
+		if err := ValidAssetSource(source); err != nil {
+			return err
+		}
+	}
+
+	assetMutex.Lock()
+	defer assetMutex.Unlock()
+	if source == "" {
+		delete(assetSources, name)
+	} else {
+		assetSources[name] = source
+	}
+	return nil
+}
+
+// AssetSource 返回数据文件的自定义来源，使用默认来源时返回空
+func AssetSource(name string) string {
+	assetMutex.Lock()
+	defer assetMutex.Unlock()
+	return assetSources[name]
+}
+
+// SetAssetRefresh 设置数据文件的更新周期，0 表示不自动更新
+func SetAssetRefresh(d time.Duration) {
+	assetMutex.Lock()
+	defer assetMutex.Unlock()
+	assetRefresh = d
+}
+
+// assetMirrors 数据文件的下载来源：自定义来源（校验文件为 <来源>.sha256sum 或 <来源>.sha256），
+// 或按配置的镜像顺序从默认发布页下载
+func assetMirrors(name string) []DownloadMirror {
+	file := name + ".dat"
+	source := AssetSource(name)
+	if source == "" {
+		return releaseMirrors(assetsRepo, "latest", file, file+".sha256sum")
+	}
+
+	mirror := DownloadMirror{
+		Name:       source,
+		URL:        source,
+		DigestURLs: []string{source + ".sha256sum", source + ".sha256"},
+	}
+	if isLocalSource(source) {
+		mirror.Name = "本地文件"
+	}
+	return []DownloadMirror{mirror}
+}
+
+// sourceFileName 来源中的文件名，用于在校验文件中查找摘要
+func sourceFileName(source string) string {
+	if isLocalSource(source) {
+		return filepath.Base(source)
+	}
+	if u, err := url.Parse(source); err == nil {
+		return path.Base(u.Path)
+	}
+	return path.Base(source)
+}
+
+// readAssetsState 读取数据文件记录，文件不存在时返回空记录；调用者需持有 assetMutex
+func readAssetsState() (map[string]AssetInfo, error) {
+	state := make(map[string]AssetInfo)
+	data, err := os.ReadFile(filepath.Join(AssetDir(), assetsStateFile))
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %v", assetsStateFile, err)
+	}
+	return state, nil
+}
+
+// writeAssetsState 写入数据文件记录；调用者需持有 assetMutex
+func writeAssetsState(state map[string]AssetInfo) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(AssetDir(), assetsStateFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// InstalledAsset 返回已安装的数据文件，文件被删除时视为未安装
+func InstalledAsset(name string) (AssetInfo, bool) {
+	assetMutex.Lock()
+	defer assetMutex.Unlock()
+
+	state, err := readAssetsState()
+	if err != nil {
+		return AssetInfo{}, false
+	}
+	info, ok := state[name]
+	if !ok || !fileExists(AssetPath(name)) {
+		return AssetInfo{}, false
+	}
+	return info, true
+}
+
+// InstallAsset 下载（或复制本地文件）并校验数据文件，通过后替换已安装的文件。
+// 校验文件中的SHA256不一致或取不到校验文件时拒绝安装，原有文件保持不变
+func InstallAsset(name string) (AssetInfo, error) {
+	if err := validAsset(name); err != nil {
+		return AssetInfo{}, err
+	}
+	installMutex.Lock()
+	defer installMutex.Unlock()
+	return installAsset(name)
+}
+
+// installAsset 依次尝试各个来源；调用者需持有 installMutex
+func installAsset(name string) (AssetInfo, error) {
+	if err := os.MkdirAll(AssetDir(), 0755); err != nil {
+		return AssetInfo{}, fmt.Errorf("创建数据文件目录失败: %v", err)
+	}
+
+	download := AssetPath(name) + ".download"
+	var lastErr error
+	for _, mirror := range assetMirrors(name) {
+		fmt.Printf("📥 下载 %s.dat (%s): %s\n", name, mirror.Name, mirror.URL)
+
+		releaseURL, err := fetchFile(mirror.URL, download)
+		if err == nil {
+			var info AssetInfo
+			if info, err = verifyAssetFile(name, mirror, releaseURL, download); err == nil {
+				return info, saveAsset(info, download)
+			}
+			os.Remove(download)
+		}
+		lastErr = err
+		fmt.Printf("❌ %v\n", err)
+	}
+	return AssetInfo{}, fmt.Errorf("%s.dat 的所有来源都失败: %v", name, lastErr)
+}
+
+// saveAsset 用校验通过的文件替换已安装的数据文件并更新记录
+func saveAsset(info AssetInfo, download string) error {
+	assetMutex.Lock()
+	defer assetMutex.Unlock()
+
+	dest := AssetPath(info.Name)
+	if err := os.Rename(download, dest); err != nil {
+		os.Remove(download)
+		return fmt.Errorf("替换 %s 失败: %v", dest, err)
+	}
+	state, err := readAssetsState()
+	if err != nil {
+		state = make(map[string]AssetInfo)
+	}
+	state[info.Name] = info
+	if err := writeAssetsState(state); err != nil {
+		fmt.Printf("⚠️ 写入 %s 失败: %v\n", assetsStateFile, err)
+	}
+	fmt.Printf("✅ %s.dat 已更新: %s\n", info.Name, dest)
+	return nil
+}
+
+// verifyAssetFile 用来源的校验文件校验下载的数据文件，返回安装记录
+func verifyAssetFile(name string, mirror DownloadMirror, releaseURL, path string) (AssetInfo, error) {
+	actual, err := FileSHA256(path)
+	if err != nil {
+		return AssetInfo{}, fmt.Errorf("计算SHA256失败: %v", err)
+	}
+
+	file := sourceFileName(mirror.URL)
+	expected, source, err := fetchDigest(mirror.DigestURLs, file)
+	if err != nil {
+		return AssetInfo{}, fmt.Errorf("无法获取 %s 的校验文件，拒绝安装: %v", file, err)
+	}
+	if actual != expected {
+		return AssetInfo{}, fmt.Errorf("%s 的SHA256与校验文件不符 (期望 %s)，拒绝安装", file, expected)
+	}
+	fmt.Printf("✅ 与校验文件一致: %s\n", source)
+
+	if _, err := assetCategories(path); err != nil {
+		return AssetInfo{}, fmt.Errorf("%s 不是有效的数据文件: %v", file, err)
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return AssetInfo{}, err
+	}
+	return AssetInfo{
+		Name:      name,
+		Source:    mirror.URL,
+		Tag:       releaseTag(releaseURL),
+		SHA256:    actual,
+		Size:      stat.Size(),
+		UpdatedAt: time.Now(),
+	}, nil
+}
+
+// assetStale 已安装的数据文件是否超过了更新周期。从本地文件安装的只在安装时复制一次，不自动更新
+func assetStale(info AssetInfo, now time.Time) bool {
+	assetMutex.Lock()
+	defer assetMutex.Unlock()
+	if assetRefresh <= 0 || isLocalSource(info.Source) {
+		return false
+	}
+	return now.Sub(info.UpdatedAt) >= assetRefresh
+}
+
+// tryAsset 记录一次下载尝试，同一进程内失败后一段时间内不再尝试
+func tryAsset(name string, now time.Time) bool {
+	assetMutex.Lock()
+	defer assetMutex.Unlock()
+	if last, ok := assetAttempts[name]; ok && now.Sub(last) < assetRetryInterval {
+		return false
+	}
+	assetAttempts[name] = now
+	return true
+}
+
+// EnsureAssets 安装缺少的数据文件，更新超过更新周期的数据文件。
+// 缺少数据文件且无法安装时返回错误；已有文件更新失败只给出提示，继续使用旧文件
+func EnsureAssets() error {
+	installMutex.Lock()
+	defer installMutex.Unlock()
+
+	now := time.Now()
+	for _, name := range Assets {
+		info, installed := InstalledAsset(name)
+		if installed && !assetStale(info, now) {
+			continue
+		}
+		if !tryAsset(name, now) {
+			if !installed {
+				return fmt.Errorf("%s.dat 未安装（最近一次下载失败，运行 assets install 重试）", name)
+			}
+			continue
+		}
+		if _, err := installAsset(name); err != nil {
+			if !installed {
+				return err
+			}
+			fmt.Printf("⚠️ 更新 %s.dat 失败，继续使用现有文件: %v\n", name, err)
+		}
+	}
+	return nil
+}
+
+// RefreshStaleAssets 只更新已安装且超过更新周期的数据文件，供长时间运行的进程定期调用
+func RefreshStaleAssets() {
+	installMutex.Lock()
+	defer installMutex.Unlock()
+
+	now := time.Now()
+	for _, name := range Assets {
+		info, installed := InstalledAsset(name)
+		if !installed || !assetStale(info, now) || !tryAsset(name, now) {
+			continue
+		}
+		if _, err := installAsset(name); err != nil {
+			fmt.Printf("⚠️ 更新 %s.dat 失败，继续使用现有文件: %v\n", name, err)
+		}
+	}
+}
+
+// AssetCategories 返回已安装数据文件中的分类（按名称排序），用于构建 geoip:<分类>、geosite:<分类> 规则
+func AssetCategories(name string) ([]AssetCategory, error) {
+	if err := validAsset(name); err != nil {
+		return nil, err
+	}
+	if _, ok := InstalledAsset(name); !ok {
+		return nil, fmt.Errorf("%s.dat 未安装", name)
+	}
+	return assetCategories(AssetPath(name))
+}
+
+// assetCategories 解析数据文件。geoip.dat 和 geosite.dat 都是 protobuf 列表：
+// 字段1为重复的条目，每个条目的字段1为分类名（country_code），字段2为重复的IP段或域名
+func assetCategories(path string) ([]AssetCategory, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var categories []AssetCategory
+	err = walkProtoFields(data, func(field uint64, entry []byte) error {
+		if field != 1 {
+			return nil
+		}
+		var category AssetCategory
+		err := walkProtoFields(entry, func(field uint64, value []byte) error {
+			switch field {
+			case 1:
+				category.Name = strings.ToLower(string(value))
+			case 2:
+				category.Entries++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if category.Name != "" {
+			categories = append(categories, category)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(categories) == 0 {
+		return nil, fmt.Errorf("没有任何分类")
+	}
+
+	sort.Slice(categories, func(i, j int) bool {
+		return categories[i].Name < categories[j].Name
+	})
+	return categories, nil
+}
+
+// walkProtoFields 遍历 protobuf 消息的字段，对长度分隔（wire type 2）的字段调用 fn，其余类型跳过
+func walkProtoFields(data []byte, fn func(field uint64, value []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return fmt.Errorf("数据格式错误")
+		}
+		data = data[n:]
+
+		switch key & 7 {
+		case 0: // varint
+			if _, n = binary.Uvarint(data); n <= 0 {
+				return fmt.Errorf("数据格式错误")
+			}
+			data = data[n:]
+		case 1: // 64位
+			if len(data) < 8 {
+				return fmt.Errorf("数据格式错误")
+			}
+			data = data[8:]
+		case 2: // 长度分隔
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return fmt.Errorf("数据格式错误")
+			}
+			if err := fn(key>>3, data[n:n+int(length)]); err != nil {
+				return err
+			}
+			data = data[n+int(length):]
+		case 5: // 32位
+			if len(data) < 4 {
+				return fmt.Errorf("数据格式错误")
+			}
+			data = data[4:]
+		default:
+			return fmt.Errorf("数据格式错误")
+		}
+	}
+	return nil
+}
@@ -2,15 +2,15 @@ package downloader
 
 import (
 	"fmt"
-	"io"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/yxhpy/v2ray-subscription-manager/internal/platform"
 	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
 )
 
@@ -19,22 +19,25 @@ var hysteria2DownloadMutex sync.Mutex
 
 // Hysteria2Downloader Hysteria2客户端下载器
 type Hysteria2Downloader struct {
+	Version    string // 安装的版本，latest 表示最新版本
+	Archive    string // 本地发布文件，设置后不从网络下载（离线安装）
 	BaseDir    string
 	BinaryPath string
 	ConfigPath string
 }
 
-// NewHysteria2Downloader 创建新的Hysteria2下载器
+// NewHysteria2Downloader 创建新的Hysteria2下载器，BinaryPath 按
+// 档案指定版本 > 仓库当前版本 > 旧版 ./hysteria2 > 系统PATH 的顺序选择
 func NewHysteria2Downloader() *Hysteria2Downloader {
-	binaryPath := "./hysteria2/hysteria"
-	// Windows 系统使用 .exe 扩展名
-	if runtime.GOOS == "windows" {
-		binaryPath = "./hysteria2/hysteria.exe"
+	version := ActiveVersion(CoreHysteria2)
+	if version == "" {
+		version = "latest"
 	}
 
 	return &Hysteria2Downloader{
-		BaseDir:    "./hysteria2",
-		BinaryPath: binaryPath,
+		Version:    version,
+		BaseDir:    CoreDir(CoreHysteria2),
+		BinaryPath: ExecutablePath(CoreHysteria2),
 		ConfigPath: "./hysteria2/config.yaml",
 	}
 }
@@ -46,13 +49,20 @@ func (h *Hysteria2Downloader) CheckHysteria2Installed() bool {
 		return true
 	}
 
-	// Windows 下检查可能的原始下载文件名
+	// 档案指定的版本未安装，不使用其他版本代替
+	if VersionOverride(CoreHysteria2) != "" {
+		return false
+	}
+
+	// Windows 下检查旧版安装目录中可能的原始下载文件名
 	if runtime.GOOS == "windows" {
 		originalName := "./hysteria2/hysteria-windows-amd64.exe"
+		legacy := legacyExecutablePath(CoreHysteria2)
 		if _, err := os.Stat(originalName); err == nil {
 			// 如果找到原始文件，重命名为预期的名称
-			if err := os.Rename(originalName, h.BinaryPath); err == nil {
-				fmt.Printf("✅ 发现已下载的 Hysteria2 文件，已重命名为: %s\n", h.BinaryPath)
+			if err := os.Rename(originalName, legacy); err == nil {
+				fmt.Printf("✅ 发现已下载的 Hysteria2 文件，已重命名为: %s\n", legacy)
+				h.BinaryPath = legacy
 				return true
 			}
 		}
@@ -78,59 +88,118 @@ func (h *Hysteria2Downloader) ShowHysteria2Version() {
 	fmt.Printf("📍 Hysteria2版本: %s", string(output))
 }
 
-// DownloadHysteria2 下载Hysteria2客户端
+// DownloadHysteria2 下载Hysteria2客户端到版本仓库，仓库中还没有当前版本时设为当前版本
 func (h *Hysteria2Downloader) DownloadHysteria2() error {
+	version, err := h.Install()
+	if err != nil {
+		return err
+	}
+	if CurrentVersion(CoreHysteria2) == "" {
+		if err := UseVersion(CoreHysteria2, version); err != nil {
+			return err
+		}
+	}
+	h.BinaryPath = VersionExecutable(CoreHysteria2, version)
+	return nil
+}
+
+// Install 下载并安装 Version 指定的版本到 cores/hysteria2/<版本>/（不切换当前版本），返回安装的版本
+func (h *Hysteria2Downloader) Install() (string, error) {
 	fmt.Println("🚀 开始下载 Hysteria2...")
 
-	// 清理可能存在的损坏文件
-	if _, err := os.Stat(h.BinaryPath); err == nil {
-		fmt.Printf("🗑️ 删除现有文件: %s\n", h.BinaryPath)
-		os.Remove(h.BinaryPath)
+	version := NormalizeVersion(h.Version)
+	if h.Archive != "" {
+		var err error
+		if version, err = archiveVersion(h.Archive, h.Version); err != nil {
+			return "", err
+		}
+	} else if version == "" || version == "latest" {
+		latest, err := LatestVersion(CoreHysteria2)
+		if err != nil {
+			fmt.Printf("⚠️ %v，从 latest 地址下载\n", err)
+			latest = "latest"
+		}
+		version = latest
 	}
 
 	// 创建目录
 	if err := os.MkdirAll(h.BaseDir, 0755); err != nil {
-		return fmt.Errorf("创建目录失败: %v", err)
+		return "", fmt.Errorf("创建目录失败: %v", err)
 	}
 
-	// 获取下载URL列表
-	downloadURLs, err := h.getDownloadURLs()
+	// 获取下载源列表
+	mirrors, err := h.getDownloadMirrors(version)
 	if err != nil {
-		return fmt.Errorf("获取下载链接失败: %v", err)
+		return "", fmt.Errorf("获取下载链接失败: %v", err)
 	}
 
-	fmt.Printf("📂 目标路径: %s\n", h.BinaryPath)
-
-	// 尝试从多个源下载
+	// 按镜像顺序尝试下载，先下载到临时文件，校验通过后再放到版本目录
+	downloadPath := filepath.Join(h.BaseDir, "hysteria.download")
 	var lastErr error
-	for i, downloadURL := range downloadURLs {
+	for i, mirror := range mirrors {
 		if i > 0 {
 			fmt.Printf("🔄 尝试备用下载源...\n")
 		}
 
-		fmt.Printf("📥 下载链接: %s\n", downloadURL)
+		fmt.Printf("📥 下载链接 (%s): %s\n", mirror.Name, mirror.URL)
 
-		lastErr = h.downloadFile(downloadURL, h.BinaryPath)
+		var releaseURL string
+		releaseURL, lastErr = h.downloadFile(mirror.URL, downloadPath)
+		if lastErr == nil {
+			version, lastErr = h.verifyDownload(mirror, releaseURL, downloadPath, version)
+		}
 		if lastErr == nil {
-			break // 下载成功
+			break // 下载并校验成功
 		}
 
+		os.Remove(downloadPath)
 		fmt.Printf("❌ 下载源失败: %v\n", lastErr)
 	}
 
 	if lastErr != nil {
-		return fmt.Errorf("所有下载源都失败: %v", lastErr)
+		return "", fmt.Errorf("所有下载源都失败: %v", lastErr)
+	}
+	if !ValidVersion(version) {
+		os.Remove(downloadPath)
+		return "", fmt.Errorf("无法确定下载的Hysteria2版本")
+	}
+
+	binaryPath := VersionExecutable(CoreHysteria2, version)
+	fmt.Printf("📂 目标路径: %s\n", binaryPath)
+	if err := os.MkdirAll(filepath.Dir(binaryPath), 0755); err != nil {
+		os.Remove(downloadPath)
+		return "", fmt.Errorf("创建目录失败: %v", err)
+	}
+	os.Remove(binaryPath)
+	if err := os.Rename(downloadPath, binaryPath); err != nil {
+		os.Remove(downloadPath)
+		return "", fmt.Errorf("安装可执行文件失败: %v", err)
+	}
+
+	// Windows 下验证是否为有效的 PE 文件
+	if runtime.GOOS == "windows" {
+		if err := h.validateWindowsExecutable(binaryPath); err != nil {
+			// 删除无效文件
+			os.RemoveAll(filepath.Dir(binaryPath))
+			return "", fmt.Errorf("下载的文件无效: %v", err)
+		}
 	}
 
 	// 设置执行权限
-	if err := os.Chmod(h.BinaryPath, 0755); err != nil {
-		return fmt.Errorf("设置权限失败: %v", err)
+	if err := os.Chmod(binaryPath, 0755); err != nil {
+		return "", fmt.Errorf("设置权限失败: %v", err)
 	}
 
-	fmt.Println("✅ Hysteria2 下载完成!")
-	h.ShowHysteria2Version()
+	// 记录可执行文件的SHA256，启动前校验
+	if err := recordBinary(CoreHysteria2, version, binaryPath); err != nil {
+		fmt.Printf("⚠️ 记录可执行文件摘要失败: %v\n", err)
+	}
 
-	return nil
+	fmt.Printf("✅ Hysteria2 %s 下载完成!\n", version)
+	installed := &Hysteria2Downloader{BinaryPath: binaryPath}
+	installed.ShowHysteria2Version()
+
+	return version, nil
 }
 
 // SafeDownloadHysteria2 安全下载Hysteria2（带互斥锁）
@@ -163,111 +232,103 @@ func (h *Hysteria2Downloader) SafeDownloadHysteria2() error {
 	return fmt.Errorf("下载失败，已重试3次: %v", lastErr)
 }
 
-// getDownloadURL 获取对应平台的下载链接
-func (h *Hysteria2Downloader) getDownloadURL() (string, error) {
-	var suffix string
+// assetName 获取对应平台的发布文件名
+func (h *Hysteria2Downloader) assetName() (string, error) {
 	switch runtime.GOOS {
 	case "darwin":
 		if runtime.GOARCH == "amd64" {
-			suffix = "hysteria-darwin-amd64"
+			return "hysteria-darwin-amd64", nil
 		} else if runtime.GOARCH == "arm64" {
-			suffix = "hysteria-darwin-arm64"
-		} else {
-			return "", fmt.Errorf("不支持的架构: %s", runtime.GOARCH)
+			return "hysteria-darwin-arm64", nil
 		}
+		return "", fmt.Errorf("不支持的架构: %s", runtime.GOARCH)
 	case "linux":
 		if runtime.GOARCH == "amd64" {
-			suffix = "hysteria-linux-amd64"
+			return "hysteria-linux-amd64", nil
 		} else if runtime.GOARCH == "arm64" {
-			suffix = "hysteria-linux-arm64"
-		} else {
-			return "", fmt.Errorf("不支持的架构: %s", runtime.GOARCH)
+			return "hysteria-linux-arm64", nil
 		}
+		return "", fmt.Errorf("不支持的架构: %s", runtime.GOARCH)
 	case "windows":
 		if runtime.GOARCH == "amd64" {
-			suffix = "hysteria-windows-amd64.exe"
-		} else {
-			return "", fmt.Errorf("不支持的架构: %s", runtime.GOARCH)
+			return "hysteria-windows-amd64.exe", nil
 		}
+		return "", fmt.Errorf("不支持的架构: %s", runtime.GOARCH)
 	default:
 		return "", fmt.Errorf("不支持的操作系统: %s", runtime.GOOS)
 	}
-
-	return "https://github.com/apernet/hysteria/releases/latest/download/" + suffix, nil
 }
 
-// getDownloadURLs 获取多个下载源
-func (h *Hysteria2Downloader) getDownloadURLs() ([]string, error) {
-	mainURL, err := h.getDownloadURL()
+// getDownloadMirrors 按配置的镜像顺序获取指定版本的下载源，latest 使用最新发布地址；
+// 指定了本地发布文件时只使用该文件（校验文件为同目录下的 hashes.txt）
+func (h *Hysteria2Downloader) getDownloadMirrors(version string) ([]DownloadMirror, error) {
+	if h.Archive != "" {
+		return []DownloadMirror{archiveMirror(h.Archive, "hashes.txt")}, nil
+	}
+
+	asset, err := h.assetName()
 	if err != nil {
 		return nil, err
 	}
 
-	// 返回多个下载源
-	urls := []string{
-		mainURL,
-		// 可以添加其他镜像源
+	tag := version
+	if version != "latest" {
+		tag = "app/" + version
 	}
-
-	return urls, nil
+	return releaseMirrors(coreRepos[CoreHysteria2], tag, asset, "hashes.txt"), nil
 }
 
-// downloadFile 下载文件
-func (h *Hysteria2Downloader) downloadFile(url, dest string) error {
-	// 创建HTTP客户端
-	client := &http.Client{
-		Timeout: 10 * time.Minute,
+// verifyDownload 用发布页的 hashes.txt 校验下载的文件，返回文件的实际版本。
+// latest 地址会重定向到具体版本，从重定向经过的地址取得版本号，固定摘要按实际版本记录
+func (h *Hysteria2Downloader) verifyDownload(mirror DownloadMirror, releaseURL, path, version string) (string, error) {
+	asset := filepath.Base(mirror.URL)
+	digestURLs := mirror.DigestURLs
+
+	if tag := releaseTag(releaseURL); tag != "" {
+		version = NormalizeVersion(tag)
+		official := mirrorURL(MirrorGitHub, coreRepos[CoreHysteria2], tag, "hashes.txt")
+		if len(digestURLs) == 0 || digestURLs[0] != official {
+			digestURLs = append([]string{official}, digestURLs...)
+		}
 	}
+	return version, verifyAsset(CoreHysteria2, version, asset, path, digestURLs)
+}
 
-	// 发送请求
-	resp, err := client.Get(url)
-	if err != nil {
-		return err
+// releaseTag 从 GitHub 发布文件地址 .../releases/download/<标签>/<文件名> 中取出标签
+func releaseTag(url string) string {
+	const marker = "/releases/download/"
+	i := strings.Index(url, marker)
+	if i < 0 {
+		return ""
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP状态错误: %d", resp.StatusCode)
+	rest := url[i+len(marker):]
+	j := strings.LastIndex(rest, "/")
+	if j <= 0 {
+		return ""
 	}
+	return rest[:j]
+}
 
-	// 创建目标文件
-	out, err := os.Create(dest)
+// downloadFile 下载文件（支持断点续传，url 为本地文件路径时直接复制），
+// 返回重定向过程中经过的 GitHub 发布文件地址（用于确定版本号）
+func (h *Hysteria2Downloader) downloadFile(url, dest string) (string, error) {
+	releaseURL, err := fetchFile(url, dest)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer out.Close()
-
-	// 显示下载进度
-	fmt.Printf("📊 开始下载 (大小: %d bytes)...\n", resp.ContentLength)
 
-	// 复制数据
-	written, err := io.Copy(out, resp.Body)
+	info, err := os.Stat(dest)
 	if err != nil {
-		return err
-	}
-
-	// 验证下载完整性
-	if resp.ContentLength > 0 && written != resp.ContentLength {
-		return fmt.Errorf("下载不完整: 期望 %d 字节，实际 %d 字节", resp.ContentLength, written)
+		return "", err
 	}
 
 	// 验证文件大小
-	if written < 1000000 { // 小于1MB可能有问题
-		return fmt.Errorf("下载的文件过小 (%d 字节)，可能下载失败", written)
-	}
-
-	fmt.Printf("✅ 下载完成，文件大小: %d 字节\n", written)
-
-	// Windows 下验证是否为有效的 PE 文件
-	if runtime.GOOS == "windows" {
-		if err := h.validateWindowsExecutable(dest); err != nil {
-			// 删除无效文件
-			os.Remove(dest)
-			return fmt.Errorf("下载的文件无效: %v", err)
-		}
+	if info.Size() < 1000000 { // 小于1MB可能有问题
+		return "", fmt.Errorf("下载的文件过小 (%d 字节)，可能下载失败", info.Size())
 	}
 
-	return nil
+	fmt.Printf("✅ 下载完成，文件大小: %d 字节\n", info.Size())
+	return releaseURL, nil
 }
 
 // validateWindowsExecutable 验证 Windows 可执行文件
@@ -315,53 +376,15 @@ func (h *Hysteria2Downloader) validateWindowsExecutable(filePath string) error {
 	return nil
 }
 
-// GenerateHysteria2Config 生成Hysteria2配置文件
+// GenerateHysteria2Config 按节点生成Hysteria2配置文件（见 NewHysteria2Config）
 func (h *Hysteria2Downloader) GenerateHysteria2Config(node *types.Node, httpPort int, socksPort int) error {
-	// 解析服务器和端口
-	server := node.Server
-	port := node.Port
-
-	// 获取参数
-	password := node.UUID // Hysteria2中用户标识通常作为密码
-	obfs := ""
-	insecure := "false"
-
-	if obsParam, ok := node.Parameters["obfs"]; ok {
-		obfs = obsParam
-	}
-
-	if _, ok := node.Parameters["insecure"]; ok {
-		insecure = "true"
+	config, err := NewHysteria2Config(node, httpPort, socksPort)
+	if err != nil {
+		return fmt.Errorf("生成配置失败: %v", err)
 	}
-
-	// 生成配置
-	config := fmt.Sprintf(`# Hysteria2 客户端配置
-server: %s:%s
-
-auth: %s
-
-bandwidth:
-  up: 20 mbps
-  down: 100 mbps
-
-socks5:
-  listen: 127.0.0.1:%d
-
-http:
-  listen: 127.0.0.1:%d
-
-tls:
-  insecure: %s
-`, server, port, password, socksPort, httpPort, insecure)
-
-	// 如果有混淆参数
-	if obfs != "" {
-		config += fmt.Sprintf(`
-obfs:
-  type: salamander
-  salamander:
-    password: %s
-`, obfs)
+	content, err := config.Marshal()
+	if err != nil {
+		return fmt.Errorf("序列化配置失败: %v", err)
 	}
 
 	// 创建配置目录
@@ -369,8 +392,8 @@ obfs:
 		return fmt.Errorf("创建配置目录失败: %v", err)
 	}
 
-	// 写入配置文件
-	if err := os.WriteFile(h.ConfigPath, []byte(config), 0644); err != nil {
+	// 写入配置文件（包含认证密码，只允许当前用户读取）
+	if err := os.WriteFile(h.ConfigPath, content, 0600); err != nil {
 		return fmt.Errorf("写入配置文件失败: %v", err)
 	}
 
@@ -384,9 +407,17 @@ func (h *Hysteria2Downloader) StartHysteria2() (*exec.Cmd, error) {
 		return nil, fmt.Errorf("Hysteria2未安装")
 	}
 
+	// 启动前重新校验可执行文件
+	if err := VerifyBinary(h.BinaryPath); err != nil {
+		return nil, fmt.Errorf("Hysteria2核心校验失败: %v", err)
+	}
+
 	// 启动命令
 	cmd := exec.Command(h.BinaryPath, "client", "-c", h.ConfigPath)
 
+	// 作为独立进程组启动，便于停止时连同子进程一起终止
+	platform.SetProcAttributes(cmd)
+
 	// 启动进程
 	err := cmd.Start()
 	if err != nil {
@@ -0,0 +1,240 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
+)
+
+// 没有为节点指定带宽时使用的默认带宽（Mbps）
+const (
+	DefaultHysteria2UpMbps   = 20
+	DefaultHysteria2DownMbps = 100
+)
+
+var (
+	bandwidthMutex sync.RWMutex
+
+	// hysteria2Up / hysteria2Down 档案中设置的本地带宽（Mbps），-1 表示未设置
+	hysteria2Up   = -1
+	hysteria2Down = -1
+)
+
+// Hysteria2Config Hysteria2客户端配置。按官方客户端配置的字段组织，
+// 序列化为JSON写入 .yaml 文件：JSON 是 YAML 的子集，任何字符的密码都能正确转义
+type Hysteria2Config struct {
+	Server    string                 `json:"server"`
+	Auth      string                 `json:"auth"`
+	TLS       Hysteria2TLS           `json:"tls"`
+	Obfs      *Hysteria2Obfs         `json:"obfs,omitempty"`
+	Transport *Hysteria2Transport    `json:"transport,omitempty"`
+	Bandwidth *Hysteria2Bandwidth    `json:"bandwidth,omitempty"` // 为空时使用BBR拥塞控制
+	FastOpen  bool                   `json:"fastOpen,omitempty"`
+	SOCKS5    *Hysteria2ListenConfig `json:"socks5,omitempty"`
+	HTTP      *Hysteria2ListenConfig `json:"http,omitempty"`
+}
+
+// Hysteria2TLS TLS设置
+type Hysteria2TLS struct {
+	SNI       string `json:"sni,omitempty"`
+	Insecure  bool   `json:"insecure"`
+	PinSHA256 string `json:"pinSHA256,omitempty"` // 证书指纹，设置后只接受该证书
+}
+
+// Hysteria2Obfs 混淆设置，目前只有 salamander
+type Hysteria2Obfs struct {
+	Type       string `json:"type"`
+	Salamander struct {
+		Password string `json:"password"`
+	} `json:"salamander"`
+}
+
+// Hysteria2Transport 传输设置，端口跳跃时指定跳跃间隔
+type Hysteria2Transport struct {
+	Type string `json:"type"`
+	UDP  struct {
+		HopInterval string `json:"hopInterval,omitempty"`
+	} `json:"udp"`
+}
+
+// Hysteria2Bandwidth 带宽设置
+type Hysteria2Bandwidth struct {
+	Up   string `json:"up,omitempty"`
+	Down string `json:"down,omitempty"`
+}
+
+// Hysteria2ListenConfig 本地代理监听设置
+type Hysteria2ListenConfig struct {
+	Listen string `json:"listen"`
+}
+
+// SetHysteria2Bandwidth 设置本地带宽（来自档案的 hysteria2_up_mbps / hysteria2_down_mbps），
+// 优先于节点链接中的带宽；-1 表示未设置，0 表示不设置带宽、使用BBR拥塞控制
+func SetHysteria2Bandwidth(up, down int) {
+	bandwidthMutex.Lock()
+	defer bandwidthMutex.Unlock()
+	hysteria2Up = up
+	hysteria2Down = down
+}
+
+// Hysteria2BandwidthMbps 返回节点使用的上下行带宽（Mbps）：档案设置 > 链接中的 upmbps/downmbps > 默认值。
+// 返回 0 表示不设置带宽（使用BBR）
+func Hysteria2BandwidthMbps(node *types.Node) (int, int) {
+	bandwidthMutex.RLock()
+	up, down := hysteria2Up, hysteria2Down
+	bandwidthMutex.RUnlock()
+
+	if up < 0 {
+		up = linkMbps(node, DefaultHysteria2UpMbps, "upmbps", "up")
+	}
+	if down < 0 {
+		down = linkMbps(node, DefaultHysteria2DownMbps, "downmbps", "down")
+	}
+	return up, down
+}
+
+// mbpsPattern 链接中的带宽，如 50、50 Mbps、1 Gbps
+var mbpsPattern = regexp.MustCompile(`^(\d+)\s*([a-zA-Z]*)$`)
+
+// linkMbps 从链接参数中读取带宽（Mbps），不存在或无法识别时返回默认值
+func linkMbps(node *types.Node, fallback int, keys ...string) int {
+	for _, key := range keys {
+		value := strings.TrimSpace(node.Parameters[key])
+		if value == "" {
+			continue
+		}
+		match := mbpsPattern.FindStringSubmatch(value)
+		if match == nil {
+			continue
+		}
+		n, err := strconv.Atoi(match[1])
+		if err != nil {
+			continue
+		}
+		switch strings.ToLower(match[2]) {
+		case "", "m", "mbps":
+			return n
+		case "g", "gbps":
+			return n * 1000
+		}
+	}
+	return fallback
+}
+
+// Hysteria2PortSpec 节点的端口：端口跳跃时为 mport 参数（如 "443,20000-30000"），否则为节点端口
+func Hysteria2PortSpec(node *types.Node) string {
+	if mport := strings.TrimSpace(node.Parameters["mport"]); mport != "" {
+		return strings.ReplaceAll(mport, " ", "")
+	}
+	return node.Port
+}
+
+// Hysteria2HopInterval 端口跳跃的间隔，链接中为秒数或时长（hop-interval / hopInterval），未指定时返回空
+func Hysteria2HopInterval(node *types.Node) string {
+	for _, key := range []string{"hop-interval", "hopInterval", "hop_interval"} {
+		value := strings.TrimSpace(node.Parameters[key])
+		if value == "" {
+			continue
+		}
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			return (time.Duration(seconds) * time.Second).String()
+		}
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d.String()
+		}
+	}
+	return ""
+}
+
+// Hysteria2ObfsPassword salamander 混淆密码。标准链接为 obfs=salamander&obfs-password=密码，
+// 部分订阅直接把密码放在 obfs 中
+func Hysteria2ObfsPassword(node *types.Node) string {
+	password := node.Parameters["obfs-password"]
+	if obfs := node.Parameters["obfs"]; password == "" && obfs != "" && obfs != "salamander" && obfs != "none" {
+		password = obfs
+	}
+	return password
+}
+
+// linkBool 链接中的布尔参数：1 或 true
+func linkBool(node *types.Node, keys ...string) bool {
+	for _, key := range keys {
+		switch strings.ToLower(node.Parameters[key]) {
+		case "1", "true":
+			return true
+		}
+	}
+	return false
+}
+
+// NewHysteria2Config 按节点链接生成客户端配置，覆盖官方URI的全部参数
+// （auth、多端口、obfs、obfs-password、sni、insecure、pinSHA256）以及常见扩展参数
+// （mport 端口跳跃、hop-interval、upmbps/downmbps、fastopen）
+func NewHysteria2Config(node *types.Node, httpPort, socksPort int) (*Hysteria2Config, error) {
+	if node.Server == "" {
+		return nil, fmt.Errorf("节点缺少服务器地址")
+	}
+	ports := Hysteria2PortSpec(node)
+	if ports == "" {
+		return nil, fmt.Errorf("节点缺少端口")
+	}
+
+	config := &Hysteria2Config{
+		Server:   net.JoinHostPort(node.Server, ports),
+		Auth:     node.UUID, // Hysteria2中用户标识作为认证密码
+		FastOpen: linkBool(node, "fastopen", "fastOpen"),
+	}
+
+	for _, key := range []string{"sni", "peer"} {
+		if sni := node.Parameters[key]; sni != "" {
+			config.TLS.SNI = sni
+			break
+		}
+	}
+	config.TLS.Insecure = linkBool(node, "insecure", "allowInsecure", "allow_insecure")
+	config.TLS.PinSHA256 = node.Parameters["pinSHA256"]
+
+	if password := Hysteria2ObfsPassword(node); password != "" {
+		config.Obfs = &Hysteria2Obfs{Type: "salamander"}
+		config.Obfs.Salamander.Password = password
+	}
+
+	if hop := Hysteria2HopInterval(node); hop != "" {
+		config.Transport = &Hysteria2Transport{Type: "udp"}
+		config.Transport.UDP.HopInterval = hop
+	}
+
+	if up, down := Hysteria2BandwidthMbps(node); up > 0 || down > 0 {
+		config.Bandwidth = &Hysteria2Bandwidth{}
+		if up > 0 {
+			config.Bandwidth.Up = fmt.Sprintf("%d mbps", up)
+		}
+		if down > 0 {
+			config.Bandwidth.Down = fmt.Sprintf("%d mbps", down)
+		}
+	}
+
+	if socksPort > 0 {
+		config.SOCKS5 = &Hysteria2ListenConfig{Listen: fmt.Sprintf("127.0.0.1:%d", socksPort)}
+	}
+	if httpPort > 0 {
+		config.HTTP = &Hysteria2ListenConfig{Listen: fmt.Sprintf("127.0.0.1:%d", httpPort)}
+	}
+	return config, nil
+}
+
+// Marshal 序列化为配置文件内容
+func (c *Hysteria2Config) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
@@ -0,0 +1,156 @@
+package downloader
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/parser"
+	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
+)
+
+var update = flag.Bool("update", false, "重新生成 testdata 中的 golden 文件")
+
+// TestHysteria2ConfigGolden 用真实格式的分享链接生成客户端配置，与 testdata/hysteria2/*.golden 比对。
+// 修改配置生成逻辑后用 go test ./internal/core/downloader -run Golden -update 更新
+func TestHysteria2ConfigGolden(t *testing.T) {
+	tests := []struct {
+		name      string
+		link      string
+		up, down  int // 档案中的本地带宽，-1 表示未设置
+		httpPort  int
+		socksPort int
+	}{
+		{
+			name: "basic",
+			link: "hysteria2://letmein@example.com:443/?sni=real.example.com#HK-01",
+			up:   -1, down: -1, httpPort: 8080, socksPort: 1080,
+		},
+		{
+			name: "short_scheme",
+			link: "hy2://letmein@example.com:8443?peer=cdn.example.com#JP",
+			up:   -1, down: -1, httpPort: 8080, socksPort: 1080,
+		},
+		{
+			name: "percent_encoded_password",
+			link: "hysteria2://p%40ss%3Aw0rd%2F%25%20x@example.com:443/?sni=example.com#encoded",
+			up:   -1, down: -1, httpPort: 8080, socksPort: 1080,
+		},
+		{
+			name: "quoted_password",
+			link: `hysteria2://%22quoted%5C%22pass%27@example.com:443/?sni=example.com#quoted`,
+			up:   -1, down: -1, httpPort: 8080, socksPort: 1080,
+		},
+		{
+			name: "port_hopping_multiport",
+			link: "hysteria2://letmein@example.com:443,20000-30000/?sni=example.com&hop-interval=30#hop",
+			up:   -1, down: -1, httpPort: 8080, socksPort: 1080,
+		},
+		{
+			name: "port_hopping_mport",
+			link: "hy2://letmein@example.com:443?mport=443, 5000-6000&hopInterval=1m#mport",
+			up:   -1, down: -1, httpPort: 8080, socksPort: 1080,
+		},
+		{
+			name: "port_hopping_ipv6",
+			link: "hysteria2://letmein@[2001:db8::1]:443,20000-30000/?insecure=1#v6",
+			up:   -1, down: -1, httpPort: 8080, socksPort: 1080,
+		},
+		{
+			name: "obfs_salamander",
+			link: "hysteria2://letmein@example.com:443/?obfs=salamander&obfs-password=gawrgura&sni=example.com#obfs",
+			up:   -1, down: -1, httpPort: 8080, socksPort: 1080,
+		},
+		{
+			name: "obfs_password_only",
+			link: "hysteria2://letmein@example.com:443/?obfs=secretobfs#obfs-legacy",
+			up:   -1, down: -1, httpPort: 8080, socksPort: 1080,
+		},
+		{
+			name: "pin_sha256",
+			link: "hysteria2://letmein@example.com:443/?sni=example.com&pinSHA256=BA%3A88%3A45%3A17%3AA1#pinned",
+			up:   -1, down: -1, httpPort: 8080, socksPort: 1080,
+		},
+		{
+			name: "insecure",
+			link: "hysteria2://letmein@1.2.3.4:443/?insecure=1&sni=fake.example.com&fastopen=1#insecure",
+			up:   -1, down: -1, httpPort: 8080, socksPort: 1080,
+		},
+		{
+			name: "bandwidth_default",
+			link: "hysteria2://letmein@example.com:443/#default-bandwidth",
+			up:   -1, down: -1, httpPort: 8080, socksPort: 1080,
+		},
+		{
+			name: "bandwidth_link",
+			link: "hysteria2://letmein@example.com:443/?upmbps=50&downmbps=1%20Gbps#link-bandwidth",
+			up:   -1, down: -1, httpPort: 8080, socksPort: 1080,
+		},
+		{
+			name: "bandwidth_profile_overrides_link",
+			link: "hysteria2://letmein@example.com:443/?upmbps=50&downmbps=200#profile-bandwidth",
+			up:   10, down: -1, httpPort: 8080, socksPort: 1080,
+		},
+		{
+			name: "bandwidth_bbr",
+			link: "hysteria2://letmein@example.com:443/?upmbps=50&downmbps=200#bbr",
+			up:   0, down: 0, httpPort: 8080, socksPort: 1080,
+		},
+		{
+			name: "socks_only",
+			link: "hysteria2://letmein@example.com:443/#socks-only",
+			up:   -1, down: -1, socksPort: 1080,
+		},
+	}
+
+	defer SetHysteria2Bandwidth(-1, -1)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := parser.ParseLink(tt.link)
+			if err != nil {
+				t.Fatalf("解析链接失败: %v", err)
+			}
+
+			SetHysteria2Bandwidth(tt.up, tt.down)
+			config, err := NewHysteria2Config(node, tt.httpPort, tt.socksPort)
+			if err != nil {
+				t.Fatalf("生成配置失败: %v", err)
+			}
+			got, err := config.Marshal()
+			if err != nil {
+				t.Fatalf("序列化失败: %v", err)
+			}
+
+			golden := filepath.Join("testdata", "hysteria2", tt.name+".golden")
+			if *update {
+				if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("读取 golden 文件失败（使用 -update 生成）: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("配置与 %s 不一致\n得到:\n%s\n期望:\n%s", golden, got, want)
+			}
+		})
+	}
+}
+
+func TestNewHysteria2ConfigErrors(t *testing.T) {
+	for _, node := range []*types.Node{
+		{Protocol: "hysteria2", Port: "443", UUID: "letmein"},
+		{Protocol: "hysteria2", Server: "example.com", UUID: "letmein"},
+	} {
+		if _, err := NewHysteria2Config(node, 8080, 1080); err == nil {
+			t.Errorf("%+v: 期望返回错误", node)
+		}
+	}
+}
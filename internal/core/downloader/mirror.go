@@ -0,0 +1,315 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 内置镜像名，可以和自定义镜像地址一起出现在镜像列表中
+const (
+	MirrorGitHub  = "github"  // GitHub官方发布地址
+	MirrorGHProxy = "ghproxy" // ghproxy.com 代理的GitHub发布地址
+)
+
+// DefaultMirrors 默认的镜像顺序
+var DefaultMirrors = []string{MirrorGitHub, MirrorGHProxy}
+
+// downloadAttempts 单个地址下载中断后的最大尝试次数，每次从已下载的位置续传
+const downloadAttempts = 3
+
+var (
+	mirrorMutex sync.RWMutex
+	mirrors     = DefaultMirrors
+)
+
+// ValidMirror 检查镜像：内置镜像名或 http(s) 基础地址
+func ValidMirror(mirror string) error {
+	if mirror == MirrorGitHub || mirror == MirrorGHProxy {
+		return nil
+	}
+	u, err := url.Parse(mirror)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("无效的镜像 %q（可用: %s、%s 或 http(s) 基础地址）", mirror, MirrorGitHub, MirrorGHProxy)
+	}
+	return nil
+}
+
+// SetMirrors 设置下载核心时依次尝试的镜像，空列表恢复默认顺序。
+// 自定义镜像按GitHub的路径组织发布文件：<基础地址>/<仓库>/releases/download/<版本>/<文件名>
+func SetMirrors(list []string) error {
+	for _, mirror := range list {
+		if err := ValidMirror(mirror); err != nil {
+			return err
+		}
+	}
+	if len(list) == 0 {
+		list = DefaultMirrors
+	}
+
+	mirrorMutex.Lock()
+	defer mirrorMutex.Unlock()
+	mirrors = append([]string(nil), list...)
+	return nil
+}
+
+// Mirrors 返回当前的镜像顺序
+func Mirrors() []string {
+	mirrorMutex.RLock()
+	defer mirrorMutex.RUnlock()
+	return append([]string(nil), mirrors...)
+}
+
+// releasePath 发布文件在GitHub上的路径，tag 为 latest 时使用最新发布地址
+func releasePath(repo, tag, file string) string {
+	if tag == "latest" {
+		return repo + "/releases/latest/download/" + file
+	}
+	return repo + "/releases/download/" + tag + "/" + file
+}
+
+// mirrorURL 返回镜像上的发布文件地址
+func mirrorURL(mirror, repo, tag, file string) string {
+	official := "https://github.com/" + releasePath(repo, tag, file)
+	switch mirror {
+	case MirrorGitHub:
+		return official
+	case MirrorGHProxy:
+		return "https://ghproxy.com/" + official
+	default:
+		return strings.TrimRight(mirror, "/") + "/" + releasePath(repo, tag, file)
+	}
+}
+
+// mirrorName 镜像的显示名称
+func mirrorName(mirror string) string {
+	switch mirror {
+	case MirrorGitHub:
+		return "GitHub Official"
+	case MirrorGHProxy:
+		return "GitHub Mirror"
+	default:
+		return mirror
+	}
+}
+
+// releaseMirrors 按镜像顺序返回发布文件的下载地址。digestFile 为发布页中的校验文件名
+// （如 v2ray-linux-64.zip.dgst、hashes.txt），只使用GitHub官方的校验文件：
+// 镜像提供的校验文件与发布文件来自同一方，不能证明文件未被篡改。官方地址不可达时，
+// 只有 cores.lock 中已固定摘要的文件能从镜像安装；为空时没有校验文件，由调用方另行提供
+func releaseMirrors(repo, tag, asset, digestFile string) []DownloadMirror {
+	var list []DownloadMirror
+	for _, mirror := range Mirrors() {
+		m := DownloadMirror{
+			Name: mirrorName(mirror),
+			URL:  mirrorURL(mirror, repo, tag, asset),
+		}
+		if digestFile != "" {
+			m.DigestURLs = []string{mirrorURL(MirrorGitHub, repo, tag, digestFile)}
+		}
+		list = append(list, m)
+	}
+	return list
+}
+
+// archiveMirror 本地发布文件作为唯一的来源，校验文件为同目录下的 digestFiles
+// 和 <文件名>.sha256（sha256sum 格式）
+func archiveMirror(archive string, digestFiles ...string) DownloadMirror {
+	var digests []string
+	for _, name := range digestFiles {
+		digests = append(digests, filepath.Join(filepath.Dir(archive), name))
+	}
+	digests = append(digests, archive+".sha256")
+	return DownloadMirror{Name: "本地文件", URL: archive, DigestURLs: digests}
+}
+
+// archiveVersionPattern 从发布文件名中识别版本号，如 sing-box-1.11.4-linux-amd64.tar.gz
+var archiveVersionPattern = regexp.MustCompile(`v?\d+\.\d+\.\d+`)
+
+// archiveVersion 返回本地发布文件的版本：优先使用指定的版本，否则从文件名识别
+func archiveVersion(archive, version string) (string, error) {
+	if _, err := os.Stat(archive); err != nil {
+		return "", fmt.Errorf("本地发布文件不可用: %v", err)
+	}
+	if version != "" && version != "latest" {
+		version = NormalizeVersion(version)
+		if !ValidVersion(version) {
+			return "", fmt.Errorf("无效的版本号: %s", version)
+		}
+		return version, nil
+	}
+	if found := archiveVersionPattern.FindString(filepath.Base(archive)); found != "" {
+		return NormalizeVersion(found), nil
+	}
+	return "", fmt.Errorf("无法从文件名 %s 识别版本，请用 --version= 指定", filepath.Base(archive))
+}
+
+// isLocalSource 来源是否为本地文件（不是 http(s) 地址）
+func isLocalSource(source string) bool {
+	return !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://")
+}
+
+// fetchFile 把来源（下载地址或本地文件）的内容放到 dest，返回重定向经过的GitHub发布文件地址
+// （用于确定 latest 的实际版本，本地文件返回空）
+func fetchFile(source, dest string) (string, error) {
+	if isLocalSource(source) {
+		return "", copyFile(source, dest)
+	}
+	return downloadResumable(source, dest)
+}
+
+// copyFile 复制本地文件
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	fmt.Printf("📦 使用本地文件: %s\n", src)
+	return out.Close()
+}
+
+// httpStatusError 服务器返回的错误状态码，4xx 不重试
+type httpStatusError struct {
+	code int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("HTTP状态码 %d", e.code)
+}
+
+// downloadResumable 下载到 dest。未完成的数据保存在 dest+".part"，连接中断时用 HTTP Range
+// 从已下载的位置续传，之后再下载同一文件（包括从其他镜像）也会继续。
+// 返回重定向过程中经过的 GitHub 发布文件地址（latest 地址会先重定向到带版本标签的地址）
+func downloadResumable(source, dest string) (string, error) {
+	releaseURL := source
+	client := &http.Client{
+		Timeout: 10 * time.Minute,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("重定向次数过多")
+			}
+			if strings.Contains(req.URL.Path, "/releases/download/") {
+				releaseURL = req.URL.String()
+			}
+			return nil
+		},
+	}
+
+	part := dest + ".part"
+	var err error
+	for attempt := 1; attempt <= downloadAttempts; attempt++ {
+		if attempt > 1 {
+			fmt.Printf("🔄 下载中断 (%v)，第 %d 次尝试续传...\n", err, attempt)
+			time.Sleep(time.Duration(attempt-1) * time.Second)
+		}
+		if err = downloadRange(client, source, part); err == nil {
+			return releaseURL, os.Rename(part, dest)
+		}
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) && statusErr.code >= 400 && statusErr.code < 500 {
+			break
+		}
+	}
+	return "", err
+}
+
+// downloadRange 从 part 文件的末尾继续下载，服务器不支持 Range 时重新下载
+func downloadRange(client *http.Client, source, part string) error {
+	var offset int64
+	if info, err := os.Stat(part); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, source, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("下载请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+		fmt.Printf("⏩ 从 %.2f MB 处续传\n", float64(offset)/1024/1024)
+	case http.StatusOK:
+		flags |= os.O_TRUNC
+		offset = 0
+	case http.StatusRequestedRangeNotSatisfiable:
+		// 未完成的文件与服务器上的文件不一致，下次重新下载
+		os.Remove(part)
+		return fmt.Errorf("续传位置无效")
+	default:
+		return &httpStatusError{code: resp.StatusCode}
+	}
+
+	out, err := os.OpenFile(part, flags, 0644)
+	if err != nil {
+		return fmt.Errorf("创建文件失败: %v", err)
+	}
+	defer out.Close()
+
+	var total int64 = -1
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+		fmt.Printf("文件大小: %.2f MB\n", float64(total)/1024/1024)
+	}
+
+	// 进度跟踪
+	downloaded := offset
+	buffer := make([]byte, 32*1024)
+	for {
+		n, readErr := resp.Body.Read(buffer)
+		if n > 0 {
+			if _, err := out.Write(buffer[:n]); err != nil {
+				return fmt.Errorf("写入文件失败: %v", err)
+			}
+			downloaded += int64(n)
+			if total > 0 {
+				fmt.Printf("\r下载进度: %.1f%% (%.2f/%.2f MB)",
+					float64(downloaded)/float64(total)*100,
+					float64(downloaded)/1024/1024,
+					float64(total)/1024/1024)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			fmt.Println()
+			return fmt.Errorf("读取数据失败: %v", readErr)
+		}
+	}
+	fmt.Println()
+
+	if total > 0 && downloaded != total {
+		return fmt.Errorf("下载不完整: 期望 %d 字节，实际 %d 字节", total, downloaded)
+	}
+	fmt.Println("下载完成!")
+	return nil
+}
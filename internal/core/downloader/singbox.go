@@ -0,0 +1,324 @@
+package downloader
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// fallbackSingBoxVersion 无法查询GitHub最新版本时使用的sing-box版本
+const fallbackSingBoxVersion = "v1.11.4"
+
+// 全局互斥锁，防止并发下载 sing-box
+var singBoxDownloadMutex sync.Mutex
+
+// SingBoxDownloader sing-box下载器。发布文件为 tar.gz（Windows 为 zip），
+// 不提供校验文件，SHA256 取自GitHub发布API中的文件摘要
+type SingBoxDownloader struct {
+	*V2RayDownloader
+}
+
+// NewSingBoxDownloader 创建sing-box下载器，版本按 档案指定版本 > 仓库当前版本 > latest 选择
+func NewSingBoxDownloader() *SingBoxDownloader {
+	version := ActiveVersion(CoreSingBox)
+	if version == "" {
+		version = "latest"
+	}
+	return &SingBoxDownloader{
+		V2RayDownloader: &V2RayDownloader{
+			Version: version,
+			TempDir: "./temp",
+		},
+	}
+}
+
+// resolvedVersion 返回实际下载的版本号，latest 时查询GitHub最新版本
+func (d *SingBoxDownloader) resolvedVersion() string {
+	if d.Version != "latest" && d.Version != "" {
+		return NormalizeVersion(d.Version)
+	}
+	if d.resolved == "" {
+		latest, err := LatestVersion(CoreSingBox)
+		if err != nil {
+			fmt.Printf("⚠️ %v，使用 %s\n", err, fallbackSingBoxVersion)
+			latest = fallbackSingBoxVersion
+		}
+		d.resolved = latest
+	}
+	return d.resolved
+}
+
+// installDir 返回安装目录
+func (d *SingBoxDownloader) installDir() string {
+	if d.InstallPath != "" {
+		return d.InstallPath
+	}
+	return VersionDir(CoreSingBox, d.resolvedVersion())
+}
+
+// ExecutablePath 返回将要启动的sing-box可执行文件
+func (d *SingBoxDownloader) ExecutablePath() string {
+	if d.InstallPath != "" {
+		return filepath.Join(d.InstallPath, coreExecutableName(CoreSingBox))
+	}
+	return ExecutablePath(CoreSingBox)
+}
+
+// CheckSingBoxInstalled 检查sing-box是否已安装
+func (d *SingBoxDownloader) CheckSingBoxInstalled() bool {
+	singBoxPath := d.ExecutablePath()
+	if singBoxPath != "sing-box" {
+		if fileExists(singBoxPath) {
+			return true
+		}
+		if VersionOverride(CoreSingBox) != "" || d.InstallPath != "" {
+			return false
+		}
+	}
+
+	// 检查系统PATH中的sing-box
+	_, err := exec.LookPath("sing-box")
+	return err == nil
+}
+
+// fileName 返回当前平台的发布文件名，如 sing-box-1.11.4-linux-amd64.tar.gz
+func (d *SingBoxDownloader) fileName() (string, error) {
+	var arch string
+	switch runtime.GOARCH {
+	case "amd64", "386", "arm64":
+		arch = runtime.GOARCH
+	case "arm":
+		arch = "armv7"
+	default:
+		return "", fmt.Errorf("不支持的架构: %s", runtime.GOARCH)
+	}
+
+	ext := ".tar.gz"
+	if runtime.GOOS == "windows" {
+		ext = ".zip"
+	}
+	version := strings.TrimPrefix(d.resolvedVersion(), "v")
+	return fmt.Sprintf("sing-box-%s-%s-%s%s", version, runtime.GOOS, arch, ext), nil
+}
+
+// GetDownloadMirrors 获取下载镜像源列表，按配置的镜像顺序排列；指定了本地发布文件时只使用该文件。
+// 发布页没有校验文件，镜像下载的文件同样与GitHub发布API中记录的文件摘要比对
+func (d *SingBoxDownloader) GetDownloadMirrors() ([]DownloadMirror, error) {
+	if d.Archive != "" {
+		return []DownloadMirror{archiveMirror(d.Archive)}, nil
+	}
+	fileName, err := d.fileName()
+	if err != nil {
+		return nil, err
+	}
+
+	version := d.resolvedVersion()
+	mirrors := releaseMirrors(coreRepos[CoreSingBox], version, fileName, "")
+	for i := range mirrors {
+		mirrors[i].DigestURLs = []string{fmt.Sprintf("https://api.github.com/repos/%s/releases/tags/%s", coreRepos[CoreSingBox], version)}
+	}
+	return mirrors, nil
+}
+
+// Install 下载并安装 Version 指定的版本到 cores/sing-box/<版本>/（不切换当前版本），返回安装的版本
+func (d *SingBoxDownloader) Install() (string, error) {
+	if d.Archive != "" {
+		version, err := archiveVersion(d.Archive, d.Version)
+		if err != nil {
+			return "", err
+		}
+		d.Version = version
+	}
+	installDir := d.installDir()
+	version := d.resolvedVersion()
+
+	mirrors, err := d.GetDownloadMirrors()
+	if err != nil {
+		return "", err
+	}
+
+	var downloadErr error
+	installed := false
+	for _, mirror := range mirrors {
+		fmt.Printf("\n尝试从 %s 下载 sing-box %s...\n", mirror.Name, version)
+		fmt.Printf("下载链接: %s\n", mirror.URL)
+
+		fileName := filepath.Base(mirror.URL)
+		downloadErr = d.DownloadWithProgress(mirror.URL, fileName)
+		if downloadErr != nil {
+			fmt.Printf("从 %s 下载失败: %v\n", mirror.Name, downloadErr)
+			continue
+		}
+
+		// 校验SHA256，不一致时拒绝安装
+		archivePath := filepath.Join(d.TempDir, fileName)
+		downloadErr = verifyAsset(CoreSingBox, version, fileName, archivePath, mirror.DigestURLs)
+		if downloadErr != nil {
+			fmt.Printf("❌ 校验失败: %v\n", downloadErr)
+			os.Remove(archivePath)
+			continue
+		}
+
+		singBoxPath := filepath.Join(installDir, coreExecutableName(CoreSingBox))
+		if downloadErr = extractExecutable(archivePath, singBoxPath); downloadErr != nil {
+			fmt.Printf("解压失败: %v\n", downloadErr)
+			continue
+		}
+
+		if downloadErr = d.SetExecutablePermission(singBoxPath); downloadErr != nil {
+			fmt.Printf("设置执行权限失败: %v\n", downloadErr)
+			continue
+		}
+
+		// 记录可执行文件的SHA256，启动前校验
+		if err := recordBinary(CoreSingBox, version, singBoxPath); err != nil {
+			fmt.Printf("⚠️ 记录可执行文件摘要失败: %v\n", err)
+		}
+
+		installed = true
+		break
+	}
+
+	if !installed {
+		if d.InstallPath == "" {
+			os.RemoveAll(installDir)
+		}
+		return "", fmt.Errorf("从所有镜像源下载sing-box都失败，最后一个错误: %v", downloadErr)
+	}
+
+	// 清理临时文件（失败时保留未完成的下载，下次续传）
+	d.CleanupTempFiles()
+
+	singBoxPath := filepath.Join(installDir, coreExecutableName(CoreSingBox))
+	fmt.Printf("\n✅ sing-box核心安装成功！\n")
+	fmt.Printf("安装路径: %s\n", singBoxPath)
+	showSingBoxVersion(singBoxPath)
+
+	return version, nil
+}
+
+// extractExecutable 从发布压缩包（tar.gz 或 zip）中取出与 dest 同名的可执行文件，
+// 发布包中的文件位于 sing-box-<版本>-<平台>/ 子目录下
+func extractExecutable(archive, dest string) error {
+	name := filepath.Base(dest)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("创建目标目录失败: %v", err)
+	}
+
+	if strings.HasSuffix(archive, ".zip") {
+		r, err := zip.OpenReader(archive)
+		if err != nil {
+			return fmt.Errorf("打开ZIP文件失败: %v", err)
+		}
+		defer r.Close()
+
+		for _, f := range r.File {
+			if f.FileInfo().IsDir() || filepath.Base(f.Name) != name {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			defer rc.Close()
+			return writeExecutable(rc, dest)
+		}
+		return fmt.Errorf("压缩包中没有 %s", name)
+	}
+
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("打开tar.gz文件失败: %v", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("读取tar.gz文件失败: %v", err)
+		}
+		if header.Typeflag == tar.TypeReg && filepath.Base(header.Name) == name {
+			return writeExecutable(tr, dest)
+		}
+	}
+	return fmt.Errorf("压缩包中没有 %s", name)
+}
+
+// writeExecutable 写入可执行文件
+func writeExecutable(r io.Reader, dest string) error {
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// DownloadAndInstall 未安装时下载并安装sing-box，仓库中还没有当前版本时设为当前版本
+func (d *SingBoxDownloader) DownloadAndInstall() error {
+	if d.CheckSingBoxInstalled() {
+		return nil
+	}
+
+	version, err := d.Install()
+	if err != nil {
+		return err
+	}
+	if d.InstallPath == "" && CurrentVersion(CoreSingBox) == "" {
+		return UseVersion(CoreSingBox, version)
+	}
+	return nil
+}
+
+// SafeDownloadSingBox 安全下载sing-box（带互斥锁），代理启动时按需调用
+func (d *SingBoxDownloader) SafeDownloadSingBox() error {
+	singBoxDownloadMutex.Lock()
+	defer singBoxDownloadMutex.Unlock()
+
+	// 再次检查是否已安装（可能在等待锁的过程中被其他goroutine安装了）
+	if d.CheckSingBoxInstalled() {
+		return nil
+	}
+	return d.DownloadAndInstall()
+}
+
+// ShowSingBoxVersion 显示sing-box版本信息
+func (d *SingBoxDownloader) ShowSingBoxVersion() {
+	showSingBoxVersion(d.ExecutablePath())
+}
+
+// showSingBoxVersion 显示指定可执行文件的版本信息
+func showSingBoxVersion(singBoxPath string) {
+	output, err := exec.Command(singBoxPath, "version").Output()
+	if err != nil {
+		fmt.Printf("无法获取sing-box版本信息: %v\n", err)
+		return
+	}
+	fmt.Printf("sing-box版本信息:\n%s\n", string(output))
+}
+
+// AutoDownloadSingBox 自动下载sing-box的便捷函数
+func AutoDownloadSingBox() error {
+	return NewSingBoxDownloader().DownloadAndInstall()
+}
@@ -0,0 +1,445 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultStoreRoot 核心版本仓库的默认位置，与旧版的 ./v2ray、./hysteria2 一样位于工作目录下
+const DefaultStoreRoot = "cores"
+
+// 支持版本管理的核心
+const (
+	CoreV2Ray     = "v2ray"
+	CoreXray      = "xray"
+	CoreHysteria2 = "hysteria2"
+	CoreSingBox   = "sing-box"
+)
+
+// Cores 支持版本管理的核心列表
+var Cores = []string{CoreV2Ray, CoreXray, CoreHysteria2, CoreSingBox}
+
+// fallbackV2RayVersion 无法查询GitHub最新版本时使用的V2Ray版本
+const fallbackV2RayVersion = "v5.33.0"
+
+// 仓库中每个核心目录下记录状态的文件
+const (
+	currentFile  = "current"  // 当前使用的版本
+	previousFile = "previous" // 切换前的版本，rollback 时恢复
+	pendingFile  = "pending"  // 刚升级、尚未通过冒烟测试的版本
+)
+
+var (
+	storeMutex sync.Mutex
+	storeRoot  = DefaultStoreRoot
+
+	// versionOverrides 档案中为各核心指定的版本，优先于仓库中的当前版本
+	versionOverrides = make(map[string]string)
+)
+
+// SetStoreRoot 设置核心版本仓库位置，空字符串恢复默认值
+func SetStoreRoot(root string) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	if root == "" {
+		root = DefaultStoreRoot
+	}
+	storeRoot = root
+}
+
+// StoreRoot 返回核心版本仓库位置
+func StoreRoot() string {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	return storeRoot
+}
+
+// SetVersionOverride 指定核心使用的版本（来自档案的 <核心>_version），空字符串取消
+func SetVersionOverride(core, version string) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	if version == "" {
+		delete(versionOverrides, core)
+		return
+	}
+	versionOverrides[core] = NormalizeVersion(version)
+}
+
+// VersionOverride 返回档案为核心指定的版本
+func VersionOverride(core string) string {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	return versionOverrides[core]
+}
+
+// NormalizeVersion 统一版本号格式：5.33.0 -> v5.33.0，app/v2.6.1 -> v2.6.1
+func NormalizeVersion(version string) string {
+	version = strings.TrimSpace(version)
+	version = strings.TrimPrefix(version, "app/")
+	if version == "" || version == "latest" || strings.HasPrefix(version, "v") {
+		return version
+	}
+	return "v" + version
+}
+
+// ValidVersion 是否为 v主版本.次版本[.修订号][-后缀] 格式的版本号
+func ValidVersion(version string) bool {
+	version = NormalizeVersion(version)
+	if !strings.HasPrefix(version, "v") {
+		return false
+	}
+	core := strings.SplitN(version[1:], "-", 2)[0]
+	parts := strings.Split(core, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return false
+	}
+	for _, part := range parts {
+		if _, err := strconv.Atoi(part); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// CompareVersions 比较两个版本号，a<b 返回负数，相等返回0，a>b 返回正数
+func CompareVersions(a, b string) int {
+	pa := versionNumbers(a)
+	pb := versionNumbers(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x != y {
+			return x - y
+		}
+	}
+	return strings.Compare(a, b)
+}
+
+// versionNumbers 取出版本号中的数字部分
+func versionNumbers(version string) []int {
+	version = strings.TrimPrefix(NormalizeVersion(version), "v")
+	version = strings.SplitN(version, "-", 2)[0]
+	var numbers []int
+	for _, part := range strings.Split(version, ".") {
+		n, _ := strconv.Atoi(part)
+		numbers = append(numbers, n)
+	}
+	return numbers
+}
+
+// coreExecutableName 核心可执行文件名
+func coreExecutableName(core string) string {
+	name := core
+	if core == CoreHysteria2 {
+		name = "hysteria"
+	}
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// legacyExecutablePath 旧版安装位置（./v2ray/v2ray、./hysteria2/hysteria）
+func legacyExecutablePath(core string) string {
+	return filepath.Join(".", core, coreExecutableName(core))
+}
+
+// CoreDir 返回核心在仓库中的目录
+func CoreDir(core string) string {
+	return filepath.Join(StoreRoot(), core)
+}
+
+// VersionDir 返回核心某个版本的安装目录，如 cores/v2ray/v5.33.0
+func VersionDir(core, version string) string {
+	return filepath.Join(CoreDir(core), NormalizeVersion(version))
+}
+
+// VersionExecutable 返回核心某个版本的可执行文件路径
+func VersionExecutable(core, version string) string {
+	return filepath.Join(VersionDir(core, version), coreExecutableName(core))
+}
+
+// InstalledVersions 返回仓库中已安装的版本，从新到旧排序
+func InstalledVersions(core string) []string {
+	entries, err := os.ReadDir(CoreDir(core))
+	if err != nil {
+		return nil
+	}
+
+	var versions []string
+	for _, entry := range entries {
+		if !entry.IsDir() || !ValidVersion(entry.Name()) {
+			continue
+		}
+		if _, err := os.Stat(VersionExecutable(core, entry.Name())); err == nil {
+			versions = append(versions, entry.Name())
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return CompareVersions(versions[i], versions[j]) > 0
+	})
+	return versions
+}
+
+// IsInstalled 核心的某个版本是否已安装
+func IsInstalled(core, version string) bool {
+	_, err := os.Stat(VersionExecutable(core, version))
+	return err == nil
+}
+
+// readMarker 读取核心目录下的状态文件
+func readMarker(core, name string) string {
+	data, err := os.ReadFile(filepath.Join(CoreDir(core), name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// writeMarker 写入核心目录下的状态文件，version 为空时删除
+func writeMarker(core, name, version string) error {
+	path := filepath.Join(CoreDir(core), name)
+	if version == "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(CoreDir(core), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(version+"\n"), 0644)
+}
+
+// CurrentVersion 返回仓库中的当前版本
+func CurrentVersion(core string) string {
+	return readMarker(core, currentFile)
+}
+
+// PreviousVersion 返回切换前的版本
+func PreviousVersion(core string) string {
+	return readMarker(core, previousFile)
+}
+
+// PendingVersion 返回刚升级、尚未通过冒烟测试的版本
+func PendingVersion(core string) string {
+	return readMarker(core, pendingFile)
+}
+
+// ActiveVersion 返回核心实际使用的版本：档案指定的版本优先，其次是仓库中的当前版本；
+// 都没有时返回空字符串（使用旧版安装位置或系统PATH中的核心）
+func ActiveVersion(core string) string {
+	if version := VersionOverride(core); version != "" {
+		return version
+	}
+	return CurrentVersion(core)
+}
+
+// UseVersion 切换核心的当前版本，原来的版本记为 previous
+func UseVersion(core, version string) error {
+	version = NormalizeVersion(version)
+	if !IsInstalled(core, version) {
+		return fmt.Errorf("%s %s 未安装", core, version)
+	}
+
+	current := CurrentVersion(core)
+	if current == version {
+		return nil
+	}
+	if current != "" {
+		if err := writeMarker(core, previousFile, current); err != nil {
+			return err
+		}
+	}
+	if err := writeMarker(core, pendingFile, ""); err != nil {
+		return err
+	}
+	return writeMarker(core, currentFile, version)
+}
+
+// UpgradeTo 切换到新安装的版本，并标记为待冒烟测试
+func UpgradeTo(core, version string) error {
+	if err := UseVersion(core, version); err != nil {
+		return err
+	}
+	return writeMarker(core, pendingFile, NormalizeVersion(version))
+}
+
+// ConfirmVersion 冒烟测试通过，清除待测试标记
+func ConfirmVersion(core string) error {
+	return writeMarker(core, pendingFile, "")
+}
+
+// Rollback 切换回之前的版本，返回切换后的版本
+func Rollback(core string) (string, error) {
+	previous := PreviousVersion(core)
+	if previous == "" {
+		return "", fmt.Errorf("%s 没有可回滚的版本", core)
+	}
+	if !IsInstalled(core, previous) {
+		return "", fmt.Errorf("%s 之前的版本 %s 已被删除，无法回滚", core, previous)
+	}
+
+	current := CurrentVersion(core)
+	if err := writeMarker(core, currentFile, previous); err != nil {
+		return "", err
+	}
+	if err := writeMarker(core, previousFile, current); err != nil {
+		return "", err
+	}
+	if err := writeMarker(core, pendingFile, ""); err != nil {
+		return "", err
+	}
+	return previous, nil
+}
+
+// RemoveVersion 删除已安装的版本，当前版本和档案指定的版本不能删除
+func RemoveVersion(core, version string) error {
+	version = NormalizeVersion(version)
+	if version == CurrentVersion(core) {
+		return fmt.Errorf("%s %s 是当前版本，不能删除", core, version)
+	}
+	if version == VersionOverride(core) {
+		return fmt.Errorf("%s %s 是档案指定的版本，不能删除", core, version)
+	}
+	if err := os.RemoveAll(VersionDir(core, version)); err != nil {
+		return err
+	}
+	if PreviousVersion(core) == version {
+		return writeMarker(core, previousFile, "")
+	}
+	return nil
+}
+
+// ExecutablePath 返回启动核心时使用的可执行文件：
+// 档案或仓库选定的版本 > 旧版安装位置 > 系统PATH。
+// 档案指定的版本未安装时仍返回该版本的路径，由调用者安装或报错，不会悄悄换成其他版本
+func ExecutablePath(core string) string {
+	if version := VersionOverride(core); version != "" {
+		return VersionExecutable(core, version)
+	}
+	if version := CurrentVersion(core); version != "" && IsInstalled(core, version) {
+		return VersionExecutable(core, version)
+	}
+	if legacy := legacyExecutablePath(core); fileExists(legacy) {
+		return legacy
+	}
+	return strings.TrimSuffix(coreExecutableName(core), ".exe")
+}
+
+// LegacyInstalled 旧版安装位置是否有核心
+func LegacyInstalled(core string) (string, bool) {
+	legacy := legacyExecutablePath(core)
+	return legacy, fileExists(legacy)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// coreRepos 各核心的GitHub仓库
+var coreRepos = map[string]string{
+	CoreV2Ray:     "v2fly/v2ray-core",
+	CoreXray:      "XTLS/Xray-core",
+	CoreHysteria2: "apernet/hysteria",
+	CoreSingBox:   "SagerNet/sing-box",
+}
+
+// LatestVersion 查询GitHub上核心的最新发布版本
+func LatestVersion(core string) (string, error) {
+	repo, ok := coreRepos[core]
+	if !ok {
+		return "", fmt.Errorf("未知的核心: %s", core)
+	}
+
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Get("https://api.github.com/repos/" + repo + "/releases/latest")
+	if err != nil {
+		return "", fmt.Errorf("查询最新版本失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("查询最新版本失败，HTTP状态码: %d", resp.StatusCode)
+	}
+
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return "", fmt.Errorf("解析最新版本失败: %v", err)
+	}
+	version := NormalizeVersion(release.TagName)
+	if !ValidVersion(version) {
+		return "", fmt.Errorf("无法识别的版本标签: %s", release.TagName)
+	}
+	return version, nil
+}
+
+// InstallVersion 下载并安装核心的指定版本（latest 表示最新版本）到仓库，返回安装的版本。
+// 仓库中还没有当前版本时，安装的版本成为当前版本
+func InstallVersion(core, version string) (string, error) {
+	return install(core, version, "")
+}
+
+// InstallArchive 从本地发布文件离线安装核心（文件同目录下有校验文件或固定摘要文件中有记录时校验），
+// version 为空时从文件名识别版本，仓库中还没有当前版本时设为当前版本
+func InstallArchive(core, archive, version string) (string, error) {
+	return install(core, version, archive)
+}
+
+// install 安装核心的指定版本，archive 不为空时使用本地发布文件
+func install(core, version, archive string) (string, error) {
+	var (
+		installed string
+		err       error
+	)
+	switch core {
+	case CoreV2Ray:
+		d := NewV2RayDownloader()
+		d.Version = version
+		d.Archive = archive
+		installed, err = d.Install()
+	case CoreXray:
+		d := NewXrayDownloader()
+		d.Version = version
+		d.Archive = archive
+		installed, err = d.Install()
+	case CoreHysteria2:
+		h := NewHysteria2Downloader()
+		h.Version = version
+		h.Archive = archive
+		installed, err = h.Install()
+	case CoreSingBox:
+		d := NewSingBoxDownloader()
+		d.Version = version
+		d.Archive = archive
+		installed, err = d.Install()
+	default:
+		return "", fmt.Errorf("未知的核心: %s (可用: %s)", core, strings.Join(Cores, ", "))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if CurrentVersion(core) == "" {
+		if err := UseVersion(core, installed); err != nil {
+			return installed, err
+		}
+	}
+	return installed, nil
+}
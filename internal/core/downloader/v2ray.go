@@ -4,20 +4,21 @@ import (
 	"archive/zip"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
-	"strconv"
 	"strings"
 )
 
 // V2RayDownloader V2Ray下载器结构体
 type V2RayDownloader struct {
 	Version     string
-	InstallPath string
+	InstallPath string // 为空时安装到核心版本仓库 cores/v2ray/<版本>/
 	TempDir     string
+	Archive     string // 本地发布文件，设置后不从网络下载（离线安装）
+
+	resolved string // latest 解析出的实际版本
 }
 
 // SystemInfo 系统信息
@@ -28,16 +29,20 @@ type SystemInfo struct {
 
 // DownloadMirror 下载镜像源
 type DownloadMirror struct {
-	Name string
-	URL  string
+	Name       string
+	URL        string   // 下载地址或本地文件路径
+	DigestURLs []string // 校验文件地址，按顺序尝试
 }
 
 // NewV2RayDownloader 创建新的下载器实例
 func NewV2RayDownloader() *V2RayDownloader {
+	version := ActiveVersion(CoreV2Ray)
+	if version == "" {
+		version = "latest" // 可以指定版本，如 "v5.12.1"
+	}
 	return &V2RayDownloader{
-		Version:     "latest", // 可以指定版本，如 "v5.12.1"
-		InstallPath: "./v2ray",
-		TempDir:     "./temp",
+		Version: version,
+		TempDir: "./temp",
 	}
 }
 
@@ -83,11 +88,16 @@ func (d *V2RayDownloader) GetSystemInfo() SystemInfo {
 
 // CheckV2rayInstalled 检查V2Ray是否已安装
 func (d *V2RayDownloader) CheckV2rayInstalled() bool {
-	// 检查本地安装路径
-	v2rayPath := filepath.Join(d.InstallPath, d.getV2rayExecutableName())
-	if _, err := os.Stat(v2rayPath); err == nil {
-		fmt.Printf("在本地路径找到V2Ray: %s\n", v2rayPath)
-		return true
+	// 检查版本仓库和旧版安装路径
+	v2rayPath := d.ExecutablePath()
+	if v2rayPath != "v2ray" {
+		if fileExists(v2rayPath) {
+			fmt.Printf("在本地路径找到V2Ray: %s\n", v2rayPath)
+			return true
+		}
+		// 档案指定的版本未安装，不使用其他版本代替
+		fmt.Printf("未安装指定的V2Ray版本: %s\n", v2rayPath)
+		return false
 	}
 
 	// 检查系统PATH中的v2ray
@@ -109,35 +119,60 @@ func (d *V2RayDownloader) getV2rayExecutableName() string {
 	return "v2ray"
 }
 
-// GetDownloadMirrors 获取下载镜像源列表
+// ExecutablePath 返回将要启动的V2Ray可执行文件：指定了 InstallPath 时使用其中的文件，
+// 否则按 档案指定版本 > 仓库当前版本 > 旧版 ./v2ray > 系统PATH 的顺序选择
+func (d *V2RayDownloader) ExecutablePath() string {
+	if d.InstallPath != "" {
+		return filepath.Join(d.InstallPath, d.getV2rayExecutableName())
+	}
+	return ExecutablePath(CoreV2Ray)
+}
+
+// installDir 返回安装目录
+func (d *V2RayDownloader) installDir() string {
+	if d.InstallPath != "" {
+		return d.InstallPath
+	}
+	return VersionDir(CoreV2Ray, d.resolvedVersion())
+}
+
+// GetDownloadMirrors 获取下载镜像源列表，按配置的镜像顺序排列；指定了本地发布文件时只使用该文件
 func (d *V2RayDownloader) GetDownloadMirrors(sysInfo SystemInfo) []DownloadMirror {
-	version := d.Version
-	if version == "latest" {
-		version = "v5.33.0" // 使用最新稳定版本
+	if d.Archive != "" {
+		return []DownloadMirror{archiveMirror(d.Archive, filepath.Base(d.Archive)+".dgst")}
 	}
 
-	// 尝试多种可能的文件名格式
-	possibleFileNames := d.getPossibleFileNames(sysInfo)
+	version := d.resolvedVersion()
 
 	var mirrors []DownloadMirror
 
 	// 为每种可能的文件名创建镜像源
-	for _, fileName := range possibleFileNames {
-		mirrors = append(mirrors, []DownloadMirror{
-			{
-				Name: fmt.Sprintf("GitHub Official (%s)", fileName),
-				URL:  fmt.Sprintf("https://github.com/v2fly/v2ray-core/releases/download/%s/%s", version, fileName),
-			},
-			{
-				Name: fmt.Sprintf("GitHub Mirror (%s)", fileName),
-				URL:  fmt.Sprintf("https://ghproxy.com/https://github.com/v2fly/v2ray-core/releases/download/%s/%s", version, fileName),
-			},
-		}...)
+	for _, fileName := range d.getPossibleFileNames(sysInfo) {
+		for _, mirror := range releaseMirrors(coreRepos[CoreV2Ray], version, fileName, fileName+".dgst") {
+			mirror.Name = fmt.Sprintf("%s (%s)", mirror.Name, fileName)
+			mirrors = append(mirrors, mirror)
+		}
 	}
 
 	return mirrors
 }
 
+// resolvedVersion 返回实际下载的版本号，latest 时查询GitHub最新版本
+func (d *V2RayDownloader) resolvedVersion() string {
+	if d.Version != "latest" && d.Version != "" {
+		return NormalizeVersion(d.Version)
+	}
+	if d.resolved == "" {
+		latest, err := LatestVersion(CoreV2Ray)
+		if err != nil {
+			fmt.Printf("⚠️ %v，使用 %s\n", err, fallbackV2RayVersion)
+			latest = fallbackV2RayVersion
+		}
+		d.resolved = latest
+	}
+	return d.resolved
+}
+
 // getPossibleFileNames 获取可能的文件名列表
 func (d *V2RayDownloader) getPossibleFileNames(sysInfo SystemInfo) []string {
 	var fileNames []string
@@ -166,72 +201,17 @@ func (d *V2RayDownloader) getPossibleFileNames(sysInfo SystemInfo) []string {
 	return fileNames
 }
 
-// DownloadWithProgress 带进度显示的文件下载
+// DownloadWithProgress 带进度显示的文件下载，保存到 TempDir/fileName。
+// 支持断点续传；url 为本地文件路径时直接复制
 func (d *V2RayDownloader) DownloadWithProgress(url, fileName string) error {
 	// 创建临时目录
 	if err := os.MkdirAll(d.TempDir, 0755); err != nil {
 		return fmt.Errorf("创建临时目录失败: %v", err)
 	}
 
-	filePath := filepath.Join(d.TempDir, fileName)
-
-	// 创建文件
-	out, err := os.Create(filePath)
-	if err != nil {
-		return fmt.Errorf("创建文件失败: %v", err)
-	}
-	defer out.Close()
-
-	// 发起HTTP请求
-	resp, err := http.Get(url)
-	if err != nil {
-		return fmt.Errorf("下载请求失败: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("下载失败，HTTP状态码: %d", resp.StatusCode)
-	}
-
-	// 获取文件大小
-	size, _ := strconv.Atoi(resp.Header.Get("Content-Length"))
-
-	// 进度跟踪
-	var downloaded int64 = 0
-	buffer := make([]byte, 32*1024) // 32KB buffer
-
 	fmt.Printf("开始下载: %s\n", fileName)
-	fmt.Printf("文件大小: %.2f MB\n", float64(size)/1024/1024)
-
-	for {
-		n, err := resp.Body.Read(buffer)
-		if n > 0 {
-			_, writeErr := out.Write(buffer[:n])
-			if writeErr != nil {
-				return fmt.Errorf("写入文件失败: %v", writeErr)
-			}
-			downloaded += int64(n)
-
-			// 显示进度
-			if size > 0 {
-				progress := float64(downloaded) / float64(size) * 100
-				fmt.Printf("\r下载进度: %.1f%% (%.2f/%.2f MB)",
-					progress,
-					float64(downloaded)/1024/1024,
-					float64(size)/1024/1024)
-			}
-		}
-
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return fmt.Errorf("读取数据失败: %v", err)
-		}
-	}
-
-	fmt.Println("\n下载完成!")
-	return nil
+	_, err := fetchFile(url, filepath.Join(d.TempDir, fileName))
+	return err
 }
 
 // ExtractZip 解压ZIP文件
@@ -319,13 +299,34 @@ func (d *V2RayDownloader) CleanupTempFiles() {
 	}
 }
 
-// DownloadAndInstall 下载并安装V2Ray
+// DownloadAndInstall 未安装时下载并安装V2Ray，仓库中还没有当前版本时设为当前版本
 func (d *V2RayDownloader) DownloadAndInstall() error {
 	// 检查是否已安装
 	if d.CheckV2rayInstalled() {
 		return nil
 	}
 
+	version, err := d.Install()
+	if err != nil {
+		return err
+	}
+	if d.InstallPath == "" && CurrentVersion(CoreV2Ray) == "" {
+		return UseVersion(CoreV2Ray, version)
+	}
+	return nil
+}
+
+// Install 下载并安装 Version 指定的版本（不检查是否已安装，不切换当前版本），返回安装的版本
+func (d *V2RayDownloader) Install() (string, error) {
+	if d.Archive != "" {
+		version, err := archiveVersion(d.Archive, d.Version)
+		if err != nil {
+			return "", err
+		}
+		d.Version = version
+	}
+	installDir := d.installDir()
+
 	// 获取系统信息
 	sysInfo := d.GetSystemInfo()
 	fmt.Printf("检测到系统: %s-%s\n", sysInfo.OS, sysInfo.Arch)
@@ -350,11 +351,19 @@ func (d *V2RayDownloader) DownloadAndInstall() error {
 			continue
 		}
 
+		// 校验SHA256，不一致时拒绝安装
+		zipPath := filepath.Join(d.TempDir, fileName)
+		downloadErr = verifyAsset(CoreV2Ray, d.resolvedVersion(), fileName, zipPath, mirror.DigestURLs)
+		if downloadErr != nil {
+			fmt.Printf("❌ 校验失败: %v\n", downloadErr)
+			os.Remove(zipPath)
+			continue
+		}
+
 		downloadSuccess = true
 
 		// 解压文件
-		zipPath := filepath.Join(d.TempDir, fileName)
-		extractErr := d.ExtractZip(zipPath, d.InstallPath)
+		extractErr := d.ExtractZip(zipPath, installDir)
 		if extractErr != nil {
 			fmt.Printf("解压失败: %v\n", extractErr)
 			downloadSuccess = false
@@ -362,43 +371,56 @@ func (d *V2RayDownloader) DownloadAndInstall() error {
 		}
 
 		// 设置执行权限
-		v2rayPath := filepath.Join(d.InstallPath, d.getV2rayExecutableName())
+		v2rayPath := filepath.Join(installDir, d.getV2rayExecutableName())
 		if err := d.SetExecutablePermission(v2rayPath); err != nil {
 			fmt.Printf("设置执行权限失败: %v\n", err)
 			downloadSuccess = false
 			continue
 		}
 
+		// 记录可执行文件的SHA256，启动前校验
+		if err := recordBinary(CoreV2Ray, d.resolvedVersion(), v2rayPath); err != nil {
+			fmt.Printf("⚠️ 记录可执行文件摘要失败: %v\n", err)
+		}
+
 		break
 	}
 
-	// 清理临时文件
-	d.CleanupTempFiles()
-
 	if !downloadSuccess {
+		// 不在仓库中留下不完整的版本目录
+		if d.InstallPath == "" {
+			os.RemoveAll(installDir)
+		}
 		// 提供手动下载指导
 		d.ShowManualDownloadGuide(sysInfo)
-		return fmt.Errorf("从所有镜像源下载都失败，最后一个错误: %v", downloadErr)
+		return "", fmt.Errorf("从所有镜像源下载都失败，最后一个错误: %v", downloadErr)
 	}
 
+	// 清理临时文件（失败时保留未完成的下载，下次续传）
+	d.CleanupTempFiles()
+
 	// 验证安装
-	v2rayPath := filepath.Join(d.InstallPath, d.getV2rayExecutableName())
+	v2rayPath := filepath.Join(installDir, d.getV2rayExecutableName())
 	if _, err := os.Stat(v2rayPath); err != nil {
-		return fmt.Errorf("安装验证失败，找不到V2Ray可执行文件: %s", v2rayPath)
+		return "", fmt.Errorf("安装验证失败，找不到V2Ray可执行文件: %s", v2rayPath)
 	}
 
 	fmt.Printf("\n✅ V2Ray核心安装成功！\n")
 	fmt.Printf("安装路径: %s\n", v2rayPath)
 
 	// 显示版本信息
-	d.ShowV2rayVersion()
+	showV2rayVersion(v2rayPath)
 
-	return nil
+	return d.resolvedVersion(), nil
 }
 
 // ShowV2rayVersion 显示V2Ray版本信息
 func (d *V2RayDownloader) ShowV2rayVersion() {
-	v2rayPath := filepath.Join(d.InstallPath, d.getV2rayExecutableName())
+	showV2rayVersion(d.ExecutablePath())
+}
+
+// showV2rayVersion 显示指定可执行文件的版本信息
+func showV2rayVersion(v2rayPath string) {
 	cmd := exec.Command(v2rayPath, "-version")
 
 	output, err := cmd.Output()
@@ -432,10 +454,18 @@ func (d *V2RayDownloader) ShowManualDownloadGuide(sysInfo SystemInfo) {
 	}
 
 	fmt.Println()
-	fmt.Printf("3. 下载后解压到: %s\n", d.InstallPath)
-	fmt.Printf("4. 确保 v2ray 可执行文件位于: %s\n", filepath.Join(d.InstallPath, d.getV2rayExecutableName()))
+	fmt.Printf("3. 下载后解压到: %s\n", d.installDir())
+	fmt.Printf("4. 确保 v2ray 可执行文件位于: %s\n", filepath.Join(d.installDir(), d.getV2rayExecutableName()))
+	fmt.Println()
+	if d.InstallPath == "" {
+		fmt.Printf("5. 解压完成后，运行 '%s core use v2ray %s' 切换到该版本，再运行 '%s check-v2ray' 验证安装\n", os.Args[0], d.resolvedVersion(), os.Args[0])
+	} else {
+		fmt.Printf("5. 解压完成后，可以运行 '%s check-v2ray' 来验证安装\n", os.Args[0])
+	}
 	fmt.Println()
-	fmt.Printf("5. 解压完成后，可以运行 '%s check-v2ray' 来验证安装\n", os.Args[0])
+	fmt.Println("也可以不手动解压，把下载的文件和同名 .dgst 校验文件放在同一目录，运行:")
+	fmt.Printf("   %s download-v2ray --archive=<文件> --version=%s\n", os.Args[0], d.resolvedVersion())
+	fmt.Println("   校验、解压和安装与在线下载相同；无法访问GitHub时，可在配置档案的 mirrors 中设置内部镜像地址")
 	fmt.Println()
 }
 
@@ -0,0 +1,404 @@
+package downloader
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LockfileName 固定摘要文件的文件名
+const LockfileName = "cores.lock"
+
+var (
+	lockfilePath string // 为空时使用 DefaultLockfile()
+	lockMutex    sync.Mutex
+
+	// allowUnverified 是否允许启动没有摘要记录的核心（档案键 allow_unverified_cores）
+	allowUnverified bool
+
+	// unrecordedWarned 已提示过"未记录摘要"的可执行文件，每个路径只提示一次
+	unrecordedWarned sync.Map
+)
+
+// DefaultLockfile 固定摘要文件的默认路径：用户配置目录下的 v2ray-manager/cores.lock，
+// 与默认配置文件放在一起，不随工作目录变化
+func DefaultLockfile() string {
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, "v2ray-manager", LockfileName)
+	}
+	if abs, err := filepath.Abs(LockfileName); err == nil {
+		return abs
+	}
+	return LockfileName
+}
+
+// lockfile 固定摘要文件内容：<核心>/<版本>/<发布文件名> -> SHA256
+//
+// 首次安装某个发布文件并通过官方校验文件验证后写入，之后再次安装同一文件时
+// 必须与记录的摘要一致（类似 go.sum）。将该文件复制到其他机器即可固定核心版本。
+//
+// Binaries 记录从校验通过的发布文件中解出的可执行文件：<核心>/<版本>/<可执行文件名> -> SHA256，
+// 启动核心前由 VerifyBinary 比对
+type lockfile struct {
+	Digests  map[string]string `json:"digests"`
+	Binaries map[string]string `json:"binaries,omitempty"`
+}
+
+// SetLockfile 设置固定摘要文件路径（转换为绝对路径），空字符串恢复默认值
+func SetLockfile(path string) {
+	if path != "" {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+	}
+	lockMutex.Lock()
+	defer lockMutex.Unlock()
+	lockfilePath = path
+}
+
+// Lockfile 返回固定摘要文件路径
+func Lockfile() string {
+	lockMutex.Lock()
+	defer lockMutex.Unlock()
+	return lockfileLocked()
+}
+
+// lockfileLocked 返回固定摘要文件路径，调用者需持有 lockMutex
+func lockfileLocked() string {
+	if lockfilePath == "" {
+		return DefaultLockfile()
+	}
+	return lockfilePath
+}
+
+// SetAllowUnverified 是否允许启动没有安装时摘要记录的核心（手动安装或系统PATH中的核心）
+func SetAllowUnverified(allow bool) {
+	lockMutex.Lock()
+	defer lockMutex.Unlock()
+	allowUnverified = allow
+}
+
+// lockKey 固定摘要的键
+func lockKey(core, version, asset string) string {
+	return core + "/" + version + "/" + asset
+}
+
+// readLockfile 读取固定摘要文件，文件不存在时返回空内容；调用者需持有 lockMutex
+func readLockfile() (*lockfile, error) {
+	lock := &lockfile{Digests: make(map[string]string), Binaries: make(map[string]string)}
+	lockfilePath := lockfileLocked()
+	data, err := os.ReadFile(lockfilePath)
+	if os.IsNotExist(err) {
+		return lock, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取固定摘要文件失败: %v", err)
+	}
+	if err := json.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("解析固定摘要文件 %s 失败: %v", lockfilePath, err)
+	}
+	if lock.Digests == nil {
+		lock.Digests = make(map[string]string)
+	}
+	if lock.Binaries == nil {
+		lock.Binaries = make(map[string]string)
+	}
+	return lock, nil
+}
+
+// pinnedDigest 返回固定的摘要
+func pinnedDigest(key string) (string, bool, error) {
+	lockMutex.Lock()
+	defer lockMutex.Unlock()
+
+	lock, err := readLockfile()
+	if err != nil {
+		return "", false, err
+	}
+	digest, ok := lock.Digests[key]
+	return strings.ToLower(digest), ok, nil
+}
+
+// pinDigest 将发布文件的摘要写入固定摘要文件
+func pinDigest(key, digest string) error {
+	return updateLockfile(func(lock *lockfile) {
+		lock.Digests[key] = digest
+	})
+}
+
+// updateLockfile 读取固定摘要文件，修改后原子替换
+func updateLockfile(modify func(lock *lockfile)) error {
+	lockMutex.Lock()
+	defer lockMutex.Unlock()
+
+	lock, err := readLockfile()
+	if err != nil {
+		return err
+	}
+	modify(lock)
+	lockfilePath := lockfileLocked()
+
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(lockfilePath); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	tmp := lockfilePath + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, lockfilePath)
+}
+
+// FileSHA256 计算文件的SHA256（小写十六进制）
+func FileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fetchDigest 依次尝试从校验文件地址（或本地校验文件）获取 asset 的SHA256，返回摘要和来源
+func fetchDigest(urls []string, asset string) (string, string, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	var lastErr error
+	for _, url := range urls {
+		// 本地发布文件旁的校验文件
+		if isLocalSource(url) {
+			file, err := os.Open(url)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			digest, err := parseDigestFile(io.LimitReader(file, 1<<20), asset)
+			file.Close()
+			if err != nil {
+				lastErr = fmt.Errorf("%s: %v", url, err)
+				continue
+			}
+			return digest, url, nil
+		}
+
+		resp, err := client.Get(url)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("%s: HTTP状态码 %d", url, resp.StatusCode)
+			continue
+		}
+		digest, err := parseDigestFile(io.LimitReader(resp.Body, 1<<20), asset)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %v", url, err)
+			continue
+		}
+		return digest, url, nil
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("没有可用的校验文件地址")
+	}
+	return "", "", lastErr
+}
+
+// parseDigestFile 从校验文件中取出 asset 的SHA256，支持三种格式：
+//   - V2Ray 的 .dgst 文件：每行 "SHA2-256= <摘要>"，只描述一个文件
+//   - sha256sum 格式（如 Hysteria2 的 hashes.txt）：每行 "<摘要>  [路径/]<文件名>"
+//   - GitHub发布API的JSON（如 sing-box）：assets 中每个文件的 "digest": "sha256:<摘要>"
+func parseDigestFile(r io.Reader, asset string) (string, error) {
+	br := bufio.NewReader(r)
+	if b, err := br.Peek(1); err == nil && b[0] == '{' {
+		return parseReleaseDigest(br, asset)
+	}
+
+	scanner := bufio.NewScanner(br)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if i := strings.Index(line, "="); i > 0 {
+			name := strings.ToUpper(strings.TrimSpace(line[:i]))
+			if name == "SHA2-256" || name == "SHA256" {
+				if digest := strings.ToLower(strings.TrimSpace(line[i+1:])); isSHA256(digest) {
+					return digest, nil
+				}
+			}
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !isSHA256(strings.ToLower(fields[0])) {
+			continue
+		}
+		name := strings.TrimPrefix(fields[len(fields)-1], "*")
+		if filepath.Base(name) == asset {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("校验文件中没有 %s 的SHA256", asset)
+}
+
+// parseReleaseDigest 从GitHub发布API的响应中取出 asset 的SHA256
+func parseReleaseDigest(r io.Reader, asset string) (string, error) {
+	var release struct {
+		Assets []struct {
+			Name   string `json:"name"`
+			Digest string `json:"digest"`
+		} `json:"assets"`
+	}
+	if err := json.NewDecoder(r).Decode(&release); err != nil {
+		return "", fmt.Errorf("解析发布信息失败: %v", err)
+	}
+	for _, a := range release.Assets {
+		if a.Name != asset {
+			continue
+		}
+		digest := strings.ToLower(strings.TrimPrefix(a.Digest, "sha256:"))
+		if !isSHA256(digest) {
+			return "", fmt.Errorf("发布信息中没有 %s 的SHA256", asset)
+		}
+		return digest, nil
+	}
+	return "", fmt.Errorf("发布信息中没有 %s", asset)
+}
+
+// isSHA256 是否为64位十六进制摘要
+func isSHA256(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// verifyAsset 校验下载的发布文件，不一致时返回错误（调用者应拒绝安装）。
+//
+// 固定摘要文件中有记录时以记录为准；没有记录时必须能从校验文件地址取得摘要并且一致，
+// 校验通过后将摘要写入固定摘要文件。
+func verifyAsset(core, version, asset, path string, digestURLs []string) error {
+	actual, err := FileSHA256(path)
+	if err != nil {
+		return fmt.Errorf("计算SHA256失败: %v", err)
+	}
+	fmt.Printf("🔐 SHA256: %s\n", actual)
+
+	key := lockKey(core, version, asset)
+	pinned, ok, err := pinnedDigest(key)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if actual != pinned {
+			return fmt.Errorf("%s 与固定摘要不符 (%s 中记录 %s)，拒绝安装", asset, Lockfile(), pinned)
+		}
+		fmt.Printf("✅ 与固定摘要一致 (%s)\n", Lockfile())
+		return nil
+	}
+
+	expected, source, err := fetchDigest(digestURLs, asset)
+	if err != nil {
+		return fmt.Errorf("无法获取 %s 的校验文件，拒绝安装: %v", asset, err)
+	}
+	if actual != expected {
+		return fmt.Errorf("%s 的SHA256与校验文件不符 (期望 %s)，拒绝安装", asset, expected)
+	}
+	fmt.Printf("✅ 与校验文件一致: %s\n", source)
+
+	if err := pinDigest(key, actual); err != nil {
+		fmt.Printf("⚠️ 写入固定摘要文件失败: %v\n", err)
+	} else {
+		fmt.Printf("📌 已固定摘要: %s (%s)\n", key, Lockfile())
+	}
+	return nil
+}
+
+// recordBinary 将从校验通过的发布文件中解出的可执行文件的SHA256写入固定摘要文件，
+// 启动前由 VerifyBinary 校验；只能在 verifyAsset 通过之后调用
+func recordBinary(core, version, binaryPath string) error {
+	digest, err := FileSHA256(binaryPath)
+	if err != nil {
+		return err
+	}
+	return updateLockfile(func(lock *lockfile) {
+		lock.Binaries[lockKey(core, version, filepath.Base(binaryPath))] = digest
+	})
+}
+
+// VerifyBinary 启动核心前重新校验可执行文件的SHA256。
+//
+// 只信任固定摘要文件中安装时记录的摘要（来自校验通过的发布文件），不读取可执行文件旁的任何文件；
+// 与记录都不一致时返回错误。手动安装或系统PATH中的核心没有记录，默认拒绝启动，
+// 只有设置了 SetAllowUnverified 时提示一次并放行。
+func VerifyBinary(binaryPath string) error {
+	path := binaryPath
+	if !strings.ContainsRune(path, os.PathSeparator) && !strings.Contains(path, "/") {
+		if found, err := exec.LookPath(path); err == nil {
+			path = found
+		}
+	}
+
+	actual, err := FileSHA256(path)
+	if err != nil {
+		return fmt.Errorf("计算SHA256失败: %v", err)
+	}
+
+	lockMutex.Lock()
+	lock, err := readLockfile()
+	allow := allowUnverified
+	lockfilePath := lockfileLocked()
+	lockMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	recorded := false
+	name := filepath.Base(path)
+	for key, digest := range lock.Binaries {
+		if strings.ToLower(digest) == actual {
+			return nil
+		}
+		if strings.HasSuffix(key, "/"+name) {
+			recorded = true
+		}
+	}
+	if recorded {
+		return fmt.Errorf("%s 的SHA256 (%s) 与 %s 中安装时记录的摘要都不符，可执行文件可能已被替换，拒绝启动", path, actual, lockfilePath)
+	}
+
+	if !allow {
+		return fmt.Errorf("%s 在 %s 中没有安装时记录的摘要，无法校验完整性，拒绝启动（用 core install 重新安装即可记录；确认要使用未校验的核心时设置档案键 allow_unverified_cores = true）", path, lockfilePath)
+	}
+	if _, warned := unrecordedWarned.LoadOrStore(path, true); !warned {
+		fmt.Fprintf(os.Stderr, "⚠️ %s 没有安装时记录的摘要，已按 allow_unverified_cores 跳过完整性校验\n", path)
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// fallbackXrayVersion 无法查询GitHub最新版本时使用的Xray版本
+const fallbackXrayVersion = "v25.3.6"
+
+// 全局互斥锁，防止并发下载 Xray
+var xrayDownloadMutex sync.Mutex
+
+// XrayDownloader Xray-core下载器，发布文件格式与V2Ray相同（zip + .dgst 校验文件），
+// 复用 V2RayDownloader 的下载、解压和权限设置
+type XrayDownloader struct {
+	*V2RayDownloader
+}
+
+// NewXrayDownloader 创建Xray下载器，版本按 档案指定版本 > 仓库当前版本 > latest 选择
+func NewXrayDownloader() *XrayDownloader {
+	version := ActiveVersion(CoreXray)
+	if version == "" {
+		version = "latest"
+	}
+	return &XrayDownloader{
+		V2RayDownloader: &V2RayDownloader{
+			Version: version,
+			TempDir: "./temp",
+		},
+	}
+}
+
+// resolvedVersion 返回实际下载的版本号，latest 时查询GitHub最新版本
+func (d *XrayDownloader) resolvedVersion() string {
+	if d.Version != "latest" && d.Version != "" {
+		return NormalizeVersion(d.Version)
+	}
+	if d.resolved == "" {
+		latest, err := LatestVersion(CoreXray)
+		if err != nil {
+			fmt.Printf("⚠️ %v，使用 %s\n", err, fallbackXrayVersion)
+			latest = fallbackXrayVersion
+		}
+		d.resolved = latest
+	}
+	return d.resolved
+}
+
+// installDir 返回安装目录
+func (d *XrayDownloader) installDir() string {
+	if d.InstallPath != "" {
+		return d.InstallPath
+	}
+	return VersionDir(CoreXray, d.resolvedVersion())
+}
+
+// ExecutablePath 返回将要启动的Xray可执行文件
+func (d *XrayDownloader) ExecutablePath() string {
+	if d.InstallPath != "" {
+		return filepath.Join(d.InstallPath, coreExecutableName(CoreXray))
+	}
+	return ExecutablePath(CoreXray)
+}
+
+// CheckXrayInstalled 检查Xray是否已安装
+func (d *XrayDownloader) CheckXrayInstalled() bool {
+	xrayPath := d.ExecutablePath()
+	if xrayPath != "xray" {
+		if fileExists(xrayPath) {
+			return true
+		}
+		if VersionOverride(CoreXray) != "" || d.InstallPath != "" {
+			return false
+		}
+	}
+
+	// 检查系统PATH中的xray
+	_, err := exec.LookPath("xray")
+	return err == nil
+}
+
+// fileName 返回当前平台的发布文件名，如 Xray-linux-64.zip、Xray-macos-arm64-v8a.zip
+func (d *XrayDownloader) fileName() (string, error) {
+	osName := runtime.GOOS
+	if osName == "darwin" {
+		osName = "macos"
+	}
+
+	var arch string
+	switch runtime.GOARCH {
+	case "amd64":
+		arch = "64"
+	case "386":
+		arch = "32"
+	case "arm64":
+		arch = "arm64-v8a"
+	case "arm":
+		arch = "arm32-v7a"
+	default:
+		return "", fmt.Errorf("不支持的架构: %s", runtime.GOARCH)
+	}
+	return fmt.Sprintf("Xray-%s-%s.zip", osName, arch), nil
+}
+
+// GetDownloadMirrors 获取下载镜像源列表，按配置的镜像顺序排列；指定了本地发布文件时只使用该文件
+func (d *XrayDownloader) GetDownloadMirrors() ([]DownloadMirror, error) {
+	if d.Archive != "" {
+		return []DownloadMirror{archiveMirror(d.Archive, filepath.Base(d.Archive)+".dgst")}, nil
+	}
+	fileName, err := d.fileName()
+	if err != nil {
+		return nil, err
+	}
+	return releaseMirrors(coreRepos[CoreXray], d.resolvedVersion(), fileName, fileName+".dgst"), nil
+}
+
+// Install 下载并安装 Version 指定的版本到 cores/xray/<版本>/（不切换当前版本），返回安装的版本
+func (d *XrayDownloader) Install() (string, error) {
+	if d.Archive != "" {
+		version, err := archiveVersion(d.Archive, d.Version)
+		if err != nil {
+			return "", err
+		}
+		d.Version = version
+	}
+	installDir := d.installDir()
+	version := d.resolvedVersion()
+
+	mirrors, err := d.GetDownloadMirrors()
+	if err != nil {
+		return "", err
+	}
+
+	var downloadErr error
+	installed := false
+	for _, mirror := range mirrors {
+		fmt.Printf("\n尝试从 %s 下载 Xray %s...\n", mirror.Name, version)
+		fmt.Printf("下载链接: %s\n", mirror.URL)
+
+		fileName := filepath.Base(mirror.URL)
+		downloadErr = d.DownloadWithProgress(mirror.URL, fileName)
+		if downloadErr != nil {
+			fmt.Printf("从 %s 下载失败: %v\n", mirror.Name, downloadErr)
+			continue
+		}
+
+		// 校验SHA256，不一致时拒绝安装；优先使用官方校验文件
+		zipPath := filepath.Join(d.TempDir, fileName)
+		downloadErr = verifyAsset(CoreXray, version, fileName, zipPath, mirror.DigestURLs)
+		if downloadErr != nil {
+			fmt.Printf("❌ 校验失败: %v\n", downloadErr)
+			os.Remove(zipPath)
+			continue
+		}
+
+		if downloadErr = d.ExtractZip(zipPath, installDir); downloadErr != nil {
+			fmt.Printf("解压失败: %v\n", downloadErr)
+			continue
+		}
+
+		xrayPath := filepath.Join(installDir, coreExecutableName(CoreXray))
+		if downloadErr = d.SetExecutablePermission(xrayPath); downloadErr != nil {
+			fmt.Printf("设置执行权限失败: %v\n", downloadErr)
+			continue
+		}
+
+		// 记录可执行文件的SHA256，启动前校验
+		if err := recordBinary(CoreXray, version, xrayPath); err != nil {
+			fmt.Printf("⚠️ 记录可执行文件摘要失败: %v\n", err)
+		}
+
+		installed = true
+		break
+	}
+
+	if !installed {
+		if d.InstallPath == "" {
+			os.RemoveAll(installDir)
+		}
+		return "", fmt.Errorf("从所有镜像源下载Xray都失败，最后一个错误: %v", downloadErr)
+	}
+
+	// 清理临时文件（失败时保留未完成的下载，下次续传）
+	d.CleanupTempFiles()
+
+	xrayPath := filepath.Join(installDir, coreExecutableName(CoreXray))
+	fmt.Printf("\n✅ Xray核心安装成功！\n")
+	fmt.Printf("安装路径: %s\n", xrayPath)
+	showXrayVersion(xrayPath)
+
+	return version, nil
+}
+
+// DownloadAndInstall 未安装时下载并安装Xray，仓库中还没有当前版本时设为当前版本
+func (d *XrayDownloader) DownloadAndInstall() error {
+	if d.CheckXrayInstalled() {
+		return nil
+	}
+
+	version, err := d.Install()
+	if err != nil {
+		return err
+	}
+	if d.InstallPath == "" && CurrentVersion(CoreXray) == "" {
+		return UseVersion(CoreXray, version)
+	}
+	return nil
+}
+
+// SafeDownloadXray 安全下载Xray（带互斥锁），代理启动时按需调用
+func (d *XrayDownloader) SafeDownloadXray() error {
+	xrayDownloadMutex.Lock()
+	defer xrayDownloadMutex.Unlock()
+
+	// 再次检查是否已安装（可能在等待锁的过程中被其他goroutine安装了）
+	if d.CheckXrayInstalled() {
+		return nil
+	}
+	return d.DownloadAndInstall()
+}
+
+// ShowXrayVersion 显示Xray版本信息
+func (d *XrayDownloader) ShowXrayVersion() {
+	showXrayVersion(d.ExecutablePath())
+}
+
+// showXrayVersion 显示指定可执行文件的版本信息
+func showXrayVersion(xrayPath string) {
+	output, err := exec.Command(xrayPath, "version").Output()
+	if err != nil {
+		fmt.Printf("无法获取Xray版本信息: %v\n", err)
+		return
+	}
+	fmt.Printf("Xray版本信息:\n%s\n", string(output))
+}
+
+// AutoDownloadXray 自动下载Xray的便捷函数
+func AutoDownloadXray() error {
+	return NewXrayDownloader().DownloadAndInstall()
+}
@@ -0,0 +1,280 @@
+package filter
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
+)
+
+// Syntax 规则语法说明（CLI帮助和Web UI共用）
+const Syntax = `# 每行一条规则，# 开头的行为注释
+# 只保留匹配的节点（多条include为"或"关系）
+include name ~ 香港|HK
+# 排除匹配的节点
+exclude name ~ 剩余流量|官网|到期
+# 字段: name/server/protocol/port/param.<参数名>，!~ 表示不匹配
+exclude param.type !~ ^(ws|tcp)$
+# 用正则重命名，模板支持 $1 分组和 {protocol}/{server}/{port}
+rename ^\[(.+?)\]\s*(.+)$ => $2 ($1)
+# 根据名称识别地区并添加国旗
+flag
+# 按顺序编号，模板可省略（默认 {name} {index}）
+number {name} {index}`
+
+// matchRule 包含/排除规则
+type matchRule struct {
+	field  string
+	negate bool
+	re     *regexp.Regexp
+}
+
+// renameRule 重命名规则
+type renameRule struct {
+	re       *regexp.Regexp
+	template string
+}
+
+// Filter 节点过滤与重命名规则集
+// 处理顺序: include -> exclude -> rename -> flag -> number
+type Filter struct {
+	includes       []matchRule
+	excludes       []matchRule
+	renames        []renameRule
+	addFlag        bool
+	numbering      bool
+	numberTemplate string
+	source         string
+}
+
+// Parse 解析规则文本
+func Parse(text string) (*Filter, error) {
+	f := &Filter{source: text}
+
+	for i, rawLine := range strings.Split(text, "\n") {
+		line := strings.TrimSpace(rawLine)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		keyword, rest := cutToken(line)
+		var err error
+		switch strings.ToLower(keyword) {
+		case "include", "exclude":
+			var rule matchRule
+			rule, err = parseMatchRule(rest)
+			if err == nil {
+				if strings.ToLower(keyword) == "include" {
+					f.includes = append(f.includes, rule)
+				} else {
+					f.excludes = append(f.excludes, rule)
+				}
+			}
+		case "rename":
+			var rule renameRule
+			rule, err = parseRenameRule(rest)
+			if err == nil {
+				f.renames = append(f.renames, rule)
+			}
+		case "flag":
+			f.addFlag = true
+		case "number":
+			f.numbering = true
+			f.numberTemplate = strings.TrimSpace(rest)
+		default:
+			err = fmt.Errorf("未知的规则类型: %s", keyword)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("第%d行: %v", i+1, err)
+		}
+	}
+
+	return f, nil
+}
+
+// LoadFile 从文件加载规则
+func LoadFile(path string) (*Filter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取过滤规则文件失败: %v", err)
+	}
+	f, err := Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("解析过滤规则文件 %s 失败: %v", path, err)
+	}
+	return f, nil
+}
+
+// Empty 规则集是否为空
+func (f *Filter) Empty() bool {
+	return f == nil || (len(f.includes) == 0 && len(f.excludes) == 0 && len(f.renames) == 0 && !f.addFlag && !f.numbering)
+}
+
+// String 返回原始规则文本
+func (f *Filter) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.source
+}
+
+// Apply 对节点列表应用规则，返回新的节点列表（不修改传入的节点）
+func (f *Filter) Apply(nodes []*types.Node) []*types.Node {
+	if f.Empty() {
+		return nodes
+	}
+
+	result := make([]*types.Node, 0, len(nodes))
+	for _, node := range nodes {
+		if !f.keep(node) {
+			continue
+		}
+
+		copied := *node
+		for _, rule := range f.renames {
+			copied.Name = rule.apply(&copied)
+		}
+		if f.addFlag {
+			copied.Name = withFlag(copied.Name)
+		}
+		result = append(result, &copied)
+	}
+
+	if f.numbering {
+		width := len(strconv.Itoa(len(result)))
+		template := f.numberTemplate
+		if template == "" {
+			template = "{name} {index}"
+		}
+		for i, node := range result {
+			node.Name = strings.NewReplacer(
+				"{name}", node.Name,
+				"{index}", fmt.Sprintf("%0*d", width, i+1),
+			).Replace(template)
+		}
+	}
+
+	return result
+}
+
+// keep 判断节点是否通过包含/排除规则
+func (f *Filter) keep(node *types.Node) bool {
+	if len(f.includes) > 0 {
+		matched := false
+		for _, rule := range f.includes {
+			if rule.match(node) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	for _, rule := range f.excludes {
+		if rule.match(node) {
+			return false
+		}
+	}
+	return true
+}
+
+// match 判断节点字段是否匹配
+func (r matchRule) match(node *types.Node) bool {
+	return r.re.MatchString(fieldValue(node, r.field)) != r.negate
+}
+
+// apply 执行重命名
+func (r renameRule) apply(node *types.Node) string {
+	template := strings.NewReplacer(
+		"{protocol}", node.Protocol,
+		"{server}", node.Server,
+		"{port}", node.Port,
+	).Replace(r.template)
+	return r.re.ReplaceAllString(node.Name, template)
+}
+
+// parseMatchRule 解析 "<字段> ~ <正则>" 或 "<字段> !~ <正则>"
+func parseMatchRule(text string) (matchRule, error) {
+	field, rest := cutToken(text)
+	op, pattern := cutToken(rest)
+	if field == "" || pattern == "" {
+		return matchRule{}, fmt.Errorf("格式应为: <字段> ~ <正则>")
+	}
+	if !validField(field) {
+		return matchRule{}, fmt.Errorf("未知的字段: %s", field)
+	}
+
+	rule := matchRule{field: field}
+	switch op {
+	case "~":
+	case "!~":
+		rule.negate = true
+	default:
+		return matchRule{}, fmt.Errorf("未知的操作符: %s (支持 ~ 和 !~)", op)
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return matchRule{}, fmt.Errorf("无效的正则表达式: %v", err)
+	}
+	rule.re = re
+	return rule, nil
+}
+
+// parseRenameRule 解析 "<正则> => <模板>"
+func parseRenameRule(text string) (renameRule, error) {
+	pattern, template, found := strings.Cut(text, "=>")
+	pattern = strings.TrimSpace(pattern)
+	if !found || pattern == "" {
+		return renameRule{}, fmt.Errorf("格式应为: rename <正则> => <模板>")
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return renameRule{}, fmt.Errorf("无效的正则表达式: %v", err)
+	}
+	return renameRule{re: re, template: strings.TrimSpace(template)}, nil
+}
+
+// cutToken 切出第一个以空白分隔的词
+func cutToken(text string) (string, string) {
+	text = strings.TrimSpace(text)
+	index := strings.IndexAny(text, " \t")
+	if index < 0 {
+		return text, ""
+	}
+	return text[:index], strings.TrimSpace(text[index+1:])
+}
+
+// validField 字段名是否合法
+func validField(field string) bool {
+	switch field {
+	case "name", "server", "protocol", "port":
+		return true
+	}
+	return strings.HasPrefix(field, "param.") && len(field) > len("param.")
+}
+
+// fieldValue 获取节点字段值
+func fieldValue(node *types.Node, field string) string {
+	switch field {
+	case "name":
+		return node.Name
+	case "server":
+		return node.Server
+	case "protocol":
+		return node.Protocol
+	case "port":
+		return node.Port
+	}
+	if key := strings.TrimPrefix(field, "param."); node.Parameters != nil {
+		return node.Parameters[key]
+	}
+	return ""
+}
@@ -0,0 +1,79 @@
+package filter
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// region 地区识别规则
+type region struct {
+	code string
+	re   *regexp.Regexp
+}
+
+// regionPattern 生成地区匹配正则，英文关键字要求前后不是字母，避免 "US" 误匹配 "BONUS"
+func regionPattern(chinese, english string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)` + chinese + `|(^|[^a-z])(` + english + `)([^a-z]|$)`)
+}
+
+// regions 按顺序匹配，先匹配到的优先
+var regions = []region{
+	{"HK", regionPattern(`香港|港区`, `hk|hong ?kong`)},
+	{"TW", regionPattern(`台湾|台灣|台北`, `tw|taiwan`)},
+	{"MO", regionPattern(`澳门|澳門`, `mo|macau|macao`)},
+	{"JP", regionPattern(`日本|东京|東京|大阪`, `jp|japan|tokyo|osaka`)},
+	{"SG", regionPattern(`新加坡|狮城`, `sg|singapore`)},
+	{"KR", regionPattern(`韩国|韓國|首尔`, `kr|korea|seoul`)},
+	{"US", regionPattern(`美国|美國|洛杉矶|硅谷|纽约`, `us|usa|united states|los angeles|san jose`)},
+	{"GB", regionPattern(`英国|英國|伦敦`, `uk|gb|united kingdom|london`)},
+	{"DE", regionPattern(`德国|德國|法兰克福`, `de|germany|frankfurt`)},
+	{"FR", regionPattern(`法国|法國|巴黎`, `fr|france|paris`)},
+	{"NL", regionPattern(`荷兰|荷蘭|阿姆斯特丹`, `nl|netherlands|amsterdam`)},
+	{"CA", regionPattern(`加拿大`, `ca|canada`)},
+	{"AU", regionPattern(`澳大利亚|澳洲|悉尼`, `au|australia|sydney`)},
+	{"RU", regionPattern(`俄罗斯|俄羅斯|莫斯科`, `ru|russia|moscow`)},
+	{"IN", regionPattern(`印度`, `india|mumbai`)},
+	{"TR", regionPattern(`土耳其`, `tr|turkey`)},
+	{"MY", regionPattern(`马来西亚|馬來西亞`, `my|malaysia`)},
+	{"TH", regionPattern(`泰国|泰國`, `th|thailand`)},
+	{"VN", regionPattern(`越南`, `vn|vietnam`)},
+	{"PH", regionPattern(`菲律宾|菲律賓`, `ph|philippines`)},
+	{"ID", regionPattern(`印尼|印度尼西亚`, `indonesia`)},
+	{"BR", regionPattern(`巴西`, `br|brazil`)},
+	{"AR", regionPattern(`阿根廷`, `argentina`)},
+}
+
+// DetectRegion 根据节点名称识别地区代码，无法识别时返回空字符串
+func DetectRegion(name string) string {
+	for _, r := range regions {
+		if r.re.MatchString(name) {
+			return r.code
+		}
+	}
+	return ""
+}
+
+// FlagEmoji 将两位地区代码转换为国旗emoji
+func FlagEmoji(code string) string {
+	if len(code) != 2 {
+		return ""
+	}
+	code = strings.ToUpper(code)
+	return string([]rune{
+		rune(0x1F1E6 + int(code[0]-'A')),
+		rune(0x1F1E6 + int(code[1]-'A')),
+	})
+}
+
+// withFlag 为名称添加国旗（已有国旗或无法识别地区时保持不变）
+func withFlag(name string) string {
+	if first, _ := utf8.DecodeRuneInString(name); first >= 0x1F1E6 && first <= 0x1F1FF {
+		return name
+	}
+	flag := FlagEmoji(DetectRegion(name))
+	if flag == "" {
+		return name
+	}
+	return flag + " " + name
+}
@@ -0,0 +1,122 @@
+package history
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// halfLife 历史记录权重的半衰期，越近的测试结果影响越大
+const halfLife = 24 * time.Hour
+
+// NodeStats 单个节点的历史统计
+type NodeStats struct {
+	Fingerprint  string    `json:"fingerprint"`
+	Name         string    `json:"name"`
+	Protocol     string    `json:"protocol"`
+	Server       string    `json:"server"`
+	Port         string    `json:"port"`
+	Tests        int       `json:"tests"`
+	Successes    int       `json:"successes"`
+	AvgLatencyMs int64     `json:"avg_latency_ms"`
+	AvgSpeedMbps float64   `json:"avg_speed_mbps"`
+	LastTest     time.Time `json:"last_test"`
+	LastSuccess  time.Time `json:"last_success,omitempty"`
+	LastError    string    `json:"last_error,omitempty"`
+	Reliability  float64   `json:"reliability"` // 按时间衰减加权的成功率（0-1）
+
+	weightedSuccess float64
+	weightedTotal   float64
+	latencySum      int64
+	speedSum        float64
+}
+
+// SuccessRate 未加权的成功率
+func (s *NodeStats) SuccessRate() float64 {
+	if s.Tests == 0 {
+		return 0
+	}
+	return float64(s.Successes) / float64(s.Tests)
+}
+
+// Summarize 按节点指纹汇总测试记录
+func Summarize(records []Record, now time.Time) map[string]*NodeStats {
+	stats := make(map[string]*NodeStats)
+	for _, record := range records {
+		stat, ok := stats[record.Fingerprint]
+		if !ok {
+			stat = &NodeStats{Fingerprint: record.Fingerprint}
+			stats[record.Fingerprint] = stat
+		}
+
+		// 名称等信息以最近一次记录为准
+		if !record.TestTime.Before(stat.LastTest) {
+			stat.Name = record.Name
+			stat.Protocol = record.Protocol
+			stat.Server = record.Server
+			stat.Port = record.Port
+			stat.LastTest = record.TestTime
+			if !record.Success {
+				stat.LastError = record.Error
+			} else {
+				stat.LastError = ""
+			}
+		}
+
+		age := now.Sub(record.TestTime)
+		if age < 0 {
+			age = 0
+		}
+		weight := math.Pow(0.5, float64(age)/float64(halfLife))
+
+		stat.Tests++
+		stat.weightedTotal += weight
+		if record.Success {
+			stat.Successes++
+			stat.weightedSuccess += weight
+			stat.latencySum += record.LatencyMs
+			stat.speedSum += record.SpeedMbps
+			if record.TestTime.After(stat.LastSuccess) {
+				stat.LastSuccess = record.TestTime
+			}
+		}
+	}
+
+	for _, stat := range stats {
+		// 拉普拉斯平滑：测试次数少的节点向0.5靠拢
+		stat.Reliability = (stat.weightedSuccess + 1) / (stat.weightedTotal + 2)
+		if stat.Successes > 0 {
+			stat.AvgLatencyMs = stat.latencySum / int64(stat.Successes)
+			stat.AvgSpeedMbps = stat.speedSum / float64(stat.Successes)
+		}
+	}
+	return stats
+}
+
+// Rank 按可靠性降序、平均延迟升序排列节点
+func Rank(stats map[string]*NodeStats) []*NodeStats {
+	ranked := make([]*NodeStats, 0, len(stats))
+	for _, stat := range stats {
+		ranked = append(ranked, stat)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Reliability != ranked[j].Reliability {
+			return ranked[i].Reliability > ranked[j].Reliability
+		}
+		if ranked[i].AvgLatencyMs != ranked[j].AvgLatencyMs {
+			return ranked[i].AvgLatencyMs < ranked[j].AvgLatencyMs
+		}
+		return ranked[i].Fingerprint < ranked[j].Fingerprint
+	})
+	return ranked
+}
+
+// AdjustScore 用历史可靠性修正本轮测试分数
+// 没有历史记录的节点按0.5的可靠性处理，长期稳定的节点分数接近原值，经常失败的节点被降权
+func AdjustScore(score float64, stat *NodeStats) float64 {
+	reliability := 0.5
+	if stat != nil {
+		reliability = stat.Reliability
+	}
+	return score * (0.5 + 0.5*reliability)
+}
@@ -0,0 +1,243 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/statedir"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/platform"
+	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
+)
+
+// DefaultFile 默认历史记录文件，位于状态目录下
+func DefaultFile() string {
+	return statedir.File("node_history.jsonl")
+}
+
+// DefaultRetention 默认历史记录保留时长
+const DefaultRetention = 30 * 24 * time.Hour
+
+// 测试来源
+const (
+	SourceMVPTester = "mvp-tester"
+	SourceAutoProxy = "auto-proxy"
+	SourceSpeedTest = "speed-test"
+)
+
+// Record 一次节点测试记录
+type Record struct {
+	Fingerprint string    `json:"fingerprint"`
+	Name        string    `json:"name"`
+	Protocol    string    `json:"protocol"`
+	Server      string    `json:"server"`
+	Port        string    `json:"port"`
+	Source      string    `json:"source"`
+	Success     bool      `json:"success"`
+	LatencyMs   int64     `json:"latency_ms,omitempty"`
+	SpeedMbps   float64   `json:"speed_mbps,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	TestTime    time.Time `json:"test_time"`
+}
+
+// NewRecord 根据节点创建测试记录
+func NewRecord(node *types.Node, source string) Record {
+	return Record{
+		Fingerprint: node.Fingerprint(),
+		Name:        node.Name,
+		Protocol:    node.Protocol,
+		Server:      node.Server,
+		Port:        node.Port,
+		Source:      source,
+		TestTime:    time.Now(),
+	}
+}
+
+// Store 基于追加写JSONL文件的节点测试历史存储
+type Store struct {
+	path      string
+	retention time.Duration
+	mutex     sync.Mutex
+}
+
+// NewStore 创建历史记录存储
+func NewStore(path string) *Store {
+	if path == "" {
+		path = DefaultFile()
+	}
+	return &Store{
+		path:      path,
+		retention: DefaultRetention,
+	}
+}
+
+// SetRetention 设置历史记录保留时长
+func (s *Store) SetRetention(retention time.Duration) {
+	s.retention = retention
+}
+
+// Path 获取历史记录文件路径
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Append 追加测试记录
+func (s *Store) Append(records ...Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if dir := filepath.Dir(s.path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("创建历史记录目录失败: %v", err)
+		}
+	}
+
+	unlock, err := s.lockFile()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("打开历史记录文件失败: %v", err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return fmt.Errorf("写入历史记录失败: %v", err)
+		}
+	}
+	return writer.Flush()
+}
+
+// lockFile 对历史文件旁的 .lock 文件加排他锁，多个进程（auto-proxy、mvp-tester、Web界面）共用同一历史文件时
+// 串行化追加和清理（调用方需持有 mutex）
+func (s *Store) lockFile() (func(), error) {
+	unlock, err := platform.LockFile(s.path + ".lock")
+	if err != nil {
+		return nil, fmt.Errorf("锁定历史记录文件失败: %v", err)
+	}
+	return unlock, nil
+}
+
+// Load 读取指定时间之后的测试记录（文件不存在时返回空列表，损坏的行会被跳过）
+func (s *Store) Load(since time.Time) ([]Record, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.load(since)
+}
+
+// load 读取测试记录（调用方需持有锁）
+func (s *Store) load(since time.Time) ([]Record, error) {
+	file, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("打开历史记录文件失败: %v", err)
+	}
+	defer file.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		if record.TestTime.Before(since) {
+			continue
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取历史记录失败: %v", err)
+	}
+	return records, nil
+}
+
+// Stats 统计指定时间之后每个节点的历史表现
+func (s *Store) Stats(since time.Time) (map[string]*NodeStats, error) {
+	records, err := s.Load(since)
+	if err != nil {
+		return nil, err
+	}
+	return Summarize(records, time.Now()), nil
+}
+
+// Prune 删除超过保留时长的记录，返回删除的记录数
+func (s *Store) Prune() (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, err := os.Stat(s.path); os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	// 读取-重写-替换期间持有文件锁，避免其他进程追加的记录被替换掉
+	unlock, err := s.lockFile()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	all, err := s.load(time.Time{})
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-s.retention)
+	kept := make([]Record, 0, len(all))
+	for _, record := range all {
+		if !record.TestTime.Before(cutoff) {
+			kept = append(kept, record)
+		}
+	}
+
+	removed := len(all) - len(kept)
+	if removed == 0 {
+		return 0, nil
+	}
+
+	// 先写临时文件再替换，避免中途退出导致历史丢失
+	tempPath := s.path + ".tmp"
+	file, err := os.Create(tempPath)
+	if err != nil {
+		return 0, fmt.Errorf("创建临时历史文件失败: %v", err)
+	}
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, record := range kept {
+		if err := encoder.Encode(record); err != nil {
+			file.Close()
+			os.Remove(tempPath)
+			return 0, fmt.Errorf("写入临时历史文件失败: %v", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		os.Remove(tempPath)
+		return 0, fmt.Errorf("写入临时历史文件失败: %v", err)
+	}
+	file.Close()
+
+	if err := os.Rename(tempPath, s.path); err != nil {
+		os.Remove(tempPath)
+		return 0, fmt.Errorf("替换历史文件失败: %v", err)
+	}
+	return removed, nil
+}
@@ -0,0 +1,128 @@
+package ipc
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
+)
+
+// 客户端超时设置
+const (
+	dialTimeout  = 2 * time.Second
+	writeTimeout = 5 * time.Second
+)
+
+// Client 控制通道客户端（由测试器持有）
+// 连接在首次发送时建立，断开后下次发送自动重连；代理服务器的确认通过 OnAck 回调异步通知
+type Client struct {
+	path  string
+	conn  net.Conn
+	seq   uint64
+	onAck func(*Message)
+	mutex sync.Mutex
+}
+
+// NewClient 创建控制通道客户端
+func NewClient(path string) *Client {
+	return &Client{path: path}
+}
+
+// Path 返回socket路径
+func (c *Client) Path() string {
+	return c.path
+}
+
+// SetAckHandler 设置确认消息回调
+func (c *Client) SetAckHandler(handler func(*Message)) {
+	c.mutex.Lock()
+	c.onAck = handler
+	c.mutex.Unlock()
+}
+
+// SendCandidates 推送排序后的候选节点列表，返回消息序号
+func (c *Client) SendCandidates(candidates []types.ValidNode) (uint64, error) {
+	return c.send(&Message{Type: TypeCandidates, Candidates: candidates})
+}
+
+// SendSwitch 要求代理服务器切换到指定节点，返回消息序号；force 为 true 时跳过切换策略
+func (c *Client) SendSwitch(node *types.ValidNode, force bool, reason string) (uint64, error) {
+	return c.send(&Message{Type: TypeSwitch, Node: node, Force: force, Reason: reason})
+}
+
+// Close 关闭连接
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// send 编码并发送一条消息
+func (c *Client) send(msg *Message) (uint64, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.conn == nil {
+		conn, err := net.DialTimeout("unix", c.path, dialTimeout)
+		if err != nil {
+			return 0, fmt.Errorf("连接控制通道失败: %v", err)
+		}
+		c.conn = conn
+		go c.readAcks(conn)
+	}
+
+	c.seq++
+	msg.Version = ProtocolVersion
+	msg.Seq = c.seq
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return 0, fmt.Errorf("编码消息失败: %v", err)
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := c.conn.Write(append(data, '\n')); err != nil {
+		c.conn.Close()
+		c.conn = nil
+		return 0, fmt.Errorf("发送消息失败: %v", err)
+	}
+	return msg.Seq, nil
+}
+
+// readAcks 读取代理服务器返回的确认消息
+func (c *Client) readAcks(conn net.Conn) {
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageSize)
+
+	for scanner.Scan() {
+		var msg Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil || msg.Type != TypeAck {
+			continue
+		}
+
+		c.mutex.Lock()
+		handler := c.onAck
+		c.mutex.Unlock()
+		if handler != nil {
+			handler(&msg)
+		}
+	}
+
+	// 连接断开，下次发送时重连
+	c.mutex.Lock()
+	if c.conn == conn {
+		c.conn.Close()
+		c.conn = nil
+	}
+	c.mutex.Unlock()
+}
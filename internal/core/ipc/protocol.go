@@ -0,0 +1,86 @@
+package ipc
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"strings"
+
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/rundir"
+	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
+)
+
+// ProtocolVersion 控制通道协议版本，收到不同版本的消息时拒绝处理
+const ProtocolVersion = 1
+
+// MessageType 消息类型
+type MessageType string
+
+const (
+	// TypeCandidates 测试器推送按分数排序的候选节点列表
+	TypeCandidates MessageType = "candidates"
+	// TypeSwitch 测试器要求代理服务器切换到指定节点
+	TypeSwitch MessageType = "switch"
+	// TypeAck 代理服务器对消息的处理结果
+	TypeAck MessageType = "ack"
+)
+
+// Message 控制通道消息，每条消息编码为一行JSON
+type Message struct {
+	Version    int               `json:"version"`
+	Type       MessageType       `json:"type"`
+	Seq        uint64            `json:"seq"`
+	Candidates []types.ValidNode `json:"candidates,omitempty"` // candidates: 排名从高到低
+	Node       *types.ValidNode  `json:"node,omitempty"`       // switch: 目标节点; ack: 当前节点
+	Force      bool              `json:"force,omitempty"`      // switch: 跳过代理服务器的切换策略（如手动固定、当前节点失效）
+	Reason     string            `json:"reason,omitempty"`     // switch: 切换原因，记录在切换决策中
+	AckSeq     uint64            `json:"ack_seq,omitempty"`    // ack: 被确认的消息序号
+	OK         bool              `json:"ok,omitempty"`         // ack: 是否处理成功
+	Error      string            `json:"error,omitempty"`      // ack: 失败原因
+}
+
+// NewAck 创建对指定消息的确认
+func NewAck(msg *Message, current *types.ValidNode, err error) *Message {
+	ack := &Message{
+		Version: ProtocolVersion,
+		Type:    TypeAck,
+		AckSeq:  msg.Seq,
+		Node:    current,
+		OK:      err == nil,
+	}
+	if err != nil {
+		ack.Error = err.Error()
+	}
+	return ack
+}
+
+// validate 检查消息版本和必要字段
+func (msg *Message) validate() error {
+	if msg.Version != ProtocolVersion {
+		return fmt.Errorf("不支持的协议版本: %d (当前版本: %d)", msg.Version, ProtocolVersion)
+	}
+	switch msg.Type {
+	case TypeCandidates:
+	case TypeSwitch:
+		if msg.Node == nil || msg.Node.Node == nil {
+			return fmt.Errorf("切换命令缺少目标节点")
+		}
+	case TypeAck:
+	default:
+		return fmt.Errorf("未知的消息类型: %s", msg.Type)
+	}
+	return nil
+}
+
+// SocketPathFor 根据状态快照文件推导控制通道的socket路径，测试器和代理服务器只需约定同一个状态文件
+// socket 放在运行目录，文件名取快照文件名加上其绝对路径的短哈希，
+// 例如 ~/.local/state/v2ray-manager/mvp_best_node.json -> <运行目录>/mvp_best_node-1a2b3c4d.sock
+func SocketPathFor(stateFile string) string {
+	if abs, err := filepath.Abs(stateFile); err == nil {
+		stateFile = abs
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(stateFile))
+	base := strings.TrimSuffix(filepath.Base(stateFile), filepath.Ext(stateFile))
+	return rundir.File(fmt.Sprintf("%s-%08x.sock", base, hash.Sum32()))
+}
@@ -0,0 +1,140 @@
+package ipc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxMessageSize 单条消息的最大长度（候选节点列表可能较大）
+const maxMessageSize = 8 * 1024 * 1024
+
+// Handler 处理一条消息并返回确认
+type Handler func(msg *Message) *Message
+
+// Server 控制通道服务端（由代理服务器持有）
+type Server struct {
+	path     string
+	handler  Handler
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	mutex    sync.Mutex
+}
+
+// NewServer 创建控制通道服务端
+func NewServer(path string, handler Handler) *Server {
+	return &Server{
+		path:    path,
+		handler: handler,
+		conns:   make(map[net.Conn]struct{}),
+	}
+}
+
+// Path 返回socket路径
+func (s *Server) Path() string {
+	return s.path
+}
+
+// Listen 开始监听socket，残留的socket文件会先被清理
+func (s *Server) Listen() error {
+	if conn, err := net.DialTimeout("unix", s.path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("控制通道已被其他进程占用: %s", s.path)
+	}
+	os.Remove(s.path)
+
+	listener, err := net.Listen("unix", s.path)
+	if err != nil {
+		return fmt.Errorf("监听控制通道失败: %v", err)
+	}
+
+	s.mutex.Lock()
+	s.listener = listener
+	s.mutex.Unlock()
+	return nil
+}
+
+// Serve 接受连接并处理消息，直到ctx取消或监听关闭
+func (s *Server) Serve(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		s.Close()
+	}()
+
+	s.mutex.Lock()
+	listener := s.listener
+	s.mutex.Unlock()
+	if listener == nil {
+		return
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mutex.Lock()
+		s.conns[conn] = struct{}{}
+		s.mutex.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn 按顺序处理单个连接上的消息
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageSize)
+	encoder := json.NewEncoder(conn)
+
+	for scanner.Scan() {
+		var msg Message
+		var reply *Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			reply = NewAck(&msg, nil, fmt.Errorf("解析消息失败: %v", err))
+		} else if err := msg.validate(); err != nil {
+			reply = NewAck(&msg, nil, err)
+		} else if msg.Type == TypeAck {
+			continue
+		} else {
+			reply = s.handler(&msg)
+		}
+
+		if reply == nil {
+			continue
+		}
+		if err := encoder.Encode(reply); err != nil {
+			return
+		}
+	}
+}
+
+// Close 关闭监听和所有连接，并删除socket文件
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+		s.listener = nil
+		os.Remove(s.path)
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	return err
+}
@@ -0,0 +1,321 @@
+//go:build !windows
+
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/yxhpy/v2ray-subscription-manager/internal/platform"
+)
+
+// 辅助进程模式：测试二进制以 -test.run=TestHelperProcess 重新启动自身，按模式模拟核心进程
+const (
+	helperExitOnTerm = "exit-on-term" // 收到终止信号后正常退出
+	helperIgnoreTerm = "ignore-term"  // 记录终止信号但不退出，只能被强制杀死
+)
+
+// TestHelperProcess 不是真正的测试：由 helperCommand 创建的子进程运行。
+// 就绪后向标记文件写入 ready，收到终止信号时追加 term
+func TestHelperProcess(t *testing.T) {
+	mode := os.Getenv("LIFECYCLE_HELPER_MODE")
+	if mode == "" {
+		return
+	}
+	marker := os.Getenv("LIFECYCLE_HELPER_MARKER")
+
+	terms := make(chan os.Signal, 1)
+	signal.Notify(terms, syscall.SIGTERM)
+	appendMarker(marker, "ready\n")
+
+	deadline := time.After(time.Minute)
+	for {
+		select {
+		case <-terms:
+			appendMarker(marker, "term\n")
+			if mode == helperExitOnTerm {
+				os.Exit(0)
+			}
+		case <-deadline:
+			os.Exit(2)
+		}
+	}
+}
+
+func appendMarker(path, text string) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		os.Exit(3)
+	}
+	file.WriteString(text)
+	file.Close()
+}
+
+// helperCommand 创建以指定模式运行的辅助进程命令，返回命令和标记文件路径
+func helperCommand(t *testing.T, mode string) (*exec.Cmd, string) {
+	t.Helper()
+	marker := filepath.Join(t.TempDir(), "marker")
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+	cmd.Env = append(os.Environ(), "LIFECYCLE_HELPER_MODE="+mode, "LIFECYCLE_HELPER_MARKER="+marker)
+	platform.SetProcAttributes(cmd)
+	return cmd, marker
+}
+
+// waitMarker 等待标记文件中出现 want
+func waitMarker(t *testing.T, marker, want string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if data, _ := os.ReadFile(marker); strings.Contains(string(data), want) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("等待辅助进程写入 %q 超时", strings.TrimSpace(want))
+}
+
+// requireStartTime 进程登记依赖启动时间识别PID复用，平台不提供时跳过
+func requireStartTime(t *testing.T) {
+	t.Helper()
+	if _, ok := platform.ProcessStartTime(os.Getpid()); !ok {
+		t.Skip("当前平台不提供进程启动时间")
+	}
+}
+
+// useRegistryDir 让全局进程登记表使用临时目录，测试结束后恢复
+func useRegistryDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	previous := defaultRegistry.Dir()
+	defaultRegistry.SetDir(dir)
+	t.Cleanup(func() { defaultRegistry.SetDir(previous) })
+	return dir
+}
+
+// killedBy 进程是否被指定信号终止
+func killedBy(err error, sig syscall.Signal) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	return ok && status.Signaled() && status.Signal() == sig
+}
+
+// recordExists 登记目录中是否有进程的登记文件
+func recordExists(dir string, pid int) bool {
+	_, err := os.Stat(filepath.Join(dir, strconv.Itoa(pid)+".json"))
+	return err == nil
+}
+
+func TestProcessStopGraceful(t *testing.T) {
+	requireStartTime(t)
+	dir := useRegistryDir(t)
+
+	cmd, marker := helperCommand(t, helperExitOnTerm)
+	process, err := StartProcess(cmd)
+	if err != nil {
+		t.Fatalf("启动辅助进程失败: %v", err)
+	}
+	waitMarker(t, marker, "ready\n")
+	if !recordExists(dir, process.Pid()) {
+		t.Fatalf("进程 %d 没有登记", process.Pid())
+	}
+
+	if err := process.StopTimeout(5 * time.Second); err != nil {
+		t.Fatalf("Stop 返回错误: %v", err)
+	}
+	if !process.Exited() {
+		t.Fatalf("Stop 返回后进程仍在运行")
+	}
+	if err := process.Err(); err != nil {
+		t.Errorf("收到终止信号后应正常退出，实际: %v", err)
+	}
+	waitMarker(t, marker, "term\n")
+	if recordExists(dir, process.Pid()) {
+		t.Errorf("进程退出后登记没有删除")
+	}
+}
+
+func TestProcessStopEscalates(t *testing.T) {
+	requireStartTime(t)
+	dir := useRegistryDir(t)
+
+	cmd, marker := helperCommand(t, helperIgnoreTerm)
+	process, err := StartProcess(cmd)
+	if err != nil {
+		t.Fatalf("启动辅助进程失败: %v", err)
+	}
+	waitMarker(t, marker, "ready\n")
+
+	const grace = 300 * time.Millisecond
+	start := time.Now()
+	if err := process.StopTimeout(grace); err == nil {
+		t.Fatalf("忽略终止信号的进程应在期限后被强制终止并返回错误")
+	}
+	if elapsed := time.Since(start); elapsed < grace {
+		t.Errorf("期限 %v 未到就强制终止（%v）", grace, elapsed)
+	}
+
+	// 先发终止信号，等待期限，再强制杀死
+	waitMarker(t, marker, "term\n")
+	if !killedBy(process.Err(), syscall.SIGKILL) {
+		t.Errorf("进程应被 SIGKILL 终止，实际: %v", process.Err())
+	}
+	if recordExists(dir, process.Pid()) {
+		t.Errorf("进程退出后登记没有删除")
+	}
+}
+
+// startRegistered 启动辅助进程并登记到 registry；由后台goroutine回收，避免僵尸进程被当作仍在运行
+func startRegistered(t *testing.T, registry *Registry, mode string) (*Record, string, <-chan error) {
+	t.Helper()
+	cmd, marker := helperCommand(t, mode)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("启动辅助进程失败: %v", err)
+	}
+	exited := make(chan error, 1)
+	reaped := make(chan struct{})
+	go func() {
+		exited <- cmd.Wait()
+		close(reaped)
+	}()
+	t.Cleanup(func() {
+		platform.KillGroup(cmd.Process.Pid)
+		<-reaped
+	})
+
+	waitMarker(t, marker, "ready\n")
+	record, err := registry.Register(cmd)
+	if err != nil {
+		t.Fatalf("登记进程失败: %v", err)
+	}
+	return record, marker, exited
+}
+
+func waitExit(t *testing.T, exited <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-exited:
+		return err
+	case <-time.After(10 * time.Second):
+		t.Fatalf("等待辅助进程退出超时")
+		return nil
+	}
+}
+
+func TestRegistryTerminate(t *testing.T) {
+	requireStartTime(t)
+	dir := t.TempDir()
+	registry := NewRegistry(dir)
+
+	graceful, gracefulMarker, gracefulExited := startRegistered(t, registry, helperExitOnTerm)
+	stubborn, stubbornMarker, stubbornExited := startRegistered(t, registry, helperIgnoreTerm)
+	if got := len(registry.Records()); got != 2 {
+		t.Fatalf("登记数量 %d，期望 2", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := registry.Terminate(ctx, *graceful, *stubborn); err == nil {
+		t.Errorf("有进程忽略终止信号时 Terminate 应返回超时错误")
+	}
+
+	if err := waitExit(t, gracefulExited); err != nil {
+		t.Errorf("收到终止信号后应正常退出，实际: %v", err)
+	}
+	waitMarker(t, gracefulMarker, "term\n")
+	waitMarker(t, stubbornMarker, "term\n")
+	if err := waitExit(t, stubbornExited); !killedBy(err, syscall.SIGKILL) {
+		t.Errorf("忽略终止信号的进程应被 SIGKILL 终止，实际: %v", err)
+	}
+	if records := registry.Records(); len(records) != 0 {
+		t.Errorf("Terminate 后仍有登记: %v", records)
+	}
+}
+
+func TestRegistryStalePID(t *testing.T) {
+	requireStartTime(t)
+	registry := NewRegistry(t.TempDir())
+	record, marker, exited := startRegistered(t, registry, helperExitOnTerm)
+
+	// PID 相同但启动时间不同（PID 已被复用），或登记时没有启动时间：都不是我们启动的进程
+	for _, stale := range []Record{
+		{PID: record.PID, StartTime: record.StartTime + 1, Binary: record.Binary, OwnerPID: os.Getpid()},
+		{PID: record.PID, Binary: record.Binary, OwnerPID: os.Getpid()},
+	} {
+		if stale.Alive() {
+			t.Errorf("启动时间 %d 与实际不符，不应视为同一进程", stale.StartTime)
+		}
+		if err := registry.write(&stale); err != nil {
+			t.Fatalf("写入登记失败: %v", err)
+		}
+		if err := registry.Terminate(context.Background(), stale); err != nil {
+			t.Errorf("Terminate 返回错误: %v", err)
+		}
+		if len(registry.Records()) != 0 {
+			t.Errorf("过期登记没有删除")
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if data, _ := os.ReadFile(marker); strings.Contains(string(data), "term") {
+		t.Fatalf("向PID复用的进程发送了终止信号")
+	}
+	select {
+	case err := <-exited:
+		t.Fatalf("PID复用的进程被终止: %v", err)
+	default:
+	}
+}
+
+func TestRegistryReapOrphans(t *testing.T) {
+	requireStartTime(t)
+	registry := NewRegistry(t.TempDir())
+
+	// 已退出的进程作为"崩溃的管理程序"
+	owner := exec.Command(os.Args[0], "-test.run=^$")
+	if err := owner.Run(); err != nil {
+		t.Fatalf("运行已退出的启动者失败: %v", err)
+	}
+	deadOwner := owner.Process.Pid
+
+	orphan, orphanMarker, orphanExited := startRegistered(t, registry, helperExitOnTerm)
+	detached, _, detachedExited := startRegistered(t, registry, helperExitOnTerm)
+	for _, record := range []*Record{orphan, detached} {
+		record.OwnerPID, record.OwnerStart = deadOwner, 0
+	}
+	detached.Detached = true
+	for _, record := range []*Record{orphan, detached} {
+		if err := registry.write(record); err != nil {
+			t.Fatalf("写入登记失败: %v", err)
+		}
+	}
+
+	if reaped := registry.ReapOrphans(context.Background()); reaped != 1 {
+		t.Errorf("回收数量 %d，期望 1", reaped)
+	}
+	if err := waitExit(t, orphanExited); err != nil {
+		t.Errorf("孤儿进程应收到终止信号后正常退出，实际: %v", err)
+	}
+	waitMarker(t, orphanMarker, "term\n")
+
+	select {
+	case err := <-detachedExited:
+		t.Errorf("后台进程不应被回收: %v", err)
+	default:
+	}
+	records := registry.Records()
+	if len(records) != 1 || records[0].PID != detached.PID {
+		t.Errorf("回收后应只保留后台进程的登记，实际: %v", records)
+	}
+}
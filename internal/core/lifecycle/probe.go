@@ -0,0 +1,91 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// 就绪探测的轮询间隔：从很短的间隔开始，逐步退避到上限
+const (
+	probeInitialInterval = 10 * time.Millisecond
+	probeMaxInterval     = 200 * time.Millisecond
+	probeDialTimeout     = 500 * time.Millisecond
+)
+
+// PortAddr 返回本地端口地址
+func PortAddr(port int) string {
+	return fmt.Sprintf("127.0.0.1:%d", port)
+}
+
+// Dialable 地址当前是否可以建立TCP连接
+func Dialable(addr string) bool {
+	conn, err := net.DialTimeout("tcp", addr, probeDialTimeout)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// WaitReady 主动探测，直到所有地址都可以建立TCP连接；exited 关闭时（进程已退出）立即失败
+// exited 可以为nil
+func WaitReady(ctx context.Context, exited <-chan struct{}, addrs ...string) error {
+	return poll(ctx, exited, func() bool {
+		for _, addr := range addrs {
+			if !Dialable(addr) {
+				return false
+			}
+		}
+		return true
+	}, "等待端口就绪")
+}
+
+// WaitPortsReady 等待本地端口全部开始监听
+func WaitPortsReady(ctx context.Context, exited <-chan struct{}, ports ...int) error {
+	addrs := make([]string, 0, len(ports))
+	for _, port := range ports {
+		if port > 0 {
+			addrs = append(addrs, PortAddr(port))
+		}
+	}
+	return WaitReady(ctx, exited, addrs...)
+}
+
+// WaitPortsFree 等待本地端口全部停止监听
+func WaitPortsFree(ctx context.Context, ports ...int) error {
+	return poll(ctx, nil, func() bool {
+		for _, port := range ports {
+			if port > 0 && Dialable(PortAddr(port)) {
+				return false
+			}
+		}
+		return true
+	}, "等待端口释放")
+}
+
+// poll 按退避间隔检查条件，直到满足、ctx结束或 exited 关闭
+func poll(ctx context.Context, exited <-chan struct{}, done func() bool, what string) error {
+	interval := probeInitialInterval
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s超时: %v", what, ctx.Err())
+		case <-exited:
+			return fmt.Errorf("%s失败: 进程已退出", what)
+		case <-timer.C:
+		}
+
+		if done() {
+			return nil
+		}
+		timer.Reset(interval)
+		if interval *= 2; interval > probeMaxInterval {
+			interval = probeMaxInterval
+		}
+	}
+}
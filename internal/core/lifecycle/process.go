@@ -0,0 +1,121 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/yxhpy/v2ray-subscription-manager/internal/platform"
+)
+
+// DefaultStopTimeout 进程收到终止信号后等待退出的默认时长，超时后强制杀死
+const DefaultStopTimeout = 5 * time.Second
+
+// Process 已启动的子进程；由后台goroutine统一调用 Wait，退出状态通过 Done 通知
+type Process struct {
+	cmd  *exec.Cmd
+	done chan struct{}
+	err  error
+}
+
+// StartProcess 启动进程并开始等待其退出
+func StartProcess(cmd *exec.Cmd) (*Process, error) {
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return Watch(cmd), nil
+}
+
+// Watch 接管一个已经启动的进程，调用方之后不能再对 cmd 调用 Wait
+// 进程会登记到全局进程登记表，退出后自动注销
+func Watch(cmd *exec.Cmd) *Process {
+	p := &Process{cmd: cmd, done: make(chan struct{})}
+	if _, err := defaultRegistry.Register(cmd); err != nil {
+		fmt.Printf("⚠️ 登记进程 %d 失败: %v\n", cmd.Process.Pid, err)
+	}
+	go func() {
+		p.err = cmd.Wait()
+		defaultRegistry.Unregister(cmd.Process.Pid)
+		close(p.done)
+	}()
+	return p
+}
+
+// Cmd 返回底层命令
+func (p *Process) Cmd() *exec.Cmd {
+	return p.cmd
+}
+
+// Pid 返回进程ID
+func (p *Process) Pid() int {
+	if p.cmd.Process == nil {
+		return 0
+	}
+	return p.cmd.Process.Pid
+}
+
+// Done 进程退出时关闭
+func (p *Process) Done() <-chan struct{} {
+	return p.done
+}
+
+// Exited 进程是否已退出
+func (p *Process) Exited() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Err 返回进程的退出错误，仅在 Done 关闭后有意义
+func (p *Process) Err() error {
+	<-p.done
+	return p.err
+}
+
+// Detach 标记进程在管理程序退出后继续运行，孤儿回收时不会终止它
+func (p *Process) Detach() error {
+	return defaultRegistry.Detach(p.Pid())
+}
+
+// Stop 向进程组发送终止信号并等待进程退出；ctx 结束时仍未退出则强制杀死
+// 不支持终止信号的平台（Windows）直接强制杀死
+func (p *Process) Stop(ctx context.Context) error {
+	if p.Exited() {
+		return nil
+	}
+
+	if err := platform.TerminateGroup(p.Pid()); err != nil {
+		if err := p.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			p.cmd.Process.Kill()
+		}
+	}
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	p.kill()
+	<-p.done
+	return fmt.Errorf("进程 %d 未在期限内退出，已强制终止", p.Pid())
+}
+
+// StopTimeout 使用给定的期限停止进程
+func (p *Process) StopTimeout(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return p.Stop(ctx)
+}
+
+// kill 强制杀死进程组，进程不是组长时只杀死进程本身
+func (p *Process) kill() {
+	if err := platform.KillGroup(p.Pid()); err != nil {
+		p.cmd.Process.Kill()
+	}
+}
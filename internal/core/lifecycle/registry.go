@@ -0,0 +1,309 @@
+package lifecycle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/rundir"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/platform"
+)
+
+// reapGracePeriod 孤儿进程收到终止信号后等待退出的时长，超时后强制杀死
+const reapGracePeriod = 3 * time.Second
+
+// Record 由本程序启动的核心进程的登记信息
+// 启动时间用于识别PID复用：PID相同但启动时间不同的进程不是我们启动的，绝不触碰
+type Record struct {
+	PID        int       `json:"pid"`
+	StartTime  uint64    `json:"start_time,omitempty"`
+	Binary     string    `json:"binary"`
+	Args       []string  `json:"args,omitempty"`
+	OwnerPID   int       `json:"owner_pid"`
+	OwnerStart uint64    `json:"owner_start,omitempty"`
+	LaunchedAt time.Time `json:"launched_at"`
+	Detached   bool      `json:"detached,omitempty"` // 有意在管理程序退出后继续运行（如命令行 start-proxy），不作为孤儿回收
+}
+
+// Alive 登记的进程是否仍然是我们启动的那个进程
+func (r Record) Alive() bool {
+	return sameProcess(r.PID, r.StartTime)
+}
+
+// OwnerAlive 启动该进程的管理程序是否仍在运行
+// 管理程序不一定是进程组组长，平台不提供启动时间时只检查PID是否存在（宁可漏收也不误杀）
+func (r Record) OwnerAlive() bool {
+	if !platform.ProcessAlive(r.OwnerPID) {
+		return false
+	}
+	if current, ok := platform.ProcessStartTime(r.OwnerPID); ok && r.OwnerStart != 0 {
+		return current == r.OwnerStart
+	}
+	return true
+}
+
+// Name 返回核心程序名（不含目录和 .exe 扩展名）
+func (r Record) Name() string {
+	return strings.TrimSuffix(filepath.Base(r.Binary), ".exe")
+}
+
+// String 返回便于阅读的描述
+func (r Record) String() string {
+	return fmt.Sprintf("%s (PID: %d, 启动于 %s)", r.Name(), r.PID, r.LaunchedAt.Format("2006-01-02 15:04:05"))
+}
+
+// Registry 进程登记表，每个核心进程对应目录下的一个 <pid>.json 文件
+// 清理时只处理登记表中的进程，不再按进程名或端口批量查杀
+type Registry struct {
+	mutex sync.Mutex
+	dir   string
+}
+
+// NewRegistry 创建使用指定目录的进程登记表，dir 为空时使用运行目录下的 procs（随 --runtime-dir 变化）
+func NewRegistry(dir string) *Registry {
+	return &Registry{dir: dir}
+}
+
+var defaultRegistry = NewRegistry("")
+
+// DefaultRegistry 返回全局进程登记表，Watch 启动的所有进程都登记在这里
+func DefaultRegistry() *Registry {
+	return defaultRegistry
+}
+
+// Dir 返回登记目录
+func (r *Registry) Dir() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.dirLocked()
+}
+
+// SetDir 修改登记目录
+func (r *Registry) SetDir(dir string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.dir = dir
+}
+
+// Register 登记一个已启动的进程
+func (r *Registry) Register(cmd *exec.Cmd) (*Record, error) {
+	if cmd.Process == nil {
+		return nil, fmt.Errorf("进程尚未启动")
+	}
+
+	record := &Record{
+		PID:        cmd.Process.Pid,
+		Binary:     cmd.Path,
+		OwnerPID:   os.Getpid(),
+		LaunchedAt: time.Now(),
+	}
+	if len(cmd.Args) > 1 {
+		record.Args = cmd.Args[1:]
+	}
+	record.StartTime, _ = platform.ProcessStartTime(record.PID)
+	record.OwnerStart, _ = platform.ProcessStartTime(record.OwnerPID)
+
+	if err := r.write(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Detach 标记进程在管理程序退出后继续运行，之后只能通过 Detached 找到并显式停止
+func (r *Registry) Detach(pid int) error {
+	for _, record := range r.Records() {
+		if record.PID == pid {
+			record.Detached = true
+			return r.write(&record)
+		}
+	}
+	return fmt.Errorf("进程 %d 未登记", pid)
+}
+
+// Unregister 删除进程登记
+func (r *Registry) Unregister(pid int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	os.Remove(r.path(pid))
+}
+
+// Records 返回所有登记的进程；无法解析的登记文件会被删除
+func (r *Registry) Records() []Record {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	dir := r.dirLocked()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var records []Record
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		file := filepath.Join(dir, entry.Name())
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(data, &record); err != nil || record.PID <= 0 {
+			os.Remove(file)
+			continue
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// Owned 返回当前程序启动且仍在运行的进程
+func (r *Registry) Owned() []Record {
+	var owned []Record
+	for _, record := range r.Records() {
+		if record.OwnerPID == os.Getpid() && record.Alive() {
+			owned = append(owned, record)
+		}
+	}
+	return owned
+}
+
+// Detached 返回仍在运行的、指定核心程序的后台进程
+func (r *Registry) Detached(name string) []Record {
+	var detached []Record
+	for _, record := range r.Records() {
+		if record.Detached && record.Name() == name && record.Alive() {
+			detached = append(detached, record)
+		}
+	}
+	return detached
+}
+
+// Terminate 向登记的进程组发送终止信号并等待退出，ctx 结束时强制杀死；完成后删除登记
+// 已退出或PID已被复用的进程只删除登记，不发送信号
+func (r *Registry) Terminate(ctx context.Context, records ...Record) error {
+	for _, record := range records {
+		if record.Alive() {
+			platform.TerminateGroup(record.PID)
+		}
+	}
+
+	err := poll(ctx, nil, func() bool {
+		for _, record := range records {
+			if record.Alive() {
+				return false
+			}
+		}
+		return true
+	}, "等待进程退出")
+
+	for _, record := range records {
+		if record.Alive() {
+			platform.KillGroup(record.PID)
+		}
+		r.Unregister(record.PID)
+	}
+	return err
+}
+
+// KillOwned 强制终止当前程序启动且仍在运行的所有进程（连同其进程组），返回终止的数量
+// 用于正常关闭流程超时后的兜底清理
+func (r *Registry) KillOwned() int {
+	killed := 0
+	for _, record := range r.Owned() {
+		if err := platform.KillGroup(record.PID); err != nil {
+			fmt.Printf("    ⚠️ 终止进程 %s 失败: %v\n", record, err)
+			continue
+		}
+		fmt.Printf("    🔪 已终止进程 %s\n", record)
+		r.Unregister(record.PID)
+		killed++
+	}
+	return killed
+}
+
+// ReapOrphans 回收管理程序崩溃后遗留的孤儿进程：启动者已不在运行的登记进程会被整组终止
+// 已退出的进程只删除登记，有意转入后台的进程保留；返回回收的进程数量
+func (r *Registry) ReapOrphans(ctx context.Context) int {
+	var orphans []Record
+	for _, record := range r.Records() {
+		if record.OwnerAlive() {
+			continue
+		}
+		if !record.Alive() {
+			r.Unregister(record.PID)
+			continue
+		}
+		if record.Detached {
+			continue
+		}
+		orphans = append(orphans, record)
+	}
+	if len(orphans) == 0 {
+		return 0
+	}
+
+	for _, record := range orphans {
+		fmt.Printf("🧹 回收孤儿进程 %s\n", record)
+	}
+	graceCtx, cancel := context.WithTimeout(ctx, reapGracePeriod)
+	defer cancel()
+	r.Terminate(graceCtx, orphans...)
+	return len(orphans)
+}
+
+// write 写入登记文件
+func (r *Registry) write(record *Record) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.dir == "" {
+		// 默认登记目录在运行目录下，写入前确认运行目录安全
+		if _, err := rundir.Path("procs"); err != nil {
+			return fmt.Errorf("进程登记目录不可用: %v", err)
+		}
+	}
+	if err := os.MkdirAll(r.dirLocked(), 0700); err != nil {
+		return fmt.Errorf("创建进程登记目录失败: %v", err)
+	}
+	if err := os.WriteFile(r.path(record.PID), data, 0600); err != nil {
+		return fmt.Errorf("写入进程登记失败: %v", err)
+	}
+	return nil
+}
+
+// path 返回进程登记文件路径，调用方需持有锁
+func (r *Registry) path(pid int) string {
+	return filepath.Join(r.dirLocked(), strconv.Itoa(pid)+".json")
+}
+
+// dirLocked 返回登记目录（不创建），调用方需持有锁
+func (r *Registry) dirLocked() string {
+	if r.dir == "" {
+		return rundir.File("procs")
+	}
+	return r.dir
+}
+
+// sameProcess PID对应的进程是否存在且就是登记时的那个进程
+// 只有登记时和现在都能读到启动时间且二者一致才算同一进程；平台不提供启动时间时无法排除PID复用，
+// 一律视为已退出：调用方只删除登记，不会向可能属于其他程序的进程发送信号
+func sameProcess(pid int, startTime uint64) bool {
+	if startTime == 0 || !platform.ProcessAlive(pid) {
+		return false
+	}
+	current, ok := platform.ProcessStartTime(pid)
+	return ok && current == startTime
+}
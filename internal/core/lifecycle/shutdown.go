@@ -0,0 +1,61 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Step 关闭流程中的一个组件
+type Step struct {
+	Name    string
+	Timeout time.Duration // 该组件的关闭期限，0表示只受整体期限限制
+	Stop    func(ctx context.Context) error
+}
+
+// Shutdown 按给定顺序（依赖方在前，被依赖方在后）依次关闭组件
+// 每个组件在自己的期限内关闭，某个组件失败或超时不影响后续组件；返回所有失败组件的汇总错误
+func Shutdown(ctx context.Context, steps ...Step) error {
+	var failures []string
+	for _, step := range steps {
+		if step.Stop == nil {
+			continue
+		}
+
+		stepCtx, cancel := ctx, context.CancelFunc(func() {})
+		if step.Timeout > 0 {
+			stepCtx, cancel = context.WithTimeout(ctx, step.Timeout)
+		}
+		start := time.Now()
+		err := runStep(stepCtx, step)
+		cancel()
+
+		if err != nil {
+			fmt.Printf("    ⚠️ %s 关闭异常 (%v): %v\n", step.Name, time.Since(start).Round(time.Millisecond), err)
+			failures = append(failures, fmt.Sprintf("%s: %v", step.Name, err))
+			continue
+		}
+		fmt.Printf("    ✅ %s 已关闭 (%v)\n", step.Name, time.Since(start).Round(time.Millisecond))
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("部分组件关闭失败: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// runStep 运行关闭函数；函数未在期限内返回时放弃等待并返回超时错误
+func runStep(ctx context.Context, step Step) error {
+	result := make(chan error, 1)
+	go func() {
+		result <- step.Stop(ctx)
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("关闭超时: %v", ctx.Err())
+	}
+}
@@ -0,0 +1,108 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Status 长期运行组件的就绪和退出通知
+// 组件在 Run 开始时调用 Begin，完成启动后调用 MarkReady，返回前调用 End
+type Status struct {
+	mutex   sync.Mutex
+	ready   chan struct{}
+	done    chan struct{}
+	started bool
+}
+
+// NewStatus 创建组件状态
+func NewStatus() *Status {
+	return &Status{
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+// Begin 标记新一轮运行开始；上一轮已结束时重新创建通知通道
+func (s *Status) Begin() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.started && isClosed(s.done) {
+		s.ready = make(chan struct{})
+		s.done = make(chan struct{})
+	}
+	s.started = true
+}
+
+// MarkReady 标记组件已就绪
+func (s *Status) MarkReady() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !isClosed(s.ready) {
+		close(s.ready)
+	}
+}
+
+// End 标记本轮运行结束
+func (s *Status) End() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !isClosed(s.done) {
+		close(s.done)
+	}
+}
+
+// Ready 组件就绪时关闭
+func (s *Status) Ready() <-chan struct{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.ready
+}
+
+// Done 组件本轮运行结束时关闭
+func (s *Status) Done() <-chan struct{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.done
+}
+
+// WaitReady 等待组件就绪；组件在就绪前退出或 ctx 结束时返回错误
+func (s *Status) WaitReady(ctx context.Context, name string) error {
+	ready, done := s.Ready(), s.Done()
+	select {
+	case <-ready:
+		return nil
+	case <-done:
+		return fmt.Errorf("%s 在就绪前退出", name)
+	case <-ctx.Done():
+		return fmt.Errorf("等待 %s 就绪超时: %v", name, ctx.Err())
+	}
+}
+
+// WaitStopped 等待组件本轮运行结束；从未启动的组件立即返回
+func (s *Status) WaitStopped(ctx context.Context) error {
+	s.mutex.Lock()
+	started, done := s.started, s.done
+	s.mutex.Unlock()
+	if !started {
+		return nil
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("等待退出超时: %v", ctx.Err())
+	}
+}
+
+// isClosed 通道是否已关闭
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
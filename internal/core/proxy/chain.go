@@ -0,0 +1,185 @@
+package proxy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/downloader"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/parser"
+	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
+)
+
+// 代理链中前置节点出站的标签
+const (
+	frontTag          = "front"
+	frontShadowTLSTag = "front-shadowtls"
+)
+
+var (
+	chainMutex sync.RWMutex
+	chain      *Chain
+)
+
+// Chain 代理链：匹配的出口节点不直接连接服务器，而是经由固定的前置节点（中转）连接，
+// 用于只接受特定中转IP连接的出口节点
+type Chain struct {
+	Front    *types.Node
+	Patterns []string // 匹配出口节点名称或服务器地址的正则，为空时所有节点都经由前置节点
+
+	patterns []*regexp.Regexp
+}
+
+// NewChain 由前置节点的分享链接和出口节点规则创建代理链，链接为空时返回 nil
+func NewChain(frontLink string, patterns []string) (*Chain, error) {
+	frontLink = strings.TrimSpace(frontLink)
+	if frontLink == "" {
+		return nil, nil
+	}
+	// 前置节点链接可以省略名称
+	if !strings.HasPrefix(frontLink, "vmess://") && !strings.Contains(frontLink, "#") {
+		frontLink += "#前置节点"
+	}
+	front, err := parser.ParseLink(frontLink)
+	if err != nil {
+		return nil, fmt.Errorf("无效的前置节点链接: %v", err)
+	}
+
+	c := &Chain{Front: front}
+	for _, pattern := range patterns {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("无效的出口节点规则 %q: %v", pattern, err)
+		}
+		c.Patterns = append(c.Patterns, pattern)
+		c.patterns = append(c.patterns, re)
+	}
+	return c, nil
+}
+
+// Matches 节点是否经由前置节点连接，前置节点本身不经由自己
+func (c *Chain) Matches(node *types.Node) bool {
+	if node.Fingerprint() == c.Front.Fingerprint() {
+		return false
+	}
+	if len(c.patterns) == 0 {
+		return true
+	}
+	for _, re := range c.patterns {
+		if re.MatchString(node.Name) || re.MatchString(node.Server) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetChain 设置代理链（来自档案的 front_node / chain_nodes 或Web UI设置），nil 表示不使用代理链
+func SetChain(c *Chain) {
+	chainMutex.Lock()
+	defer chainMutex.Unlock()
+	chain = c
+}
+
+// ActiveChain 返回当前的代理链，未设置时返回 nil
+func ActiveChain() *Chain {
+	chainMutex.RLock()
+	defer chainMutex.RUnlock()
+	return chain
+}
+
+// FrontNode 返回节点经由的前置节点，节点直接连接时返回 nil
+func FrontNode(node *types.Node) *types.Node {
+	c := ActiveChain()
+	if c == nil || !c.Matches(node) {
+		return nil
+	}
+	return c.Front
+}
+
+// selectChainCore 为代理链选择核心：前置节点和出口节点必须在同一个核心中运行。
+// Hysteria2 客户端不能经由其他节点连接，需要时改用 sing-box
+func selectChainCore(exit, front *types.Node) (string, string) {
+	exitCore, exitReason := selectNodeCore(exit)
+	frontCore, frontReason := selectNodeCore(front)
+
+	reason := "代理链"
+	switch {
+	case exitCore == frontCore && exitCore != downloader.CoreHysteria2:
+		if exitReason != "" {
+			reason += ", " + exitReason
+		}
+		return exitCore, reason
+	case exitCore == downloader.CoreSingBox || frontCore == downloader.CoreSingBox ||
+		exitCore == downloader.CoreHysteria2 || frontCore == downloader.CoreHysteria2:
+		return downloader.CoreSingBox, reason
+	case exitCore == downloader.CoreXray:
+		return downloader.CoreXray, reason + ", " + exitReason
+	case frontCore == downloader.CoreXray:
+		return downloader.CoreXray, reason + ", 前置节点" + frontReason
+	}
+	return exitCore, reason
+}
+
+// unsupportedChain 代理链无法在选定的核心中运行时返回原因
+func unsupportedChain(exit, front *types.Node) string {
+	if reason := UnsupportedReason(front); reason != "" {
+		return "前置节点" + reason
+	}
+
+	core, _ := SelectCore(exit)
+	for _, hop := range []*types.Node{front, exit} {
+		switch core {
+		case downloader.CoreSingBox:
+			if _, err := singBoxTransport(hop); err != nil {
+				return fmt.Sprintf("代理链需要 sing-box 运行，但节点 %s: %v", hop.Name, err)
+			}
+		case downloader.CoreXray:
+			if usesTransport(hop) && transportType(hop) == "quic" {
+				return fmt.Sprintf("代理链需要 Xray 运行，但 Xray 不支持节点 %s 的 QUIC 传输", hop.Name)
+			}
+		}
+	}
+	return ""
+}
+
+// chainV2RayOutbound 让V2Ray/Xray出站经由前置节点出站连接。
+// V2Ray 使用 proxySettings（transportLayer 保留出口节点自身的传输设置），Xray 使用 sockopt.dialerProxy
+func chainV2RayOutbound(core string, outbound map[string]interface{}) {
+	if core != downloader.CoreXray {
+		outbound["proxySettings"] = map[string]interface{}{
+			"tag":            frontTag,
+			"transportLayer": true,
+		}
+		return
+	}
+
+	streamSettings, ok := outbound["streamSettings"].(map[string]interface{})
+	if !ok {
+		streamSettings = map[string]interface{}{}
+		outbound["streamSettings"] = streamSettings
+	}
+	sockopt, ok := streamSettings["sockopt"].(map[string]interface{})
+	if !ok {
+		sockopt = map[string]interface{}{}
+		streamSettings["sockopt"] = sockopt
+	}
+	sockopt["dialerProxy"] = frontTag
+}
+
+// v2rayFrontOutbound 生成前置节点的V2Ray/Xray出站
+func v2rayFrontOutbound(core string, front *types.Node) (map[string]interface{}, error) {
+	config, err := generateV2RayConfig(front, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("生成前置节点配置失败: %v", err)
+	}
+	outbound := config["outbounds"].([]map[string]interface{})[0]
+	outbound["tag"] = frontTag
+	if core == downloader.CoreXray {
+		applyXraySettings(outbound, front)
+	}
+	return outbound, nil
+}
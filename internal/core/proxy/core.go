@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/downloader"
+	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
+)
+
+var (
+	coreMutex sync.RWMutex
+
+	// preferredCore 节点没有特殊要求时使用的核心
+	preferredCore = downloader.CoreV2Ray
+)
+
+// SetPreferredCore 设置首选核心（v2ray、xray 或 sing-box），空字符串恢复默认的 v2ray。
+// sing-box 可以运行所有协议，选择它即可只用一种核心；其他情况下需要 REALITY、XTLS Vision、
+// xhttp 等特性的节点使用Xray，Hysteria2 节点使用独立的Hysteria2客户端
+func SetPreferredCore(core string) error {
+	if core == "" {
+		core = downloader.CoreV2Ray
+	}
+	switch core {
+	case downloader.CoreV2Ray, downloader.CoreXray, downloader.CoreSingBox:
+	default:
+		return fmt.Errorf("无效的首选核心 %q（可用: v2ray, xray, sing-box）", core)
+	}
+
+	coreMutex.Lock()
+	defer coreMutex.Unlock()
+	preferredCore = core
+	return nil
+}
+
+// PreferredCore 返回首选核心
+func PreferredCore() string {
+	coreMutex.RLock()
+	defer coreMutex.RUnlock()
+	return preferredCore
+}
+
+// SelectCore 为节点选择核心，返回核心名和选择原因（使用默认核心时原因为空）。
+// 经由前置节点的节点选择能同时运行两个节点的核心
+func SelectCore(node *types.Node) (string, string) {
+	if front := FrontNode(node); front != nil {
+		return selectChainCore(node, front)
+	}
+	return selectNodeCore(node)
+}
+
+// selectNodeCore 按单个节点的需求选择核心
+func selectNodeCore(node *types.Node) (string, string) {
+	if node.Protocol == "tuic" {
+		return downloader.CoreSingBox, "TUIC协议"
+	}
+	if feature := ssPluginSingBoxFeature(node); feature != "" {
+		return downloader.CoreSingBox, "需要 " + feature
+	}
+
+	preferred := PreferredCore()
+	if preferred == downloader.CoreSingBox {
+		if _, err := singBoxTransport(node); err == nil {
+			return downloader.CoreSingBox, "首选核心"
+		}
+		// sing-box 不支持的传输（如 xhttp、mKCP）按默认规则选择
+	}
+
+	if node.Protocol == "hysteria2" {
+		return downloader.CoreHysteria2, "Hysteria2协议"
+	}
+	if features := XrayFeatures(node); len(features) > 0 {
+		return downloader.CoreXray, "需要 " + strings.Join(features, ", ")
+	}
+	if transportType(node) == "quic" && usesTransport(node) {
+		// Xray 已移除 QUIC 传输
+		return downloader.CoreV2Ray, "QUIC传输"
+	}
+	if preferred == downloader.CoreXray {
+		return preferred, "首选核心"
+	}
+	return downloader.CoreV2Ray, ""
+}
+
+// SupportedProtocol 是否有核心可以运行该协议的节点
+func SupportedProtocol(protocol string) bool {
+	switch protocol {
+	case "vmess", "vless", "trojan", "ss", "hysteria2", "tuic":
+		return true
+	}
+	return false
+}
+
+// UsesHysteria2Client 节点是否由独立的Hysteria2客户端运行（Hysteria2ProxyManager），
+// 否则由 ProxyManager 运行（V2Ray、Xray 或 sing-box）
+func UsesHysteria2Client(node *types.Node) bool {
+	core, _ := SelectCore(node)
+	return core == downloader.CoreHysteria2
+}
+
+// generateCoreConfig 生成指定核心的配置。Xray兼容V2Ray的JSON配置格式，
+// 在V2Ray配置的基础上补充 flow 和 REALITY 等Xray专有字段；节点经由前置节点时加入前置节点出站
+func generateCoreConfig(core string, node *types.Node, httpPort, socksPort int) (map[string]interface{}, error) {
+	if core == downloader.CoreSingBox {
+		return generateSingBoxConfig(node, httpPort, socksPort)
+	}
+
+	config, err := generateV2RayConfig(node, httpPort, socksPort)
+	if err != nil {
+		return nil, err
+	}
+
+	outbounds := config["outbounds"].([]map[string]interface{})
+	if core == downloader.CoreXray {
+		applyXraySettings(outbounds[0], node)
+	}
+
+	// 代理链：出口节点经由前置节点出站连接
+	if front := FrontNode(node); front != nil {
+		frontOutbound, err := v2rayFrontOutbound(core, front)
+		if err != nil {
+			return nil, err
+		}
+		chainV2RayOutbound(core, outbounds[0])
+		config["outbounds"] = append([]map[string]interface{}{outbounds[0], frontOutbound}, outbounds[1:]...)
+	}
+	return config, nil
+}
+
+// usesRoutingAssets 生成的配置是否引用了 geoip.dat / geosite.dat 中的分类
+func usesRoutingAssets(configJSON []byte) bool {
+	return strings.Contains(string(configJSON), `"geoip:`) || strings.Contains(string(configJSON), `"geosite:`)
+}
+
+// ensureCoreInstalled 检查节点所需的核心是否安装，Xray和sing-box未安装时自动下载
+func (pm *ProxyManager) ensureCoreInstalled(core string) error {
+	var err error
+	switch core {
+	case downloader.CoreXray:
+		d := downloader.NewXrayDownloader()
+		if d.CheckXrayInstalled() {
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "🔽 节点需要Xray，正在自动下载...\n")
+		err = d.SafeDownloadXray()
+	case downloader.CoreSingBox:
+		d := downloader.NewSingBoxDownloader()
+		if d.CheckSingBoxInstalled() {
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "🔽 节点需要sing-box，正在自动下载...\n")
+		err = d.SafeDownloadSingBox()
+	default:
+		if !pm.checkV2RayInstalled() {
+			return fmt.Errorf("V2Ray未安装，请先运行: %s download-v2ray", os.Args[0])
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("自动下载%s失败: %v", core, err)
+	}
+	return nil
+}
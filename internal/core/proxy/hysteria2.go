@@ -1,14 +1,17 @@
 package proxy
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"os"
 	"os/exec"
-	"syscall"
+	"path/filepath"
 	"time"
 
 	"github.com/yxhpy/v2ray-subscription-manager/internal/core/downloader"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/lifecycle"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/rundir"
 	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
 )
 
@@ -19,13 +22,15 @@ type Hysteria2ProxyManager struct {
 	Hysteria2Process *exec.Cmd
 	HTTPPort         int
 	SOCKSPort        int
+
+	process *lifecycle.Process // 统一等待进程退出，用于就绪探测和确定性的停止
 }
 
 // NewHysteria2ProxyManager 创建新的Hysteria2代理管理器
 func NewHysteria2ProxyManager() *Hysteria2ProxyManager {
 	downloader := downloader.NewHysteria2Downloader()
-	// 为每个实例生成唯一的配置文件路径
-	downloader.ConfigPath = fmt.Sprintf("./hysteria2/config_%d.yaml", time.Now().UnixNano())
+	// 配置文件路径在启动时于本进程的会话目录中生成
+	downloader.ConfigPath = ""
 
 	return &Hysteria2ProxyManager{
 		downloader: downloader,
@@ -37,8 +42,7 @@ func NewHysteria2ProxyManager() *Hysteria2ProxyManager {
 // NewTestHysteria2ProxyManager 创建用于测试的Hysteria2代理管理器
 func NewTestHysteria2ProxyManager() *Hysteria2ProxyManager {
 	downloader := downloader.NewHysteria2Downloader()
-	// 为测试实例生成带测试前缀的唯一配置文件路径
-	downloader.ConfigPath = fmt.Sprintf("./hysteria2/test_config_%d_%d.yaml", time.Now().UnixNano(), os.Getpid())
+	downloader.ConfigPath = ""
 
 	return &Hysteria2ProxyManager{
 		downloader: downloader,
@@ -49,10 +53,21 @@ func NewTestHysteria2ProxyManager() *Hysteria2ProxyManager {
 
 // StartHysteria2Proxy 启动Hysteria2代理
 func (h *Hysteria2ProxyManager) StartHysteria2Proxy(node *types.Node) error {
+	return h.StartHysteria2ProxyWithContext(context.Background(), node)
+}
+
+// StartHysteria2ProxyWithContext 启动Hysteria2代理，端口就绪后立即返回；ctx 取消时放弃启动
+func (h *Hysteria2ProxyManager) StartHysteria2ProxyWithContext(ctx context.Context, node *types.Node) error {
 	if node.Protocol != "hysteria2" {
 		return fmt.Errorf("节点协议不是Hysteria2: %s", node.Protocol)
 	}
 
+	// 核心刚升级时先做冒烟测试，失败自动回滚；之后重新选择可执行文件
+	if err := EnsureCoreHealthy(ctx, downloader.CoreHysteria2); err != nil {
+		return err
+	}
+	h.downloader.BinaryPath = downloader.ExecutablePath(downloader.CoreHysteria2)
+
 	// 检查Hysteria2是否安装
 	if !h.downloader.CheckHysteria2Installed() {
 		fmt.Println("🔽 Hysteria2未安装，正在自动下载...")
@@ -66,17 +81,29 @@ func (h *Hysteria2ProxyManager) StartHysteria2Proxy(node *types.Node) error {
 		h.StopHysteria2Proxy()
 	}
 
-	// 分配端口（如果还未设置）
-	if h.HTTPPort == 0 {
-		h.HTTPPort = findAvailablePort(8081)
-	}
-	if h.SOCKSPort == 0 {
-		h.SOCKSPort = findAvailablePort(1081)
+	// 分配端口（如果还未设置，从共享分配器申请）
+	releasePorts, err := acquireProxyPorts(&h.HTTPPort, &h.SOCKSPort, "hysteria2")
+	if err != nil {
+		return err
 	}
+	// 启动未成功时释放租约并清零端口，避免租约一直占用到过期
+	started := false
+	defer func() {
+		if !started {
+			releasePorts()
+		}
+	}()
 
 	fmt.Printf("🔧 配置代理端口: HTTP=%d, SOCKS=%d\n", h.HTTPPort, h.SOCKSPort)
 
 	// 生成配置文件
+	if h.downloader.ConfigPath == "" {
+		configPath, err := rundir.TempFile("hysteria2", ".yaml")
+		if err != nil {
+			return err
+		}
+		h.downloader.ConfigPath = configPath
+	}
 	if err := h.downloader.GenerateHysteria2Config(node, h.HTTPPort, h.SOCKSPort); err != nil {
 		return fmt.Errorf("生成配置失败: %v", err)
 	}
@@ -89,16 +116,23 @@ func (h *Hysteria2ProxyManager) StartHysteria2Proxy(node *types.Node) error {
 
 	h.Hysteria2Process = process
 	h.Hysteria2Node = node
+	h.process = lifecycle.Watch(process)
 
-	// 等待启动
-	fmt.Println("⏳ 等待Hysteria2启动...")
-	time.Sleep(3 * time.Second)
+	// 端口租约随进程退出释放
+	Ports().Attach(h.HTTPPort, "hysteria2", h.process.Pid(), h.process.Done())
+	Ports().Attach(h.SOCKSPort, "hysteria2", h.process.Pid(), h.process.Done())
 
-	// 检查是否成功启动
-	if !h.IsHysteria2Running() {
-		h.Hysteria2Process = nil
-		h.Hysteria2Node = nil
-		return fmt.Errorf("Hysteria2启动失败或意外退出")
+	// 等待端口就绪，进程提前退出时立即失败
+	fmt.Println("⏳ 等待Hysteria2启动...")
+	readyCtx, cancel := context.WithTimeout(ctx, startTimeout())
+	defer cancel()
+	if err := lifecycle.WaitPortsReady(readyCtx, h.process.Done(), h.HTTPPort, h.SOCKSPort); err != nil {
+		exited := h.process.Exited()
+		h.StopHysteria2Proxy()
+		if exited {
+			return fmt.Errorf("Hysteria2启动失败或意外退出")
+		}
+		return fmt.Errorf("Hysteria2启动失败: %v", err)
 	}
 
 	fmt.Printf("✅ Hysteria2代理启动成功!\n")
@@ -106,31 +140,61 @@ func (h *Hysteria2ProxyManager) StartHysteria2Proxy(node *types.Node) error {
 	fmt.Printf("🌐 HTTP代理: http://127.0.0.1:%d\n", h.HTTPPort)
 	fmt.Printf("🧦 SOCKS代理: socks5://127.0.0.1:%d\n", h.SOCKSPort)
 
+	started = true
 	return nil
 }
 
 // StopHysteria2Proxy 停止Hysteria2代理
 func (h *Hysteria2ProxyManager) StopHysteria2Proxy() error {
+	ctx, cancel := context.WithTimeout(context.Background(), lifecycle.DefaultStopTimeout)
+	defer cancel()
+	return h.StopHysteria2ProxyWithContext(ctx)
+}
+
+// Detach 让代理在当前程序退出后继续在后台运行（命令行 start-hysteria2 使用），之后可由 stop-hysteria2 停止
+func (h *Hysteria2ProxyManager) Detach() error {
+	if h.process == nil {
+		return fmt.Errorf("没有运行中的Hysteria2代理")
+	}
+	return h.process.Detach()
+}
+
+// StopDetached 停止之前由 start-hysteria2 转入后台的Hysteria2进程（只供 stop-hysteria2 命令使用）
+func (h *Hysteria2ProxyManager) StopDetached() error {
+	detached := lifecycle.DefaultRegistry().Detached("hysteria")
+	if len(detached) == 0 {
+		return fmt.Errorf("没有运行中的Hysteria2代理")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lifecycle.DefaultStopTimeout)
+	defer cancel()
+	if err := lifecycle.DefaultRegistry().Terminate(ctx, detached...); err != nil {
+		fmt.Printf("⚠️ %v\n", err)
+	}
+
+	fmt.Println("🛑 Hysteria2代理已停止")
+	return nil
+}
+
+// StopHysteria2ProxyWithContext 发送终止信号并等待进程退出，ctx 结束时强制杀死
+// 只停止本管理器启动的进程；后台代理由 StopDetached 停止
+func (h *Hysteria2ProxyManager) StopHysteria2ProxyWithContext(ctx context.Context) error {
 	if h.Hysteria2Process == nil {
 		return fmt.Errorf("没有运行中的Hysteria2代理")
 	}
 
-	// 发送终止信号
-	if h.Hysteria2Process.Process != nil {
-		err := h.Hysteria2Process.Process.Signal(syscall.SIGTERM)
-		if err != nil {
-			// 如果温和终止失败，强制杀死
-			h.Hysteria2Process.Process.Kill()
+	// 终止进程并等待退出
+	if h.process != nil {
+		if err := h.process.Stop(ctx); err != nil {
+			fmt.Printf("⚠️ %v\n", err)
 		}
 	}
-
-	// 等待进程结束
-	h.Hysteria2Process.Wait()
+	h.process = nil
 	h.Hysteria2Process = nil
 	h.Hysteria2Node = nil
 
-	// 清理临时配置文件
-	if h.downloader != nil && h.downloader.ConfigPath != "./hysteria2/config.yaml" {
+	// 清理会话目录中的临时配置文件（用户通过 SetConfigPath 指定的配置保留）
+	if h.downloader != nil && isSessionFile(h.downloader.ConfigPath) {
 		os.Remove(h.downloader.ConfigPath)
 	}
 
@@ -141,11 +205,13 @@ func (h *Hysteria2ProxyManager) StopHysteria2Proxy() error {
 // IsHysteria2Running 检查Hysteria2是否运行
 func (h *Hysteria2ProxyManager) IsHysteria2Running() bool {
 	// 首先检查进程状态
-	if h.Hysteria2Process != nil && h.Hysteria2Process.Process != nil {
-		err := h.Hysteria2Process.Process.Signal(syscall.Signal(0))
-		if err == nil {
-			return true
-		}
+	if h.process != nil && !h.process.Exited() {
+		return true
+	}
+
+	// 其次检查由 start-hysteria2 转入后台的进程
+	if len(lifecycle.DefaultRegistry().Detached("hysteria")) > 0 {
+		return true
 	}
 
 	// 通过端口检查
@@ -210,6 +276,11 @@ func (h *Hysteria2ProxyManager) GetHysteria2Status() ProxyStatus {
 	return status
 }
 
+// isSessionFile 文件是否位于本进程的会话目录中
+func isSessionFile(path string) bool {
+	return path != "" && filepath.Dir(path) == rundir.SessionDir()
+}
+
 // SetConfigPath 设置配置文件路径
 func (h *Hysteria2ProxyManager) SetConfigPath(configPath string) {
 	if h.downloader != nil {
@@ -0,0 +1,243 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultPortLeaseTTL 未绑定进程的租约有效期；绑定核心进程后租约持续到进程退出
+const DefaultPortLeaseTTL = 2 * time.Minute
+
+// portAcquireAttempts 申请端口时的最大尝试次数（系统分配的端口恰好仍在租约中时重试）
+const portAcquireAttempts = 20
+
+// PortLease 端口租约
+type PortLease struct {
+	ID         uint64    `json:"id"`
+	Port       int       `json:"port"`
+	Owner      string    `json:"owner"`
+	PID        int       `json:"pid,omitempty"` // 绑定的核心进程，进程退出时租约自动释放
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at,omitempty"` // 未绑定进程时的过期时间，零值表示不过期
+}
+
+// PortAllocator 进程内共享的端口分配器
+// 通过监听0端口由系统分配空闲端口（落在系统临时端口范围内，不会与常用服务端口冲突），
+// 并以租约记录占用者，避免同一进程内的测试器、代理服务器和Web UI之间互相抢占端口。
+//
+// 租约只在本进程内有效，不跨进程共享：双代理模式下 mvp-tester 和 proxy-server 是两个进程，
+// 各自的分配器互不知晓对方的租约。系统在两个进程间分配到同一临时端口的概率很低，
+// 端口在核心启动前被另一进程占用时表现为核心启动失败，调用方释放租约后重新申请即可
+type PortAllocator struct {
+	mutex  sync.Mutex
+	leases map[int]*PortLease
+	nextID uint64
+}
+
+// NewPortAllocator 创建端口分配器
+func NewPortAllocator() *PortAllocator {
+	return &PortAllocator{leases: make(map[int]*PortLease)}
+}
+
+var defaultPortAllocator = NewPortAllocator()
+
+// Ports 返回全局端口分配器
+func Ports() *PortAllocator {
+	return defaultPortAllocator
+}
+
+// Acquire 为 owner 申请一个空闲端口，ttl 为0时使用 DefaultPortLeaseTTL
+func (a *PortAllocator) Acquire(owner string, ttl time.Duration) (*PortLease, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.pruneLocked()
+
+	for i := 0; i < portAcquireAttempts; i++ {
+		port, err := freePort()
+		if err != nil {
+			return nil, fmt.Errorf("申请端口失败: %v", err)
+		}
+		if _, leased := a.leases[port]; leased {
+			continue
+		}
+		return a.leaseLocked(port, owner, ttl), nil
+	}
+	return nil, fmt.Errorf("申请端口失败: 连续 %d 次分配到已租用的端口", portAcquireAttempts)
+}
+
+// AcquirePair 为 owner 申请HTTP和SOCKS两个端口；失败时不保留任何租约
+func (a *PortAllocator) AcquirePair(owner string, ttl time.Duration) (httpLease, socksLease *PortLease, err error) {
+	httpLease, err = a.Acquire(owner, ttl)
+	if err != nil {
+		return nil, nil, err
+	}
+	socksLease, err = a.Acquire(owner, ttl)
+	if err != nil {
+		a.Release(httpLease)
+		return nil, nil, err
+	}
+	return httpLease, socksLease, nil
+}
+
+// Reserve 为 owner 租用指定端口（如用户配置的固定端口）
+// 端口已被其他占用者租用时返回错误，已被同一占用者租用时返回原租约
+func (a *PortAllocator) Reserve(port int, owner string, ttl time.Duration) (*PortLease, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.pruneLocked()
+
+	if lease, ok := a.leases[port]; ok {
+		if lease.Owner != owner {
+			return nil, fmt.Errorf("端口 %d 已被 %s 租用", port, lease.Owner)
+		}
+		return lease, nil
+	}
+	return a.leaseLocked(port, owner, ttl), nil
+}
+
+// Attach 将端口租约绑定到核心进程，进程退出（done 关闭）时释放；端口没有租约时以 owner 的名义新建
+// 绑定后租约不再按有效期过期
+func (a *PortAllocator) Attach(port int, owner string, pid int, done <-chan struct{}) {
+	if port <= 0 {
+		return
+	}
+
+	a.mutex.Lock()
+	lease, ok := a.leases[port]
+	if !ok {
+		lease = a.leaseLocked(port, owner, 0)
+	}
+	lease.PID = pid
+	lease.ExpiresAt = time.Time{}
+	id := lease.ID
+	a.mutex.Unlock()
+
+	go func() {
+		<-done
+		a.releaseID(port, id)
+	}()
+}
+
+// Release 释放租约；租约已被释放或端口已被重新租用时不做任何事
+func (a *PortAllocator) Release(lease *PortLease) {
+	if lease == nil {
+		return
+	}
+	a.releaseID(lease.Port, lease.ID)
+}
+
+// ReleaseOwner 释放 owner 持有的所有租约，返回释放的数量
+func (a *PortAllocator) ReleaseOwner(owner string) int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	released := 0
+	for port, lease := range a.leases {
+		if lease.Owner == owner {
+			delete(a.leases, port)
+			released++
+		}
+	}
+	return released
+}
+
+// Leased 端口当前是否有租约
+func (a *PortAllocator) Leased(port int) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.pruneLocked()
+	_, ok := a.leases[port]
+	return ok
+}
+
+// Leases 返回当前所有租约的快照，按端口排序
+func (a *PortAllocator) Leases() []PortLease {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.pruneLocked()
+
+	leases := make([]PortLease, 0, len(a.leases))
+	for _, lease := range a.leases {
+		leases = append(leases, *lease)
+	}
+	sort.Slice(leases, func(i, j int) bool { return leases[i].Port < leases[j].Port })
+	return leases
+}
+
+// leaseLocked 新建租约，调用方需持有锁
+func (a *PortAllocator) leaseLocked(port int, owner string, ttl time.Duration) *PortLease {
+	if ttl <= 0 {
+		ttl = DefaultPortLeaseTTL
+	}
+	a.nextID++
+	now := time.Now()
+	lease := &PortLease{
+		ID:         a.nextID,
+		Port:       port,
+		Owner:      owner,
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}
+	a.leases[port] = lease
+	return lease
+}
+
+// releaseID 仅当端口的当前租约仍是指定租约时释放
+func (a *PortAllocator) releaseID(port int, id uint64) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if lease, ok := a.leases[port]; ok && lease.ID == id {
+		delete(a.leases, port)
+	}
+}
+
+// pruneLocked 清理过期租约，调用方需持有锁
+func (a *PortAllocator) pruneLocked() {
+	now := time.Now()
+	for port, lease := range a.leases {
+		if !lease.ExpiresAt.IsZero() && now.After(lease.ExpiresAt) {
+			delete(a.leases, port)
+		}
+	}
+}
+
+// acquireProxyPorts 为未设置的代理端口申请租约；核心启动后由 Attach 接管租约
+// 返回的 release 释放本次申请的租约并将对应端口清零（用户指定的固定端口不受影响），启动失败时调用
+func acquireProxyPorts(httpPort, socksPort *int, owner string) (release func(), err error) {
+	var ports []*int
+	var leases []*PortLease
+	release = func() {
+		for i, lease := range leases {
+			Ports().Release(lease)
+			*ports[i] = 0
+		}
+	}
+
+	for _, port := range []*int{httpPort, socksPort} {
+		if *port != 0 {
+			continue
+		}
+		lease, err := Ports().Acquire(owner, 0)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		*port = lease.Port
+		ports = append(ports, port)
+		leases = append(leases, lease)
+	}
+	return release, nil
+}
+
+// freePort 在本机回环地址上监听0端口，由系统分配一个当前空闲的端口；探测时不在外部网卡上开放监听
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,279 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/downloader"
+	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
+)
+
+// generateSingBoxConfig 生成sing-box配置。sing-box支持 vmess/vless/trojan/ss/hysteria2/tuic，
+// 入站与V2Ray配置相同：HTTP 和 SOCKS 各一个端口
+func generateSingBoxConfig(node *types.Node, httpPort, socksPort int) (map[string]interface{}, error) {
+	outbound, err := singBoxOutbound(node)
+	if err != nil {
+		return nil, err
+	}
+	outbound["tag"] = "proxy"
+
+	// firstHop 直接连接服务器的出站（shadow-tls 时为其单独的出站）
+	outbounds := []map[string]interface{}{outbound}
+	firstHop := outbound
+	if detour := applySingBoxSSPlugin(outbound, node, "shadowtls"); detour != nil {
+		outbounds = append(outbounds, detour)
+		firstHop = detour
+	}
+
+	// 代理链：出口节点经由前置节点出站连接
+	if front := FrontNode(node); front != nil {
+		frontOutbound, err := singBoxOutbound(front)
+		if err != nil {
+			return nil, fmt.Errorf("生成前置节点配置失败: %v", err)
+		}
+		frontOutbound["tag"] = frontTag
+		firstHop["detour"] = frontTag
+		outbounds = append(outbounds, frontOutbound)
+		if detour := applySingBoxSSPlugin(frontOutbound, front, frontShadowTLSTag); detour != nil {
+			outbounds = append(outbounds, detour)
+		}
+	}
+	outbounds = append(outbounds, map[string]interface{}{
+		"type": "direct",
+		"tag":  "direct",
+	})
+
+	return map[string]interface{}{
+		"log": map[string]interface{}{
+			"level": "warn",
+		},
+		"inbounds": []map[string]interface{}{
+			{
+				"type":        "http",
+				"tag":         "http",
+				"listen":      "0.0.0.0",
+				"listen_port": httpPort,
+			},
+			{
+				"type":        "socks",
+				"tag":         "socks",
+				"listen":      "0.0.0.0",
+				"listen_port": socksPort,
+			},
+		},
+		"outbounds": outbounds,
+		"route": map[string]interface{}{
+			"final": "proxy",
+		},
+	}, nil
+}
+
+// singBoxOutbound 生成节点对应的sing-box出站
+func singBoxOutbound(node *types.Node) (map[string]interface{}, error) {
+	outbound := map[string]interface{}{
+		"server":      node.Server,
+		"server_port": parsePort(node.Port),
+	}
+
+	switch node.Protocol {
+	case "vmess":
+		outbound["type"] = "vmess"
+		outbound["uuid"] = node.UUID
+		outbound["security"] = getVmessSecurity(node.Parameters["scy"])
+		outbound["alter_id"] = parseAlterId(node.Parameters["aid"])
+		if node.Parameters["tls"] == "tls" {
+			outbound["tls"] = singBoxTLS(node)
+		}
+
+	case "vless":
+		outbound["type"] = "vless"
+		outbound["uuid"] = node.UUID
+		if flow := node.Parameters["flow"]; flow != "" {
+			outbound["flow"] = flow
+		}
+		switch node.Parameters["security"] {
+		case "tls", "reality":
+			outbound["tls"] = singBoxTLS(node)
+		}
+
+	case "trojan":
+		outbound["type"] = "trojan"
+		outbound["password"] = node.Password
+		if node.Parameters["security"] != "none" {
+			outbound["tls"] = singBoxTLS(node)
+		}
+
+	case "ss":
+		// sing-box 原生支持旧式加密方法，不需要像V2Ray那样转换
+		outbound["type"] = "shadowsocks"
+		outbound["method"] = node.Method
+		outbound["password"] = node.Password
+
+	case "hysteria2":
+		outbound["type"] = "hysteria2"
+		outbound["password"] = node.UUID // Hysteria2中用户标识作为密码
+		// 带宽为 0 时不设置，使用BBR拥塞控制
+		up, down := downloader.Hysteria2BandwidthMbps(node)
+		if up > 0 {
+			outbound["up_mbps"] = up
+		}
+		if down > 0 {
+			outbound["down_mbps"] = down
+		}
+		// 端口跳跃：sing-box 的端口范围写作 起始:结束
+		if node.Parameters["mport"] != "" {
+			var ports []string
+			for _, spec := range strings.Split(downloader.Hysteria2PortSpec(node), ",") {
+				if spec == "" {
+					continue
+				}
+				start, end, found := strings.Cut(spec, "-")
+				if !found {
+					end = start
+				}
+				ports = append(ports, start+":"+end)
+			}
+			delete(outbound, "server_port")
+			outbound["server_ports"] = ports
+			if hop := downloader.Hysteria2HopInterval(node); hop != "" {
+				outbound["hop_interval"] = hop
+			}
+		}
+		if obfsPassword := downloader.Hysteria2ObfsPassword(node); obfsPassword != "" {
+			outbound["obfs"] = map[string]interface{}{
+				"type":     "salamander",
+				"password": obfsPassword,
+			}
+		}
+		outbound["tls"] = singBoxTLS(node)
+
+	case "tuic":
+		outbound["type"] = "tuic"
+		outbound["uuid"] = node.UUID
+		outbound["password"] = node.Password
+		if cc := node.Parameters["congestion_control"]; cc != "" {
+			outbound["congestion_control"] = cc
+		}
+		if mode := node.Parameters["udp_relay_mode"]; mode != "" {
+			outbound["udp_relay_mode"] = mode
+		}
+		tls := singBoxTLS(node)
+		if _, ok := tls["alpn"]; !ok {
+			tls["alpn"] = []string{"h3"}
+		}
+		outbound["tls"] = tls
+
+	default:
+		return nil, fmt.Errorf("sing-box不支持的协议类型: %s", node.Protocol)
+	}
+
+	transport, err := singBoxTransport(node)
+	if err != nil {
+		return nil, err
+	}
+	if transport != nil {
+		outbound["transport"] = transport
+	}
+	return outbound, nil
+}
+
+// singBoxTLS 生成sing-box出站的TLS设置，包括 uTLS 指纹和 REALITY
+func singBoxTLS(node *types.Node) map[string]interface{} {
+	tls := map[string]interface{}{
+		"enabled": true,
+	}
+
+	for _, key := range []string{"sni", "peer"} {
+		if sni := node.Parameters[key]; sni != "" {
+			tls["server_name"] = sni
+			break
+		}
+	}
+
+	for _, key := range []string{"allowInsecure", "allow_insecure", "insecure"} {
+		if value := node.Parameters[key]; value == "1" || value == "true" {
+			tls["insecure"] = true
+			break
+		}
+	}
+
+	if alpn := node.Parameters["alpn"]; alpn != "" {
+		tls["alpn"] = strings.Split(alpn, ",")
+	}
+
+	fingerprint := node.Parameters["fp"]
+	if strings.EqualFold(node.Parameters["security"], "reality") {
+		if fingerprint == "" {
+			fingerprint = "chrome" // REALITY 必须启用 uTLS
+		}
+		tls["reality"] = map[string]interface{}{
+			"enabled":    true,
+			"public_key": node.Parameters["pbk"],
+			"short_id":   node.Parameters["sid"],
+		}
+	}
+	if fingerprint != "" {
+		tls["utls"] = map[string]interface{}{
+			"enabled":     true,
+			"fingerprint": fingerprint,
+		}
+	}
+
+	return tls
+}
+
+// singBoxTransport 生成sing-box出站的传输设置，TCP 返回 nil
+func singBoxTransport(node *types.Node) (map[string]interface{}, error) {
+	path := node.Parameters["path"]
+	host := node.Parameters["host"]
+
+	switch network := transportType(node); network {
+	case "", "tcp", "udp":
+		if usesTransport(node) && headerType(node) == "http" {
+			return nil, fmt.Errorf("sing-box不支持 TCP HTTP 伪装")
+		}
+		return nil, nil
+	case "quic":
+		// sing-box 的 QUIC 传输没有额外加密和伪装，只兼容 security=none、header=none 的节点
+		security := transportParam(node, "quicSecurity", "host")
+		if (security != "" && security != "none") || (headerType(node) != "" && headerType(node) != "none") {
+			return nil, fmt.Errorf("sing-box不支持带加密或伪装的 QUIC 传输")
+		}
+		return map[string]interface{}{"type": "quic"}, nil
+	case "ws":
+		transport := map[string]interface{}{"type": "ws"}
+		if path != "" {
+			transport["path"] = path
+		}
+		if host != "" {
+			transport["headers"] = map[string]interface{}{"Host": host}
+		}
+		return transport, nil
+	case "grpc":
+		transport := map[string]interface{}{"type": "grpc"}
+		if serviceName := node.Parameters["serviceName"]; serviceName != "" {
+			transport["service_name"] = serviceName
+		}
+		return transport, nil
+	case "h2", "http":
+		transport := map[string]interface{}{"type": "http"}
+		if path != "" {
+			transport["path"] = path
+		}
+		if host != "" {
+			transport["host"] = []string{host}
+		}
+		return transport, nil
+	case "httpupgrade":
+		transport := map[string]interface{}{"type": "httpupgrade"}
+		if path != "" {
+			transport["path"] = path
+		}
+		if host != "" {
+			transport["host"] = host
+		}
+		return transport, nil
+	default:
+		return nil, fmt.Errorf("sing-box不支持 %s 传输", network)
+	}
+}
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/downloader"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/lifecycle"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/rundir"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/platform"
+)
+
+// coreHealthMutex 同一时间只运行一个冒烟测试，并发启动的代理等待其结果
+var coreHealthMutex sync.Mutex
+
+// EnsureCoreHealthy 核心刚升级（仓库中有待测试标记）时先做冒烟测试：
+// 通过则确认升级，失败则自动回滚到之前的版本。没有待测试的升级时立即返回
+func EnsureCoreHealthy(ctx context.Context, core string) error {
+	if downloader.PendingVersion(core) == "" {
+		return nil
+	}
+
+	coreHealthMutex.Lock()
+	defer coreHealthMutex.Unlock()
+
+	// 等待锁期间可能已由其他调用处理
+	pending := downloader.PendingVersion(core)
+	if pending == "" || downloader.VersionOverride(core) != "" {
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "🧪 %s 已升级到 %s，运行冒烟测试...\n", core, pending)
+	err := SmokeTestCore(ctx, core, downloader.VersionExecutable(core, pending))
+	if err == nil {
+		fmt.Fprintf(os.Stderr, "✅ %s %s 冒烟测试通过\n", core, pending)
+		return downloader.ConfirmVersion(core)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	fmt.Fprintf(os.Stderr, "❌ %s %s 冒烟测试失败: %v\n", core, pending, err)
+	previous, rollbackErr := downloader.Rollback(core)
+	if rollbackErr != nil {
+		return fmt.Errorf("%s %s 冒烟测试失败且无法回滚: %v", core, pending, rollbackErr)
+	}
+	fmt.Fprintf(os.Stderr, "↩️ 已自动回滚到 %s %s\n", core, previous)
+	return nil
+}
+
+// SmokeTestCore 校验核心可执行文件并用最小配置启动，本地端口就绪即视为通过。
+// 配置不连接任何节点（V2Ray/Xray/sing-box 直连出站，Hysteria2 使用 lazy 模式），与订阅和网络状况无关
+func SmokeTestCore(ctx context.Context, core, binary string) error {
+	if err := downloader.VerifyBinary(binary); err != nil {
+		return err
+	}
+
+	lease, err := Ports().Acquire("smoke-test", 0)
+	if err != nil {
+		return err
+	}
+	defer Ports().Release(lease)
+
+	var (
+		content string
+		args    []string
+	)
+	ext := ".json"
+	if core == downloader.CoreHysteria2 {
+		ext = ".yaml"
+	}
+	configPath, err := rundir.TempFile("smoke-"+core, ext)
+	if err != nil {
+		return err
+	}
+	switch core {
+	case downloader.CoreV2Ray, downloader.CoreXray:
+		content = fmt.Sprintf(`{
+  "log": {"loglevel": "warning"},
+  "inbounds": [{"listen": "127.0.0.1", "port": %d, "protocol": "socks", "settings": {"udp": false}}],
+  "outbounds": [{"protocol": "freedom"}]
+}
+`, lease.Port)
+		args = []string{"run", "-c", configPath}
+	case downloader.CoreSingBox:
+		content = fmt.Sprintf(`{
+  "log": {"level": "warn"},
+  "inbounds": [{"type": "socks", "listen": "127.0.0.1", "listen_port": %d}],
+  "outbounds": [{"type": "direct"}]
+}
+`, lease.Port)
+		args = []string{"run", "-c", configPath}
+	case downloader.CoreHysteria2:
+		content = fmt.Sprintf(`server: 127.0.0.1:1
+auth: smoke-test
+lazy: true
+socks5:
+  listen: 127.0.0.1:%d
+`, lease.Port)
+		args = []string{"client", "-c", configPath}
+	default:
+		return fmt.Errorf("未知的核心: %s", core)
+	}
+
+	if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+		return fmt.Errorf("写入测试配置失败: %v", err)
+	}
+	defer os.Remove(configPath)
+
+	cmd := exec.Command(binary, args...)
+	platform.SetProcAttributes(cmd)
+	process, err := lifecycle.StartProcess(cmd)
+	if err != nil {
+		return fmt.Errorf("启动失败: %v", err)
+	}
+	Ports().Attach(lease.Port, "smoke-test", process.Pid(), process.Done())
+	defer process.StopTimeout(5 * time.Second)
+
+	readyCtx, cancel := context.WithTimeout(ctx, startTimeout())
+	defer cancel()
+	if err := lifecycle.WaitPortsReady(readyCtx, process.Done(), lease.Port); err != nil {
+		if process.Exited() {
+			return fmt.Errorf("进程启动后立即退出: %v", process.Err())
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package proxy
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
+)
+
+// defaultObfsHost simple-obfs 没有指定 obfs-host 时使用的伪装域名
+const defaultObfsHost = "bing.com"
+
+// UnsupportedReason 返回节点无法运行的原因（协议、传输方式、Shadowsocks 插件或代理链不受支持），可以运行时返回空
+func UnsupportedReason(node *types.Node) string {
+	if !SupportedProtocol(node.Protocol) {
+		return fmt.Sprintf("不支持的协议: %s", node.Protocol)
+	}
+	if reason := unsupportedTransport(node); reason != "" {
+		return reason
+	}
+	if front := FrontNode(node); front != nil {
+		if reason := unsupportedChain(node, front); reason != "" {
+			return reason
+		}
+	}
+
+	plugin := node.SSPlugin()
+	if plugin == nil {
+		return ""
+	}
+	switch plugin.Name {
+	case types.PluginObfs:
+		switch mode := plugin.Mode(); mode {
+		case "http", "tls":
+			return ""
+		case "":
+			return "simple-obfs 插件缺少 obfs 模式"
+		default:
+			return fmt.Sprintf("simple-obfs 插件不支持 %s 模式（支持 http、tls）", mode)
+		}
+	case types.PluginV2Ray:
+		if mode := plugin.Mode(); mode != "websocket" {
+			return fmt.Sprintf("v2ray-plugin 插件不支持 %s 模式（支持 websocket）", mode)
+		}
+		return ""
+	case types.PluginShadowTLS:
+		if plugin.Option("password") == "" {
+			return "shadow-tls 插件缺少 password"
+		}
+		if plugin.Option("host") == "" {
+			return "shadow-tls 插件缺少 host"
+		}
+		return ""
+	}
+	return fmt.Sprintf("不支持的 Shadowsocks 插件: %s", plugin.Name)
+}
+
+// ssPluginSingBoxFeature 只有sing-box能运行的插件，返回插件说明；其他插件返回空
+func ssPluginSingBoxFeature(node *types.Node) string {
+	plugin := node.SSPlugin()
+	if plugin == nil {
+		return ""
+	}
+	switch {
+	case plugin.Name == types.PluginObfs && plugin.Mode() == "tls":
+		return "simple-obfs TLS"
+	case plugin.Name == types.PluginShadowTLS:
+		return "shadow-tls"
+	}
+	return ""
+}
+
+// applyV2RaySSPlugin 把 Shadowsocks 插件转换为V2Ray出站的传输设置：
+// simple-obfs http 对应 TCP 的 HTTP 伪装头，v2ray-plugin 对应 WebSocket（可选 TLS 和 Mux）
+func applyV2RaySSPlugin(outbound map[string]interface{}, node *types.Node) {
+	plugin := node.SSPlugin()
+	if plugin == nil {
+		return
+	}
+	streamSettings := outbound["streamSettings"].(map[string]interface{})
+
+	switch plugin.Name {
+	case types.PluginObfs:
+		host := plugin.Option("obfs-host", "host")
+		if host == "" {
+			host = defaultObfsHost
+		}
+		path := plugin.Option("obfs-uri", "path")
+		if path == "" {
+			path = "/"
+		}
+		streamSettings["tcpSettings"] = map[string]interface{}{
+			"header": map[string]interface{}{
+				"type": "http",
+				"request": map[string]interface{}{
+					"version": "1.1",
+					"method":  "GET",
+					"path":    []string{path},
+					"headers": map[string]interface{}{
+						"Host":       []string{host},
+						"User-Agent": []string{"curl/7.88.1"},
+						"Upgrade":    []string{"websocket"},
+						"Connection": []string{"Upgrade"},
+					},
+				},
+			},
+		}
+
+	case types.PluginV2Ray:
+		host := plugin.Option("host")
+		path := plugin.Option("path")
+		if path == "" {
+			path = "/"
+		}
+		wsSettings := map[string]interface{}{"path": path}
+		if host != "" {
+			wsSettings["headers"] = map[string]interface{}{"Host": host}
+		}
+		delete(streamSettings, "tcpSettings")
+		streamSettings["network"] = "ws"
+		streamSettings["wsSettings"] = wsSettings
+
+		if plugin.Flag("tls") {
+			serverName := host
+			if serverName == "" {
+				serverName = node.Server
+			}
+			streamSettings["security"] = "tls"
+			streamSettings["tlsSettings"] = map[string]interface{}{
+				"serverName":    serverName,
+				"allowInsecure": plugin.Flag("skip-cert-verify") || plugin.Flag("insecure"),
+			}
+		}
+
+		// v2ray-plugin 客户端默认开启 Mux，mux 为并发数，0 表示关闭
+		concurrency := 1
+		if value := plugin.Option("mux"); value != "" {
+			if n, err := strconv.Atoi(value); err == nil {
+				concurrency = n
+			}
+		}
+		if concurrency > 0 {
+			outbound["mux"] = map[string]interface{}{
+				"enabled":     true,
+				"concurrency": concurrency,
+			}
+		}
+	}
+}
+
+// applySingBoxSSPlugin 设置sing-box的 Shadowsocks 插件。simple-obfs 和 v2ray-plugin 由sing-box内置实现，
+// 选项原样传递；shadow-tls 返回一个标签为 tag 的单独出站，Shadowsocks 出站经由它连接服务器
+func applySingBoxSSPlugin(outbound map[string]interface{}, node *types.Node, tag string) map[string]interface{} {
+	plugin := node.SSPlugin()
+	if plugin == nil {
+		return nil
+	}
+
+	switch plugin.Name {
+	case types.PluginObfs, types.PluginV2Ray:
+		outbound["plugin"] = plugin.Name
+		if plugin.Raw != "" {
+			outbound["plugin_opts"] = plugin.Raw
+		}
+		return nil
+
+	case types.PluginShadowTLS:
+		version := 3
+		if value := plugin.Option("version"); value != "" {
+			if n, err := strconv.Atoi(value); err == nil {
+				version = n
+			}
+		} else if plugin.Flag("v2") {
+			version = 2
+		}
+
+		outbound["detour"] = tag
+		return map[string]interface{}{
+			"type":        "shadowtls",
+			"tag":         tag,
+			"server":      node.Server,
+			"server_port": parsePort(node.Port),
+			"version":     version,
+			"password":    plugin.Option("password"),
+			"tls": map[string]interface{}{
+				"enabled":     true,
+				"server_name": plugin.Option("host"),
+				"utls": map[string]interface{}{
+					"enabled":     true,
+					"fingerprint": "chrome",
+				},
+			},
+		}
+	}
+	return nil
+}
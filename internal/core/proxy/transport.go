@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
+)
+
+// knownTransports 可以生成配置的传输方式（vless/trojan 的 type 参数，vmess 的 net 参数）
+var knownTransports = map[string]bool{
+	"": true, "tcp": true, "ws": true, "grpc": true, "h2": true, "http": true,
+	"kcp": true, "mkcp": true, "quic": true, "httpupgrade": true, "xhttp": true, "splithttp": true,
+}
+
+// usesTransport 协议是否通过 V2Ray 传输层承载（ss/hysteria2/tuic 不使用这些参数）
+func usesTransport(node *types.Node) bool {
+	switch node.Protocol {
+	case "vmess", "vless", "trojan":
+		return true
+	}
+	return false
+}
+
+// headerType 伪装类型：vless/trojan 链接使用 headerType 参数，vmess 使用 type 参数
+func headerType(node *types.Node) string {
+	if node.Protocol == "vmess" {
+		return node.Parameters["type"]
+	}
+	return node.Parameters["headerType"]
+}
+
+// transportParam 读取传输参数。vmess 链接（JSON）没有单独的字段，
+// 按 v2rayN 的约定把 mKCP 种子、QUIC 密钥和 gRPC 服务名放在 path，QUIC 加密方式放在 host
+func transportParam(node *types.Node, key, vmessKey string) string {
+	if value := node.Parameters[key]; value != "" || node.Protocol != "vmess" {
+		return value
+	}
+	return node.Parameters[vmessKey]
+}
+
+// splitList 逗号分隔的列表，忽略空项
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// applyTransportSettings 按节点的传输方式写入 streamSettings 的 network 和对应的传输设置，
+// 覆盖 tcp（含 HTTP 伪装）、ws、grpc、h2、mKCP、QUIC、HTTPUpgrade 和 XHTTP（SplitHTTP）
+func applyTransportSettings(streamSettings map[string]interface{}, node *types.Node) {
+	path := node.Parameters["path"]
+	host := node.Parameters["host"]
+	header := headerType(node)
+
+	switch network := transportType(node); network {
+	case "", "tcp":
+		streamSettings["network"] = "tcp"
+		if header == "" || header == "none" {
+			return
+		}
+		tcpHeader := map[string]interface{}{"type": header}
+		if header == "http" {
+			request := map[string]interface{}{}
+			if paths := splitList(path); len(paths) > 0 {
+				request["path"] = paths
+			}
+			if hosts := splitList(host); len(hosts) > 0 {
+				request["headers"] = map[string]interface{}{"Host": hosts}
+			}
+			tcpHeader["request"] = request
+		}
+		streamSettings["tcpSettings"] = map[string]interface{}{"header": tcpHeader}
+
+	case "ws":
+		streamSettings["network"] = "ws"
+		wsSettings := map[string]interface{}{}
+		if path != "" {
+			wsSettings["path"] = path
+		}
+		if host != "" {
+			wsSettings["headers"] = map[string]interface{}{
+				"Host": host,
+			}
+		}
+		streamSettings["wsSettings"] = wsSettings
+
+	case "grpc":
+		streamSettings["network"] = "grpc"
+		grpcSettings := map[string]interface{}{}
+		if serviceName := transportParam(node, "serviceName", "path"); serviceName != "" {
+			grpcSettings["serviceName"] = serviceName
+		}
+		// 多路模式：vless/trojan 为 mode=multi，vmess 为 type=multi
+		if node.Parameters["mode"] == "multi" || header == "multi" {
+			grpcSettings["multiMode"] = true
+		}
+		streamSettings["grpcSettings"] = grpcSettings
+
+	case "h2", "http":
+		streamSettings["network"] = "h2"
+		h2Settings := map[string]interface{}{}
+		if path != "" {
+			h2Settings["path"] = path
+		}
+		if hosts := splitList(host); len(hosts) > 0 {
+			h2Settings["host"] = hosts
+		}
+		streamSettings["httpSettings"] = h2Settings
+
+	case "kcp", "mkcp":
+		streamSettings["network"] = "kcp"
+		if header == "" {
+			header = "none"
+		}
+		kcpSettings := map[string]interface{}{
+			"header": map[string]interface{}{"type": header},
+		}
+		if seed := transportParam(node, "seed", "path"); seed != "" {
+			kcpSettings["seed"] = seed
+		}
+		streamSettings["kcpSettings"] = kcpSettings
+
+	case "quic":
+		streamSettings["network"] = "quic"
+		security := transportParam(node, "quicSecurity", "host")
+		if security == "" {
+			security = "none"
+		}
+		if header == "" {
+			header = "none"
+		}
+		quicSettings := map[string]interface{}{
+			"security": security,
+			"header":   map[string]interface{}{"type": header},
+		}
+		if key := transportParam(node, "key", "path"); key != "" && security != "none" {
+			quicSettings["key"] = key
+		}
+		streamSettings["quicSettings"] = quicSettings
+
+	case "httpupgrade":
+		streamSettings["network"] = "httpupgrade"
+		httpUpgradeSettings := map[string]interface{}{}
+		if path != "" {
+			httpUpgradeSettings["path"] = path
+		}
+		if host != "" {
+			httpUpgradeSettings["host"] = host
+		}
+		streamSettings["httpupgradeSettings"] = httpUpgradeSettings
+
+	case "xhttp", "splithttp":
+		// 只有Xray支持，SelectCore 会为这类节点选择Xray
+		streamSettings["network"] = "xhttp"
+		xhttpSettings := map[string]interface{}{}
+		if path != "" {
+			xhttpSettings["path"] = path
+		}
+		if host != "" {
+			xhttpSettings["host"] = host
+		}
+		if mode := node.Parameters["mode"]; mode != "" {
+			xhttpSettings["mode"] = mode
+		}
+		// extra 为 JSON 对象（分块大小、填充、下行分离等高级设置），原样传给Xray
+		if extra := node.Parameters["extra"]; extra != "" {
+			var extraSettings map[string]interface{}
+			if err := json.Unmarshal([]byte(extra), &extraSettings); err == nil {
+				xhttpSettings["extra"] = extraSettings
+			}
+		}
+		streamSettings["xhttpSettings"] = xhttpSettings
+
+	default:
+		// UnsupportedReason 已拒绝未知的传输方式
+		streamSettings["network"] = network
+	}
+}
+
+// unsupportedTransport 节点的传输方式无法生成配置时返回原因
+func unsupportedTransport(node *types.Node) string {
+	if !usesTransport(node) {
+		return ""
+	}
+	if network := transportType(node); !knownTransports[network] {
+		return fmt.Sprintf("不支持的传输方式: %s", network)
+	}
+	return ""
+}
@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/downloader"
+	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
+)
+
+func TestApplyTransportSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol string
+		params   map[string]string
+		want     string
+	}{
+		{
+			name:     "tcp",
+			protocol: "vless",
+			params:   map[string]string{},
+			want:     `{"network":"tcp"}`,
+		},
+		{
+			name:     "tcp_http_header",
+			protocol: "vless",
+			params:   map[string]string{"type": "tcp", "headerType": "http", "path": "/a,/b", "host": "a.com, b.com"},
+			want: `{"network":"tcp","tcpSettings":{"header":{"type":"http","request":{
+				"path":["/a","/b"],"headers":{"Host":["a.com","b.com"]}}}}}`,
+		},
+		{
+			name:     "ws_path_host",
+			protocol: "trojan",
+			params:   map[string]string{"type": "ws", "path": "/ws?ed=2048", "host": "cdn.example.com"},
+			want:     `{"network":"ws","wsSettings":{"path":"/ws?ed=2048","headers":{"Host":"cdn.example.com"}}}`,
+		},
+		{
+			name:     "grpc_multi",
+			protocol: "vless",
+			params:   map[string]string{"type": "grpc", "serviceName": "tunnel", "mode": "multi"},
+			want:     `{"network":"grpc","grpcSettings":{"serviceName":"tunnel","multiMode":true}}`,
+		},
+		{
+			name:     "grpc_gun",
+			protocol: "trojan",
+			params:   map[string]string{"type": "grpc", "serviceName": "tunnel", "mode": "gun"},
+			want:     `{"network":"grpc","grpcSettings":{"serviceName":"tunnel"}}`,
+		},
+		{
+			name:     "h2",
+			protocol: "vless",
+			params:   map[string]string{"type": "h2", "path": "/h2", "host": "a.com,b.com"},
+			want:     `{"network":"h2","httpSettings":{"path":"/h2","host":["a.com","b.com"]}}`,
+		},
+		{
+			name:     "http_alias",
+			protocol: "vless",
+			params:   map[string]string{"type": "http", "path": "/h2"},
+			want:     `{"network":"h2","httpSettings":{"path":"/h2"}}`,
+		},
+		{
+			name:     "kcp_seed_header",
+			protocol: "vless",
+			params:   map[string]string{"type": "kcp", "headerType": "wechat-video", "seed": "s3cret"},
+			want:     `{"network":"kcp","kcpSettings":{"header":{"type":"wechat-video"},"seed":"s3cret"}}`,
+		},
+		{
+			name:     "kcp_default_header",
+			protocol: "trojan",
+			params:   map[string]string{"type": "mkcp"},
+			want:     `{"network":"kcp","kcpSettings":{"header":{"type":"none"}}}`,
+		},
+		{
+			name:     "quic_security_key",
+			protocol: "vless",
+			params:   map[string]string{"type": "quic", "quicSecurity": "aes-128-gcm", "key": "k3y", "headerType": "srtp"},
+			want:     `{"network":"quic","quicSettings":{"security":"aes-128-gcm","key":"k3y","header":{"type":"srtp"}}}`,
+		},
+		{
+			name:     "quic_key_ignored_without_security",
+			protocol: "vless",
+			params:   map[string]string{"type": "quic", "key": "k3y"},
+			want:     `{"network":"quic","quicSettings":{"security":"none","header":{"type":"none"}}}`,
+		},
+		{
+			name:     "httpupgrade",
+			protocol: "vless",
+			params:   map[string]string{"type": "httpupgrade", "path": "/up", "host": "up.example.com"},
+			want:     `{"network":"httpupgrade","httpupgradeSettings":{"path":"/up","host":"up.example.com"}}`,
+		},
+		{
+			name:     "xhttp_mode_extra",
+			protocol: "vless",
+			params: map[string]string{"type": "xhttp", "path": "/x", "host": "x.com", "mode": "packet-up",
+				"extra": `{"xPaddingBytes":"100-1000","noGRPCHeader":true}`},
+			want: `{"network":"xhttp","xhttpSettings":{"path":"/x","host":"x.com","mode":"packet-up",
+				"extra":{"xPaddingBytes":"100-1000","noGRPCHeader":true}}}`,
+		},
+		{
+			name:     "splithttp_invalid_extra",
+			protocol: "vless",
+			params:   map[string]string{"type": "splithttp", "path": "/x", "extra": "{broken"},
+			want:     `{"network":"xhttp","xhttpSettings":{"path":"/x"}}`,
+		},
+		{
+			name:     "vmess_ws",
+			protocol: "vmess",
+			params:   map[string]string{"net": "ws", "type": "none", "path": "/vm", "host": "vm.example.com"},
+			want:     `{"network":"ws","wsSettings":{"path":"/vm","headers":{"Host":"vm.example.com"}}}`,
+		},
+		{
+			name:     "vmess_tcp_http_header",
+			protocol: "vmess",
+			params:   map[string]string{"net": "tcp", "type": "http", "path": "/", "host": "h.com"},
+			want:     `{"network":"tcp","tcpSettings":{"header":{"type":"http","request":{"path":["/"],"headers":{"Host":["h.com"]}}}}}`,
+		},
+		{
+			name:     "vmess_grpc_service_in_path",
+			protocol: "vmess",
+			params:   map[string]string{"net": "grpc", "type": "multi", "path": "tunnel"},
+			want:     `{"network":"grpc","grpcSettings":{"serviceName":"tunnel","multiMode":true}}`,
+		},
+		{
+			name:     "vmess_kcp_seed_in_path",
+			protocol: "vmess",
+			params:   map[string]string{"net": "kcp", "type": "dtls", "path": "s3cret"},
+			want:     `{"network":"kcp","kcpSettings":{"header":{"type":"dtls"},"seed":"s3cret"}}`,
+		},
+		{
+			name:     "vmess_quic_security_in_host",
+			protocol: "vmess",
+			params:   map[string]string{"net": "quic", "type": "wireguard", "host": "chacha20-poly1305", "path": "k3y"},
+			want:     `{"network":"quic","quicSettings":{"security":"chacha20-poly1305","key":"k3y","header":{"type":"wireguard"}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &types.Node{Protocol: tt.protocol, Server: "example.com", Port: "443", Parameters: tt.params}
+			streamSettings := map[string]interface{}{}
+			applyTransportSettings(streamSettings, node)
+			assertJSONEqual(t, streamSettings, tt.want)
+
+			if reason := unsupportedTransport(node); reason != "" {
+				t.Errorf("支持的传输被拒绝: %s", reason)
+			}
+		})
+	}
+}
+
+func TestTransportCoreSelection(t *testing.T) {
+	defer SetPreferredCore("")
+
+	tests := []struct {
+		name        string
+		preferred   string
+		params      map[string]string
+		wantCore    string
+		unsupported bool
+	}{
+		{name: "ws_default", params: map[string]string{"type": "ws"}, wantCore: downloader.CoreV2Ray},
+		{name: "ws_preferred_xray", preferred: downloader.CoreXray, params: map[string]string{"type": "ws"}, wantCore: downloader.CoreXray},
+		{name: "kcp_v2ray", params: map[string]string{"type": "kcp"}, wantCore: downloader.CoreV2Ray},
+		// xhttp 和 httpupgrade 只有Xray支持
+		{name: "xhttp_xray", params: map[string]string{"type": "xhttp"}, wantCore: downloader.CoreXray},
+		{name: "splithttp_xray", params: map[string]string{"type": "splithttp"}, wantCore: downloader.CoreXray},
+		{name: "httpupgrade_xray", params: map[string]string{"type": "httpupgrade"}, wantCore: downloader.CoreXray},
+		// Xray 已移除 QUIC，即使首选Xray也使用V2Ray
+		{name: "quic_v2ray", params: map[string]string{"type": "quic"}, wantCore: downloader.CoreV2Ray},
+		{name: "quic_preferred_xray", preferred: downloader.CoreXray, params: map[string]string{"type": "quic"}, wantCore: downloader.CoreV2Ray},
+		// sing-box 不支持 mKCP 和 xhttp，按默认规则选择
+		{name: "kcp_preferred_singbox", preferred: downloader.CoreSingBox, params: map[string]string{"type": "kcp"}, wantCore: downloader.CoreV2Ray},
+		{name: "xhttp_preferred_singbox", preferred: downloader.CoreSingBox, params: map[string]string{"type": "xhttp"}, wantCore: downloader.CoreXray},
+		{name: "ws_preferred_singbox", preferred: downloader.CoreSingBox, params: map[string]string{"type": "ws"}, wantCore: downloader.CoreSingBox},
+		// 未知的传输方式不再退回TCP
+		{name: "unknown_transport", params: map[string]string{"type": "meek"}, unsupported: true},
+		{name: "unknown_transport_preferred_xray", preferred: downloader.CoreXray, params: map[string]string{"type": "meek"}, unsupported: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SetPreferredCore(tt.preferred); err != nil {
+				t.Fatal(err)
+			}
+			node := &types.Node{Protocol: "vless", Server: "example.com", Port: "443", UUID: "id", Parameters: tt.params}
+
+			reason := UnsupportedReason(node)
+			if tt.unsupported {
+				if reason == "" {
+					t.Errorf("期望拒绝传输 %s", tt.params["type"])
+				}
+				return
+			}
+			if reason != "" {
+				t.Fatalf("节点被拒绝: %s", reason)
+			}
+			if core, _ := SelectCore(node); core != tt.wantCore {
+				t.Errorf("核心 = %s，期望 %s", core, tt.wantCore)
+			}
+		})
+	}
+}
+
+// assertJSONEqual 比较生成的设置和期望的JSON（忽略字段顺序和空白）
+func assertJSONEqual(t *testing.T, got interface{}, want string) {
+	t.Helper()
+	gotJSON, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotValue, wantValue interface{}
+	if err := json.Unmarshal(gotJSON, &gotValue); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatalf("期望的JSON无效: %v", err)
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("得到 %s\n期望 %s", gotJSON, want)
+	}
+}
@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"math/rand"
@@ -9,17 +10,15 @@ import (
 	"os/exec"
 	"runtime"
 	"strconv"
-	"sync"
-	"syscall"
 	"time"
 
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/downloader"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/lifecycle"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/rundir"
 	"github.com/yxhpy/v2ray-subscription-manager/internal/platform"
 	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
 )
 
-// 全局互斥锁用于端口分配
-var portAllocationMutex sync.Mutex
-
 // 初始化随机种子
 func init() {
 	rand.Seed(time.Now().UnixNano())
@@ -33,15 +32,27 @@ type ProxyStatus struct {
 	NodeName  string `json:"node_name"`
 	Protocol  string `json:"protocol"`
 	Server    string `json:"server"`
+	Core      string `json:"core,omitempty"`
 }
 
-// ProxyManager V2Ray代理管理器
+// ProxyManager V2Ray/Xray/sing-box代理管理器，按节点需要的特性和首选核心自动选择核心
 type ProxyManager struct {
 	ConfigPath   string
 	HTTPPort     int
 	SOCKSPort    int
 	V2RayProcess *exec.Cmd
 	CurrentNode  *types.Node
+	Core         string // 当前节点使用的核心：v2ray、xray 或 sing-box
+
+	process *lifecycle.Process // 统一等待进程退出，用于就绪探测和确定性的停止
+}
+
+// startTimeout 等待代理端口就绪的最长时间，Windows下核心启动较慢
+func startTimeout() time.Duration {
+	if runtime.GOOS == "windows" {
+		return 30 * time.Second
+	}
+	return 15 * time.Second
 }
 
 // ProxyState 代理状态持久化结构
@@ -52,157 +63,40 @@ type ProxyState struct {
 	Protocol    string `json:"protocol"`
 	Server      string `json:"server"`
 	ConfigPath  string `json:"config_path"`
+	Core        string `json:"core,omitempty"`
 	LastUpdated int64  `json:"last_updated"`
 }
 
-const StateFile = "proxy_state.json"
+// StateFile 返回代理状态文件路径（位于运行目录，只记录正在运行的后台代理，重启后无需保留）
+func StateFile() string {
+	return rundir.File("proxy_state.json")
+}
 
 // NewProxyManager 创建新的代理管理器
+// 配置文件路径在启动时于本进程的会话目录中生成，避免并发冲突，也不会写入当前工作目录
 func NewProxyManager() *ProxyManager {
-	// 为每个实例生成唯一的配置文件路径，避免并发冲突
-	uniqueConfigPath := fmt.Sprintf("temp_v2ray_config_%d.json", time.Now().UnixNano())
-
 	pm := &ProxyManager{
-		ConfigPath: uniqueConfigPath,
-		HTTPPort:   0, // 将自动分配
-		SOCKSPort:  0, // 将自动分配
+		HTTPPort:  0, // 将自动分配
+		SOCKSPort: 0, // 将自动分配
 	}
 
 	// 注意：不加载状态，确保每个管理器实例都是独立的
 	// 这样每次都会分配新的端口，避免端口冲突
 
-	fmt.Printf("DEBUG: 创建新的代理管理器，配置文件: %s\n", uniqueConfigPath)
-
 	return pm
 }
 
 // NewTestProxyManager 创建用于测试的代理管理器（不加载状态）
 func NewTestProxyManager() *ProxyManager {
-	// 为测试实例生成带测试前缀的唯一配置文件路径
-	uniqueConfigPath := fmt.Sprintf("test_v2ray_config_%d_%d.json", time.Now().UnixNano(), os.Getpid())
-
 	pm := &ProxyManager{
-		ConfigPath: uniqueConfigPath,
-		HTTPPort:   0, // 将自动分配
-		SOCKSPort:  0, // 将自动分配
+		HTTPPort:  0, // 将自动分配
+		SOCKSPort: 0, // 将自动分配
 	}
 
 	// 测试实例不加载状态，保持完全独立
 	return pm
 }
 
-// 全局端口计数器，确保每次分配不同的端口
-var globalPortCounter int64
-
-// 已占用端口记录
-var usedPorts = make(map[int]bool)
-var usedPortsMutex sync.RWMutex
-
-// findAvailablePort 查找可用端口（改进版本，确保端口唯一）
-func findAvailablePort(startPort int) int {
-	fmt.Printf("DEBUG: 开始查找可用端口，起始端口: %d\n", startPort)
-
-	portAllocationMutex.Lock()
-	defer portAllocationMutex.Unlock()
-
-	fmt.Printf("DEBUG: 获得端口分配锁\n")
-
-	// 使用全局计数器确保每次分配不同的起始点
-	globalPortCounter++
-
-	// 从多个不同的范围开始搜索，避免集中在一个区域
-	searchRanges := []int{
-		8000 + int(globalPortCounter*37%500), // 8000-8499
-		8500 + int(globalPortCounter*41%500), // 8500-8999
-		9000 + int(globalPortCounter*43%500), // 9000-9499
-		9500 + int(globalPortCounter*47%500), // 9500-9999
-	}
-
-	// 添加随机偏移
-	randomOffset := rand.Intn(50)
-
-	fmt.Printf("DEBUG: 搜索范围起始点: %v, 计数器: %d, 随机偏移: %d\n", searchRanges, globalPortCounter, randomOffset)
-
-	// 在每个范围内搜索可用端口
-	for _, basePort := range searchRanges {
-		for i := 0; i < 50; i++ {
-			candidatePort := basePort + i + randomOffset
-
-			// 确保端口在合理范围内
-			if candidatePort < 8000 {
-				candidatePort = 8000 + (candidatePort % 100)
-			}
-			if candidatePort > 65535 {
-				candidatePort = 8000 + (candidatePort % 2000)
-			}
-
-			// 检查是否已被我们记录为占用
-			usedPortsMutex.RLock()
-			alreadyUsed := usedPorts[candidatePort]
-			usedPortsMutex.RUnlock()
-
-			if alreadyUsed {
-				fmt.Printf("DEBUG: 端口 %d 已被记录为占用，跳过\n", candidatePort)
-				continue
-			}
-
-			// 使用TCP服务器测试端口可用性
-			if isPortAvailable(candidatePort) {
-				// 记录端口为已使用
-				usedPortsMutex.Lock()
-				usedPorts[candidatePort] = true
-				usedPortsMutex.Unlock()
-
-				fmt.Printf("DEBUG: 找到并分配可用端口: %d\n", candidatePort)
-				return candidatePort
-			}
-		}
-	}
-
-	// 最后备选方案：使用计数器直接生成一个端口
-	fallbackPort := 8000 + int(globalPortCounter%2000)
-	fmt.Printf("DEBUG: 使用fallback端口: %d\n", fallbackPort)
-
-	// 记录fallback端口
-	usedPortsMutex.Lock()
-	usedPorts[fallbackPort] = true
-	usedPortsMutex.Unlock()
-
-	return fallbackPort
-}
-
-// releasePort 释放端口（当连接关闭时调用）
-func releasePort(port int) {
-	usedPortsMutex.Lock()
-	defer usedPortsMutex.Unlock()
-	delete(usedPorts, port)
-	fmt.Printf("DEBUG: 释放端口: %d\n", port)
-}
-
-// isPortAvailable 检查端口是否可用（通过建立TCP服务器测试）
-func isPortAvailable(port int) bool {
-	// 方法1：尝试监听该端口
-	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
-	if err != nil {
-		// 监听失败说明端口被占用
-		fmt.Printf("DEBUG: 端口 %d 被占用 (监听失败: %v)\n", port, err)
-		return false
-	}
-	listener.Close()
-
-	// 方法2：尝试连接该端口确认没有服务在运行
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), 100*time.Millisecond)
-	if err == nil {
-		// 如果连接成功，说明端口被占用
-		conn.Close()
-		fmt.Printf("DEBUG: 端口 %d 被占用 (连接成功)\n", port)
-		return false
-	}
-
-	fmt.Printf("DEBUG: 端口 %d 可用\n", port)
-	return true
-}
-
 // generateV2RayConfig 生成V2Ray配置
 func generateV2RayConfig(node *types.Node, httpPort, socksPort int) (map[string]interface{}, error) {
 	config := map[string]interface{}{
@@ -350,6 +244,8 @@ func generateV2RayConfig(node *types.Node, httpPort, socksPort int) (map[string]
 				},
 			},
 		}
+		// Shadowsocks 插件转换为对应的传输设置
+		applyV2RaySSPlugin(outbound, node)
 
 	case "vmess":
 		outbound = map[string]interface{}{
@@ -417,50 +313,8 @@ func generateStreamSettings(node *types.Node) map[string]interface{} {
 		"network": "tcp", // 默认TCP
 	}
 
-	// 检查传输类型
-	if transportType, ok := node.Parameters["type"]; ok {
-		switch transportType {
-		case "ws":
-			streamSettings["network"] = "ws"
-			wsSettings := map[string]interface{}{}
-
-			if path, ok := node.Parameters["path"]; ok && path != "" {
-				wsSettings["path"] = path
-			}
-
-			if host, ok := node.Parameters["host"]; ok && host != "" {
-				wsSettings["headers"] = map[string]interface{}{
-					"Host": host,
-				}
-			}
-
-			streamSettings["wsSettings"] = wsSettings
-
-		case "grpc":
-			streamSettings["network"] = "grpc"
-			grpcSettings := map[string]interface{}{}
-
-			if serviceName, ok := node.Parameters["serviceName"]; ok && serviceName != "" {
-				grpcSettings["serviceName"] = serviceName
-			}
-
-			streamSettings["grpcSettings"] = grpcSettings
-
-		case "h2":
-			streamSettings["network"] = "h2"
-			h2Settings := map[string]interface{}{}
-
-			if path, ok := node.Parameters["path"]; ok && path != "" {
-				h2Settings["path"] = path
-			}
-
-			if host, ok := node.Parameters["host"]; ok && host != "" {
-				h2Settings["host"] = []string{host}
-			}
-
-			streamSettings["httpSettings"] = h2Settings
-		}
-	}
+	// 传输设置
+	applyTransportSettings(streamSettings, node)
 
 	// TLS设置
 	if security, ok := node.Parameters["security"]; ok && security == "tls" {
@@ -482,15 +336,6 @@ func generateStreamSettings(node *types.Node) map[string]interface{} {
 		streamSettings["tlsSettings"] = tlsSettings
 	}
 
-	// TCP头部设置
-	if headerType, ok := node.Parameters["headerType"]; ok {
-		streamSettings["tcpSettings"] = map[string]interface{}{
-			"header": map[string]interface{}{
-				"type": headerType,
-			},
-		}
-	}
-
 	return streamSettings
 }
 
@@ -531,49 +376,8 @@ func generateVmessStreamSettings(node *types.Node) map[string]interface{} {
 		"network": "tcp", // 默认TCP
 	}
 
-	// 检查网络类型
-	if net, ok := node.Parameters["net"]; ok {
-		streamSettings["network"] = net
-
-		switch net {
-		case "ws":
-			wsSettings := map[string]interface{}{}
-
-			if path, ok := node.Parameters["path"]; ok && path != "" {
-				wsSettings["path"] = path
-			}
-
-			if host, ok := node.Parameters["host"]; ok && host != "" {
-				wsSettings["headers"] = map[string]interface{}{
-					"Host": host,
-				}
-			}
-
-			streamSettings["wsSettings"] = wsSettings
-
-		case "h2":
-			h2Settings := map[string]interface{}{}
-
-			if path, ok := node.Parameters["path"]; ok && path != "" {
-				h2Settings["path"] = path
-			}
-
-			if host, ok := node.Parameters["host"]; ok && host != "" {
-				h2Settings["host"] = []string{host}
-			}
-
-			streamSettings["httpSettings"] = h2Settings
-
-		case "grpc":
-			grpcSettings := map[string]interface{}{}
-
-			if serviceName, ok := node.Parameters["serviceName"]; ok && serviceName != "" {
-				grpcSettings["serviceName"] = serviceName
-			}
-
-			streamSettings["grpcSettings"] = grpcSettings
-		}
-	}
+	// 传输设置，vmess 的伪装类型为 type 参数
+	applyTransportSettings(streamSettings, node)
 
 	// TLS设置
 	if tls, ok := node.Parameters["tls"]; ok && tls == "tls" {
@@ -604,6 +408,9 @@ func generateTrojanStreamSettings(node *types.Node) map[string]interface{} {
 		"network": "tcp", // 默认TCP
 	}
 
+	// 传输设置
+	applyTransportSettings(streamSettings, node)
+
 	// Trojan通常使用TLS
 	streamSettings["security"] = "tls"
 	tlsSettings := map[string]interface{}{}
@@ -639,13 +446,22 @@ func (pm *ProxyManager) StartRandomProxy(nodes []*types.Node) error {
 	// 过滤支持的协议
 	supportedNodes := []*types.Node{}
 	for _, node := range nodes {
-		if node.Protocol == "vless" || node.Protocol == "ss" || node.Protocol == "vmess" || node.Protocol == "trojan" {
+		if UnsupportedReason(node) != "" {
+			continue
+		}
+		switch node.Protocol {
+		case "vless", "ss", "vmess", "trojan", "tuic":
 			supportedNodes = append(supportedNodes, node)
+		case "hysteria2":
+			// 首选 sing-box 时Hysteria2节点也由本管理器运行
+			if !UsesHysteria2Client(node) {
+				supportedNodes = append(supportedNodes, node)
+			}
 		}
 	}
 
 	if len(supportedNodes) == 0 {
-		return fmt.Errorf("没有支持的协议节点 (支持VLESS、SS、VMess、Trojan)")
+		return fmt.Errorf("没有支持的协议节点 (支持VLESS、SS、VMess、Trojan、TUIC)")
 	}
 
 	rand.Seed(time.Now().UnixNano())
@@ -678,9 +494,20 @@ func (pm *ProxyManager) StartProxyByIndex(nodes []*types.Node, index int) error
 
 // StartProxy 启动代理
 func (pm *ProxyManager) StartProxy(node *types.Node) error {
-	// 检查V2Ray是否安装
-	if !pm.checkV2RayInstalled() {
-		return fmt.Errorf("V2Ray未安装，请先运行: %s download-v2ray", os.Args[0])
+	return pm.StartProxyWithContext(context.Background(), node)
+}
+
+// StartProxyWithContext 启动代理，主动探测HTTP和SOCKS端口，端口就绪后立即返回；ctx 取消时放弃启动
+func (pm *ProxyManager) StartProxyWithContext(ctx context.Context, node *types.Node) error {
+	if unsupported := UnsupportedReason(node); unsupported != "" {
+		return fmt.Errorf("%s", unsupported)
+	}
+
+	// 选择核心：需要 REALITY、XTLS Vision、xhttp 等特性的节点使用Xray，TUIC节点、
+	// simple-obfs TLS / shadow-tls 插件节点和首选 sing-box 时使用sing-box
+	core, reason := SelectCore(node)
+	if err := pm.ensureCoreInstalled(core); err != nil {
+		return err
 	}
 
 	// 停止现有代理
@@ -688,18 +515,23 @@ func (pm *ProxyManager) StartProxy(node *types.Node) error {
 		pm.StopProxy()
 	}
 
-	// 分配端口（只在端口为0时才重新分配）
-	if pm.HTTPPort == 0 {
-		pm.HTTPPort = findAvailablePort(8080)
-	}
-	if pm.SOCKSPort == 0 {
-		pm.SOCKSPort = findAvailablePort(1080)
+	// 分配端口（只在端口为0时才从共享分配器申请）
+	releasePorts, err := acquireProxyPorts(&pm.HTTPPort, &pm.SOCKSPort, core)
+	if err != nil {
+		return err
 	}
+	// 启动未成功时释放租约并清零端口，避免租约一直占用到过期
+	started := false
+	defer func() {
+		if !started {
+			releasePorts()
+		}
+	}()
 
 	fmt.Fprintf(os.Stderr, "🔧 配置代理端口: HTTP=%d, SOCKS=%d\n", pm.HTTPPort, pm.SOCKSPort)
 
 	// 生成配置
-	config, err := generateV2RayConfig(node, pm.HTTPPort, pm.SOCKSPort)
+	config, err := generateCoreConfig(core, node, pm.HTTPPort, pm.SOCKSPort)
 	if err != nil {
 		return fmt.Errorf("生成配置失败: %v", err)
 	}
@@ -710,88 +542,142 @@ func (pm *ProxyManager) StartProxy(node *types.Node) error {
 		return fmt.Errorf("序列化配置失败: %v", err)
 	}
 
-	err = os.WriteFile(pm.ConfigPath, configJSON, 0644)
+	if pm.ConfigPath == "" {
+		if pm.ConfigPath, err = rundir.TempFile(core, ".json"); err != nil {
+			return err
+		}
+	}
+	err = os.WriteFile(pm.ConfigPath, configJSON, 0600)
 	if err != nil {
 		return fmt.Errorf("保存配置文件失败: %v", err)
 	}
 
-	// 启动V2Ray
-	v2rayPath := "./v2ray/v2ray"
-	if runtime.GOOS == "windows" {
-		v2rayPath = "./v2ray/v2ray.exe"
+	// 核心刚升级时先做冒烟测试，失败自动回滚
+	if err := EnsureCoreHealthy(ctx, core); err != nil {
+		return err
 	}
 
-	if _, err := os.Stat(v2rayPath); os.IsNotExist(err) {
-		v2rayPath = "v2ray" // 尝试系统路径
+	// 路由规则用到 geoip:/geosite: 时需要数据文件
+	if usesRoutingAssets(configJSON) {
+		if err := downloader.EnsureAssets(); err != nil {
+			return fmt.Errorf("路由规则数据文件不可用: %v", err)
+		}
 	}
 
-	pm.V2RayProcess = exec.Command(v2rayPath, "run", "-c", pm.ConfigPath)
+	// 启动核心：档案指定版本 > 仓库当前版本 > 旧版安装 > 系统PATH
+	corePath := downloader.ExecutablePath(core)
+
+	// 启动前重新校验可执行文件
+	if err := downloader.VerifyBinary(corePath); err != nil {
+		return fmt.Errorf("%s核心校验失败: %v", core, err)
+	}
+
+	// Xray、sing-box与V2Ray v5的启动参数相同
+	pm.V2RayProcess = exec.Command(corePath, "run", "-c", pm.ConfigPath)
+	pm.V2RayProcess.Env = append(os.Environ(), downloader.AssetEnv()...)
 	pm.CurrentNode = node
+	pm.Core = core
 
 	// 设置进程组，便于管理
 	platform.SetProcAttributes(pm.V2RayProcess)
 
-	err = pm.V2RayProcess.Start()
+	pm.process, err = lifecycle.StartProcess(pm.V2RayProcess)
 	if err != nil {
 		pm.V2RayProcess = nil
 		pm.CurrentNode = nil
-		return fmt.Errorf("启动V2Ray失败: %v", err)
+		pm.Core = ""
+		return fmt.Errorf("启动%s失败: %v", core, err)
 	}
 
-	// 等待一下确保启动成功
-	time.Sleep(2 * time.Second)
+	// 端口租约随进程退出释放
+	Ports().Attach(pm.HTTPPort, core, pm.process.Pid(), pm.process.Done())
+	Ports().Attach(pm.SOCKSPort, core, pm.process.Pid(), pm.process.Done())
 
-	// 检查进程是否仍在运行
-	if !pm.isV2RayRunning() {
-		pm.V2RayProcess = nil
-		pm.CurrentNode = nil
-		return fmt.Errorf("V2Ray进程启动后意外退出，可能是配置问题")
+	// 等待端口就绪，进程提前退出时立即失败
+	readyCtx, cancel := context.WithTimeout(ctx, startTimeout())
+	defer cancel()
+	if err := lifecycle.WaitPortsReady(readyCtx, pm.process.Done(), pm.HTTPPort, pm.SOCKSPort); err != nil {
+		exited := pm.process.Exited()
+		pm.StopProxy()
+		if exited {
+			return fmt.Errorf("%s进程启动后意外退出，可能是配置问题", core)
+		}
+		return fmt.Errorf("%s启动失败: %v", core, err)
 	}
 
 	fmt.Fprintf(os.Stderr, "✅ 代理启动成功!\n")
 	fmt.Fprintf(os.Stderr, "📡 节点: %s\n", node.Name)
+	if reason != "" {
+		fmt.Fprintf(os.Stderr, "🧩 核心: %s (%s)\n", core, reason)
+	} else {
+		fmt.Fprintf(os.Stderr, "🧩 核心: %s\n", core)
+	}
 	fmt.Fprintf(os.Stderr, "🌐 HTTP代理: http://127.0.0.1:%d\n", pm.HTTPPort)
 	fmt.Fprintf(os.Stderr, "🧦 SOCKS代理: socks5://127.0.0.1:%d\n", pm.SOCKSPort)
 
 	// 保存状态
 	pm.saveState()
 
+	started = true
 	return nil
 }
 
 // StopProxy 停止代理
 func (pm *ProxyManager) StopProxy() error {
-	if pm.V2RayProcess == nil {
+	ctx, cancel := context.WithTimeout(context.Background(), lifecycle.DefaultStopTimeout)
+	defer cancel()
+	return pm.StopProxyWithContext(ctx)
+}
+
+// Detach 让代理在当前程序退出后继续在后台运行（命令行 start-proxy 使用），之后可由 stop-proxy 停止
+func (pm *ProxyManager) Detach() error {
+	if pm.process == nil {
 		return fmt.Errorf("没有运行中的代理")
 	}
+	return pm.process.Detach()
+}
 
-	// 记录要释放的端口
-	httpPortToRelease := pm.HTTPPort
-	socksPortToRelease := pm.SOCKSPort
+// StopDetached 停止之前由 start-proxy 转入后台的V2Ray、Xray和sing-box进程（只供 stop-proxy 命令使用）
+// 其他路径的管理器只停止自己启动的进程，不能误杀用户在后台运行的代理
+func (pm *ProxyManager) StopDetached() error {
+	detached := detachedCores()
+	if len(detached) == 0 {
+		return fmt.Errorf("没有运行中的代理")
+	}
 
-	// 发送终止信号
-	if pm.V2RayProcess.Process != nil {
-		err := pm.V2RayProcess.Process.Signal(syscall.SIGTERM)
-		if err != nil {
-			// 如果温和终止失败，强制杀死
-			pm.V2RayProcess.Process.Kill()
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), lifecycle.DefaultStopTimeout)
+	defer cancel()
+	if err := lifecycle.DefaultRegistry().Terminate(ctx, detached...); err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ %v\n", err)
 	}
 
-	// 等待进程结束
-	pm.V2RayProcess.Wait()
-	pm.V2RayProcess = nil
-	pm.CurrentNode = nil
+	pm.HTTPPort = 0
+	pm.SOCKSPort = 0
+	pm.saveState()
 
-	// 释放端口资源
-	if httpPortToRelease > 0 {
-		releasePort(httpPortToRelease)
+	fmt.Fprintf(os.Stderr, "🛑 代理已停止\n")
+	return nil
+}
+
+// StopProxyWithContext 发送终止信号并等待进程退出，ctx 结束时强制杀死
+// 只停止本管理器启动的进程；后台代理由 StopDetached 停止
+func (pm *ProxyManager) StopProxyWithContext(ctx context.Context) error {
+	if pm.V2RayProcess == nil {
+		return fmt.Errorf("没有运行中的代理")
 	}
-	if socksPortToRelease > 0 && socksPortToRelease != httpPortToRelease {
-		releasePort(socksPortToRelease)
+
+	// 终止进程并等待退出
+	if pm.process != nil {
+		if err := pm.process.Stop(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️ %v\n", err)
+		}
 	}
+	pm.process = nil
+	pm.V2RayProcess = nil
+	pm.CurrentNode = nil
+	pm.Core = ""
 
-	// 重置端口
+	// 重置端口（端口租约已随进程退出释放）
 	pm.HTTPPort = 0
 	pm.SOCKSPort = 0
 
@@ -813,6 +699,7 @@ func (pm *ProxyManager) GetStatus() ProxyStatus {
 		Running:   pm.isV2RayRunning(),
 		HTTPPort:  pm.HTTPPort,
 		SOCKSPort: pm.SOCKSPort,
+		Core:      pm.Core,
 	}
 
 	if pm.CurrentNode != nil {
@@ -827,11 +714,13 @@ func (pm *ProxyManager) GetStatus() ProxyStatus {
 // isV2RayRunning 检查V2Ray进程是否运行
 func (pm *ProxyManager) isV2RayRunning() bool {
 	// 首先检查保存的进程状态
-	if pm.V2RayProcess != nil && pm.V2RayProcess.Process != nil {
-		err := pm.V2RayProcess.Process.Signal(syscall.Signal(0))
-		if err == nil {
-			return true
-		}
+	if pm.process != nil && !pm.process.Exited() {
+		return true
+	}
+
+	// 其次检查由 start-proxy 转入后台的进程
+	if len(detachedCores()) > 0 {
+		return true
 	}
 
 	// 如果进程对象检查失败，则通过端口检查
@@ -876,22 +765,23 @@ func (pm *ProxyManager) IsPortOccupied(port int) bool {
 	return pm.HTTPPort == port || pm.SOCKSPort == port
 }
 
-// checkV2RayInstalled 检查V2Ray是否安装
+// checkV2RayInstalled 检查V2Ray是否安装（版本仓库、旧版安装路径或系统PATH）
 func (pm *ProxyManager) checkV2RayInstalled() bool {
-	paths := []string{"./v2ray/v2ray", "v2ray"}
-	if runtime.GOOS == "windows" {
-		paths = []string{"./v2ray/v2ray.exe", "v2ray"}
+	path := downloader.ExecutablePath(downloader.CoreV2Ray)
+	if _, err := os.Stat(path); err == nil {
+		return true
 	}
+	_, err := exec.LookPath(path)
+	return err == nil
+}
 
-	for _, path := range paths {
-		if _, err := os.Stat(path); err == nil {
-			return true
-		}
-		if _, err := exec.LookPath(path); err == nil {
-			return true
-		}
+// detachedCores 返回由 start-proxy 转入后台的V2Ray、Xray和sing-box进程
+func detachedCores() []lifecycle.Record {
+	var detached []lifecycle.Record
+	for _, core := range []string{downloader.CoreV2Ray, downloader.CoreXray, downloader.CoreSingBox} {
+		detached = append(detached, lifecycle.DefaultRegistry().Detached(core)...)
 	}
-	return false
+	return detached
 }
 
 // ListNodes 列出所有节点（带索引）
@@ -938,7 +828,7 @@ func (pm *ProxyManager) TestProxy() error {
 func (pm *ProxyManager) saveState() error {
 	if pm.CurrentNode == nil {
 		// 删除状态文件
-		os.Remove(StateFile)
+		os.Remove(StateFile())
 		return nil
 	}
 
@@ -949,6 +839,7 @@ func (pm *ProxyManager) saveState() error {
 		Protocol:    pm.CurrentNode.Protocol,
 		Server:      pm.CurrentNode.Server,
 		ConfigPath:  pm.ConfigPath,
+		Core:        pm.Core,
 		LastUpdated: time.Now().Unix(),
 	}
 
@@ -957,12 +848,12 @@ func (pm *ProxyManager) saveState() error {
 		return err
 	}
 
-	return os.WriteFile(StateFile, data, 0644)
+	return os.WriteFile(StateFile(), data, 0644)
 }
 
 // loadState 加载代理状态
 func (pm *ProxyManager) loadState() {
-	data, err := os.ReadFile(StateFile)
+	data, err := os.ReadFile(StateFile())
 	if err != nil {
 		return // 文件不存在或读取失败，忽略
 	}
@@ -974,7 +865,7 @@ func (pm *ProxyManager) loadState() {
 
 	// 检查状态是否过期（超过1小时）
 	if time.Now().Unix()-state.LastUpdated > 3600 {
-		os.Remove(StateFile)
+		os.Remove(StateFile())
 		return
 	}
 
@@ -982,6 +873,7 @@ func (pm *ProxyManager) loadState() {
 	pm.HTTPPort = state.HTTPPort
 	pm.SOCKSPort = state.SOCKSPort
 	pm.ConfigPath = state.ConfigPath
+	pm.Core = state.Core
 
 	// 创建虚拟types.Node对象
 	pm.CurrentNode = &types.Node{
@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"strings"
+
+	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
+)
+
+// transportType 节点的传输方式：vless/trojan 链接使用 type 参数，vmess 使用 net 参数（其 type 为伪装类型）
+func transportType(node *types.Node) string {
+	if node.Protocol == "vmess" {
+		return node.Parameters["net"]
+	}
+	return node.Parameters["type"]
+}
+
+// XrayFeatures 返回节点需要的、V2Ray不支持的Xray特性
+func XrayFeatures(node *types.Node) []string {
+	var features []string
+	if strings.EqualFold(node.Parameters["security"], "reality") {
+		features = append(features, "REALITY")
+	}
+	if flow := node.Parameters["flow"]; strings.HasPrefix(flow, "xtls-") {
+		features = append(features, flow)
+	}
+	switch transportType(node) {
+	case "xhttp", "splithttp":
+		features = append(features, "xhttp")
+	case "httpupgrade":
+		features = append(features, "HTTPUpgrade")
+	}
+	return features
+}
+
+// applyXraySettings 向代理出站写入Xray专有字段
+func applyXraySettings(outbound map[string]interface{}, node *types.Node) {
+	// VLESS 流控，如 xtls-rprx-vision
+	if flow := node.Parameters["flow"]; flow != "" && node.Protocol == "vless" {
+		settings := outbound["settings"].(map[string]interface{})
+		vnext := settings["vnext"].([]map[string]interface{})
+		users := vnext[0]["users"].([]map[string]interface{})
+		users[0]["flow"] = flow
+	}
+
+	streamSettings, ok := outbound["streamSettings"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	if strings.EqualFold(node.Parameters["security"], "reality") {
+		fingerprint := node.Parameters["fp"]
+		if fingerprint == "" {
+			fingerprint = "chrome" // REALITY 必须指定指纹
+		}
+		realitySettings := map[string]interface{}{
+			"serverName":  node.Parameters["sni"],
+			"fingerprint": fingerprint,
+			"publicKey":   node.Parameters["pbk"],
+			"shortId":     node.Parameters["sid"],
+		}
+		if spiderX := node.Parameters["spx"]; spiderX != "" {
+			realitySettings["spiderX"] = spiderX
+		}
+		streamSettings["security"] = "reality"
+		delete(streamSettings, "tlsSettings")
+		streamSettings["realitySettings"] = realitySettings
+	}
+}
@@ -0,0 +1,210 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriteCSV 写出CSV报告（带UTF-8 BOM，便于Excel直接打开中文）
+func WriteCSV(w io.Writer, r *Report) error {
+	if _, err := w.Write([]byte("\xef\xbb\xbf")); err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"name", "protocol", "server", "port", "success", "latency_ms", "speed_mbps", "error", "test_time"})
+	for _, entry := range r.Entries {
+		writer.Write([]string{
+			entry.Name,
+			entry.Protocol,
+			entry.Server,
+			entry.Port,
+			strconv.FormatBool(entry.Success),
+			strconv.FormatInt(entry.LatencyMs, 10),
+			strconv.FormatFloat(entry.SpeedMbps, 'f', 2, 64),
+			entry.Error,
+			formatTime(entry.TestTime),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// junitTestSuites JUnit XML根节点
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Time     string           `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+// junitTestSuite JUnit测试套件（按协议分组）
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+// junitTestCase JUnit测试用例（一个节点）
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+// junitFailure JUnit失败信息
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit 写出JUnit XML报告，每个协议一个testsuite，每个节点一个testcase
+func WriteJUnit(w io.Writer, r *Report) error {
+	suites := junitTestSuites{
+		Name:     r.Title,
+		Tests:    len(r.Entries),
+		Failures: r.FailureCount(),
+		Time:     formatSeconds(r.Duration()),
+	}
+
+	suiteIndex := make(map[string]int)
+	var suiteTimes []time.Duration
+	for _, entry := range r.Entries {
+		protocol := entry.Protocol
+		if protocol == "" {
+			protocol = "unknown"
+		}
+		index, ok := suiteIndex[protocol]
+		if !ok {
+			index = len(suites.Suites)
+			suiteIndex[protocol] = index
+			suites.Suites = append(suites.Suites, junitTestSuite{
+				Name:      protocol,
+				Timestamp: formatTime(r.StartTime),
+			})
+			suiteTimes = append(suiteTimes, 0)
+		}
+		suite := &suites.Suites[index]
+
+		latency := time.Duration(entry.LatencyMs) * time.Millisecond
+		suiteTimes[index] += latency
+		testCase := junitTestCase{
+			Name:      entry.Name,
+			Classname: fmt.Sprintf("%s.%s", protocol, strings.ReplaceAll(entry.Server, ".", "_")),
+			Time:      formatSeconds(latency),
+			SystemOut: fmt.Sprintf("server=%s:%s latency_ms=%d speed_mbps=%.2f", entry.Server, entry.Port, entry.LatencyMs, entry.SpeedMbps),
+		}
+		// 代理链节点附加每一跳的状态，如 hop.front=中转(ok,35ms)
+		for _, hop := range entry.Hops {
+			status := "fail"
+			if hop.Success {
+				status = fmt.Sprintf("ok,%dms", hop.Latency)
+			}
+			testCase.SystemOut += fmt.Sprintf(" hop.%s=%s(%s)", hop.Role, hop.Name, status)
+		}
+		if !entry.Success {
+			testCase.Failure = &junitFailure{
+				Message: entry.Error,
+				Type:    "NodeTestFailure",
+				Text:    entry.Error,
+			}
+			suite.Failures++
+		}
+
+		suite.Tests++
+		suite.Cases = append(suite.Cases, testCase)
+	}
+
+	for i := range suites.Suites {
+		suites.Suites[i].Time = formatSeconds(suiteTimes[i])
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteMarkdown 写出Markdown报告
+func WriteMarkdown(w io.Writer, r *Report) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# %s\n\n", escapeMarkdown(r.Title))
+	if r.SubscriptionURL != "" {
+		fmt.Fprintf(&b, "- 订阅链接: %s\n", escapeMarkdown(r.SubscriptionURL))
+	}
+	if r.TestURL != "" {
+		fmt.Fprintf(&b, "- 测试目标: %s\n", escapeMarkdown(r.TestURL))
+	}
+	fmt.Fprintf(&b, "- 测试时间: %s\n", formatTime(r.StartTime))
+	fmt.Fprintf(&b, "- 节点总数: %d（成功 %d，失败 %d）\n\n", len(r.Entries), r.SuccessCount(), r.FailureCount())
+
+	fmt.Fprintf(&b, "| # | 节点名称 | 协议 | 服务器 | 结果 | 延迟(ms) | 速度(Mbps) | 错误 |\n")
+	fmt.Fprintf(&b, "|---|---|---|---|---|---:|---:|---|\n")
+	for i, entry := range r.Entries {
+		status := "✅"
+		if !entry.Success {
+			status = "❌"
+		}
+		fmt.Fprintf(&b, "| %d | %s | %s | %s | %s | %d | %.2f | %s |\n",
+			i+1,
+			escapeMarkdown(entry.Name),
+			entry.Protocol,
+			escapeMarkdown(entry.Server+":"+entry.Port),
+			status,
+			entry.LatencyMs,
+			entry.SpeedMbps,
+			escapeMarkdown(entry.Error),
+		)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON 写出JSON报告
+func WriteJSON(w io.Writer, r *Report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// escapeMarkdown 转义Markdown表格中的特殊字符
+func escapeMarkdown(value string) string {
+	value = strings.ReplaceAll(value, "|", "\\|")
+	value = strings.ReplaceAll(value, "\n", " ")
+	return value
+}
+
+// formatTime 格式化时间，零值返回空字符串
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// formatSeconds 格式化为JUnit使用的秒数
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
@@ -0,0 +1,141 @@
+package report
+
+import (
+	"html/template"
+	"io"
+	"sort"
+)
+
+// htmlChartBar 延迟图表中的一根柱子
+type htmlChartBar struct {
+	Name      string
+	LatencyMs int64
+	Y         int
+	Width     int
+}
+
+// htmlReportData HTML模板数据
+type htmlReportData struct {
+	*Report
+	Success     int
+	Failure     int
+	Bars        []htmlChartBar
+	ChartHeight int
+}
+
+// 图表最多展示的节点数
+const htmlChartMaxBars = 30
+
+// WriteHTML 写出自包含的HTML报告（内联样式和脚本，表格可点击表头排序，附延迟柱状图）
+func WriteHTML(w io.Writer, r *Report) error {
+	data := htmlReportData{
+		Report:  r,
+		Success: r.SuccessCount(),
+		Failure: r.FailureCount(),
+	}
+
+	// 成功节点按延迟升序绘制柱状图
+	var successEntries []Entry
+	for _, entry := range r.Entries {
+		if entry.Success && entry.LatencyMs > 0 {
+			successEntries = append(successEntries, entry)
+		}
+	}
+	sort.Slice(successEntries, func(i, j int) bool {
+		return successEntries[i].LatencyMs < successEntries[j].LatencyMs
+	})
+	if len(successEntries) > htmlChartMaxBars {
+		successEntries = successEntries[:htmlChartMaxBars]
+	}
+
+	var maxLatency int64
+	for _, entry := range successEntries {
+		if entry.LatencyMs > maxLatency {
+			maxLatency = entry.LatencyMs
+		}
+	}
+	for i, entry := range successEntries {
+		width := 1
+		if maxLatency > 0 {
+			width = int(entry.LatencyMs * 560 / maxLatency)
+			if width < 1 {
+				width = 1
+			}
+		}
+		data.Bars = append(data.Bars, htmlChartBar{
+			Name:      entry.Name,
+			LatencyMs: entry.LatencyMs,
+			Y:         i * 22,
+			Width:     width,
+		})
+	}
+	data.ChartHeight = len(data.Bars) * 22
+
+	return htmlTemplate.Execute(w, data)
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"add":        func(a, b int) int { return a + b },
+	"formatTime": formatTime,
+}).Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body{font-family:-apple-system,"Segoe UI","PingFang SC","Microsoft YaHei",sans-serif;margin:24px;color:#222}
+h1{font-size:22px}
+.summary span{display:inline-block;margin-right:18px}
+.ok{color:#1a7f37}.fail{color:#cf222e}
+table{border-collapse:collapse;width:100%;margin-top:16px;font-size:13px}
+th,td{border:1px solid #d0d7de;padding:6px 8px;text-align:left}
+th{background:#f6f8fa;cursor:pointer;user-select:none}
+th.asc::after{content:" ▲"}th.desc::after{content:" ▼"}
+td.num{text-align:right;font-variant-numeric:tabular-nums}
+svg text{font-size:11px}
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="summary">
+{{if .SubscriptionURL}}<div>订阅链接: {{.SubscriptionURL}}</div>{{end}}
+{{if .TestURL}}<div>测试目标: {{.TestURL}}</div>{{end}}
+<div>测试时间: {{formatTime .StartTime}}</div>
+<span>节点总数: {{len .Entries}}</span><span class="ok">成功: {{.Success}}</span><span class="fail">失败: {{.Failure}}</span>
+</div>
+{{if .Bars}}
+<h2>延迟分布（最快 {{len .Bars}} 个节点）</h2>
+<svg width="900" height="{{.ChartHeight}}" role="img">
+{{range .Bars}}<g transform="translate(0,{{.Y}})"><text x="0" y="14">{{.Name}}</text><rect x="260" y="3" width="{{.Width}}" height="16" fill="#0969da"></rect><text x="{{add .Width 266}}" y="15">{{.LatencyMs}}ms</text></g>
+{{end}}</svg>
+{{end}}
+<h2>测试结果</h2>
+<table id="results">
+<thead><tr><th data-type="num">#</th><th>节点名称</th><th>协议</th><th>服务器</th><th>结果</th><th data-type="num">延迟(ms)</th><th data-type="num">速度(Mbps)</th><th>错误</th></tr></thead>
+<tbody>
+{{range $i, $e := .Entries}}<tr><td class="num">{{add $i 1}}</td><td>{{$e.Name}}</td><td>{{$e.Protocol}}</td><td>{{$e.Server}}:{{$e.Port}}</td><td class="{{if $e.Success}}ok{{else}}fail{{end}}">{{if $e.Success}}成功{{else}}失败{{end}}</td><td class="num">{{$e.LatencyMs}}</td><td class="num">{{printf "%.2f" $e.SpeedMbps}}</td><td>{{$e.Error}}</td></tr>
+{{end}}</tbody>
+</table>
+<script>
+(function(){
+  var table=document.getElementById("results");
+  var headers=table.tHead.rows[0].cells;
+  for(var i=0;i<headers.length;i++){(function(col){
+    headers[col].addEventListener("click",function(){
+      var th=headers[col],asc=!th.classList.contains("asc"),numeric=th.getAttribute("data-type")==="num";
+      for(var j=0;j<headers.length;j++){headers[j].classList.remove("asc","desc");}
+      th.classList.add(asc?"asc":"desc");
+      var body=table.tBodies[0],rows=Array.prototype.slice.call(body.rows);
+      rows.sort(function(a,b){
+        var x=a.cells[col].textContent,y=b.cells[col].textContent;
+        var d=numeric?parseFloat(x)-parseFloat(y):x.localeCompare(y);
+        return asc?d:-d;
+      });
+      rows.forEach(function(r){body.appendChild(r);});
+    });
+  })(i);}
+})();
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,183 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
+)
+
+// 支持的报告格式
+const (
+	FormatCSV      = "csv"
+	FormatJUnit    = "junit"
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+	FormatJSON     = "json"
+)
+
+// Entry 单个节点的测试记录
+type Entry struct {
+	Name      string    `json:"name"`
+	Protocol  string    `json:"protocol"`
+	Server    string    `json:"server"`
+	Port      string    `json:"port"`
+	Success   bool      `json:"success"`
+	LatencyMs int64     `json:"latency_ms"`
+	SpeedMbps float64   `json:"speed_mbps"`
+	Error     string    `json:"error,omitempty"`
+	TestTime  time.Time `json:"test_time"`
+
+	Hops []types.HopResult `json:"hops,omitempty"` // 代理链节点每一跳的状态
+}
+
+// Report 一次测速/批量测试的报告数据
+type Report struct {
+	Title           string    `json:"title"`
+	SubscriptionURL string    `json:"subscription_url,omitempty"`
+	TestURL         string    `json:"test_url,omitempty"`
+	StartTime       time.Time `json:"start_time"`
+	EndTime         time.Time `json:"end_time"`
+	Entries         []Entry   `json:"entries"`
+}
+
+// SuccessCount 成功节点数
+func (r *Report) SuccessCount() int {
+	count := 0
+	for _, entry := range r.Entries {
+		if entry.Success {
+			count++
+		}
+	}
+	return count
+}
+
+// FailureCount 失败节点数
+func (r *Report) FailureCount() int {
+	return len(r.Entries) - r.SuccessCount()
+}
+
+// Duration 测试总耗时
+func (r *Report) Duration() time.Duration {
+	if r.StartTime.IsZero() || r.EndTime.Before(r.StartTime) {
+		return 0
+	}
+	return r.EndTime.Sub(r.StartTime)
+}
+
+// Writer 报告写入函数
+type Writer func(w io.Writer, r *Report) error
+
+var writers = map[string]Writer{
+	FormatCSV:      WriteCSV,
+	FormatJUnit:    WriteJUnit,
+	FormatMarkdown: WriteMarkdown,
+	FormatHTML:     WriteHTML,
+	FormatJSON:     WriteJSON,
+}
+
+// 格式别名
+var aliases = map[string]string{
+	"xml":       FormatJUnit,
+	"md":        FormatMarkdown,
+	"htm":       FormatHTML,
+	"junit-xml": FormatJUnit,
+}
+
+// Formats 获取所有支持的格式
+func Formats() []string {
+	return []string{FormatCSV, FormatJUnit, FormatMarkdown, FormatHTML, FormatJSON}
+}
+
+// NormalizeFormat 规范化格式名称
+func NormalizeFormat(format string) (string, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if alias, ok := aliases[format]; ok {
+		format = alias
+	}
+	if _, ok := writers[format]; !ok {
+		return "", fmt.Errorf("不支持的报告格式: %s (支持: %s)", format, strings.Join(Formats(), ", "))
+	}
+	return format, nil
+}
+
+// ParseFormats 解析逗号分隔的格式列表
+func ParseFormats(value string) ([]string, error) {
+	var formats []string
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(value, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		format, err := NormalizeFormat(part)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[format] {
+			seen[format] = true
+			formats = append(formats, format)
+		}
+	}
+	return formats, nil
+}
+
+// Extension 获取格式对应的文件扩展名
+func Extension(format string) string {
+	switch format {
+	case FormatJUnit:
+		return ".xml"
+	case FormatMarkdown:
+		return ".md"
+	default:
+		return "." + format
+	}
+}
+
+// ContentType 获取格式对应的HTTP Content-Type
+func ContentType(format string) string {
+	switch format {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatJUnit:
+		return "application/xml; charset=utf-8"
+	case FormatMarkdown:
+		return "text/markdown; charset=utf-8"
+	case FormatHTML:
+		return "text/html; charset=utf-8"
+	default:
+		return "application/json; charset=utf-8"
+	}
+}
+
+// Write 以指定格式写出报告
+func Write(w io.Writer, format string, r *Report) error {
+	format, err := NormalizeFormat(format)
+	if err != nil {
+		return err
+	}
+	return writers[format](w, r)
+}
+
+// WriteFile 以指定格式写出报告到文件
+func WriteFile(path, format string, r *Report) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("创建报告目录失败: %v", err)
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("创建报告文件失败: %v", err)
+	}
+	defer file.Close()
+
+	if err := Write(file, format, r); err != nil {
+		return fmt.Errorf("写入%s报告失败: %v", format, err)
+	}
+	return nil
+}
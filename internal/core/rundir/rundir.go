@@ -0,0 +1,188 @@
+// Package rundir 管理运行目录：核心配置、控制通道和进程登记都放在这里，不再写入当前工作目录
+// 运行目录可能位于 tmpfs（$XDG_RUNTIME_DIR），重启或注销后清空；需要持久保存的状态放在 statedir
+//
+// 目录布局：
+//
+//	<运行目录>/*.sock, proxy_state.json  控制通道和后台代理的状态（如 auto_proxy_ctl.sock）
+//	<运行目录>/procs/              核心进程登记（见 lifecycle.Registry）
+//	<运行目录>/sessions/<pid>/     每个管理程序进程自己的临时文件（核心配置等），进程退出时整个删除
+//
+// 清理只删除本进程的会话目录和明确知道名字的状态文件，从不按通配符匹配删除。
+package rundir
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/yxhpy/v2ray-subscription-manager/internal/platform"
+)
+
+// appName 运行目录名称
+const appName = "v2ray-manager"
+
+var (
+	mutex    sync.RWMutex
+	current  string
+	verified string // 已通过 Ensure 检查的运行目录
+	counter  uint64
+)
+
+// Default 默认运行目录（遵循XDG规范）：
+// 优先 $XDG_RUNTIME_DIR/v2ray-manager；Windows和macOS使用用户缓存目录下的 v2ray-manager/run；
+// 其他情况使用系统临时目录下按用户隔离的 v2ray-manager-<用户名>
+func Default() string {
+	if runtime.GOOS != "windows" {
+		if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+			return filepath.Join(dir, appName)
+		}
+	}
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		if dir, err := os.UserCacheDir(); err == nil {
+			return filepath.Join(dir, appName, "run")
+		}
+	}
+
+	name := appName
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		name += "-" + sanitizeName(u.Username)
+	}
+	return filepath.Join(os.TempDir(), name)
+}
+
+// Dir 返回当前运行目录
+func Dir() string {
+	mutex.RLock()
+	dir := current
+	mutex.RUnlock()
+	if dir == "" {
+		return Default()
+	}
+	return dir
+}
+
+// Set 设置运行目录（命令行 --runtime-dir），空字符串恢复默认值
+func Set(dir string) error {
+	if dir != "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return fmt.Errorf("无效的运行目录 %s: %v", dir, err)
+		}
+		dir = abs
+	}
+
+	mutex.Lock()
+	current = dir
+	mutex.Unlock()
+	return nil
+}
+
+// Ensure 创建运行目录（仅当前用户可访问），并确认它没有被其他用户抢先创建：
+// 目录不能是符号链接，属主必须是当前用户，权限必须恰好为 0700。
+// 默认目录在系统临时目录下时路径可以预测，检查不通过时必须停止，不能继续使用
+func Ensure() error {
+	dir := Dir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("创建运行目录失败: %v", err)
+	}
+	if err := platform.CheckPrivateDir(dir); err != nil {
+		return fmt.Errorf("运行目录不安全: %v", err)
+	}
+
+	mutex.Lock()
+	verified = dir
+	mutex.Unlock()
+	return nil
+}
+
+// ensured 确保当前运行目录已通过 Ensure 检查；主程序启动时已调用 Ensure 的情况下不会重复检查
+func ensured() (string, error) {
+	dir := Dir()
+	mutex.RLock()
+	ok := verified == dir
+	mutex.RUnlock()
+	if ok {
+		return dir, nil
+	}
+	if err := Ensure(); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// File 返回运行目录下的路径，不检查也不创建目录（用于默认值，主程序启动时已调用 Ensure）
+func File(elem ...string) string {
+	return filepath.Join(append([]string{Dir()}, elem...)...)
+}
+
+// Path 返回运行目录下的路径，并确保运行目录安全、其所在目录存在
+func Path(elem ...string) (string, error) {
+	dir, err := ensured()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(append([]string{dir}, elem...)...)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", fmt.Errorf("创建目录失败: %v", err)
+	}
+	return path, nil
+}
+
+// SessionDir 返回本进程的会话目录路径（不创建）
+func SessionDir() string {
+	return sessionDir(os.Getpid())
+}
+
+// TempFile 在本进程的会话目录下生成一个唯一的文件路径，如 TempFile("v2ray", ".json") -> sessions/<pid>/v2ray-3.json
+// 只生成路径不创建文件；文件随会话目录一起清理
+func TempFile(prefix, ext string) (string, error) {
+	n := atomic.AddUint64(&counter, 1)
+	return Path("sessions", strconv.Itoa(os.Getpid()), fmt.Sprintf("%s-%d%s", prefix, n, ext))
+}
+
+// CleanupSession 删除本进程的会话目录
+func CleanupSession() error {
+	return os.RemoveAll(sessionDir(os.Getpid()))
+}
+
+// ReapSessions 删除进程已不存在的会话目录（管理程序崩溃后遗留），返回删除的数量
+func ReapSessions() int {
+	entries, err := os.ReadDir(filepath.Join(Dir(), "sessions"))
+	if err != nil {
+		return 0
+	}
+
+	reaped := 0
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() || pid == os.Getpid() || platform.ProcessAlive(pid) {
+			continue
+		}
+		if err := os.RemoveAll(sessionDir(pid)); err == nil {
+			reaped++
+		}
+	}
+	return reaped
+}
+
+// sessionDir 返回指定进程的会话目录
+func sessionDir(pid int) string {
+	return filepath.Join(Dir(), "sessions", strconv.Itoa(pid))
+}
+
+// sanitizeName 去掉用户名中不适合作为路径的字符（如Windows的 DOMAIN\user）
+func sanitizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '\\', '/', ':', ' ':
+			return '_'
+		}
+		return r
+	}, name)
+}
@@ -0,0 +1,234 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Syntax 调度表达式说明，供命令行和Web UI展示
+const Syntax = `10m                     每10分钟（等同于 @every 10m）
+@every 2m               每2分钟
+@hourly / @daily / @weekly
+0 3 * * *               每天03:00（分 时 日 月 周）
+*/2 9-18 * * 1-5        工作日9点到18点每2分钟`
+
+// Schedule 调度计划
+type Schedule interface {
+	// Next 返回晚于 after 的下一次触发时间
+	Next(after time.Time) time.Time
+	// String 返回原始表达式
+	String() string
+}
+
+// everySchedule 固定间隔
+type everySchedule struct {
+	spec     string
+	interval time.Duration
+}
+
+func (s *everySchedule) Next(after time.Time) time.Time {
+	return after.Add(s.interval)
+}
+
+func (s *everySchedule) String() string {
+	return s.spec
+}
+
+// Every 创建固定间隔的调度计划
+func Every(interval time.Duration) Schedule {
+	return &everySchedule{spec: "@every " + interval.String(), interval: interval}
+}
+
+// cronSchedule 五段式cron表达式，每段用位图表示允许的取值
+type cronSchedule struct {
+	spec    string
+	minute  uint64
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	domStar bool
+	dowStar bool
+}
+
+// field cron字段的取值范围
+type field struct {
+	name     string
+	min, max int
+}
+
+var fields = []field{
+	{"分钟", 0, 59},
+	{"小时", 0, 23},
+	{"日", 1, 31},
+	{"月", 1, 12},
+	{"星期", 0, 7},
+}
+
+// descriptors 预定义的表达式
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse 解析调度表达式：Go时长（如 10m）、@every 时长、预定义表达式或五段式cron
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, fmt.Errorf("调度表达式为空")
+	}
+
+	if strings.HasPrefix(spec, "@every") {
+		interval, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every")))
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("无效的间隔: %s", spec)
+		}
+		return &everySchedule{spec: spec, interval: interval}, nil
+	}
+	if interval, err := time.ParseDuration(spec); err == nil {
+		if interval <= 0 {
+			return nil, fmt.Errorf("无效的间隔: %s", spec)
+		}
+		return &everySchedule{spec: spec, interval: interval}, nil
+	}
+
+	expr := spec
+	if strings.HasPrefix(spec, "@") {
+		var ok bool
+		if expr, ok = descriptors[spec]; !ok {
+			return nil, fmt.Errorf("未知的预定义表达式: %s", spec)
+		}
+	}
+
+	parts := strings.Fields(expr)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("cron表达式需要5段（分 时 日 月 周）: %s", spec)
+	}
+
+	s := &cronSchedule{spec: spec}
+	targets := []*uint64{&s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+	for i, part := range parts {
+		bits, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, err
+		}
+		*targets[i] = bits
+	}
+
+	// 星期的7等同于0（周日）
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = parts[2] == "*"
+	s.dowStar = parts[4] == "*"
+	return s, nil
+}
+
+// MustParse 解析调度表达式，失败时panic（用于内置默认值）
+func MustParse(spec string) Schedule {
+	s, err := Parse(spec)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// parseField 解析单个字段，支持 *、a-b、a-b/n、*/n 和逗号分隔的列表
+func parseField(part string, f field) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(part, ",") {
+		step := 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("%s字段的步长无效: %s", f.name, item)
+			}
+			step = n
+			item = item[:i]
+		}
+
+		start, end := f.min, f.max
+		switch {
+		case item == "*":
+		case strings.Contains(item, "-"):
+			bounds := strings.SplitN(item, "-", 2)
+			var err1, err2 error
+			start, err1 = strconv.Atoi(bounds[0])
+			end, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return 0, fmt.Errorf("%s字段的范围无效: %s", f.name, item)
+			}
+		default:
+			n, err := strconv.Atoi(item)
+			if err != nil {
+				return 0, fmt.Errorf("%s字段的取值无效: %s", f.name, item)
+			}
+			start, end = n, n
+			if step > 1 {
+				end = f.max
+			}
+		}
+
+		if start < f.min || end > f.max || start > end {
+			return 0, fmt.Errorf("%s字段超出范围 %d-%d: %s", f.name, f.min, f.max, item)
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (s *cronSchedule) String() string {
+	return s.spec
+}
+
+// Next 逐级查找下一次匹配的时间（按本地时区），最多向后查找5年
+func (s *cronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// dayMatches 日和星期都有限制时满足其一即可（与标准cron一致）
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case s.domStar && s.dowStar:
+		return true
+	case s.domStar:
+		return dowMatch
+	case s.dowStar:
+		return domMatch
+	default:
+		return domMatch || dowMatch
+	}
+}
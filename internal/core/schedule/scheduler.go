@@ -0,0 +1,188 @@
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job 调度任务
+type Job struct {
+	Name     string
+	Schedule Schedule
+	Run      func()
+	next     time.Time
+}
+
+// JobInfo 任务状态，用于展示
+type JobInfo struct {
+	Name    string    `json:"name"`
+	Spec    string    `json:"spec"`
+	NextRun time.Time `json:"next_run"`
+	LastRun time.Time `json:"last_run,omitempty"`
+}
+
+// Scheduler 调度引擎：按各任务的调度计划依次触发，同一时刻只运行一个任务
+// 可以由 Run 驱动，也可以由调用方在自己的事件循环中使用 Timer/Fire 驱动
+type Scheduler struct {
+	jobs    map[string]*Job
+	lastRun map[string]time.Time
+	changed chan struct{}
+	mutex   sync.Mutex
+}
+
+// NewScheduler 创建调度引擎
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		jobs:    make(map[string]*Job),
+		lastRun: make(map[string]time.Time),
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// Set 添加或替换任务，spec 为空时移除任务
+func (s *Scheduler) Set(name, spec string, run func()) error {
+	if spec == "" {
+		s.Remove(name)
+		return nil
+	}
+
+	schedule, err := Parse(spec)
+	if err != nil {
+		return fmt.Errorf("解析调度表达式失败: %v", err)
+	}
+	s.SetSchedule(name, schedule, run)
+	return nil
+}
+
+// SetSchedule 使用已解析的调度计划添加或替换任务
+func (s *Scheduler) SetSchedule(name string, schedule Schedule, run func()) {
+	s.mutex.Lock()
+	s.jobs[name] = &Job{
+		Name:     name,
+		Schedule: schedule,
+		Run:      run,
+		next:     schedule.Next(time.Now()),
+	}
+	s.mutex.Unlock()
+	s.notify()
+}
+
+// Remove 移除任务
+func (s *Scheduler) Remove(name string) {
+	s.mutex.Lock()
+	_, ok := s.jobs[name]
+	delete(s.jobs, name)
+	s.mutex.Unlock()
+	if ok {
+		s.notify()
+	}
+}
+
+// Spec 返回任务的调度表达式，任务不存在时返回空字符串
+func (s *Scheduler) Spec(name string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if job, ok := s.jobs[name]; ok {
+		return job.Schedule.String()
+	}
+	return ""
+}
+
+// Jobs 返回所有任务的状态，按下一次运行时间排序
+func (s *Scheduler) Jobs() []JobInfo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	infos := make([]JobInfo, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		infos = append(infos, JobInfo{
+			Name:    job.Name,
+			Spec:    job.Schedule.String(),
+			NextRun: job.next,
+			LastRun: s.lastRun[job.Name],
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].NextRun.Before(infos[j].NextRun)
+	})
+	return infos
+}
+
+// Next 返回最早到期的任务及其运行时间，没有任务时返回nil
+func (s *Scheduler) Next() (*Job, time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var next *Job
+	for _, job := range s.jobs {
+		if job.next.IsZero() {
+			continue
+		}
+		if next == nil || job.next.Before(next.next) {
+			next = job
+		}
+	}
+	if next == nil {
+		return nil, time.Time{}
+	}
+	return next, next.next
+}
+
+// Timer 返回一个在最早的任务到期时触发的通道及对应任务；没有任务时通道永不触发
+// 调用方需要在不再等待时调用返回的 stop 函数
+func (s *Scheduler) Timer() (<-chan time.Time, *Job, func()) {
+	job, at := s.Next()
+	if job == nil {
+		return nil, nil, func() {}
+	}
+	timer := time.NewTimer(time.Until(at))
+	return timer.C, job, func() { timer.Stop() }
+}
+
+// Changed 任务被添加、替换或移除时收到通知，等待中的调用方应重新调用 Timer
+func (s *Scheduler) Changed() <-chan struct{} {
+	return s.changed
+}
+
+// Fire 运行任务并计算下一次运行时间；任务在运行前已被替换或移除时不运行
+func (s *Scheduler) Fire(job *Job) {
+	now := time.Now()
+
+	s.mutex.Lock()
+	if s.jobs[job.Name] != job {
+		s.mutex.Unlock()
+		return
+	}
+	job.next = job.Schedule.Next(now)
+	s.lastRun[job.Name] = now
+	s.mutex.Unlock()
+
+	job.Run()
+}
+
+// Run 在当前goroutine中按调度运行任务，直到ctx取消
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		fire, job, stop := s.Timer()
+		select {
+		case <-fire:
+			s.Fire(job)
+		case <-s.changed:
+		case <-ctx.Done():
+			stop()
+			return
+		}
+		stop()
+	}
+}
+
+// notify 通知等待中的调用方任务已变化
+func (s *Scheduler) notify() {
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,94 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window 每天的一个时间段，End 早于 Start 时表示跨越午夜（如 23:00-07:00）
+type Window struct {
+	Start int // 从0点开始的分钟数
+	End   int
+}
+
+// Contains 时间是否落在时间段内（包含开始，不包含结束）
+func (w Window) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.Start <= w.End {
+		return minute >= w.Start && minute < w.End
+	}
+	return minute >= w.Start || minute < w.End
+}
+
+// String 返回 HH:MM-HH:MM 格式
+func (w Window) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.Start/60, w.Start%60, w.End/60, w.End%60)
+}
+
+// Windows 多个时间段，如静默时段
+type Windows []Window
+
+// ParseWindows 解析逗号分隔的时间段，如 "23:00-07:00,12:00-13:30"，空字符串表示没有时间段
+func ParseWindows(spec string) (Windows, error) {
+	var windows Windows
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		bounds := strings.SplitN(item, "-", 2)
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("时间段格式应为 HH:MM-HH:MM: %s", item)
+		}
+		start, err := parseClock(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseClock(bounds[1])
+		if err != nil {
+			return nil, err
+		}
+		if start == end {
+			return nil, fmt.Errorf("时间段的开始和结束不能相同: %s", item)
+		}
+		windows = append(windows, Window{Start: start, End: end})
+	}
+	return windows, nil
+}
+
+// Contains 时间是否落在任一时间段内
+func (ws Windows) Contains(t time.Time) bool {
+	for _, w := range ws {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// String 返回逗号分隔的时间段
+func (ws Windows) String() string {
+	parts := make([]string, len(ws))
+	for i, w := range ws {
+		parts[i] = w.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseClock 解析 HH:MM，24:00 表示一天结束
+func parseClock(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	var hour, minute int
+	if _, err := fmt.Sscanf(value, "%d:%d", &hour, &minute); err != nil {
+		return 0, fmt.Errorf("无效的时间: %s", value)
+	}
+	if hour == 24 && minute == 0 {
+		return 24 * 60, nil
+	}
+	if hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return 0, fmt.Errorf("无效的时间: %s", value)
+	}
+	return hour*60 + minute, nil
+}
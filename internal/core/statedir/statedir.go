@@ -0,0 +1,46 @@
+// Package statedir 管理持久状态目录：重启后仍需保留的状态快照、有效节点列表、节点测试历史和黑名单放在这里
+//
+// 运行目录（rundir）可能位于 tmpfs，只存放控制socket、进程登记和会话临时文件；
+// 持久状态与配置文件、cores.lock 放在一起，不随工作目录变化，多个工作目录启动的程序共用同一份数据
+package statedir
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// appName 状态目录名称
+const appName = "v2ray-manager"
+
+// Dir 返回持久状态目录：优先 $XDG_STATE_HOME/v2ray-manager，否则为用户配置目录下的 v2ray-manager
+// （与默认配置文件同一目录）；无法确定用户目录时使用工作目录的绝对路径
+func Dir() string {
+	if runtime.GOOS != "windows" {
+		if dir := os.Getenv("XDG_STATE_HOME"); dir != "" && filepath.IsAbs(dir) {
+			return filepath.Join(dir, appName)
+		}
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, appName)
+	}
+	if abs, err := filepath.Abs("."); err == nil {
+		return abs
+	}
+	return "."
+}
+
+// File 返回状态目录下的文件路径，不创建目录（写入方负责创建所在目录）
+func File(name string) string {
+	return filepath.Join(Dir(), name)
+}
+
+// Path 返回状态目录下的文件路径，并确保状态目录存在
+func Path(name string) (string, error) {
+	dir := Dir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("创建状态目录失败: %v", err)
+	}
+	return filepath.Join(dir, name), nil
+}
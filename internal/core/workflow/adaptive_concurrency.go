@@ -0,0 +1,230 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yxhpy/v2ray-subscription-manager/internal/platform"
+)
+
+// AdaptiveLimiter AIMD自适应并发控制器
+// 每完成一个窗口（窗口大小等于当前并发上限）的测试后评估一次：
+// 超时率过高时并发减半（乘性减小），延迟稳定且主机仍有空闲端口和CPU时并发加一（加性增大）。
+// MVP测试器、测速工作流和Web UI批量测试共用此控制器
+type AdaptiveLimiter struct {
+	mutex    sync.Mutex
+	limit    float64
+	minLimit int
+	maxLimit int
+	inFlight int
+	notify   chan struct{}
+
+	// 当前评估窗口的统计
+	windowDone     int
+	windowTimeouts int
+
+	// 延迟统计
+	ewmaLatency     time.Duration
+	baselineLatency time.Duration
+
+	timeoutRatio     float64     // 超时率达到此值时减小并发
+	latencyTolerance float64     // 平均延迟超过基线的倍数时不再增加并发
+	portCheck        func() bool // 检查主机是否仍有空闲端口
+}
+
+// NewAdaptiveLimiter 创建自适应并发控制器，initial和max小于等于0时使用平台默认值；
+// max 是硬上限（通常为用户配置的并发数），initial 超过 max 时从 max 起步
+func NewAdaptiveLimiter(initial, max int) *AdaptiveLimiter {
+	defaultInitial, defaultMax := DefaultConcurrencyBounds()
+	if initial <= 0 {
+		initial = defaultInitial
+	}
+	if max <= 0 {
+		max = defaultMax
+	}
+	if initial > max {
+		initial = max
+	}
+
+	return &AdaptiveLimiter{
+		limit:            float64(initial),
+		minLimit:         1,
+		maxLimit:         max,
+		notify:           make(chan struct{}),
+		timeoutRatio:     0.3,
+		latencyTolerance: 1.5,
+		portCheck:        hasFreeLocalPort,
+	}
+}
+
+// DefaultConcurrencyBounds 获取平台相关的默认初始并发数和并发上限
+func DefaultConcurrencyBounds() (int, int) {
+	if runtime.GOOS == "windows" {
+		// Windows下进程创建和端口释放都较慢，保守起步并限制上限
+		return 1, 4
+	}
+
+	max := runtime.NumCPU() * 2
+	if max < 4 {
+		max = 4
+	}
+	if max > 32 {
+		max = 32
+	}
+	return 2, max
+}
+
+// SetMinLimit 设置并发下限
+func (l *AdaptiveLimiter) SetMinLimit(min int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if min > 0 && min <= l.maxLimit {
+		l.minLimit = min
+		if l.limit < float64(min) {
+			l.limit = float64(min)
+		}
+	}
+}
+
+// SetPortCheck 设置空闲端口检查函数
+func (l *AdaptiveLimiter) SetPortCheck(check func() bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.portCheck = check
+}
+
+// Limit 获取当前并发上限
+func (l *AdaptiveLimiter) Limit() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return int(l.limit)
+}
+
+// Max 获取并发上限的最大值
+func (l *AdaptiveLimiter) Max() int {
+	return l.maxLimit
+}
+
+// Acquire 获取一个并发槽位，阻塞直到有空闲槽位或上下文取消
+func (l *AdaptiveLimiter) Acquire(ctx context.Context) error {
+	for {
+		l.mutex.Lock()
+		if l.inFlight < int(l.limit) {
+			l.inFlight++
+			l.mutex.Unlock()
+			return nil
+		}
+		notify := l.notify
+		l.mutex.Unlock()
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Release 释放槽位并反馈本次测试结果
+// latency为探测延迟（0表示没有有效样本，如测试失败），timedOut表示本次测试是否超时
+func (l *AdaptiveLimiter) Release(latency time.Duration, timedOut bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.inFlight > 0 {
+		l.inFlight--
+	}
+
+	l.windowDone++
+	if timedOut {
+		l.windowTimeouts++
+	}
+	if latency > 0 {
+		if l.ewmaLatency == 0 {
+			l.ewmaLatency = latency
+		} else {
+			l.ewmaLatency = time.Duration(float64(l.ewmaLatency)*0.7 + float64(latency)*0.3)
+		}
+		if l.baselineLatency == 0 || l.ewmaLatency < l.baselineLatency {
+			l.baselineLatency = l.ewmaLatency
+		}
+	}
+
+	if l.windowDone >= int(l.limit) {
+		l.adjust()
+	}
+
+	// 唤醒等待中的调用方
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+// adjust 根据当前窗口统计调整并发上限（调用方需持有锁）
+func (l *AdaptiveLimiter) adjust() {
+	oldLimit := int(l.limit)
+	ratio := float64(l.windowTimeouts) / float64(l.windowDone)
+
+	switch {
+	case ratio >= l.timeoutRatio:
+		l.limit = l.limit / 2
+		if l.limit < float64(l.minLimit) {
+			l.limit = float64(l.minLimit)
+		}
+		if int(l.limit) != oldLimit {
+			fmt.Printf("📉 超时率 %.0f%%，并发数下调: %d -> %d\n", ratio*100, oldLimit, int(l.limit))
+		}
+	case l.latencyStable() && l.hostHasCapacity():
+		l.limit++
+		if l.limit > float64(l.maxLimit) {
+			l.limit = float64(l.maxLimit)
+		}
+		if int(l.limit) != oldLimit {
+			fmt.Printf("📈 延迟稳定，并发数上调: %d -> %d\n", oldLimit, int(l.limit))
+		}
+	}
+
+	l.windowDone = 0
+	l.windowTimeouts = 0
+}
+
+// latencyStable 平均延迟是否仍在基线容忍范围内（没有样本时视为稳定）
+func (l *AdaptiveLimiter) latencyStable() bool {
+	if l.baselineLatency == 0 {
+		return true
+	}
+	return float64(l.ewmaLatency) <= float64(l.baselineLatency)*l.latencyTolerance
+}
+
+// hostHasCapacity 主机是否仍有空闲的CPU和端口（平均负载只在Linux上可用，其他平台只检查端口）
+func (l *AdaptiveLimiter) hostHasCapacity() bool {
+	if load, ok := platform.LoadAverage(); ok && load >= float64(runtime.NumCPU())*0.9 {
+		return false
+	}
+	if l.portCheck != nil && !l.portCheck() {
+		return false
+	}
+	return true
+}
+
+// hasFreeLocalPort 尝试监听一个临时端口，判断本机端口是否耗尽
+func hasFreeLocalPort() bool {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
+
+// IsTimeoutMessage 判断错误信息是否表示超时
+func IsTimeoutMessage(message string) bool {
+	message = strings.ToLower(message)
+	return strings.Contains(message, "timeout") ||
+		strings.Contains(message, "deadline exceeded") ||
+		strings.Contains(message, "超时")
+}
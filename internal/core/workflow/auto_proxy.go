@@ -4,18 +4,23 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"net"
+	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/blacklist"
 	"github.com/yxhpy/v2ray-subscription-manager/internal/core/downloader"
-	"github.com/yxhpy/v2ray-subscription-manager/internal/platform"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/filter"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/history"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/lifecycle"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/rundir"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/statedir"
 	"github.com/yxhpy/v2ray-subscription-manager/internal/utils"
 	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
 )
@@ -42,11 +47,20 @@ type AutoProxyManager struct {
 	bestNodeFile string
 
 	// 用于进程间通信
-	testResults    []types.ValidNode
-	blacklist      map[string]time.Time
-	blacklistMutex sync.RWMutex
+	testResults []types.ValidNode
+	blacklist   *blacklist.Blacklist
+
+	// 控制API
+	controlServers []*http.Server
+	reloader       AutoProxyReloader
 }
 
+// 自动代理的启动和关闭期限
+const (
+	autoProxyStartTimeout = 10 * time.Second
+	autoProxyStopTimeout  = mvpTesterStopTimeout + proxyServerStopTimeout + 10*time.Second
+)
+
 // NewAutoProxyManager 创建新的双进程自动代理管理器
 func NewAutoProxyManager(config types.AutoProxyConfig) *AutoProxyManager {
 	ctx, cancel := context.WithCancel(context.Background())
@@ -86,15 +100,22 @@ func NewAutoProxyManager(config types.AutoProxyConfig) *AutoProxyManager {
 	if config.MinPassingNodes == 0 {
 		config.MinPassingNodes = 5
 	}
+	if config.ControlSocket == "" {
+		config.ControlSocket = DefaultControlSocket()
+	}
+	if config.BlacklistFile == "" {
+		config.BlacklistFile = blacklist.DefaultFile()
+	}
+	nodeBlacklist := blacklist.New(config.BlacklistFile)
 	if config.StateFile == "" {
-		config.StateFile = "./auto_proxy_state.json"
+		config.StateFile = statedir.File("auto_proxy_state.json")
 	}
 	if config.ValidNodesFile == "" {
-		config.ValidNodesFile = "./valid_nodes.json"
+		config.ValidNodesFile = statedir.File("valid_nodes.json")
 	}
 
-	// 最佳节点文件路径
-	bestNodeFile := "auto_proxy_best_node.json"
+	// 最佳节点文件路径（重启时恢复用的快照，放在持久状态目录）
+	bestNodeFile := statedir.File("auto_proxy_best_node.json")
 
 	// 创建MVP测试器
 	tester := NewMVPTester(config.SubscriptionURL)
@@ -109,6 +130,20 @@ func NewAutoProxyManager(config types.AutoProxyConfig) *AutoProxyManager {
 	tester.SetTimeout(config.TestTimeout)
 	tester.SetTestURL(config.TestURL)
 
+	// 测试历史与mvp-tester共用同一格式，按来源区分
+	tester.SetHistorySource(history.SourceAutoProxy)
+	if config.HistoryFile != "" {
+		tester.SetHistoryFile(config.HistoryFile)
+	}
+	tester.SetAutoSwitch(config.EnableAutoSwitch)
+	if err := tester.SetSchedule(config.TestSchedule); err != nil {
+		fmt.Printf("⚠️ %v，按更新间隔测试\n", err)
+	}
+	if err := tester.SetHealthSchedule(config.HealthSchedule); err != nil {
+		fmt.Printf("⚠️ %v\n", err)
+	}
+	tester.SetBlacklist(nodeBlacklist)
+
 	// 显示当前配置信息
 	fmt.Printf("🔧 MVP测试器配置:\n")
 	fmt.Printf("   📊 并发数: %d\n", config.TestConcurrency)
@@ -121,8 +156,10 @@ func NewAutoProxyManager(config types.AutoProxyConfig) *AutoProxyManager {
 
 	// 创建代理服务器
 	proxyServer := NewProxyServer(bestNodeFile, config.HTTPPort, config.SOCKSPort)
+	proxyServer.SetBlacklist(nodeBlacklist)
+	proxyServer.SetSwitchPolicy(SwitchPolicyFromConfig(config))
 
-	return &AutoProxyManager{
+	manager := &AutoProxyManager{
 		config:       config,
 		ctx:          ctx,
 		cancel:       cancel,
@@ -139,8 +176,14 @@ func NewAutoProxyManager(config types.AutoProxyConfig) *AutoProxyManager {
 			ValidNodes: make([]types.ValidNode, 0),
 			StartTime:  time.Now(),
 		},
-		blacklist: make(map[string]time.Time),
+		blacklist: nodeBlacklist,
 	}
+	return manager
+}
+
+// SetFilter 设置节点过滤与重命名规则
+func (m *AutoProxyManager) SetFilter(f *filter.Filter) {
+	m.tester.SetFilter(f)
 }
 
 // Start 启动双进程自动代理系统
@@ -164,25 +207,29 @@ func (m *AutoProxyManager) Start() error {
 	m.state.Running = true
 	m.state.StartTime = time.Now()
 
-	// 启动进程1：节点测试器
-	fmt.Printf("🧪 启动进程1：节点测试器...\n")
-	go m.runTesterProcess()
-
-	// 等待一下，让测试器先运行并生成初始的最佳节点文件
-	// Windows需要更长的启动时间
-	waitTime := 3 * time.Second
-	if runtime.GOOS == "windows" {
-		waitTime = 8 * time.Second
+	// 先启动代理服务器并等待其控制通道就绪，测试器的节点推送依赖它
+	fmt.Printf("🌐 启动代理服务器...\n")
+	go m.runProxyServerProcess()
+	readyCtx, cancel := context.WithTimeout(m.ctx, autoProxyStartTimeout)
+	err := m.proxyServer.status.WaitReady(readyCtx, "代理服务器")
+	cancel()
+	if err != nil {
+		m.Stop()
+		return err
 	}
-	time.Sleep(waitTime)
 
-	// 启动进程2：代理服务器
-	fmt.Printf("🌐 启动进程2：代理服务器...\n")
-	go m.runProxyServerProcess()
+	// 再启动节点测试器
+	fmt.Printf("🧪 启动节点测试器...\n")
+	go m.runTesterProcess()
 
 	// 启动监控协程
 	go m.monitorProcesses()
 
+	// 启动控制API
+	if err := m.startControlAPI(); err != nil {
+		fmt.Printf("⚠️ 控制API启动失败: %v\n", err)
+	}
+
 	fmt.Printf("✅ 双进程自动代理系统启动成功！\n")
 	fmt.Printf("📝 按 Ctrl+C 停止服务\n")
 
@@ -199,11 +246,7 @@ func (m *AutoProxyManager) runTesterProcess() {
 
 	fmt.Printf("  🧪 测试进程启动中...\n")
 
-	// 重写测试器的上下文
-	m.tester.ctx = m.testerCtx
-	m.tester.cancel = m.testerCancel
-
-	if err := m.tester.Start(); err != nil {
+	if err := m.tester.Run(m.testerCtx); err != nil {
 		fmt.Printf("❌ 测试进程启动失败: %v\n", err)
 	}
 }
@@ -218,11 +261,7 @@ func (m *AutoProxyManager) runProxyServerProcess() {
 
 	fmt.Printf("  🌐 代理服务进程启动中...\n")
 
-	// 重写代理服务器的上下文
-	m.proxyServer.ctx = m.serverCtx
-	m.proxyServer.cancel = m.serverCancel
-
-	if err := m.proxyServer.Start(); err != nil {
+	if err := m.proxyServer.Run(m.serverCtx); err != nil {
 		fmt.Printf("❌ 代理服务进程启动失败: %v\n", err)
 	}
 }
@@ -264,6 +303,9 @@ func (m *AutoProxyManager) checkProcessHealth() {
 		// 代理服务进程正常运行
 	}
 
+	// 已安装的路由规则数据文件超过更新周期时更新
+	downloader.RefreshStaleAssets()
+
 	// 更新状态
 	m.updateSystemStatus()
 }
@@ -273,214 +315,98 @@ func (m *AutoProxyManager) updateSystemStatus() {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	// 从最佳节点文件读取当前状态
-	if data, err := os.ReadFile(m.bestNodeFile); err == nil {
-		var mvpState MVPState
-		if err := json.Unmarshal(data, &mvpState); err == nil && mvpState.BestNode != nil {
-			// 更新状态中的当前节点
-			m.state.CurrentNode = mvpState.BestNode.Node
-			m.state.LastUpdate = mvpState.LastUpdate
+	// 直接从代理服务器读取当前节点和候选列表
+	if current := m.proxyServer.CurrentNode(); current != nil {
+		m.state.CurrentNode = current.Node
+		m.state.LastUpdate = current.TestTime
+	}
+	m.state.CurrentCore = m.proxyServer.CurrentCore()
+	if ranked := m.tester.RankedNodes(); len(ranked) > 0 {
+		m.state.ValidNodes = ranked
+	} else if candidates := m.proxyServer.Candidates(); len(candidates) > 0 {
+		m.state.ValidNodes = candidates
+	}
 
-			// 构建ValidNodes列表
-			m.state.ValidNodes = []types.ValidNode{*mvpState.BestNode}
-		}
+	m.state.AutoSwitch = m.tester.AutoSwitch()
+	m.state.PinnedNode = nil
+	if pinned := m.tester.PinnedNode(); pinned != nil {
+		m.state.PinnedNode = pinned.Node
 	}
 }
 
 // Stop 停止双进程自动代理系统
 func (m *AutoProxyManager) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), autoProxyStopTimeout)
+	defer cancel()
+	return m.Shutdown(ctx)
+}
+
+// Shutdown 按依赖顺序停止：控制API → 测试器 → 代理服务器，每个组件有各自的关闭期限
+// 只有代理端口未能释放时才强制终止残留进程
+func (m *AutoProxyManager) Shutdown(ctx context.Context) error {
 	fmt.Printf("🛑 停止双进程自动代理系统...\n")
 
 	m.mutex.Lock()
 	m.state.Running = false
 	m.mutex.Unlock()
 
-	// 第一步：停止测试进程并等待
-	fmt.Printf("  🛑 停止测试进程...\n")
-	m.testerCancel()
-	if m.tester != nil {
-		if err := m.tester.Stop(); err != nil {
-			fmt.Printf("    ⚠️ 测试进程停止异常: %v\n", err)
-		}
-		// 等待测试进程完全停止
-		m.waitForProcessStop("tester", func() bool {
-			return m.testerCtx.Err() != nil
-		})
-	}
-
-	// 第二步：停止代理服务进程并等待
-	fmt.Printf("  🛑 停止代理服务进程...\n")
-	m.serverCancel()
-	if m.proxyServer != nil {
-		if err := m.proxyServer.Stop(); err != nil {
-			fmt.Printf("    ⚠️ 代理服务进程停止异常: %v\n", err)
-		}
-		// 等待代理服务进程完全停止
-		m.waitForProcessStop("proxy server", func() bool {
-			return m.serverCtx.Err() != nil
-		})
-	}
-
-	// 第三步：停止主进程
+	err := lifecycle.Shutdown(ctx,
+		lifecycle.Step{Name: "控制API", Timeout: 2 * time.Second, Stop: func(ctx context.Context) error {
+			m.stopControlAPI()
+			return nil
+		}},
+		lifecycle.Step{Name: "测试器", Timeout: mvpTesterStopTimeout, Stop: func(ctx context.Context) error {
+			m.testerCancel()
+			return m.tester.Shutdown(ctx)
+		}},
+		lifecycle.Step{Name: "代理服务器", Timeout: proxyServerStopTimeout, Stop: func(ctx context.Context) error {
+			m.serverCancel()
+			return m.proxyServer.Shutdown(ctx)
+		}},
+		lifecycle.Step{Name: "代理端口", Timeout: 3 * time.Second, Stop: func(ctx context.Context) error {
+			return lifecycle.WaitPortsFree(ctx, m.config.HTTPPort, m.config.SOCKSPort)
+		}},
+	)
 	m.cancel()
+	if err != nil {
+		fmt.Printf("  💀 强制终止残留进程...\n")
+		m.killRelatedProcesses()
+	}
 
-	// 第四步：等待所有进程完全停止
-	fmt.Printf("  ⏳ 等待所有进程完全停止...\n")
-	m.waitForAllProcessesStop()
-
-	// 第五步：强制终止可能残留的进程
-	fmt.Printf("  💀 强制终止残留进程...\n")
-	m.killRelatedProcesses()
-
-	// 第六步：等待进程终止完成
-	time.Sleep(2 * time.Second)
-
-	// 第七步：清理资源
+	// 清理资源并保存最终状态
 	m.cleanup()
-
-	// 第八步：验证清理结果
 	m.verifyCleanup()
-
-	// 第九步：保存最终状态
 	m.saveState()
 
 	fmt.Printf("✅ 双进程自动代理系统已完全停止\n")
-	return nil
-}
-
-// waitForProcessStop 等待单个进程停止
-func (m *AutoProxyManager) waitForProcessStop(processName string, checkFunc func() bool) {
-	maxWait := 10 * time.Second
-	interval := 500 * time.Millisecond
-	elapsed := time.Duration(0)
-
-	for elapsed < maxWait {
-		if checkFunc() {
-			fmt.Printf("    ✅ %s 进程已停止\n", processName)
-			return
-		}
-		time.Sleep(interval)
-		elapsed += interval
-	}
-
-	fmt.Printf("    ⚠️ %s 进程停止超时，将强制终止\n", processName)
-}
-
-// waitForAllProcessesStop 等待所有进程停止
-func (m *AutoProxyManager) waitForAllProcessesStop() {
-	maxWait := 15 * time.Second
-	interval := 1 * time.Second
-	elapsed := time.Duration(0)
-
-	for elapsed < maxWait {
-		if m.checkAllProcessesStopped() {
-			fmt.Printf("    ✅ 所有进程已停止\n")
-			return
-		}
-		fmt.Printf("    ⏳ 等待进程停止... (%v/%v)\n", elapsed, maxWait)
-		time.Sleep(interval)
-		elapsed += interval
-	}
-
-	fmt.Printf("    ⚠️ 进程停止超时，将执行强制清理\n")
-}
-
-// checkAllProcessesStopped 检查所有进程是否已停止
-func (m *AutoProxyManager) checkAllProcessesStopped() bool {
-	// 检查context是否已取消
-	if m.ctx.Err() == nil {
-		return false
-	}
-	if m.testerCtx.Err() == nil {
-		return false
-	}
-	if m.serverCtx.Err() == nil {
-		return false
-	}
-
-	// 检查端口是否已释放
-	ports := []int{m.config.HTTPPort, m.config.SOCKSPort}
-	for _, port := range ports {
-		if m.isPortInUse(port) {
-			return false
-		}
-	}
-
-	return true
-}
-
-// isPortInUse 检查端口是否仍在使用
-func (m *AutoProxyManager) isPortInUse(port int) bool {
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), 1*time.Second)
-	if err != nil {
-		return false // 端口未被使用
-	}
-	conn.Close()
-	return true // 端口仍在使用
+	return err
 }
 
 // verifyCleanup 验证清理结果
 func (m *AutoProxyManager) verifyCleanup() {
 	fmt.Printf("  🔍 验证清理结果...\n")
 
-	// 检查关键文件是否已删除
-	filesToCheck := []string{
-		m.bestNodeFile,
-		m.config.StateFile,
-		m.config.ValidNodesFile,
-	}
-
-	for _, file := range filesToCheck {
-		if file != "" {
-			if _, err := os.Stat(file); err == nil {
-				fmt.Printf("    ⚠️ 文件仍存在: %s，尝试再次删除\n", file)
-				if err := os.Remove(file); err != nil {
-					fmt.Printf("    ❌ 删除失败: %s - %v\n", file, err)
-				} else {
-					fmt.Printf("    ✅ 重试删除成功: %s\n", file)
-				}
-			}
-		}
-	}
-
-	// 检查进程是否仍在运行
-	processNames := []string{"v2ray", "xray", "hysteria2"}
-	for _, processName := range processNames {
-		if m.isProcessRunning(processName) {
-			fmt.Printf("    ⚠️ 进程仍在运行: %s\n", processName)
-		}
+	// 检查本进程启动的核心进程是否仍在运行
+	for _, record := range lifecycle.DefaultRegistry().Owned() {
+		fmt.Printf("    ⚠️ 进程仍在运行: %s\n", record)
 	}
 
 	fmt.Printf("    ✅ 清理验证完成\n")
 }
 
-// isProcessRunning 检查进程是否仍在运行
-func (m *AutoProxyManager) isProcessRunning(processName string) bool {
-	cmd := exec.Command("pgrep", "-f", processName)
-	output, err := cmd.Output()
-	return err == nil && len(output) > 0
-}
-
 // GetStatus 获取系统状态
 func (m *AutoProxyManager) GetStatus() types.AutoProxyState {
+	// 实时更新状态（内部加写锁，需在读锁之外调用）
+	m.updateSystemStatus()
+
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
-
-	// 实时更新状态
-	m.updateSystemStatus()
 	return m.state
 }
 
-// GetBlacklistStatus 获取黑名单状态
-func (m *AutoProxyManager) GetBlacklistStatus() map[string]time.Time {
-	m.blacklistMutex.RLock()
-	defer m.blacklistMutex.RUnlock()
-
-	result := make(map[string]time.Time)
-	for key, expireTime := range m.blacklist {
-		result[key] = expireTime
-	}
-	return result
+// GetBlacklistStatus 获取生效中的黑名单条目
+func (m *AutoProxyManager) GetBlacklistStatus() []blacklist.Entry {
+	return m.blacklist.Entries(true)
 }
 
 // 保留一些通用工具函数用于兼容性
@@ -533,6 +459,10 @@ func (m *AutoProxyManager) loadState() {
 // saveState 保存状态
 func (m *AutoProxyManager) saveState() {
 	data, _ := json.MarshalIndent(m.state, "", "  ")
+	if err := os.MkdirAll(filepath.Dir(m.config.StateFile), 0700); err != nil {
+		fmt.Printf("⚠️ 创建状态目录失败: %v\n", err)
+		return
+	}
 	os.WriteFile(m.config.StateFile, data, 0644)
 }
 
@@ -545,6 +475,7 @@ func (m *AutoProxyManager) setupSignalHandler() {
 		<-c
 		fmt.Printf("\n🛑 接收到退出信号，正在停止双进程自动代理系统...\n")
 		m.Stop()
+		rundir.CleanupSession()
 		os.Exit(0)
 	}()
 }
@@ -596,57 +527,26 @@ func (m *AutoProxyManager) cleanup() {
 	// 清理过期黑名单
 	m.cleanExpiredBlacklist()
 
-	// 使用通用清理函数
-	utils.ForceCleanupAll()
-
-	// 杀死相关进程
-	m.killRelatedProcesses()
+	// 清理会话目录（进程已在关闭流程中停止）；状态目录中的快照和有效节点列表保留，供重启时恢复
+	utils.CleanupTempFiles()
 
 	fmt.Printf("✅ 资源清理完成\n")
 }
 
 // cleanExpiredBlacklist 清理过期黑名单
 func (m *AutoProxyManager) cleanExpiredBlacklist() {
-	m.blacklistMutex.Lock()
-	defer m.blacklistMutex.Unlock()
-
-	now := time.Now()
-	for key, expireTime := range m.blacklist {
-		if now.After(expireTime) {
-			delete(m.blacklist, key)
-		}
+	if removed, err := m.blacklist.Prune(); err != nil {
+		fmt.Printf("  ⚠️ 清理黑名单失败: %v\n", err)
+	} else if removed > 0 {
+		fmt.Printf("  🧹 已清理 %d 个过期黑名单条目\n", removed)
 	}
 }
 
-// killRelatedProcesses 杀死相关进程
+// killRelatedProcesses 强制终止本进程启动且仍在运行的核心进程
 func (m *AutoProxyManager) killRelatedProcesses() {
-	fmt.Printf("  💀 终止相关进程...\n")
-
-	// 首先尝试通过端口清理
-	ports := []int{m.config.HTTPPort, m.config.SOCKSPort}
-	for _, port := range ports {
-		if err := platform.KillProcessByPort(port); err == nil {
-			fmt.Printf("    🔧 已终止占用端口 %d 的进程\n", port)
-		}
-	}
-
-	// 然后按进程名清理
-	processNames := []string{"v2ray", "xray", "hysteria2", "hysteria"}
-
-	if runtime.GOOS == "windows" {
-		// Windows 使用taskkill
-		for _, processName := range processNames {
-			if err := platform.KillProcessByName(processName + ".exe"); err == nil {
-				fmt.Printf("    💀 已终止 %s 进程\n", processName)
-			}
-		}
-	} else {
-		// Unix 使用pkill
-		for _, processName := range processNames {
-			if err := platform.KillProcessByName(processName); err == nil {
-				fmt.Printf("    💀 已终止 %s 进程\n", processName)
-			}
-		}
+	fmt.Printf("  💀 终止本进程启动的核心进程...\n")
+	if killed := lifecycle.DefaultRegistry().KillOwned(); killed == 0 {
+		fmt.Printf("    ✅ 没有残留的核心进程\n")
 	}
 }
 
@@ -0,0 +1,638 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/blacklist"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/filter"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/rundir"
+	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
+)
+
+// DefaultControlSocket 自动代理控制API的默认socket路径（位于运行目录）
+func DefaultControlSocket() string {
+	return rundir.File("auto_proxy_ctl.sock")
+}
+
+// AutoProxyReloader 重新加载配置，返回新的配置和过滤规则
+type AutoProxyReloader func() (types.AutoProxyConfig, *filter.Filter, error)
+
+// RankedNode 控制API返回的节点排名
+type RankedNode struct {
+	Rank        int     `json:"rank"`
+	Fingerprint string  `json:"fingerprint"`
+	Name        string  `json:"name"`
+	Protocol    string  `json:"protocol"`
+	Server      string  `json:"server"`
+	Port        string  `json:"port"`
+	Score       float64 `json:"score"`
+	LatencyMs   int64   `json:"latency_ms"`
+	SpeedMbps   float64 `json:"speed_mbps"`
+	Current     bool    `json:"current"`
+	Pinned      bool    `json:"pinned"`
+	Banned      bool    `json:"banned"`
+}
+
+// ControlRequest 控制API请求
+type ControlRequest struct {
+	Node     string `json:"node,omitempty"`     // 节点名称或指纹（可为前缀）；封禁时也可以是服务器地址或网段
+	Kind     string `json:"kind,omitempty"`     // 黑白名单匹配方式: fingerprint/server/cidr，为空时自动识别
+	Duration string `json:"duration,omitempty"` // 封禁时长，如 2h，为空表示永久
+	Reason   string `json:"reason,omitempty"`   // 封禁原因
+}
+
+// ControlResponse 控制API响应
+type ControlResponse struct {
+	Success bool            `json:"success"`
+	Message string          `json:"message,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// SetReloader 设置重新加载配置的函数
+func (m *AutoProxyManager) SetReloader(reloader AutoProxyReloader) {
+	m.reloader = reloader
+}
+
+// RankedNodes 返回最近一轮测试的节点排名
+func (m *AutoProxyManager) RankedNodes() []RankedNode {
+	var currentFingerprint, pinnedFingerprint string
+	if current := m.proxyServer.CurrentNode(); current != nil && current.Node != nil {
+		currentFingerprint = current.Node.Fingerprint()
+	}
+	if pinned := m.tester.PinnedNode(); pinned != nil {
+		pinnedFingerprint = pinned.Node.Fingerprint()
+	}
+
+	ranked := m.tester.RankedNodes()
+	result := make([]RankedNode, 0, len(ranked))
+	for i, validNode := range ranked {
+		node := validNode.Node
+		fingerprint := node.Fingerprint()
+		result = append(result, RankedNode{
+			Rank:        i + 1,
+			Fingerprint: fingerprint,
+			Name:        node.Name,
+			Protocol:    node.Protocol,
+			Server:      node.Server,
+			Port:        node.Port,
+			Score:       validNode.Score,
+			LatencyMs:   validNode.Latency,
+			SpeedMbps:   validNode.Speed,
+			Current:     fingerprint == currentFingerprint,
+			Pinned:      fingerprint == pinnedFingerprint,
+			Banned:      m.isBlacklisted(node),
+		})
+	}
+	return result
+}
+
+// ForceRetest 立即重新测试所有节点
+func (m *AutoProxyManager) ForceRetest() error {
+	return m.tester.TriggerRetest()
+}
+
+// PinNode 固定使用指定节点（按指纹前缀或名称在最近的排名中查找）
+func (m *AutoProxyManager) PinNode(id string) (*types.ValidNode, error) {
+	node := m.findRankedNode(id)
+	if node == nil {
+		return nil, fmt.Errorf("未在最近的测试结果中找到节点: %s", id)
+	}
+	if m.isBlacklisted(node.Node) {
+		return nil, fmt.Errorf("节点已被封禁: %s", node.Node.Name)
+	}
+	m.tester.Pin(node)
+	return node, nil
+}
+
+// UnpinNode 取消固定节点
+func (m *AutoProxyManager) UnpinNode() {
+	m.tester.Unpin()
+}
+
+// BanNode 手动封禁节点、服务器或网段，duration为0时永久封禁
+func (m *AutoProxyManager) BanNode(req *ControlRequest, duration time.Duration) (*blacklist.Entry, error) {
+	kind, value := m.resolveListTarget(req)
+	entry, err := m.blacklist.Ban(kind, value, req.Reason, duration)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("🚫 封禁 %s %s\n", entry.Kind, entry.Value)
+
+	// 被封禁的节点正在使用时立即重新选择
+	if current := m.proxyServer.CurrentNode(); current != nil && m.blacklist.Blocked(current.Node) {
+		m.tester.clearBestNode(current.Node.Fingerprint())
+		fmt.Printf("🔁 当前节点被封禁，重新测试...\n")
+		if err := m.tester.TriggerRetest(); err != nil {
+			return entry, err
+		}
+	}
+	if pinned := m.tester.PinnedNode(); pinned != nil && m.blacklist.Blocked(pinned.Node) {
+		m.tester.clearBestNode(pinned.Node.Fingerprint())
+	}
+	return entry, nil
+}
+
+// UnbanNode 移除黑名单条目
+func (m *AutoProxyManager) UnbanNode(req *ControlRequest) (string, error) {
+	kind, value := m.resolveListTarget(req)
+	if err := m.blacklist.Unban(kind, value); err != nil {
+		return "", err
+	}
+	fmt.Printf("✅ 解除封禁 %s\n", value)
+	return value, nil
+}
+
+// AllowNode 加入白名单，白名单优先于黑名单
+func (m *AutoProxyManager) AllowNode(req *ControlRequest) (*blacklist.Entry, error) {
+	kind, value := m.resolveListTarget(req)
+	entry, err := m.blacklist.Allow(kind, value, req.Reason)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("✅ 加入白名单 %s %s\n", entry.Kind, entry.Value)
+	return entry, nil
+}
+
+// DisallowNode 移出白名单
+func (m *AutoProxyManager) DisallowNode(req *ControlRequest) (string, error) {
+	kind, value := m.resolveListTarget(req)
+	if err := m.blacklist.Disallow(kind, value); err != nil {
+		return "", err
+	}
+	fmt.Printf("✅ 移出白名单 %s\n", value)
+	return value, nil
+}
+
+// resolveListTarget 解析黑白名单目标：未指定匹配方式时，能在排名中找到的节点按指纹处理
+func (m *AutoProxyManager) resolveListTarget(req *ControlRequest) (blacklist.Kind, string) {
+	kind := blacklist.Kind(req.Kind)
+	if kind == "" || kind == blacklist.KindFingerprint {
+		if node := m.findRankedNode(req.Node); node != nil {
+			return blacklist.KindFingerprint, node.Node.Fingerprint()
+		}
+	}
+	return kind, req.Node
+}
+
+// SetAutoSwitch 暂停或恢复自动切换
+func (m *AutoProxyManager) SetAutoSwitch(enabled bool) {
+	m.tester.SetAutoSwitch(enabled)
+
+	m.mutex.Lock()
+	m.config.EnableAutoSwitch = enabled
+	m.state.Config.EnableAutoSwitch = enabled
+	m.mutex.Unlock()
+
+	if enabled {
+		fmt.Printf("▶️ 已恢复自动切换\n")
+	} else {
+		fmt.Printf("⏸️ 已暂停自动切换\n")
+	}
+}
+
+// ReloadConfig 重新加载配置和过滤规则，并在下一次测试前生效
+func (m *AutoProxyManager) ReloadConfig() error {
+	if m.reloader == nil {
+		return fmt.Errorf("未设置配置加载方式")
+	}
+
+	config, nodeFilter, err := m.reloader()
+	if err != nil {
+		return fmt.Errorf("重新加载配置失败: %v", err)
+	}
+
+	m.mutex.Lock()
+	if config.HTTPPort != m.config.HTTPPort || config.SOCKSPort != m.config.SOCKSPort {
+		fmt.Printf("⚠️ 代理端口变更需要重启才能生效\n")
+	}
+	config.HTTPPort = m.config.HTTPPort
+	config.SOCKSPort = m.config.SOCKSPort
+	config.ControlSocket = m.config.ControlSocket
+	config.ControlAddr = m.config.ControlAddr
+	config.ControlToken = m.config.ControlToken
+	m.config = config
+	m.state.Config = config
+	m.mutex.Unlock()
+
+	err = m.tester.Reconfigure(func(t *MVPTester) {
+		t.subscriptionURL = config.SubscriptionURL
+		t.SetInterval(config.UpdateInterval)
+		t.SetMaxNodes(config.MaxNodes)
+		t.SetConcurrency(config.TestConcurrency)
+		t.SetTimeout(config.TestTimeout)
+		t.SetTestURL(config.TestURL)
+		t.SetFilter(nodeFilter)
+		if err := t.SetSchedule(config.TestSchedule); err != nil {
+			fmt.Printf("⚠️ %v\n", err)
+		}
+		if err := t.SetHealthSchedule(config.HealthSchedule); err != nil {
+			fmt.Printf("⚠️ %v\n", err)
+		}
+		if config.HistoryFile != "" {
+			t.SetHistoryFile(config.HistoryFile)
+		}
+		fmt.Printf("🔄 配置已重新加载\n")
+	})
+	if err != nil {
+		return err
+	}
+
+	m.proxyServer.SetSwitchPolicy(SwitchPolicyFromConfig(config))
+	m.SetAutoSwitch(config.EnableAutoSwitch)
+	return nil
+}
+
+// SwitchDecisions 返回最近的切换决策（包括被拒绝的切换）
+func (m *AutoProxyManager) SwitchDecisions() []SwitchDecision {
+	return m.proxyServer.SwitchDecisions()
+}
+
+// isBlacklisted 节点是否被黑名单封禁
+func (m *AutoProxyManager) isBlacklisted(node *types.Node) bool {
+	return m.blacklist.Blocked(node)
+}
+
+// findRankedNode 按指纹前缀或名称在最近的排名中查找节点
+func (m *AutoProxyManager) findRankedNode(id string) *types.ValidNode {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return nil
+	}
+
+	ranked := m.tester.RankedNodes()
+	for i := range ranked {
+		if ranked[i].Node.Name == id {
+			return &ranked[i]
+		}
+	}
+	lower := strings.ToLower(id)
+	for i := range ranked {
+		if strings.HasPrefix(ranked[i].Node.Fingerprint(), lower) {
+			return &ranked[i]
+		}
+	}
+	return nil
+}
+
+// startControlAPI 启动控制API（Unix socket，配置了ControlAddr时同时监听TCP）
+func (m *AutoProxyManager) startControlAPI() error {
+	handler := m.controlHandler()
+
+	if m.config.ControlSocket != "" {
+		if conn, err := net.DialTimeout("unix", m.config.ControlSocket, time.Second); err == nil {
+			conn.Close()
+			return fmt.Errorf("控制API socket已被其他进程占用: %s", m.config.ControlSocket)
+		}
+		os.Remove(m.config.ControlSocket)
+
+		listener, err := net.Listen("unix", m.config.ControlSocket)
+		if err != nil {
+			return fmt.Errorf("监听控制API socket失败: %v", err)
+		}
+		m.serveControlAPI(listener, handler)
+		fmt.Printf("🎛️ 控制API: unix://%s\n", m.config.ControlSocket)
+	}
+
+	if m.config.ControlAddr != "" {
+		// 非本机地址可以被局域网中的其他主机访问，必须设置令牌
+		if !loopbackAddr(m.config.ControlAddr) && m.config.ControlToken == "" {
+			return fmt.Errorf("控制API监听非本机地址 %s 时必须设置 --control-token", m.config.ControlAddr)
+		}
+		listener, err := net.Listen("tcp", m.config.ControlAddr)
+		if err != nil {
+			return fmt.Errorf("监听控制API地址失败: %v", err)
+		}
+		// 没有令牌时只接受 Host 为本机地址的请求，防止DNS重绑定的网页以同源身份调用控制API
+		tcpHandler := requireLoopbackHost(handler)
+		if m.config.ControlToken != "" {
+			tcpHandler = requireToken(m.config.ControlToken, handler)
+		}
+		m.serveControlAPI(listener, tcpHandler)
+		fmt.Printf("🎛️ 控制API: http://%s\n", m.config.ControlAddr)
+	}
+	return nil
+}
+
+// loopbackAddr 监听地址是否只在本机可访问（127.0.0.0/8、::1 或 localhost），主机为空表示所有网卡
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// loopbackHost 请求的 Host 是否为本机地址字面量或 localhost（可带端口）
+func loopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// requireLoopbackHost 拒绝 Host 不是本机地址的请求（DNS重绑定攻击时 Host 是攻击者的域名）
+func requireLoopbackHost(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !loopbackHost(r.Host) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(ControlResponse{Error: fmt.Sprintf("拒绝访问：Host %q 不是本机地址", r.Host)})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireToken 要求请求携带 Authorization: Bearer <令牌>
+func requireToken(token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(ControlResponse{Error: "未授权：缺少或错误的控制API令牌"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveControlAPI 在监听器上提供控制API
+func (m *AutoProxyManager) serveControlAPI(listener net.Listener, handler http.Handler) {
+	server := &http.Server{Handler: handler}
+	m.controlServers = append(m.controlServers, server)
+	go server.Serve(listener)
+}
+
+// stopControlAPI 关闭控制API
+func (m *AutoProxyManager) stopControlAPI() {
+	if len(m.controlServers) == 0 {
+		return
+	}
+
+	fmt.Printf("  🛑 关闭控制API...\n")
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	for _, server := range m.controlServers {
+		server.Shutdown(ctx)
+	}
+	m.controlServers = nil
+	if m.config.ControlSocket != "" {
+		os.Remove(m.config.ControlSocket)
+	}
+}
+
+// controlHandler 控制API路由
+func (m *AutoProxyManager) controlHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/status", getOnly(func(r *ControlRequest) (interface{}, string, error) {
+		return m.GetStatus(), "", nil
+	}))
+	mux.HandleFunc("/nodes", getOnly(func(r *ControlRequest) (interface{}, string, error) {
+		return m.RankedNodes(), "", nil
+	}))
+	mux.HandleFunc("/blacklist", getOnly(func(r *ControlRequest) (interface{}, string, error) {
+		return map[string]interface{}{
+			"entries": m.GetBlacklistStatus(),
+			"allow":   m.blacklist.AllowEntries(),
+		}, "", nil
+	}))
+	mux.HandleFunc("/schedule", getOnly(func(r *ControlRequest) (interface{}, string, error) {
+		return m.tester.ScheduledJobs(), "", nil
+	}))
+	mux.HandleFunc("/switches", getOnly(func(r *ControlRequest) (interface{}, string, error) {
+		return m.SwitchDecisions(), "", nil
+	}))
+	mux.HandleFunc("/retest", postOnly(func(r *ControlRequest) (interface{}, string, error) {
+		return nil, "已请求重新测试", m.ForceRetest()
+	}))
+	mux.HandleFunc("/pin", postOnly(func(r *ControlRequest) (interface{}, string, error) {
+		node, err := m.PinNode(r.Node)
+		if err != nil {
+			return nil, "", err
+		}
+		return node, fmt.Sprintf("已固定节点: %s", node.Node.Name), nil
+	}))
+	mux.HandleFunc("/unpin", postOnly(func(r *ControlRequest) (interface{}, string, error) {
+		m.UnpinNode()
+		return nil, "已取消固定节点", nil
+	}))
+	mux.HandleFunc("/ban", postOnly(func(r *ControlRequest) (interface{}, string, error) {
+		var duration time.Duration
+		if r.Duration != "" {
+			d, err := time.ParseDuration(r.Duration)
+			if err != nil {
+				return nil, "", fmt.Errorf("无效的封禁时长: %s", r.Duration)
+			}
+			duration = d
+		}
+		entry, err := m.BanNode(r, duration)
+		if err != nil {
+			return nil, "", err
+		}
+		return entry, fmt.Sprintf("已封禁 %s: %s", entry.Kind, entry.Value), nil
+	}))
+	mux.HandleFunc("/unban", postOnly(func(r *ControlRequest) (interface{}, string, error) {
+		value, err := m.UnbanNode(r)
+		return value, fmt.Sprintf("已解除封禁: %s", value), err
+	}))
+	mux.HandleFunc("/allow", postOnly(func(r *ControlRequest) (interface{}, string, error) {
+		entry, err := m.AllowNode(r)
+		if err != nil {
+			return nil, "", err
+		}
+		return entry, fmt.Sprintf("已加入白名单 %s: %s", entry.Kind, entry.Value), nil
+	}))
+	mux.HandleFunc("/disallow", postOnly(func(r *ControlRequest) (interface{}, string, error) {
+		value, err := m.DisallowNode(r)
+		return value, fmt.Sprintf("已移出白名单: %s", value), err
+	}))
+	mux.HandleFunc("/pause", postOnly(func(r *ControlRequest) (interface{}, string, error) {
+		m.SetAutoSwitch(false)
+		return nil, "已暂停自动切换", nil
+	}))
+	mux.HandleFunc("/resume", postOnly(func(r *ControlRequest) (interface{}, string, error) {
+		m.SetAutoSwitch(true)
+		return nil, "已恢复自动切换", nil
+	}))
+	mux.HandleFunc("/reload", postOnly(func(r *ControlRequest) (interface{}, string, error) {
+		return nil, "配置已重新加载", m.ReloadConfig()
+	}))
+
+	return mux
+}
+
+// controlAction 控制API操作，返回数据、成功消息和错误
+type controlAction func(r *ControlRequest) (interface{}, string, error)
+
+// getOnly 只接受GET请求
+func getOnly(action controlAction) http.HandlerFunc {
+	return controlEndpoint(http.MethodGet, action)
+}
+
+// postOnly 只接受POST请求
+func postOnly(action controlAction) http.HandlerFunc {
+	return controlEndpoint(http.MethodPost, action)
+}
+
+// controlEndpoint 解析请求、执行操作并写入统一格式的响应
+func controlEndpoint(method string, action controlAction) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.Method != method {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(ControlResponse{Error: fmt.Sprintf("仅支持 %s 请求", method)})
+			return
+		}
+
+		// 只接受JSON请求体：浏览器跨站发送的"简单请求"不能设置该类型，防止网页通过本机地址操作管理器
+		if r.Method == http.MethodPost && !jsonContentType(r) {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			json.NewEncoder(w).Encode(ControlResponse{Error: "请求的 Content-Type 必须为 application/json"})
+			return
+		}
+
+		var req ControlRequest
+		if r.Method == http.MethodPost && r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(ControlResponse{Error: fmt.Sprintf("请求参数错误: %v", err)})
+				return
+			}
+		}
+
+		data, message, err := action(&req)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ControlResponse{Error: err.Error()})
+			return
+		}
+
+		response := ControlResponse{Success: true, Message: message}
+		if data != nil {
+			raw, err := json.Marshal(data)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(ControlResponse{Error: fmt.Sprintf("编码响应失败: %v", err)})
+				return
+			}
+			response.Data = raw
+		}
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// jsonContentType 请求的 Content-Type 是否为 application/json
+func jsonContentType(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// AutoProxyClient 自动代理控制API客户端（供 ctl 子命令使用）
+type AutoProxyClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// NewAutoProxyClient 创建控制API客户端，addr非空时通过TCP连接（token为控制API令牌，可为空），否则使用Unix socket
+func NewAutoProxyClient(socketPath, addr, token string) *AutoProxyClient {
+	if addr != "" {
+		return &AutoProxyClient{
+			baseURL: "http://" + addr,
+			token:   token,
+			client:  &http.Client{Timeout: 30 * time.Second},
+		}
+	}
+
+	if socketPath == "" {
+		socketPath = DefaultControlSocket()
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}
+	return &AutoProxyClient{
+		baseURL: "http://auto-proxy",
+		client:  &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}
+}
+
+// Get 调用查询接口，结果解码到out
+func (c *AutoProxyClient) Get(path string, out interface{}) (*ControlResponse, error) {
+	return c.do(http.MethodGet, path, nil, out)
+}
+
+// Post 调用操作接口，结果解码到out（可为nil）
+func (c *AutoProxyClient) Post(path string, req *ControlRequest, out interface{}) (*ControlResponse, error) {
+	return c.do(http.MethodPost, path, req, out)
+}
+
+// do 发送请求并解析统一格式的响应
+func (c *AutoProxyClient) do(method, path string, req *ControlRequest, out interface{}) (*ControlResponse, error) {
+	var body io.Reader
+	if req != nil {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return nil, fmt.Errorf("编码请求失败: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	httpReq, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("创建请求失败: %v", err)
+	}
+	if method == http.MethodPost {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("连接自动代理失败（auto-proxy是否在运行？）: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var response ControlResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %v", err)
+	}
+	if !response.Success {
+		return &response, fmt.Errorf("%s", response.Error)
+	}
+	if out != nil && len(response.Data) > 0 {
+		if err := json.Unmarshal(response.Data, out); err != nil {
+			return &response, fmt.Errorf("解析响应数据失败: %v", err)
+		}
+	}
+	return &response, nil
+}
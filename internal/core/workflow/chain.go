@@ -0,0 +1,125 @@
+package workflow
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/proxy"
+	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
+)
+
+// frontProbeMaxAge 前置节点测试结果的有效期，期间经由它的节点共用同一结果
+const frontProbeMaxAge = 5 * time.Minute
+
+// FrontProbe 代理链前置节点的健康检查。经由同一前置节点的节点共用一次测试结果，
+// 完整路径测试失败时据此判断是哪一跳出了问题
+type FrontProbe struct {
+	mutex   sync.Mutex
+	entries map[string]*frontProbeEntry // 按前置节点指纹
+}
+
+// frontProbeEntry 一个前置节点的测试，done 关闭后 result 可读
+type frontProbeEntry struct {
+	done   chan struct{}
+	result types.HopResult
+}
+
+// NewFrontProbe 创建前置节点健康检查
+func NewFrontProbe() *FrontProbe {
+	return &FrontProbe{entries: make(map[string]*frontProbeEntry)}
+}
+
+// Hops 代理链节点测试的是完整路径，另外测试前置节点（test 返回其延迟）以区分每一跳的状态。
+// 返回各跳状态和指明故障位置的错误说明；节点不经由前置节点时返回 nil 和原来的 errMsg
+func (p *FrontProbe) Hops(node *types.Node, success bool, latency int64, errMsg string, test func(*types.Node) (int64, error)) ([]types.HopResult, string) {
+	front := proxy.FrontNode(node)
+	if front == nil {
+		return nil, errMsg
+	}
+	return chainHops(node, p.check(front, test), success, latency, errMsg)
+}
+
+// check 返回前置节点的测试结果，没有有效结果时用 test 测试。
+// 同一前置节点同一时间只测试一次，其他节点等待该次结果；不同前置节点的测试互不阻塞
+func (p *FrontProbe) check(front *types.Node, test func(*types.Node) (int64, error)) types.HopResult {
+	key := front.Fingerprint()
+
+	p.mutex.Lock()
+	entry, ok := p.entries[key]
+	if ok {
+		select {
+		case <-entry.done:
+			ok = time.Since(entry.result.TestTime) < frontProbeMaxAge
+		default:
+			// 正在测试，等待结果
+		}
+	}
+	if ok {
+		p.mutex.Unlock()
+		<-entry.done
+		return entry.result
+	}
+	entry = &frontProbeEntry{done: make(chan struct{})}
+	p.entries[key] = entry
+	p.mutex.Unlock()
+
+	fmt.Printf("🔗 测试代理链前置节点: %s\n", front.Name)
+	latency, err := test(front)
+	entry.result = types.HopResult{
+		Role:     types.HopFront,
+		Name:     front.Name,
+		Success:  err == nil,
+		Latency:  latency,
+		TestTime: time.Now(),
+	}
+	if err != nil {
+		entry.result.Error = err.Error()
+	}
+	close(entry.done)
+	return entry.result
+}
+
+// chainHops 由前置节点和完整路径的测试结果得出每一跳的状态，
+// 返回各跳状态和完整路径失败时指明故障位置的错误说明
+func chainHops(node *types.Node, front types.HopResult, success bool, latency int64, errMsg string) ([]types.HopResult, string) {
+	exit := types.HopResult{
+		Role:     types.HopExit,
+		Name:     node.Name,
+		Success:  success,
+		TestTime: time.Now(),
+	}
+
+	switch {
+	case success:
+		if front.Success && latency > front.Latency {
+			exit.Latency = latency - front.Latency
+		}
+	case !front.Success:
+		exit.Error = "前置节点不可用，无法判断"
+		errMsg = fmt.Sprintf("前置节点 %s 不可用: %s", front.Name, front.Error)
+	default:
+		exit.Error = errMsg
+		errMsg = "出口节点失败（前置节点正常）: " + errMsg
+	}
+	return []types.HopResult{front, exit}, errMsg
+}
+
+// hopFailure 代理链节点失败时指明出问题的一跳，不是代理链节点时返回 fallback
+func hopFailure(hops []types.HopResult, fallback string) string {
+	for _, hop := range hops {
+		if hop.Success {
+			continue
+		}
+		if hop.Role == types.HopFront {
+			return fmt.Sprintf("前置节点 %s 不可用", hop.Name)
+		}
+		return fmt.Sprintf("出口节点 %s 失败（前置节点正常）", hop.Name)
+	}
+	return fallback
+}
+
+// frontDown 代理链节点的前置节点是否不可用，此时失败不是出口节点的问题
+func frontDown(hops []types.HopResult) bool {
+	return len(hops) > 0 && hops[0].Role == types.HopFront && !hops[0].Success
+}
@@ -3,13 +3,13 @@ package workflow
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"runtime"
@@ -18,12 +18,35 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/blacklist"
 	"github.com/yxhpy/v2ray-subscription-manager/internal/core/downloader"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/filter"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/history"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/ipc"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/lifecycle"
 	"github.com/yxhpy/v2ray-subscription-manager/internal/core/parser"
 	"github.com/yxhpy/v2ray-subscription-manager/internal/core/proxy"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/rundir"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/schedule"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/statedir"
 	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
 )
 
+// 测试器的调度任务
+const (
+	jobFullTest    = "full-test"
+	jobHealthCheck = "health-check"
+)
+
+// 测试端口租约的占用者名称，在端口分配器中区分完整测试和健康检查
+const (
+	portOwnerFullTest    = "mvp-tester"
+	portOwnerHealthCheck = "mvp-health-check"
+)
+
+// mvpTesterStopTimeout 测试器整体关闭期限：等待进行中的测试退出并停止其代理进程
+const mvpTesterStopTimeout = 15 * time.Second
+
 // MVPTester MVP节点测试器
 type MVPTester struct {
 	subscriptionURL  string
@@ -41,6 +64,42 @@ type MVPTester struct {
 	// 添加配置字段
 	testTimeout time.Duration
 	testURL     string
+
+	// 预检配置
+	enablePreflight  bool
+	preflight        *PreflightChecker
+	preflightResults map[*types.Node]*types.PreflightResult
+
+	// 测试历史
+	history       *history.Store
+	historySource string
+	historyStats  map[string]*history.NodeStats
+
+	// 节点过滤与重命名规则
+	filter *filter.Filter
+
+	// 控制通道：向代理服务器推送候选节点和切换命令，stateFile 仅作为重启时的快照
+	controlSocket string
+	control       *ipc.Client
+
+	// 运行时控制（供自动代理控制API使用）
+	autoSwitch  bool              // 是否自动切换到更好的节点
+	pinned      *types.ValidNode  // 固定使用的节点，固定期间不自动切换
+	rankedNodes []types.ValidNode // 最近一轮测试的排名
+	commands    chan func()       // 在测试循环中串行执行的命令
+
+	// 节点黑名单：测试前跳过被封禁的节点，测试失败的节点按策略递增封禁时长
+	blacklist *blacklist.Blacklist
+
+	// 调度：完整测试默认按 testInterval 执行，也可以使用cron表达式；健康检查只测试当前节点
+	scheduler      *schedule.Scheduler
+	testSchedule   string
+	healthSchedule string
+
+	status *lifecycle.Status // 控制通道建立后就绪，测试循环退出时结束
+
+	// 代理链前置节点的健康检查，区分代理链节点每一跳的状态
+	frontProbe *FrontProbe
 }
 
 // MVPState MVP状态
@@ -69,7 +128,7 @@ func NewMVPTester(subscriptionURL string) *MVPTester {
 		ctx:              ctx,
 		cancel:           cancel,
 		testInterval:     5 * time.Minute, // 每5分钟测试一次
-		stateFile:        "mvp_best_node.json",
+		stateFile:        statedir.File("mvp_best_node.json"),
 		maxNodes:         50,
 		concurrency:      5,
 		proxyManager:     proxy.NewProxyManager(),
@@ -78,12 +137,64 @@ func NewMVPTester(subscriptionURL string) *MVPTester {
 		// 使用平台相关的默认值
 		testTimeout: defaultTimeout,
 		testURL:     defaultTestURL,
+
+		enablePreflight: true,
+		preflight:       NewPreflightChecker(),
+		frontProbe:      NewFrontProbe(),
+
+		history:       history.NewStore(history.DefaultFile()),
+		historySource: history.SourceMVPTester,
+
+		autoSwitch: true,
+		commands:   make(chan func(), 8),
+
+		blacklist: blacklist.New(blacklist.DefaultFile()),
+		scheduler: schedule.NewScheduler(),
+		status:    lifecycle.NewStatus(),
 	}
 }
 
-// SetInterval 设置测试间隔
+// Ready 测试器完成初始化、开始测试时关闭
+func (m *MVPTester) Ready() <-chan struct{} {
+	return m.status.Ready()
+}
+
+// Done 测试循环退出时关闭
+func (m *MVPTester) Done() <-chan struct{} {
+	return m.status.Done()
+}
+
+// SetInterval 设置测试间隔（会覆盖通过 SetSchedule 设置的调度表达式）
 func (m *MVPTester) SetInterval(interval time.Duration) {
 	m.testInterval = interval
+	m.testSchedule = ""
+}
+
+// SetSchedule 设置完整测试的调度表达式，如 "0 3 * * *"；为空时按测试间隔执行
+func (m *MVPTester) SetSchedule(spec string) error {
+	if spec != "" {
+		if _, err := schedule.Parse(spec); err != nil {
+			return fmt.Errorf("无效的测试调度: %v", err)
+		}
+	}
+	m.testSchedule = spec
+	return nil
+}
+
+// SetHealthSchedule 设置健康检查的调度表达式，如 "*/2 9-18 * * 1-5"；为空时不做健康检查
+func (m *MVPTester) SetHealthSchedule(spec string) error {
+	if spec != "" {
+		if _, err := schedule.Parse(spec); err != nil {
+			return fmt.Errorf("无效的健康检查调度: %v", err)
+		}
+	}
+	m.healthSchedule = spec
+	return nil
+}
+
+// ScheduledJobs 返回调度任务及下一次运行时间
+func (m *MVPTester) ScheduledJobs() []schedule.JobInfo {
+	return m.scheduler.Jobs()
 }
 
 // SetMaxNodes 设置最大测试节点数
@@ -111,21 +222,186 @@ func (m *MVPTester) SetTestURL(testURL string) {
 	m.testURL = testURL
 }
 
-// Start 启动MVP测试器
+// SetPreflight 设置是否在代理测试前进行直连预检
+func (m *MVPTester) SetPreflight(enabled bool) {
+	m.enablePreflight = enabled
+}
+
+// SetFilter 设置节点过滤与重命名规则
+func (m *MVPTester) SetFilter(f *filter.Filter) {
+	m.filter = f
+}
+
+// SetAutoSwitch 设置是否自动切换到更好的节点（关闭后仍继续测试和排名）
+func (m *MVPTester) SetAutoSwitch(enabled bool) {
+	m.mutex.Lock()
+	m.autoSwitch = enabled
+	best := m.topRankedLocked()
+	pinned := m.pinned
+	m.mutex.Unlock()
+
+	// 恢复自动切换时立即请求切换到排名第一的节点（仍受切换策略约束）
+	if enabled && pinned == nil && best != nil {
+		m.publishNode(best, false, "恢复自动切换")
+	}
+}
+
+// AutoSwitch 是否启用自动切换
+func (m *MVPTester) AutoSwitch() bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.autoSwitch
+}
+
+// SetBlacklist 设置节点黑名单，为nil时不检查黑名单
+func (m *MVPTester) SetBlacklist(b *blacklist.Blacklist) {
+	m.mutex.Lock()
+	m.blacklist = b
+	m.mutex.Unlock()
+}
+
+// Blacklist 返回节点黑名单
+func (m *MVPTester) Blacklist() *blacklist.Blacklist {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.blacklist
+}
+
+// Pin 固定使用指定节点并立即通知代理服务器切换
+func (m *MVPTester) Pin(node *types.ValidNode) {
+	m.mutex.Lock()
+	m.pinned = node
+	m.mutex.Unlock()
+
+	fmt.Printf("📌 固定节点: %s\n", node.Node.Name)
+	m.publishNode(node, true, "手动固定节点")
+}
+
+// Unpin 取消固定节点，恢复使用测试得到的最佳节点
+func (m *MVPTester) Unpin() {
+	m.mutex.Lock()
+	m.pinned = nil
+	best := m.topRankedLocked()
+	autoSwitch := m.autoSwitch
+	m.mutex.Unlock()
+
+	fmt.Printf("📌 已取消固定节点\n")
+	if autoSwitch && best != nil {
+		m.publishNode(best, true, "取消固定节点")
+	}
+}
+
+// PinnedNode 返回固定的节点，未固定时返回nil
+func (m *MVPTester) PinnedNode() *types.ValidNode {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.pinned
+}
+
+// topRankedLocked 返回最近一轮排名第一的节点，尚无排名时返回当前最佳节点（调用方需持有锁）
+func (m *MVPTester) topRankedLocked() *types.ValidNode {
+	if len(m.rankedNodes) > 0 {
+		top := m.rankedNodes[0]
+		return &top
+	}
+	return m.bestNode
+}
+
+// RankedNodes 返回最近一轮测试按分数排序的有效节点
+func (m *MVPTester) RankedNodes() []types.ValidNode {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return append([]types.ValidNode(nil), m.rankedNodes...)
+}
+
+// TriggerRetest 请求立即执行一轮测试（在测试循环中执行，不会与定时测试并发）
+func (m *MVPTester) TriggerRetest() error {
+	return m.enqueue(func() {
+		fmt.Printf("\n🔁 收到重新测试请求 [%s]\n", time.Now().Format("2006-01-02 15:04:05"))
+		if err := m.performTest(); err != nil {
+			fmt.Printf("❌ 重新测试失败: %v\n", err)
+		}
+	})
+}
+
+// Reconfigure 在测试循环空闲时修改测试器配置，避免与正在进行的测试竞争
+func (m *MVPTester) Reconfigure(apply func(*MVPTester)) error {
+	return m.enqueue(func() { apply(m) })
+}
+
+// enqueue 将命令放入测试循环
+func (m *MVPTester) enqueue(cmd func()) error {
+	select {
+	case m.commands <- cmd:
+		return nil
+	default:
+		return fmt.Errorf("测试器命令队列已满，请稍后重试")
+	}
+}
+
+// clearBestNode 清除当前最佳节点（节点被封禁时使用），下一轮测试会重新选择
+func (m *MVPTester) clearBestNode(fingerprint string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.bestNode != nil && m.bestNode.Node.Fingerprint() == fingerprint {
+		m.bestNode = nil
+	}
+	if m.pinned != nil && m.pinned.Node.Fingerprint() == fingerprint {
+		m.pinned = nil
+	}
+}
+
+// SetControlSocket 设置控制通道socket路径（默认由状态文件路径推导）
+func (m *MVPTester) SetControlSocket(path string) {
+	m.controlSocket = path
+}
+
+// SetHistoryFile 设置测试历史文件路径，为空时不记录历史
+func (m *MVPTester) SetHistoryFile(path string) {
+	if path == "" {
+		m.history = nil
+		return
+	}
+	m.history = history.NewStore(path)
+}
+
+// SetHistorySource 设置写入测试历史时的来源标识
+func (m *MVPTester) SetHistorySource(source string) {
+	m.historySource = source
+}
+
+// Start 启动MVP测试器，阻塞直到 Stop 被调用
 func (m *MVPTester) Start() error {
+	m.setupSignalHandler()
+	return m.Run(context.Background())
+}
+
+// Run 在给定上下文中运行测试器，阻塞直到 ctx 取消或 Stop 被调用
+func (m *MVPTester) Run(ctx context.Context) error {
+	m.ctx, m.cancel = context.WithCancel(ctx)
+	m.status.Begin()
+	defer m.status.End()
+
 	fmt.Printf("🚀 启动MVP节点测试器...\n")
 	fmt.Printf("📡 订阅链接: %s\n", m.subscriptionURL)
-	fmt.Printf("⏰ 测试间隔: %v\n", m.testInterval)
+	m.applySchedules()
 	fmt.Printf("💾 状态文件: %s\n", m.stateFile)
 
-	// 设置信号处理
-	m.setupSignalHandler()
-
 	// 检查依赖
 	if err := m.checkDependencies(); err != nil {
 		return fmt.Errorf("依赖检查失败: %v", err)
 	}
 
+	// 连接控制通道（代理服务器未启动时在每次发送时重试）
+	if m.controlSocket == "" {
+		m.controlSocket = ipc.SocketPathFor(m.stateFile)
+	}
+	m.control = ipc.NewClient(m.controlSocket)
+	m.control.SetAckHandler(m.handleAck)
+	fmt.Printf("🔌 控制通道: %s\n", m.controlSocket)
+	m.status.MarkReady()
+
 	// 加载历史最佳节点
 	m.loadBestNode()
 
@@ -135,194 +411,166 @@ func (m *MVPTester) Start() error {
 		fmt.Printf("⚠️ 初始测试失败: %v\n", err)
 	}
 
-	// 启动定时测试
-	ticker := time.NewTicker(m.testInterval)
-	defer ticker.Stop()
-
 	fmt.Printf("✅ MVP节点测试器启动成功！\n")
 	fmt.Printf("📝 按 Ctrl+C 停止服务\n")
 
+	// 调度任务和控制命令都在此循环中串行执行
 	for {
+		fire, job, stop := m.scheduler.Timer()
 		select {
-		case <-ticker.C:
-			fmt.Printf("\n⏰ 开始定时测试 [%s]\n", time.Now().Format("2006-01-02 15:04:05"))
-			if err := m.performTest(); err != nil {
-				fmt.Printf("❌ 定时测试失败: %v\n", err)
-			}
+		case <-fire:
+			m.scheduler.Fire(job)
+		case <-m.scheduler.Changed():
+		case cmd := <-m.commands:
+			cmd()
+			// 调度可能被重新配置
+			m.applySchedules()
 		case <-m.ctx.Done():
+			stop()
 			fmt.Printf("\n🛑 收到停止信号，正在退出...\n")
 			return nil
 		}
+		stop()
 	}
 }
 
-// Stop 停止MVP测试器
-func (m *MVPTester) Stop() error {
-	fmt.Printf("🛑 停止MVP测试器...\n")
-
-	// 第一步：取消上下文
-	m.cancel()
-
-	// 第二步：停止V2Ray代理并等待
-	if m.proxyManager != nil {
-		fmt.Printf("  🛑 停止V2Ray代理...\n")
-		if err := m.proxyManager.StopProxy(); err != nil {
-			fmt.Printf("    ⚠️ V2Ray代理停止异常: %v\n", err)
-		}
-		m.waitForProxyStop("V2Ray", m.proxyManager)
+// applySchedules 根据当前配置更新调度任务，配置未变化的任务保持原有的下一次运行时间
+func (m *MVPTester) applySchedules() {
+	interval := m.testInterval
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	full := schedule.Every(interval)
+	if m.testSchedule != "" {
+		full = schedule.MustParse(m.testSchedule)
+	}
+	if m.scheduler.Spec(jobFullTest) != full.String() {
+		m.scheduler.SetSchedule(jobFullTest, full, func() {
+			fmt.Printf("\n⏰ 开始定时测试 [%s]\n", time.Now().Format("2006-01-02 15:04:05"))
+			if err := m.performTest(); err != nil {
+				fmt.Printf("❌ 定时测试失败: %v\n", err)
+			}
+		})
+		fmt.Printf("⏰ 完整测试调度: %s\n", full)
 	}
 
-	// 第三步：停止Hysteria2代理并等待
-	if m.hysteria2Manager != nil {
-		fmt.Printf("  🛑 停止Hysteria2代理...\n")
-		if err := m.hysteria2Manager.StopHysteria2Proxy(); err != nil {
-			fmt.Printf("    ⚠️ Hysteria2代理停止异常: %v\n", err)
+	if m.scheduler.Spec(jobHealthCheck) != m.healthSchedule {
+		if m.healthSchedule == "" {
+			m.scheduler.Remove(jobHealthCheck)
+			fmt.Printf("💓 已关闭健康检查\n")
+		} else {
+			m.scheduler.SetSchedule(jobHealthCheck, schedule.MustParse(m.healthSchedule), m.healthCheck)
+			fmt.Printf("💓 健康检查调度: %s\n", m.healthSchedule)
 		}
-		m.waitForHysteria2Stop("Hysteria2", m.hysteria2Manager)
 	}
-
-	// 第四步：等待所有操作完成
-	fmt.Printf("  ⏳ 等待所有操作完成...\n")
-	time.Sleep(3 * time.Second)
-
-	// 第五步：强制终止残留进程
-	fmt.Printf("  💀 强制终止残留进程...\n")
-	m.killRelatedProcesses()
-
-	// 第六步：等待进程终止完成
-	time.Sleep(2 * time.Second)
-
-	// 第七步：清理临时配置文件
-	fmt.Printf("  🧹 清理临时配置文件...\n")
-	m.cleanupTempFiles()
-
-	// 第八步：清理状态文件
-	fmt.Printf("  🧹 清理状态文件...\n")
-	m.cleanupStateFile()
-
-	// 第九步：验证清理结果
-	m.verifyMVPCleanup()
-
-	fmt.Printf("✅ MVP测试器已完全停止\n")
-	return nil
 }
 
-// waitForProxyStop 等待V2Ray代理停止
-func (m *MVPTester) waitForProxyStop(name string, manager *proxy.ProxyManager) {
-	maxWait := 10 * time.Second
-	interval := 500 * time.Millisecond
-	elapsed := time.Duration(0)
+// healthCheck 轻量健康检查：只测试当前节点，失败时立即切换到排名靠前的其他节点
+func (m *MVPTester) healthCheck() {
+	m.mutex.RLock()
+	current := m.bestNode
+	pinned := m.pinned
+	ranked := append([]types.ValidNode(nil), m.rankedNodes...)
+	m.mutex.RUnlock()
 
-	for elapsed < maxWait {
-		if !manager.GetStatus().Running {
-			fmt.Printf("    ✅ %s代理已停止\n", name)
-			return
+	if pinned != nil {
+		current = pinned
+	}
+	if current == nil || current.Node == nil {
+		fmt.Printf("\n💓 尚无当前节点，执行完整测试\n")
+		if err := m.performTest(); err != nil {
+			fmt.Printf("❌ 测试失败: %v\n", err)
 		}
-		time.Sleep(interval)
-		elapsed += interval
+		return
 	}
 
-	fmt.Printf("    ⚠️ %s代理停止超时\n", name)
-}
-
-// waitForHysteria2Stop 等待Hysteria2代理停止
-func (m *MVPTester) waitForHysteria2Stop(name string, manager *proxy.Hysteria2ProxyManager) {
-	maxWait := 10 * time.Second
-	interval := 500 * time.Millisecond
-	elapsed := time.Duration(0)
-
-	for elapsed < maxWait {
-		if !manager.GetHysteria2Status().Running {
-			fmt.Printf("    ✅ %s代理已停止\n", name)
-			return
-		}
-		time.Sleep(interval)
-		elapsed += interval
+	fmt.Printf("\n💓 健康检查 [%s]: %s\n", time.Now().Format("2006-01-02 15:04:05"), current.Node.Name)
+	result := m.testSingleNode(current.Node, portOwnerHealthCheck)
+	if result.Node != nil {
+		m.recordHistory(current.Node, &result, "")
+		fmt.Printf("💓 当前节点正常 (延迟: %dms, 速度: %.2fMbps)\n", result.Latency, result.Speed)
+		return
 	}
 
-	fmt.Printf("    ⚠️ %s代理停止超时\n", name)
-}
+	reason := hopFailure(result.Hops, "健康检查失败")
+	m.recordHistory(current.Node, nil, reason)
+	if result.HardFailure && !frontDown(result.Hops) {
+		m.updateBlacklist(current.Node, false, reason)
+	}
+	fmt.Printf("💔 当前节点健康检查失败: %s\n", current.Node.Name)
 
-// verifyMVPCleanup 验证MVP清理结果
-func (m *MVPTester) verifyMVPCleanup() {
-	fmt.Printf("  🔍 验证MVP清理结果...\n")
+	if pinned != nil {
+		fmt.Printf("📌 节点已固定，保持不变\n")
+		return
+	}
 
-	// 检查状态文件是否已删除
-	if m.stateFile != "" {
-		if _, err := os.Stat(m.stateFile); err == nil {
-			fmt.Printf("    ⚠️ 状态文件仍存在: %s，尝试再次删除\n", m.stateFile)
-			if err := os.Remove(m.stateFile); err != nil {
-				fmt.Printf("    ❌ 删除失败: %s - %v\n", m.stateFile, err)
-			} else {
-				fmt.Printf("    ✅ 重试删除成功: %s\n", m.stateFile)
-			}
+	fingerprint := current.Node.Fingerprint()
+	for i := range ranked {
+		candidate := &ranked[i]
+		if candidate.Node.Fingerprint() == fingerprint || m.blacklist.Blocked(candidate.Node) {
+			continue
 		}
+		m.mutex.Lock()
+		m.bestNode = candidate
+		m.mutex.Unlock()
+		m.publishBestNode(candidate, true, "当前节点健康检查失败")
+		return
 	}
 
-	fmt.Printf("    ✅ MVP清理验证完成\n")
-}
-
-// cleanupStateFile 清理状态文件
-func (m *MVPTester) cleanupStateFile() {
-	if m.stateFile != "" {
-		if err := os.Remove(m.stateFile); err == nil {
-			fmt.Printf("    🗑️  已删除状态文件: %s\n", m.stateFile)
-		}
+	fmt.Printf("🔁 没有可替换的节点，执行完整测试\n")
+	if err := m.performTest(); err != nil {
+		fmt.Printf("❌ 测试失败: %v\n", err)
 	}
 }
 
-// cleanupTempFiles 清理临时文件
-func (m *MVPTester) cleanupTempFiles() {
-	patterns := []string{
-		"temp_v2ray_config_*.json",
-		"temp_hysteria2_config_*.json",
-		"test_proxy_*.json", // 添加test_proxy_开头的文件
-		"*.tmp",
-		"*.temp",
-	}
+// Stop 停止MVP测试器
+func (m *MVPTester) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), mvpTesterStopTimeout)
+	defer cancel()
+	return m.Shutdown(ctx)
+}
 
-	cleanedCount := 0
-	for _, pattern := range patterns {
-		matches, err := filepath.Glob(pattern)
-		if err != nil {
-			continue
-		}
+// Shutdown 停止测试器：取消进行中的测试并等待测试循环退出（每个测试在退出时停止自己的代理进程）
+// 只有测试循环未能在期限内退出时才强制终止残留进程
+func (m *MVPTester) Shutdown(ctx context.Context) error {
+	fmt.Printf("🛑 停止MVP测试器...\n")
+	m.cancel()
 
-		for _, file := range matches {
-			if err := os.Remove(file); err == nil {
-				fmt.Printf("    🗑️  已删除: %s\n", file)
-				cleanedCount++
+	err := lifecycle.Shutdown(ctx,
+		lifecycle.Step{Name: "测试循环", Stop: m.status.WaitStopped},
+		lifecycle.Step{Name: "代理进程", Timeout: lifecycle.DefaultStopTimeout, Stop: func(ctx context.Context) error {
+			if m.proxyManager != nil && m.proxyManager.V2RayProcess != nil {
+				m.proxyManager.StopProxyWithContext(ctx)
 			}
-		}
+			if m.hysteria2Manager != nil && m.hysteria2Manager.Hysteria2Process != nil {
+				m.hysteria2Manager.StopHysteria2ProxyWithContext(ctx)
+			}
+			return nil
+		}},
+	)
+	if err != nil {
+		fmt.Printf("  💀 强制终止残留进程...\n")
+		m.killRelatedProcesses()
 	}
 
-	if cleanedCount > 0 {
-		fmt.Printf("    ✅ 共清理了 %d 个临时文件\n", cleanedCount)
+	// 写入尚未保存的黑名单变更
+	m.saveBlacklist()
+
+	// 关闭控制通道（保留状态快照供重启时恢复）
+	if m.control != nil {
+		fmt.Printf("  🛑 关闭控制通道...\n")
+		m.control.Close()
 	}
+
+	fmt.Printf("✅ MVP测试器已完全停止\n")
+	return err
 }
 
-// killRelatedProcesses 杀死相关进程
+// killRelatedProcesses 强制终止本进程启动且仍在运行的核心进程
 func (m *MVPTester) killRelatedProcesses() {
-	fmt.Printf("    💀 终止MVP相关进程...\n")
-
-	processNames := []string{"v2ray", "xray", "hysteria2", "hysteria"}
-
-	if runtime.GOOS == "windows" {
-		// Windows 使用taskkill
-		for _, processName := range processNames {
-			cmd := exec.Command("taskkill", "/F", "/IM", processName+".exe")
-			if err := cmd.Run(); err == nil {
-				fmt.Printf("      🔧 已终止 %s 进程\n", processName)
-			}
-		}
-	} else {
-		// Unix 使用pkill
-		for _, processName := range processNames {
-			cmd := exec.Command("pkill", "-f", processName)
-			if err := cmd.Run(); err == nil {
-				fmt.Printf("      🔧 已终止 %s 进程\n", processName)
-			}
-		}
+	fmt.Printf("    💀 终止本进程启动的核心进程...\n")
+	if killed := lifecycle.DefaultRegistry().KillOwned(); killed == 0 {
+		fmt.Printf("      ✅ 没有残留的核心进程\n")
 	}
 }
 
@@ -340,6 +588,37 @@ func (m *MVPTester) performTest() error {
 		return fmt.Errorf("没有找到任何节点")
 	}
 
+	// 加载历史统计，用于本轮排名
+	m.loadHistoryStats()
+	defer m.pruneHistory()
+	defer m.saveBlacklist()
+
+	// 协议或插件不受支持的节点不测试，记录原因
+	nodes = m.skipUnsupportedNodes(nodes)
+	if len(nodes) == 0 {
+		fmt.Printf("❌ 没有可以运行的节点\n")
+		return nil
+	}
+
+	// 预检：直连淘汰DNS失败、拒绝连接、不可达的节点，避免为死节点启动核心
+	if m.enablePreflight {
+		var results map[*types.Node]*types.PreflightResult
+		nodes, results = m.preflight.Filter(m.ctx, nodes)
+		m.preflightResults = results
+		m.recordPreflightFailures(results)
+		for node, result := range results {
+			// 只有确认不可用的失败计入黑名单，超时和无法确认的结果不递增封禁
+			if result.HardFailure() {
+				m.updateBlacklist(node, false, fmt.Sprintf("预检失败(%s)", result.Status))
+			}
+		}
+
+		if len(nodes) == 0 {
+			fmt.Printf("❌ 所有节点均未通过预检\n")
+			return nil
+		}
+	}
+
 	// 测试所有节点
 	validNodes := m.testAllNodes(nodes)
 	fmt.Printf("✅ 测试完成，发现 %d 个有效节点\n", len(validNodes))
@@ -356,11 +635,42 @@ func (m *MVPTester) performTest() error {
 
 	newBestNode := &validNodes[0]
 
-	// 检查是否需要更新最佳节点
 	m.mutex.Lock()
-	needUpdate := m.bestNode == nil || newBestNode.Score > m.bestNode.Score
+	m.rankedNodes = append([]types.ValidNode(nil), validNodes...)
+	m.mutex.Unlock()
+
+	// 推送排序后的候选节点
+	m.sendCandidates(validNodes)
+
+	// 检查是否需要切换：用本轮测试的分数比较，是否切换由代理服务器的切换策略决定
+	m.mutex.Lock()
+	var current *types.ValidNode
+	if m.bestNode != nil {
+		for i := range validNodes {
+			if validNodes[i].Node.Fingerprint() == m.bestNode.Node.Fingerprint() {
+				current = &validNodes[i]
+				break
+			}
+		}
+	}
+
+	reason, force := "", false
+	switch {
+	case m.bestNode == nil:
+		reason = "初次选择节点"
+	case current == nil:
+		// 当前节点本轮测试失败或已被过滤、封禁，需要立即更换
+		reason, force = "当前节点本轮测试未通过", true
+	case current.Node.Fingerprint() != newBestNode.Node.Fingerprint():
+		reason = "发现分数更高的节点"
+	}
+
+	needUpdate := reason != ""
 	if needUpdate {
 		oldBest := m.bestNode
+		if current != nil {
+			oldBest = current
+		}
 		m.bestNode = newBestNode
 
 		fmt.Printf("\n🎉 发现更快的节点！\n")
@@ -370,19 +680,17 @@ func (m *MVPTester) performTest() error {
 		}
 		fmt.Printf("🚀 新节点: %s (分数: %.2f, 延迟: %dms, 速度: %.2fMbps)\n",
 			newBestNode.Node.Name, newBestNode.Score, newBestNode.Latency, newBestNode.Speed)
-
-		// 保存到文件
-		if err := m.saveBestNode(); err != nil {
-			fmt.Printf("⚠️ 保存最佳节点失败: %v\n", err)
-		} else {
-			fmt.Printf("💾 最佳节点已保存到 %s\n", m.stateFile)
-		}
 	} else {
+		m.bestNode = current
 		fmt.Printf("📊 当前最佳节点仍是最快的: %s (分数: %.2f)\n",
 			m.bestNode.Node.Name, m.bestNode.Score)
 	}
 	m.mutex.Unlock()
 
+	if needUpdate {
+		m.publishBestNode(newBestNode, force, reason)
+	}
+
 	// 显示测试摘要
 	m.showTestSummary(validNodes)
 
@@ -409,6 +717,22 @@ func (m *MVPTester) fetchAndParseSubscription() ([]*types.Node, error) {
 		return nil, fmt.Errorf("解析节点失败: %v", err)
 	}
 
+	// 应用过滤与重命名规则
+	if !m.filter.Empty() {
+		total := len(nodes)
+		nodes = m.filter.Apply(nodes)
+		fmt.Printf("🔎 过滤规则保留 %d/%d 个节点\n", len(nodes), total)
+	}
+
+	// 跳过黑名单中的节点
+	if nodeBlacklist := m.Blacklist(); nodeBlacklist != nil {
+		var skipped int
+		nodes, skipped = nodeBlacklist.Filter(nodes)
+		if skipped > 0 {
+			fmt.Printf("🚫 跳过 %d 个黑名单中的节点\n", skipped)
+		}
+	}
+
 	return nodes, nil
 }
 
@@ -418,27 +742,9 @@ func (m *MVPTester) testAllNodes(nodes []*types.Node) []types.ValidNode {
 	var mutex sync.Mutex
 	var wg sync.WaitGroup
 
-	// 使用用户通过SetConcurrency设置的并发数
-	concurrency := m.concurrency
-
-	// 如果并发数为0或过大，则使用平台相关的默认值作为安全后备
-	if concurrency <= 0 {
-		if runtime.GOOS == "windows" {
-			concurrency = 1 // Windows下默认单线程
-		} else {
-			concurrency = 2 // Unix环境默认2个并发
-		}
-		fmt.Printf("⚠️ 并发数未设置或无效，使用默认值: %d\n", concurrency)
-	} else {
-		fmt.Printf("🔧 使用设置的并发数: %d\n", concurrency)
-	}
-
-	// Windows环境提示
-	if runtime.GOOS == "windows" {
-		fmt.Printf("🪟 Windows环境：并发数 = %d\n", concurrency)
-	}
-
-	semaphore := make(chan struct{}, concurrency)
+	// 以SetConcurrency设置的并发数为起点，根据延迟和超时自适应调整（未设置时使用平台默认值）
+	limiter := NewAdaptiveLimiter(0, m.concurrency)
+	fmt.Printf("🔧 自适应并发: 初始 %d, 上限 %d\n", limiter.Limit(), limiter.Max())
 
 	// 添加总体超时控制
 	totalTimeout := 30 * time.Minute // 总测试时间限制
@@ -446,7 +752,7 @@ func (m *MVPTester) testAllNodes(nodes []*types.Node) []types.ValidNode {
 		totalTimeout = 45 * time.Minute // Windows下允许更长时间
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), totalTimeout)
+	ctx, cancel := context.WithTimeout(m.ctx, totalTimeout)
 	defer cancel()
 
 	// 添加快速跳过机制
@@ -455,12 +761,10 @@ func (m *MVPTester) testAllNodes(nodes []*types.Node) []types.ValidNode {
 	maxConsecutiveFailures := 10 // 连续失败10个节点后，缩短测试时间
 
 	for i, node := range nodes {
-		// 检查是否超时
-		select {
-		case <-ctx.Done():
-			fmt.Printf("⏰ 总体测试超时，停止后续节点测试\n")
+		// 检查是否超时或测试器已停止
+		if ctx.Err() != nil {
+			fmt.Printf("⏰ 测试超时或已停止，停止后续节点测试\n")
 			break
-		default:
 		}
 
 		// 检查是否应该快速跳过
@@ -476,14 +780,14 @@ func (m *MVPTester) testAllNodes(nodes []*types.Node) []types.ValidNode {
 		go func(node *types.Node, index int, fastFail bool) {
 			defer wg.Done()
 
-			// 获取信号量
-			select {
-			case semaphore <- struct{}{}:
-				defer func() { <-semaphore }()
-			case <-ctx.Done():
+			// 获取并发槽位
+			if err := limiter.Acquire(ctx); err != nil {
 				fmt.Printf("❌ 节点 [%d/%d] %s: 测试超时取消\n", index+1, len(nodes), node.Name)
 				return
 			}
+			var latencySample time.Duration
+			var timedOut bool
+			defer func() { limiter.Release(latencySample, timedOut) }()
 
 			fmt.Printf("🧪 测试节点 [%d/%d]: %s (%s)\n",
 				index+1, len(nodes), node.Name, node.Protocol)
@@ -504,7 +808,7 @@ func (m *MVPTester) testAllNodes(nodes []*types.Node) []types.ValidNode {
 			// 在goroutine中执行测试，以便可以被取消
 			resultChan := make(chan types.ValidNode, 1)
 			go func() {
-				result := m.testSingleNode(node, 8000+index*10)
+				result := m.testSingleNode(node, portOwnerFullTest)
 				select {
 				case resultChan <- result:
 				case <-nodeCtx.Done():
@@ -517,6 +821,8 @@ func (m *MVPTester) testAllNodes(nodes []*types.Node) []types.ValidNode {
 				// 测试完成
 			case <-nodeCtx.Done():
 				fmt.Printf("⏰ 节点 [%d/%d] %s: 单节点测试超时\n", index+1, len(nodes), node.Name)
+				timedOut = true
+				m.recordHistory(node, nil, "单节点测试超时")
 				// 记录失败
 				failureMutex.Lock()
 				consecutiveFailures++
@@ -525,6 +831,15 @@ func (m *MVPTester) testAllNodes(nodes []*types.Node) []types.ValidNode {
 			}
 
 			if validNode.Node != nil {
+				latencySample = time.Duration(validNode.Latency) * time.Millisecond
+				m.recordHistory(node, &validNode, "")
+				m.updateBlacklist(node, true, "")
+
+				// 结合历史可靠性修正分数
+				if m.history != nil {
+					validNode.Score = history.AdjustScore(validNode.Score, m.historyStats[node.Fingerprint()])
+				}
+
 				// 成功，重置连续失败计数
 				failureMutex.Lock()
 				consecutiveFailures = 0
@@ -533,17 +848,18 @@ func (m *MVPTester) testAllNodes(nodes []*types.Node) []types.ValidNode {
 				mutex.Lock()
 				validNodes = append(validNodes, validNode)
 
-				// 检查是否是更好的节点
-				if m.bestNode == nil || validNode.Score > m.bestNode.Score {
+				// 还没有可用节点时立即使用第一个通过测试的节点，其余节点在本轮测试结束后统一比较
+				m.mutex.Lock()
+				first := m.bestNode == nil
+				if first {
 					m.bestNode = &validNode
-					fmt.Printf("🏆 发现新的最佳节点: %s (分数: %.2f)\n", validNode.Node.Name, validNode.Score)
-
-					// 立即保存最佳节点
-					if err := m.saveBestNode(); err != nil {
-						fmt.Printf("⚠️ 保存最佳节点失败: %v\n", err)
-					} else {
-						fmt.Printf("💾 最佳节点已保存到文件\n")
-					}
+				}
+				m.mutex.Unlock()
+				if first {
+					fmt.Printf("🏆 发现首个可用节点: %s (分数: %.2f)\n", validNode.Node.Name, validNode.Score)
+
+					// 立即保存快照并通知代理服务器
+					m.publishBestNode(&validNode, false, "首个可用节点")
 				}
 				mutex.Unlock()
 
@@ -555,16 +871,24 @@ func (m *MVPTester) testAllNodes(nodes []*types.Node) []types.ValidNode {
 				consecutiveFailures++
 				failureMutex.Unlock()
 
+				reason := hopFailure(validNode.Hops, "测试失败")
+				m.recordHistory(node, nil, reason)
+				if validNode.HardFailure && !frontDown(validNode.Hops) {
+					m.updateBlacklist(node, false, reason)
+				}
 				fmt.Printf("❌ 节点 %s 测试失败\n", node.Name)
 			}
 		}(node, i, shouldFastFail)
 
 		// Windows环境在节点之间添加短暂延迟，但快速失败模式下减少延迟
-		if runtime.GOOS == "windows" && concurrency == 1 {
+		if runtime.GOOS == "windows" && limiter.Limit() == 1 {
+			delay := 2 * time.Second // 正常模式
 			if shouldFastFail {
-				time.Sleep(500 * time.Millisecond) // 快速模式
-			} else {
-				time.Sleep(2 * time.Second) // 正常模式
+				delay = 500 * time.Millisecond // 快速模式
+			}
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
 			}
 		}
 	}
@@ -573,26 +897,41 @@ func (m *MVPTester) testAllNodes(nodes []*types.Node) []types.ValidNode {
 	return validNodes
 }
 
-// testSingleNode 测试单个节点
-func (m *MVPTester) testSingleNode(node *types.Node, portBase int) types.ValidNode {
+// testSingleNode 测试单个节点，测试端口以 portOwner 的名义从共享端口分配器租用
+func (m *MVPTester) testSingleNode(node *types.Node, portOwner string) types.ValidNode {
 	result := types.ValidNode{
 		TestTime: time.Now(),
 	}
 
-	switch node.Protocol {
-	case "vmess", "vless", "trojan", "ss":
-		result = m.testV2RayNode(node, result, portBase)
-	case "hysteria2":
-		result = m.testHysteria2Node(node, result, portBase)
+	switch {
+	case proxy.UsesHysteria2Client(node):
+		result = m.testHysteria2Node(node, result, portOwner)
+	case proxy.UnsupportedReason(node) == "":
+		result = m.testV2RayNode(node, result, portOwner)
 	default:
-		fmt.Printf("⚠️ 不支持的协议: %s\n", node.Protocol)
+		fmt.Printf("⚠️ %s\n", proxy.UnsupportedReason(node))
 	}
 
+	var errMsg string
+	result.Hops, errMsg = m.frontProbe.Hops(node, result.Node != nil, result.Latency, "测试失败", func(front *types.Node) (int64, error) {
+		frontResult := m.testSingleNode(front, portOwner)
+		if frontResult.Node == nil {
+			return 0, fmt.Errorf("测试失败")
+		}
+		return frontResult.Latency, nil
+	})
+	if result.Hops != nil && result.Node == nil {
+		fmt.Printf("  🔗 %s\n", errMsg)
+	}
+
+	// 记录预检结果
+	result.Preflight = m.preflightResults[node]
+
 	return result
 }
 
 // testV2RayNode 测试V2Ray节点
-func (m *MVPTester) testV2RayNode(node *types.Node, result types.ValidNode, portBase int) types.ValidNode {
+func (m *MVPTester) testV2RayNode(node *types.Node, result types.ValidNode, portOwner string) types.ValidNode {
 	fmt.Printf("  🔧 启动V2Ray代理测试...\n")
 
 	proxyManager := proxy.NewProxyManager()
@@ -601,11 +940,16 @@ func (m *MVPTester) testV2RayNode(node *types.Node, result types.ValidNode, port
 		proxyManager.StopProxy()
 	}()
 
-	httpPort := portBase + 1
-	socksPort := portBase + 2
-
-	// 为每个测试线程创建唯一的配置文件名
-	proxyManager.ConfigPath = fmt.Sprintf("temp_v2ray_config_%d_%d.json", portBase, time.Now().UnixNano())
+	// 从共享端口分配器租用测试端口，代理进程退出时租约自动释放
+	httpLease, socksLease, err := proxy.Ports().AcquirePair(portOwner, 0)
+	if err != nil {
+		fmt.Printf("  ❌ 分配测试端口失败: %v\n", err)
+		return result
+	}
+	defer proxy.Ports().Release(httpLease)
+	defer proxy.Ports().Release(socksLease)
+	httpPort := httpLease.Port
+	socksPort := socksLease.Port
 
 	// 手动设置端口
 	proxyManager.HTTPPort = httpPort
@@ -613,20 +957,12 @@ func (m *MVPTester) testV2RayNode(node *types.Node, result types.ValidNode, port
 
 	fmt.Printf("  🔧 配置代理端口: HTTP=%d, SOCKS=%d\n", httpPort, socksPort)
 
-	err := proxyManager.StartProxy(node)
+	err = proxyManager.StartProxyWithContext(m.ctx, node)
 	if err != nil {
 		fmt.Printf("  ❌ V2Ray代理启动失败: %v\n", err)
 		return result
 	}
 
-	// 等待代理启动 - Windows需要更长时间
-	waitTime := 5 * time.Second
-	if runtime.GOOS == "windows" {
-		waitTime = 8 * time.Second
-	}
-	fmt.Printf("  ⏳ 等待代理启动 (%.0fs)...\n", waitTime.Seconds())
-	time.Sleep(waitTime)
-
 	// 验证代理是否真正启动
 	if !m.verifyProxyStarted(httpPort) {
 		fmt.Printf("  ❌ V2Ray代理启动验证失败\n")
@@ -640,6 +976,7 @@ func (m *MVPTester) testV2RayNode(node *types.Node, result types.ValidNode, port
 	latency, speed, err := m.testProxyPerformance(proxyTestURL)
 	if err != nil {
 		fmt.Printf("  ❌ V2Ray代理性能测试失败: %v\n", err)
+		result.HardFailure = hardTestFailure(err)
 		return result
 	}
 
@@ -657,7 +994,7 @@ func (m *MVPTester) testV2RayNode(node *types.Node, result types.ValidNode, port
 }
 
 // testHysteria2Node 测试Hysteria2节点
-func (m *MVPTester) testHysteria2Node(node *types.Node, result types.ValidNode, portBase int) types.ValidNode {
+func (m *MVPTester) testHysteria2Node(node *types.Node, result types.ValidNode, portOwner string) types.ValidNode {
 	fmt.Printf("  🔧 启动Hysteria2代理测试...\n")
 
 	hysteria2Manager := proxy.NewHysteria2ProxyManager()
@@ -666,11 +1003,16 @@ func (m *MVPTester) testHysteria2Node(node *types.Node, result types.ValidNode,
 		hysteria2Manager.StopHysteria2Proxy()
 	}()
 
-	httpPort := portBase + 1
-	socksPort := portBase + 2
-
-	// 为每个测试线程创建唯一的配置文件名
-	hysteria2Manager.SetConfigPath(fmt.Sprintf("./hysteria2/config_%d_%d.yaml", portBase, time.Now().UnixNano()))
+	// 从共享端口分配器租用测试端口，代理进程退出时租约自动释放
+	httpLease, socksLease, err := proxy.Ports().AcquirePair(portOwner, 0)
+	if err != nil {
+		fmt.Printf("  ❌ 分配测试端口失败: %v\n", err)
+		return result
+	}
+	defer proxy.Ports().Release(httpLease)
+	defer proxy.Ports().Release(socksLease)
+	httpPort := httpLease.Port
+	socksPort := socksLease.Port
 
 	// 手动设置端口
 	hysteria2Manager.HTTPPort = httpPort
@@ -678,20 +1020,12 @@ func (m *MVPTester) testHysteria2Node(node *types.Node, result types.ValidNode,
 
 	fmt.Printf("  🔧 配置代理端口: HTTP=%d, SOCKS=%d\n", httpPort, socksPort)
 
-	err := hysteria2Manager.StartHysteria2Proxy(node)
+	err = hysteria2Manager.StartHysteria2ProxyWithContext(m.ctx, node)
 	if err != nil {
 		fmt.Printf("  ❌ Hysteria2代理启动失败: %v\n", err)
 		return result
 	}
 
-	// 等待代理启动 - Windows需要更长时间
-	waitTime := 5 * time.Second
-	if runtime.GOOS == "windows" {
-		waitTime = 10 * time.Second // Hysteria2在Windows下需要更长启动时间
-	}
-	fmt.Printf("  ⏳ 等待代理启动 (%.0fs)...\n", waitTime.Seconds())
-	time.Sleep(waitTime)
-
 	// 验证代理是否真正启动
 	if !m.verifyProxyStarted(httpPort) {
 		fmt.Printf("  ❌ Hysteria2代理启动验证失败\n")
@@ -705,6 +1039,7 @@ func (m *MVPTester) testHysteria2Node(node *types.Node, result types.ValidNode,
 	latency, speed, err := m.testProxyPerformance(proxyTestURL)
 	if err != nil {
 		fmt.Printf("  ❌ Hysteria2代理性能测试失败: %v\n", err)
+		result.HardFailure = hardTestFailure(err)
 		return result
 	}
 
@@ -861,9 +1196,10 @@ func (m *MVPTester) testProxyPerformance(proxyURL string) (int64, float64, error
 			start = time.Now()
 
 			// 创建带context的请求
-			req, err := http.NewRequestWithContext(ctx, "GET", testURL, nil)
-			if err != nil {
-				lastErr = fmt.Errorf("创建请求失败: %v", err)
+			req, reqErr := http.NewRequestWithContext(ctx, "GET", testURL, nil)
+			if reqErr != nil {
+				lastErr = fmt.Errorf("创建请求失败: %v", reqErr)
+				err = lastErr
 				break
 			}
 
@@ -893,7 +1229,8 @@ func (m *MVPTester) testProxyPerformance(proxyURL string) (int64, float64, error
 				if resp != nil && resp.Body != nil {
 					resp.Body.Close()
 				}
-				lastErr = fmt.Errorf("请求超时 (%.1fs)", time.Since(requestStart).Seconds())
+				lastErr = fmt.Errorf("请求超时 (%.1fs): %w", time.Since(requestStart).Seconds(), context.DeadlineExceeded)
+				err = lastErr
 				fmt.Printf("  ⏰ 请求超时，跳过\n")
 				break
 			}
@@ -902,7 +1239,7 @@ func (m *MVPTester) testProxyPerformance(proxyURL string) (int64, float64, error
 				break // 成功，跳出重试循环
 			}
 
-			lastErr = fmt.Errorf("请求失败: %v", err)
+			lastErr = fmt.Errorf("请求失败: %w", err)
 			fmt.Printf("  ❌ %v\n", lastErr)
 
 			// 如果不是最后一次尝试，短暂等待再重试
@@ -976,13 +1313,13 @@ func (m *MVPTester) testProxyPerformance(proxyURL string) (int64, float64, error
 			body = result.data
 			err = result.err
 		case <-readCtx.Done():
-			err = fmt.Errorf("读取响应超时")
+			err = fmt.Errorf("读取响应超时: %w", context.DeadlineExceeded)
 		}
 
 		resp.Body.Close()
 
 		if err != nil {
-			lastErr = fmt.Errorf("读取响应失败: %v", err)
+			lastErr = fmt.Errorf("读取响应失败: %w", err)
 			fmt.Printf("  ❌ %v\n", lastErr)
 			continue
 		}
@@ -1001,7 +1338,142 @@ func (m *MVPTester) testProxyPerformance(proxyURL string) (int64, float64, error
 		return latency, speed, nil
 	}
 
-	return 0, 0, fmt.Errorf("所有测试URL都失败，最后错误: %v", lastErr)
+	return 0, 0, fmt.Errorf("所有测试URL都失败，最后错误: %w", lastErr)
+}
+
+// hardTestFailure 代理测试错误是否确认节点不可用：
+// 超时无法区分节点故障和网络拥塞，不计入黑名单；通过本地代理得到的连接错误、错误状态码等才算
+func hardTestFailure(err error) bool {
+	if err == nil || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr net.Error
+	return !(errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// loadHistoryStats 加载历史统计
+func (m *MVPTester) loadHistoryStats() {
+	if m.history == nil {
+		return
+	}
+
+	stats, err := m.history.Stats(time.Time{})
+	if err != nil {
+		fmt.Printf("⚠️ 加载测试历史失败: %v\n", err)
+		stats = nil
+	}
+	m.historyStats = stats
+	if len(stats) > 0 {
+		fmt.Printf("📚 已加载 %d 个节点的测试历史\n", len(stats))
+	}
+}
+
+// recordHistory 记录单个节点的测试结果，validNode为nil表示测试失败
+func (m *MVPTester) recordHistory(node *types.Node, validNode *types.ValidNode, errMsg string) {
+	if m.history == nil {
+		return
+	}
+
+	record := history.NewRecord(node, m.historySource)
+	if validNode != nil {
+		record.Success = true
+		record.LatencyMs = validNode.Latency
+		record.SpeedMbps = validNode.Speed
+	} else {
+		record.Error = errMsg
+	}
+
+	if err := m.history.Append(record); err != nil {
+		fmt.Printf("⚠️ 写入测试历史失败: %v\n", err)
+	}
+}
+
+// skipUnsupportedNodes 返回可以运行的节点，协议或 Shadowsocks 插件不受支持的节点以原因记为失败。
+// 这些节点换个时间也无法运行，不计入黑名单
+func (m *MVPTester) skipUnsupportedNodes(nodes []*types.Node) []*types.Node {
+	supported := nodes[:0:0]
+	var records []history.Record
+	for _, node := range nodes {
+		reason := proxy.UnsupportedReason(node)
+		if reason == "" {
+			supported = append(supported, node)
+			continue
+		}
+		fmt.Printf("⚠️ 跳过节点 %s: %s\n", node.Name, reason)
+		if m.history != nil {
+			record := history.NewRecord(node, m.historySource)
+			record.Error = reason
+			records = append(records, record)
+		}
+	}
+
+	if len(records) > 0 {
+		if err := m.history.Append(records...); err != nil {
+			fmt.Printf("⚠️ 写入测试历史失败: %v\n", err)
+		}
+	}
+	return supported
+}
+
+// recordPreflightFailures 将预检淘汰的节点记为失败（无法确认的结果由核心测试记录）
+func (m *MVPTester) recordPreflightFailures(results map[*types.Node]*types.PreflightResult) {
+	if m.history == nil {
+		return
+	}
+
+	var records []history.Record
+	for node, result := range results {
+		if result.Passed() {
+			continue
+		}
+		record := history.NewRecord(node, m.historySource)
+		record.Error = fmt.Sprintf("预检失败(%s): %s", result.Status, result.Error)
+		records = append(records, record)
+	}
+
+	if err := m.history.Append(records...); err != nil {
+		fmt.Printf("⚠️ 写入测试历史失败: %v\n", err)
+	}
+}
+
+// updateBlacklist 测试失败时记一次失败（递增封禁时长），成功时清除自动封禁记录
+func (m *MVPTester) updateBlacklist(node *types.Node, success bool, reason string) {
+	nodeBlacklist := m.Blacklist()
+	if nodeBlacklist == nil {
+		return
+	}
+
+	if success {
+		if err := nodeBlacklist.Forgive(node); err != nil {
+			fmt.Printf("⚠️ 更新黑名单失败: %v\n", err)
+		}
+		return
+	}
+
+	if _, err := nodeBlacklist.Penalize(node, reason); err != nil {
+		fmt.Printf("⚠️ 更新黑名单失败: %v\n", err)
+	}
+}
+
+// saveBlacklist 立即写入本轮测试中合并的黑名单变更
+func (m *MVPTester) saveBlacklist() {
+	if err := m.Blacklist().Save(); err != nil {
+		fmt.Printf("⚠️ 保存黑名单失败: %v\n", err)
+	}
+}
+
+// pruneHistory 清理超过保留时长的历史记录
+func (m *MVPTester) pruneHistory() {
+	if m.history == nil {
+		return
+	}
+
+	removed, err := m.history.Prune()
+	if err != nil {
+		fmt.Printf("⚠️ 清理测试历史失败: %v\n", err)
+	} else if removed > 0 {
+		fmt.Printf("🧹 已清理 %d 条过期测试历史\n", removed)
+	}
 }
 
 // showTestSummary 显示测试摘要
@@ -1053,23 +1525,90 @@ func (m *MVPTester) loadBestNode() {
 	}
 }
 
-// saveBestNode 保存最佳节点
-func (m *MVPTester) saveBestNode() error {
-	m.mutex.RLock()
+// saveNodeSnapshot 保存代理服务器应使用的节点快照
+func (m *MVPTester) saveNodeSnapshot(node *types.ValidNode) error {
 	state := MVPState{
-		BestNode:   m.bestNode,
+		BestNode:   node,
 		LastUpdate: time.Now(),
 	}
-	m.mutex.RUnlock()
 
 	data, err := json.MarshalIndent(state, "", "  ")
 	if err != nil {
 		return err
 	}
 
+	if err := os.MkdirAll(filepath.Dir(m.stateFile), 0700); err != nil {
+		return err
+	}
 	return os.WriteFile(m.stateFile, data, 0644)
 }
 
+// publishBestNode 发现更好的节点时调用，固定节点或暂停自动切换时不通知代理服务器
+func (m *MVPTester) publishBestNode(node *types.ValidNode, force bool, reason string) {
+	m.mutex.RLock()
+	pinned := m.pinned
+	autoSwitch := m.autoSwitch
+	m.mutex.RUnlock()
+
+	if pinned != nil {
+		fmt.Printf("📌 已固定节点 %s，跳过切换\n", pinned.Node.Name)
+		return
+	}
+	if !autoSwitch {
+		fmt.Printf("⏸️ 自动切换已暂停，跳过切换到 %s\n", node.Node.Name)
+		return
+	}
+	m.publishNode(node, force, reason)
+}
+
+// publishNode 保存节点快照并通过控制通道通知代理服务器切换，force 表示跳过代理服务器的切换策略
+func (m *MVPTester) publishNode(node *types.ValidNode, force bool, reason string) {
+	if err := m.saveNodeSnapshot(node); err != nil {
+		fmt.Printf("⚠️ 保存最佳节点失败: %v\n", err)
+	} else {
+		fmt.Printf("💾 最佳节点已保存到 %s\n", m.stateFile)
+	}
+
+	if m.control == nil {
+		return
+	}
+	seq, err := m.control.SendSwitch(node, force, reason)
+	if err != nil {
+		fmt.Printf("⚠️ 控制通道不可用，代理服务器将在重启时从快照恢复: %v\n", err)
+		return
+	}
+	fmt.Printf("📤 已发送切换命令 #%d: %s\n", seq, node.Node.Name)
+}
+
+// sendCandidates 通过控制通道推送排序后的候选节点
+func (m *MVPTester) sendCandidates(validNodes []types.ValidNode) {
+	if m.control == nil {
+		return
+	}
+	if _, err := m.control.SendCandidates(validNodes); err != nil {
+		fmt.Printf("⚠️ 推送候选节点失败: %v\n", err)
+	}
+}
+
+// handleAck 处理代理服务器的确认消息，并以代理服务器实际使用的节点作为当前最佳节点
+func (m *MVPTester) handleAck(ack *ipc.Message) {
+	if ack.Node != nil && ack.Node.Node != nil {
+		m.mutex.Lock()
+		if m.pinned == nil {
+			m.bestNode = ack.Node
+		}
+		m.mutex.Unlock()
+	}
+
+	if !ack.OK {
+		fmt.Printf("⚠️ 代理服务器拒绝命令 #%d: %s\n", ack.AckSeq, ack.Error)
+		return
+	}
+	if ack.Node != nil && ack.Node.Node != nil {
+		fmt.Printf("📨 代理服务器确认命令 #%d，当前节点: %s\n", ack.AckSeq, ack.Node.Node.Name)
+	}
+}
+
 // setupSignalHandler 设置信号处理
 func (m *MVPTester) setupSignalHandler() {
 	c := make(chan os.Signal, 1)
@@ -1079,6 +1618,7 @@ func (m *MVPTester) setupSignalHandler() {
 		<-c
 		fmt.Printf("\n🛑 接收到退出信号，正在清理资源...\n")
 		m.Stop()
+		rundir.CleanupSession()
 		os.Exit(0)
 	}()
 }
@@ -0,0 +1,317 @@
+package workflow
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/proxy"
+	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
+)
+
+// PreflightChecker 节点预检器
+// 在启动代理核心之前直接对 Server:Port 做TCP连接（以及TLS/QUIC握手），
+// 提前淘汰DNS失败、拒绝连接、不可达的节点，避免为死节点启动核心进程；
+// 超时和TLS握手失败不能证明节点不可用（直连可能受限，握手参数可能与探测不同），仍交给核心测试
+type PreflightChecker struct {
+	concurrency int
+	timeout     time.Duration
+	resolver    *net.Resolver
+}
+
+// NewPreflightChecker 创建新的预检器
+func NewPreflightChecker() *PreflightChecker {
+	return &PreflightChecker{
+		concurrency: 64, // 预检开销很小，可以使用高并发
+		timeout:     3 * time.Second,
+		resolver:    net.DefaultResolver,
+	}
+}
+
+// SetConcurrency 设置预检并发数
+func (p *PreflightChecker) SetConcurrency(concurrency int) {
+	if concurrency > 0 {
+		p.concurrency = concurrency
+	}
+}
+
+// SetTimeout 设置单个节点的预检超时
+func (p *PreflightChecker) SetTimeout(timeout time.Duration) {
+	if timeout > 0 {
+		p.timeout = timeout
+	}
+}
+
+// CheckNodes 并发预检所有节点，返回结果与节点一一对应
+func (p *PreflightChecker) CheckNodes(ctx context.Context, nodes []*types.Node) []*types.PreflightResult {
+	results := make([]*types.PreflightResult, len(nodes))
+	semaphore := make(chan struct{}, p.concurrency)
+	var wg sync.WaitGroup
+
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(index int, node *types.Node) {
+			defer wg.Done()
+
+			select {
+			case semaphore <- struct{}{}:
+				defer func() { <-semaphore }()
+			case <-ctx.Done():
+				results[index] = &types.PreflightResult{
+					Status:    types.PreflightError,
+					Address:   net.JoinHostPort(node.Server, node.Port),
+					Error:     ctx.Err().Error(),
+					CheckTime: time.Now(),
+				}
+				return
+			}
+
+			results[index] = p.CheckNode(ctx, node)
+		}(i, node)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// Filter 预检并过滤节点，返回通过预检的节点及全部预检结果（按节点指针索引）
+func (p *PreflightChecker) Filter(ctx context.Context, nodes []*types.Node) ([]*types.Node, map[*types.Node]*types.PreflightResult) {
+	fmt.Printf("🩺 开始预检 %d 个节点 (并发: %d, 超时: %v)...\n", len(nodes), p.concurrency, p.timeout)
+	start := time.Now()
+
+	results := p.CheckNodes(ctx, nodes)
+	resultMap := make(map[*types.Node]*types.PreflightResult, len(nodes))
+	passed := make([]*types.Node, 0, len(nodes))
+	stats := make(map[string]int)
+
+	for i, node := range nodes {
+		result := results[i]
+		resultMap[node] = result
+		stats[result.Status]++
+		if result.Passed() {
+			passed = append(passed, node)
+		}
+	}
+
+	fmt.Printf("🩺 预检完成，耗时 %v: 通过 %d/%d", time.Since(start).Round(time.Millisecond), len(passed), len(nodes))
+	for _, status := range []string{
+		types.PreflightUnconfirmed, types.PreflightDNSFailed, types.PreflightRefused,
+		types.PreflightUnreachable, types.PreflightTimeout, types.PreflightTLSFailed, types.PreflightError,
+	} {
+		if stats[status] > 0 {
+			fmt.Printf(", %s=%d", status, stats[status])
+		}
+	}
+	fmt.Printf("\n")
+
+	return passed, resultMap
+}
+
+// CheckNode 预检单个节点
+func (p *PreflightChecker) CheckNode(ctx context.Context, node *types.Node) *types.PreflightResult {
+	// 代理链节点的出口只接受中转IP连接，直连探测没有意义，改为探测第一跳（前置节点）
+	if front := proxy.FrontNode(node); front != nil {
+		node = front
+	}
+
+	address := net.JoinHostPort(node.Server, node.Port)
+	result := &types.PreflightResult{
+		Address:   address,
+		CheckTime: time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	// 先单独解析域名，便于区分DNS失败
+	if net.ParseIP(node.Server) == nil {
+		if _, err := p.resolver.LookupHost(ctx, node.Server); err != nil {
+			result.Method = "dns"
+			result.Status = classifyPreflightError(err)
+			result.Error = err.Error()
+			return result
+		}
+	}
+
+	start := time.Now()
+	var err error
+	if node.Protocol == "hysteria2" || node.Protocol == "tuic" {
+		result.Method = "quic"
+		var answered bool
+		answered, err = p.probeQUIC(ctx, address)
+		if err == nil && !answered {
+			result.Status = types.PreflightUnconfirmed
+		}
+	} else if serverName, ok := preflightTLSServerName(node); ok {
+		result.Method = "tls"
+		err = p.probeTLS(ctx, address, serverName)
+	} else {
+		result.Method = "tcp"
+		err = p.probeTCP(ctx, address)
+	}
+	result.Latency = time.Since(start).Milliseconds()
+
+	if err != nil {
+		result.Status = classifyPreflightError(err)
+		result.Error = err.Error()
+		return result
+	}
+	if result.Status == "" {
+		result.Status = types.PreflightOK
+	}
+	return result
+}
+
+// probeTCP 直接TCP连接
+func (p *PreflightChecker) probeTCP(ctx context.Context, address string) error {
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+// probeTLS TCP连接后完成TLS握手（不校验证书，只确认服务端能完成握手）
+func (p *PreflightChecker) probeTLS(ctx context.Context, address, serverName string) error {
+	dialer := &net.Dialer{}
+	rawConn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer rawConn.Close()
+
+	tlsConn := tls.Client(rawConn, &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: true,
+	})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return &preflightTLSError{err: err}
+	}
+	return nil
+}
+
+// probeQUIC 发送一个使用保留版本号的QUIC Initial包，
+// 正常的QUIC服务端会回复版本协商包；没有回复不代表节点不可用（如启用了混淆），
+// 但ICMP端口不可达会以连接被拒绝的形式返回
+func (p *PreflightChecker) probeQUIC(ctx context.Context, address string) (bool, error) {
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "udp", address)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write(buildQUICProbePacket()); err != nil {
+		return false, err
+	}
+
+	buf := make([]byte, 1500)
+	if _, err := conn.Read(buf); err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// buildQUICProbePacket 构造触发版本协商的QUIC长包头探测包（填充到1200字节）
+func buildQUICProbePacket() []byte {
+	packet := make([]byte, 1200)
+	packet[0] = 0xc0                                  // 长包头 + 固定位，Initial类型
+	copy(packet[1:5], []byte{0x1a, 0x2a, 0x3a, 0x4a}) // 保留用于强制版本协商的版本号
+	packet[5] = 8                                     // DCID长度
+	rand.Read(packet[6:14])                           // DCID
+	packet[14] = 8                                    // SCID长度
+	rand.Read(packet[15:23])                          // SCID
+	return packet
+}
+
+// preflightTLSServerName 判断节点是否使用TLS，并返回握手使用的SNI
+func preflightTLSServerName(node *types.Node) (string, bool) {
+	useTLS := false
+	switch node.Protocol {
+	case "trojan":
+		useTLS = node.Parameters["security"] != "none"
+	case "vless":
+		useTLS = node.Parameters["security"] == "tls"
+	case "vmess":
+		useTLS = node.Parameters["tls"] == "tls"
+	}
+	if !useTLS {
+		return "", false
+	}
+
+	for _, key := range []string{"sni", "peer", "host"} {
+		if value := node.Parameters[key]; value != "" {
+			return value, true
+		}
+	}
+	return node.Server, true
+}
+
+// preflightTLSError 标记TLS握手阶段的错误
+type preflightTLSError struct {
+	err error
+}
+
+func (e *preflightTLSError) Error() string {
+	return fmt.Sprintf("TLS握手失败: %v", e.err)
+}
+
+func (e *preflightTLSError) Unwrap() error {
+	return e.err
+}
+
+// classifyPreflightError 将网络错误归类为预检状态
+func classifyPreflightError(err error) string {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return types.PreflightDNSFailed
+	}
+
+	// TCP连接阶段的错误优先于TLS分类
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return types.PreflightRefused
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return types.PreflightUnreachable
+	}
+
+	var tlsErr *preflightTLSError
+	if errors.As(err, &tlsErr) {
+		return types.PreflightTLSFailed
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return types.PreflightTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return types.PreflightTimeout
+	}
+
+	// Windows下的错误码与syscall常量不一致，按错误信息兜底
+	message := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(message, "refused"):
+		return types.PreflightRefused
+	case strings.Contains(message, "unreachable"):
+		return types.PreflightUnreachable
+	}
+	return types.PreflightError
+}
@@ -9,18 +9,20 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"runtime"
-	"strings"
 	"sync"
 	"syscall"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/blacklist"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/filter"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/ipc"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/lifecycle"
 	"github.com/yxhpy/v2ray-subscription-manager/internal/core/proxy"
-	"github.com/yxhpy/v2ray-subscription-manager/internal/platform"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/rundir"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/statedir"
 	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
 )
 
@@ -33,11 +35,27 @@ type ProxyServer struct {
 	proxyManager     *proxy.ProxyManager
 	hysteria2Manager *proxy.Hysteria2ProxyManager
 	mutex            sync.RWMutex
+	switchMutex      sync.Mutex // 串行化节点切换
 	ctx              context.Context
 	cancel           context.CancelFunc
-	watcher          *fsnotify.Watcher
+
+	// 控制通道：接收测试器的候选节点和切换命令，configFile 仅作为重启时的快照
+	socketPath string
+	control    *ipc.Server
+	candidates []types.ValidNode
+
+	blacklist *blacklist.Blacklist // 拒绝切换到被封禁的节点
+	governor  *SwitchGovernor      // 切换策略：最小分数提升、最短停留和冷却
+
+	status *lifecycle.Status // 控制通道开始监听后就绪，Run 返回时结束
 }
 
+// proxyServerStopTimeout 代理服务器整体关闭期限
+const proxyServerStopTimeout = 15 * time.Second
+
+// portOwnerSwitchTest 切换前验证新节点时测试端口租约的占用者名称
+const portOwnerSwitchTest = "proxy-server-test"
+
 // NewProxyServer 创建新的代理服务器
 func NewProxyServer(configFile string, httpPort, socksPort int) *ProxyServer {
 	ctx, cancel := context.WithCancel(context.Background())
@@ -57,55 +75,84 @@ func NewProxyServer(configFile string, httpPort, socksPort int) *ProxyServer {
 		hysteria2Manager: proxy.NewHysteria2ProxyManager(),
 		ctx:              ctx,
 		cancel:           cancel,
+		socketPath:       ipc.SocketPathFor(absConfigFile),
+		blacklist:        blacklist.New(blacklist.DefaultFile()),
+		governor:         NewSwitchGovernor(DefaultSwitchPolicy()),
+		status:           lifecycle.NewStatus(),
 	}
 }
 
-// Start 启动代理服务器
+// Ready 控制通道开始监听、可以接收测试器的切换命令时关闭
+func (ps *ProxyServer) Ready() <-chan struct{} {
+	return ps.status.Ready()
+}
+
+// Done 代理服务器运行结束时关闭
+func (ps *ProxyServer) Done() <-chan struct{} {
+	return ps.status.Done()
+}
+
+// SetSwitchPolicy 设置节点切换策略
+func (ps *ProxyServer) SetSwitchPolicy(policy SwitchPolicy) {
+	ps.governor.SetPolicy(policy)
+}
+
+// SwitchDecisions 返回最近的切换决策（包括被拒绝的切换）
+func (ps *ProxyServer) SwitchDecisions() []SwitchDecision {
+	return ps.governor.Decisions()
+}
+
+// SetBlacklist 设置节点黑名单（与测试器共用同一个文件）
+func (ps *ProxyServer) SetBlacklist(b *blacklist.Blacklist) {
+	ps.blacklist = b
+}
+
+// SetSocketPath 设置控制通道socket路径（默认由配置文件路径推导）
+func (ps *ProxyServer) SetSocketPath(path string) {
+	ps.socketPath = path
+}
+
+// Start 启动代理服务器，阻塞直到 Stop 被调用
 func (ps *ProxyServer) Start() error {
+	ps.setupSignalHandler()
+	return ps.Run(context.Background())
+}
+
+// Run 在给定上下文中运行代理服务器，阻塞直到 ctx 取消或 Stop 被调用
+func (ps *ProxyServer) Run(ctx context.Context) error {
+	ps.ctx, ps.cancel = context.WithCancel(ctx)
+	ps.status.Begin()
+	defer ps.status.End()
+
 	fmt.Printf("🚀 启动代理服务器...\n")
 	fmt.Printf("📁 配置文件: %s\n", ps.configFile)
 	fmt.Printf("🌐 HTTP端口: %d\n", ps.httpPort)
 	fmt.Printf("🧦 SOCKS端口: %d\n", ps.socksPort)
 
-	// 设置信号处理
-	ps.setupSignalHandler()
-
-	// 启动文件监控（无论文件是否存在）
-	if err := ps.startFileWatcher(); err != nil {
-		return fmt.Errorf("启动文件监控失败: %v", err)
+	// 先启动控制通道，再读取快照，避免错过测试器在此期间发出的切换命令
+	if err := ps.startControlChannel(); err != nil {
+		return fmt.Errorf("启动控制通道失败: %v", err)
 	}
+	ps.status.MarkReady()
 
-	// 尝试加载初始配置
+	// 从快照恢复上次的最佳节点
 	if err := ps.loadConfig(); err != nil {
-		fmt.Printf("⚠️ 初始配置加载失败: %v\n", err)
-		fmt.Printf("⏳ 等待配置文件出现...\n")
-
-		// Windows 下立即启动轮询检查配置文件
-		if runtime.GOOS == "windows" {
-			fmt.Printf("🔄 启动轮询检查配置文件...\n")
-			go ps.pollConfigFile()
-		}
+		fmt.Printf("⚠️ 快照加载失败: %v\n", err)
+		fmt.Printf("⏳ 等待测试器推送节点...\n")
 	} else {
 		// 启动初始代理
 		if err := ps.startProxy(); err != nil {
 			fmt.Printf("⚠️ 启动初始代理失败: %v\n", err)
 			fmt.Printf("⏳ 等待有效配置...\n")
 		} else {
+			ps.governor.Activated(ps.CurrentNode())
 			fmt.Printf("✅ 代理服务器启动成功！\n")
 			fmt.Printf("🌐 HTTP代理: http://127.0.0.1:%d\n", ps.httpPort)
 			fmt.Printf("🧦 SOCKS代理: socks5://127.0.0.1:%d\n", ps.socksPort)
 		}
 	}
 
-	// Windows 下无论如何都启动轮询作为备用方案
-	if runtime.GOOS == "windows" {
-		go ps.pollConfigFileAsBackup()
-
-		// 启动强制初始化检查
-		go ps.forceInitCheck()
-	}
-
-	fmt.Printf("👁️ 监控配置文件变化中...\n")
+	fmt.Printf("👂 等待测试器的切换命令...\n")
 	fmt.Printf("📝 按 Ctrl+C 停止服务\n")
 
 	// 阻塞等待
@@ -115,85 +162,42 @@ func (ps *ProxyServer) Start() error {
 
 // Stop 停止代理服务器
 func (ps *ProxyServer) Stop() error {
-	fmt.Printf("🛑 停止代理服务器...\n")
+	ctx, cancel := context.WithTimeout(context.Background(), proxyServerStopTimeout)
+	defer cancel()
+	return ps.Shutdown(ctx)
+}
 
-	// 第一步：取消上下文
+// Shutdown 按依赖顺序停止代理服务器：先关闭控制通道不再接收切换命令，再停止代理进程并等待端口释放
+// 只有代理端口未能在期限内释放时才强制终止占用端口的残留进程
+func (ps *ProxyServer) Shutdown(ctx context.Context) error {
+	fmt.Printf("🛑 停止代理服务器...\n")
 	ps.cancel()
 
-	// 第二步：停止文件监控
-	if ps.watcher != nil {
-		fmt.Printf("  🛑 停止文件监控...\n")
-		if err := ps.watcher.Close(); err != nil {
-			fmt.Printf("    ⚠️ 文件监控停止异常: %v\n", err)
-		}
+	err := lifecycle.Shutdown(ctx,
+		lifecycle.Step{Name: "控制通道", Timeout: 2 * time.Second, Stop: func(ctx context.Context) error {
+			if ps.control == nil {
+				return nil
+			}
+			return ps.control.Close()
+		}},
+		lifecycle.Step{Name: "主循环", Timeout: 2 * time.Second, Stop: ps.status.WaitStopped},
+		lifecycle.Step{Name: "代理进程", Timeout: lifecycle.DefaultStopTimeout, Stop: func(ctx context.Context) error {
+			ps.stopProxyWithContext(ctx)
+			return nil
+		}},
+		lifecycle.Step{Name: "代理端口", Timeout: 3 * time.Second, Stop: func(ctx context.Context) error {
+			return lifecycle.WaitPortsFree(ctx, ps.httpPort, ps.socksPort)
+		}},
+	)
+	if err != nil {
+		fmt.Printf("  💀 强制终止残留进程...\n")
+		ps.killRelatedProcesses()
 	}
 
-	// 第三步：停止代理进程并等待
-	fmt.Printf("  🛑 停止代理进程...\n")
-	ps.stopProxy()
-	ps.waitForProxyStop()
-
-	// 第四步：等待所有操作完成
-	fmt.Printf("  ⏳ 等待所有操作完成...\n")
-	time.Sleep(3 * time.Second)
-
-	// 第五步：强制终止残留进程
-	fmt.Printf("  💀 强制终止残留进程...\n")
-	ps.killRelatedProcesses()
-
-	// 第六步：等待进程终止完成
-	time.Sleep(2 * time.Second)
-
-	// 第七步：清理临时配置文件
-	fmt.Printf("  🧹 清理临时配置文件...\n")
-	ps.cleanupTempFiles()
-
-	// 第八步：验证清理结果
 	ps.verifyProxyCleanup()
 
 	fmt.Printf("✅ 代理服务器已完全停止\n")
-	return nil
-}
-
-// waitForProxyStop 等待代理停止
-func (ps *ProxyServer) waitForProxyStop() {
-	maxWait := 10 * time.Second
-	interval := 500 * time.Millisecond
-	elapsed := time.Duration(0)
-
-	for elapsed < maxWait {
-		if ps.isProxyStopped() {
-			fmt.Printf("    ✅ 代理进程已停止\n")
-			return
-		}
-		time.Sleep(interval)
-		elapsed += interval
-	}
-
-	fmt.Printf("    ⚠️ 代理进程停止超时\n")
-}
-
-// isProxyStopped 检查代理是否已停止
-func (ps *ProxyServer) isProxyStopped() bool {
-	// 检查V2Ray代理
-	if ps.proxyManager != nil && ps.proxyManager.GetStatus().Running {
-		return false
-	}
-
-	// 检查Hysteria2代理
-	if ps.hysteria2Manager != nil && ps.hysteria2Manager.GetHysteria2Status().Running {
-		return false
-	}
-
-	// 检查端口是否已释放
-	ports := []int{ps.httpPort, ps.socksPort}
-	for _, port := range ports {
-		if ps.isPortInUse(port) {
-			return false
-		}
-	}
-
-	return true
+	return err
 }
 
 // isPortInUse 检查端口是否仍在使用
@@ -214,12 +218,8 @@ func (ps *ProxyServer) verifyProxyCleanup() {
 	ports := []int{ps.httpPort, ps.socksPort}
 	for _, port := range ports {
 		if ps.isPortInUse(port) {
-			fmt.Printf("    ⚠️ 端口仍被占用: %d\n", port)
-			// 尝试强制终止占用端口的进程
-			if pid := ps.getProcessByPort(port); pid > 0 {
-				exec.Command("kill", "-9", fmt.Sprintf("%d", pid)).Run()
-				fmt.Printf("    🔧 强制终止端口 %d 的进程 (PID: %d)\n", port, pid)
-			}
+			// 不属于本进程的占用者不做处理，只提示
+			fmt.Printf("    ⚠️ 端口仍被占用: %d（占用者不是本进程启动的核心）\n", port)
 		}
 	}
 
@@ -238,9 +238,9 @@ func (ps *ProxyServer) verifyProxyCleanup() {
 	fmt.Printf("    ✅ 代理清理验证完成\n")
 }
 
-// loadConfig 加载配置文件
+// loadConfig 加载状态快照
 func (ps *ProxyServer) loadConfig() error {
-	fmt.Printf("📄 加载配置文件: %s\n", ps.configFile)
+	fmt.Printf("📄 加载状态快照: %s\n", ps.configFile)
 
 	data, err := os.ReadFile(ps.configFile)
 	if err != nil {
@@ -255,6 +255,9 @@ func (ps *ProxyServer) loadConfig() error {
 	if state.BestNode == nil {
 		return fmt.Errorf("配置文件中没有最佳节点信息")
 	}
+	if blocked, entry := ps.blacklist.Check(state.BestNode.Node); blocked {
+		return fmt.Errorf("快照中的节点在黑名单中: %s (%s)", state.BestNode.Node.Name, entry.Value)
+	}
 
 	ps.mutex.Lock()
 	ps.currentNode = state.BestNode
@@ -283,13 +286,13 @@ func (ps *ProxyServer) startProxy() error {
 	// 停止现有代理
 	ps.stopProxy()
 
-	switch node.Node.Protocol {
-	case "vmess", "vless", "trojan", "ss":
-		return ps.startV2RayProxy(node.Node)
-	case "hysteria2":
+	switch {
+	case proxy.UsesHysteria2Client(node.Node):
 		return ps.startHysteria2Proxy(node.Node)
+	case proxy.UnsupportedReason(node.Node) == "":
+		return ps.startV2RayProxy(node.Node)
 	default:
-		return fmt.Errorf("不支持的协议: %s", node.Node.Protocol)
+		return fmt.Errorf("%s", proxy.UnsupportedReason(node.Node))
 	}
 }
 
@@ -298,9 +301,8 @@ func (ps *ProxyServer) startV2RayProxy(node *types.Node) error {
 	// 设置固定端口
 	ps.proxyManager.HTTPPort = ps.httpPort
 	ps.proxyManager.SOCKSPort = ps.socksPort
-	ps.proxyManager.ConfigPath = fmt.Sprintf("proxy_server_v2ray_%d.json", time.Now().UnixNano())
 
-	err := ps.proxyManager.StartProxy(node)
+	err := ps.proxyManager.StartProxyWithContext(ps.ctx, node)
 	if err != nil {
 		return fmt.Errorf("启动V2Ray代理失败: %v", err)
 	}
@@ -314,9 +316,8 @@ func (ps *ProxyServer) startHysteria2Proxy(node *types.Node) error {
 	// 设置固定端口
 	ps.hysteria2Manager.HTTPPort = ps.httpPort
 	ps.hysteria2Manager.SOCKSPort = ps.socksPort
-	ps.hysteria2Manager.SetConfigPath(fmt.Sprintf("./hysteria2/proxy_server_%d.yaml", time.Now().UnixNano()))
 
-	err := ps.hysteria2Manager.StartHysteria2Proxy(node)
+	err := ps.hysteria2Manager.StartHysteria2ProxyWithContext(ps.ctx, node)
 	if err != nil {
 		return fmt.Errorf("启动Hysteria2代理失败: %v", err)
 	}
@@ -327,387 +328,267 @@ func (ps *ProxyServer) startHysteria2Proxy(node *types.Node) error {
 
 // stopProxy 停止代理
 func (ps *ProxyServer) stopProxy() {
-	if ps.proxyManager != nil {
-		ps.proxyManager.StopProxy()
+	ctx, cancel := context.WithTimeout(context.Background(), lifecycle.DefaultStopTimeout)
+	defer cancel()
+	ps.stopProxyWithContext(ctx)
+}
+
+// stopProxyWithContext 停止代理进程并等待退出，ctx 结束时强制终止
+func (ps *ProxyServer) stopProxyWithContext(ctx context.Context) {
+	if ps.proxyManager != nil && ps.proxyManager.V2RayProcess != nil {
+		ps.proxyManager.StopProxyWithContext(ctx)
 	}
-	if ps.hysteria2Manager != nil {
-		ps.hysteria2Manager.StopHysteria2Proxy()
+	if ps.hysteria2Manager != nil && ps.hysteria2Manager.Hysteria2Process != nil {
+		ps.hysteria2Manager.StopHysteria2ProxyWithContext(ctx)
 	}
 }
 
-// startFileWatcher 启动文件监控
-func (ps *ProxyServer) startFileWatcher() error {
-	var err error
-	ps.watcher, err = fsnotify.NewWatcher()
-	if err != nil {
-		return fmt.Errorf("创建文件监控器失败: %v", err)
-	}
+// CurrentNode 返回当前使用的节点
+func (ps *ProxyServer) CurrentNode() *types.ValidNode {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	return ps.currentNode
+}
 
-	// Windows 下使用绝对路径
-	configFile := ps.configFile
-	if runtime.GOOS == "windows" {
-		if absPath, err := filepath.Abs(ps.configFile); err == nil {
-			configFile = absPath
-			ps.configFile = absPath // 更新为绝对路径
-			fmt.Printf("📁 使用绝对路径: %s\n", configFile)
-		}
+// CurrentCore 返回当前节点使用的核心（v2ray、xray 或 hysteria2），没有当前节点时为空
+func (ps *ProxyServer) CurrentCore() string {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	if ps.currentNode == nil {
+		return ""
 	}
+	core, _ := proxy.SelectCore(ps.currentNode.Node)
+	return core
+}
 
-	// 尝试监控配置文件，如果文件不存在则监控当前目录
-	err = ps.watcher.Add(configFile)
-	if err != nil {
-		// 如果文件不存在，监控当前目录来检测文件创建
-		fmt.Printf("📁 配置文件不存在，监控当前目录等待文件创建\n")
-
-		// 获取配置文件所在目录
-		configDir := filepath.Dir(configFile)
-		if configDir == "" || configDir == "." {
-			if absDir, err := filepath.Abs("."); err == nil {
-				configDir = absDir
-			} else {
-				configDir = "."
-			}
-		}
+// Candidates 返回测试器最近推送的候选节点（按排名）
+func (ps *ProxyServer) Candidates() []types.ValidNode {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	return append([]types.ValidNode(nil), ps.candidates...)
+}
 
-		fmt.Printf("📁 监控目录: %s\n", configDir)
-		err = ps.watcher.Add(configDir)
-		if err != nil {
-			return fmt.Errorf("添加目录监控失败: %v", err)
-		}
+// startControlChannel 启动与测试器之间的控制通道
+func (ps *ProxyServer) startControlChannel() error {
+	ps.control = ipc.NewServer(ps.socketPath, ps.handleControlMessage)
+	if err := ps.control.Listen(); err != nil {
+		return err
 	}
 
-	// 启动监控协程
-	go ps.watchFileChanges()
-
+	fmt.Printf("🔌 控制通道: %s\n", ps.socketPath)
+	go ps.control.Serve(ps.ctx)
 	return nil
 }
 
-// pollConfigFile Windows 下轮询检查配置文件（主要方案）
-func (ps *ProxyServer) pollConfigFile() {
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
-
-	var lastModTime time.Time
-	var fileExists bool
-
-	for {
-		select {
-		case <-ticker.C:
-			if info, err := os.Stat(ps.configFile); err == nil {
-				// 文件存在
-				if !fileExists {
-					// 文件刚刚创建
-					fileExists = true
-					fmt.Printf("🔄 轮询检测到配置文件创建: %s\n", ps.configFile)
-					ps.handleConfigChange()
-				} else if info.ModTime().After(lastModTime) {
-					// 文件已修改
-					lastModTime = info.ModTime()
-					fmt.Printf("🔄 轮询检测到配置文件变化: %s\n", ps.configFile)
-					ps.handleConfigChange()
-				}
-				lastModTime = info.ModTime()
-			} else {
-				// 文件不存在
-				if fileExists {
-					fileExists = false
-					fmt.Printf("🔄 轮询检测到配置文件被删除: %s\n", ps.configFile)
-				}
-			}
-		case <-ps.ctx.Done():
-			return
-		}
+// handleControlMessage 处理测试器发来的控制消息
+func (ps *ProxyServer) handleControlMessage(msg *ipc.Message) *ipc.Message {
+	var err error
+	switch msg.Type {
+	case ipc.TypeCandidates:
+		err = ps.handleCandidates(msg.Candidates)
+	case ipc.TypeSwitch:
+		fmt.Printf("📨 收到切换命令 #%d: %s\n", msg.Seq, msg.Node.Node.Name)
+		err = ps.switchToNode(msg.Node, msg.Force, msg.Reason)
 	}
-}
-
-// pollConfigFileAsBackup Windows 下轮询检查配置文件（备用方案）
-func (ps *ProxyServer) pollConfigFileAsBackup() {
-	// 等待一段时间再启动，避免与主轮询冲突
-	time.Sleep(10 * time.Second)
 
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
+	ps.mutex.RLock()
+	current := ps.currentNode
+	ps.mutex.RUnlock()
+	return ipc.NewAck(msg, current, err)
+}
 
-	var lastModTime time.Time
+// handleCandidates 更新候选节点列表；代理尚未运行时按排名依次尝试启动
+func (ps *ProxyServer) handleCandidates(candidates []types.ValidNode) error {
+	ps.mutex.Lock()
+	ps.candidates = candidates
+	running := ps.currentNode != nil
+	ps.mutex.Unlock()
 
-	for {
-		select {
-		case <-ticker.C:
-			if info, err := os.Stat(ps.configFile); err == nil {
-				if info.ModTime().After(lastModTime) {
-					lastModTime = info.ModTime()
-					fmt.Printf("🔄 备用轮询检测到配置文件变化: %s\n", ps.configFile)
-					ps.handleConfigChange()
-				}
-			}
-		case <-ps.ctx.Done():
-			return
-		}
+	fmt.Printf("📨 收到 %d 个候选节点\n", len(candidates))
+	if running || len(candidates) == 0 {
+		return nil
 	}
-}
 
-// forceInitCheck Windows 下强制初始化检查
-func (ps *ProxyServer) forceInitCheck() {
-	// 每5秒检查一次是否有配置文件但未启动代理
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ticker.C:
-			// 检查是否有配置文件但没有当前节点
-			if _, err := os.Stat(ps.configFile); err == nil {
-				ps.mutex.RLock()
-				hasCurrentNode := ps.currentNode != nil
-				ps.mutex.RUnlock()
-
-				if !hasCurrentNode {
-					fmt.Printf("🔍 强制检查：发现配置文件但未加载，尝试加载...\n")
-					if loadErr := ps.loadConfig(); loadErr == nil {
-						if startErr := ps.startProxy(); startErr == nil {
-							fmt.Printf("🎉 强制检查：成功启动代理服务！\n")
-							fmt.Printf("🌐 HTTP代理: http://127.0.0.1:%d\n", ps.httpPort)
-							fmt.Printf("🧦 SOCKS代理: socks5://127.0.0.1:%d\n", ps.socksPort)
-						} else {
-							fmt.Printf("❌ 强制检查：启动代理失败: %v\n", startErr)
-						}
-					} else {
-						fmt.Printf("❌ 强制检查：加载配置失败: %v\n", loadErr)
-					}
-				}
-			}
-		case <-ps.ctx.Done():
-			return
+	for i := range candidates {
+		candidate := &candidates[i]
+		if candidate.Node == nil || ps.blacklist.Blocked(candidate.Node) {
+			continue
+		}
+		if err := ps.switchToNode(candidate, false, "候选节点"); err == nil {
+			return nil
 		}
 	}
+	return fmt.Errorf("所有候选节点均启动失败")
 }
 
-// watchFileChanges 监控文件变化
-func (ps *ProxyServer) watchFileChanges() {
-	for {
-		select {
-		case event, ok := <-ps.watcher.Events:
-			if !ok {
-				return
-			}
-
-			// Windows 下需要处理路径格式差异
-			eventPath := event.Name
-			if runtime.GOOS == "windows" {
-				eventPath = filepath.Clean(eventPath)
-			}
-
-			configPath := ps.configFile
-			if runtime.GOOS == "windows" {
-				configPath = filepath.Clean(configPath)
-			}
-
-			// 检查是否是我们关心的配置文件
-			if eventPath == configPath || filepath.Base(eventPath) == filepath.Base(configPath) {
-				// 处理写入和创建事件
-				if event.Op&fsnotify.Write == fsnotify.Write {
-					fmt.Printf("📝 检测到配置文件变化: %s\n", event.Name)
-					// Windows 下需要额外等待时间确保文件写入完成
-					if runtime.GOOS == "windows" {
-						time.Sleep(500 * time.Millisecond)
-					}
-					ps.handleConfigChange()
-				} else if event.Op&fsnotify.Create == fsnotify.Create {
-					fmt.Printf("📄 检测到配置文件创建: %s\n", event.Name)
-					// 文件创建后，尝试添加直接监控
-					configDir := filepath.Dir(ps.configFile)
-					ps.watcher.Remove(configDir)
-					if err := ps.watcher.Add(ps.configFile); err == nil {
-						fmt.Printf("✅ 已切换到直接监控配置文件\n")
-					}
-					// Windows 下需要额外等待时间
-					if runtime.GOOS == "windows" {
-						time.Sleep(1 * time.Second)
-					}
-					ps.handleConfigChange()
-				} else if event.Op&fsnotify.Remove == fsnotify.Remove {
-					fmt.Printf("🗑️ 检测到配置文件被删除: %s\n", event.Name)
-					fmt.Printf("⏳ 继续使用当前节点，等待配置文件恢复...\n")
-					// 切换回监控目录
-					ps.watcher.Remove(ps.configFile)
-					configDir := filepath.Dir(ps.configFile)
-					ps.watcher.Add(configDir)
-				}
-			}
+// switchToNode 按切换策略决定是否切换到新节点，force 为 true 时跳过策略检查
+func (ps *ProxyServer) switchToNode(newNode *types.ValidNode, force bool, reason string) error {
+	ps.switchMutex.Lock()
+	defer ps.switchMutex.Unlock()
 
-		case err, ok := <-ps.watcher.Errors:
-			if !ok {
-				return
-			}
-			fmt.Printf("⚠️ 文件监控错误: %v\n", err)
+	if blocked, entry := ps.blacklist.Check(newNode.Node); blocked {
+		fmt.Printf("🚫 拒绝切换到黑名单中的节点: %s\n", newNode.Node.Name)
+		return fmt.Errorf("节点在黑名单中: %s (%s)", newNode.Node.Name, entry.Value)
+	}
 
-			// Windows 下如果文件监控出错，启用轮询备用方案
-			if runtime.GOOS == "windows" {
-				fmt.Printf("🔄 启用轮询备用方案...\n")
-				go ps.pollConfigFile()
-			}
+	ps.mutex.RLock()
+	currentNode := ps.currentNode
+	ps.mutex.RUnlock()
 
-		case <-ps.ctx.Done():
-			return
-		}
+	// 如果是同一个节点，不需要切换
+	if currentNode != nil && currentNode.Node.Fingerprint() == newNode.Node.Fingerprint() {
+		fmt.Printf("📊 节点未变化，无需切换\n")
+		return nil
 	}
-}
 
-// handleConfigChange 处理配置文件变化
-func (ps *ProxyServer) handleConfigChange() {
-	// Windows 下需要更长的等待时间确保文件写入完成
-	waitTime := 1 * time.Second
-	if runtime.GOOS == "windows" {
-		waitTime = 2 * time.Second
+	// 当前节点被封禁时必须立即更换
+	if currentNode != nil && !force && ps.blacklist.Blocked(currentNode.Node) {
+		force, reason = true, "当前节点已被封禁"
 	}
-	time.Sleep(waitTime)
 
-	fmt.Printf("🔄 处理配置变化...\n")
-
-	// 多次尝试读取文件（Windows 下可能存在文件锁定问题）
-	var data []byte
-	var err error
-	maxRetries := 3
-
-	for i := 0; i < maxRetries; i++ {
-		data, err = os.ReadFile(ps.configFile)
-		if err == nil {
-			break
+	// 用测试器最近推送的分数比较，避免与切换时的旧分数比较
+	current := ps.freshCurrent(currentNode)
+	decision := ps.governor.Evaluate(current, newNode, force, reason)
+	if !decision.Accepted {
+		alternative := ps.sameRegionAlternative(current, newNode)
+		if alternative == nil {
+			return fmt.Errorf("切换被策略拒绝: %s", decision.Reason)
 		}
-
-		if i < maxRetries-1 {
-			fmt.Printf("⚠️ 读取配置文件失败 (尝试 %d/%d): %v\n", i+1, maxRetries, err)
-			time.Sleep(1 * time.Second)
+		if decision = ps.governor.Evaluate(current, alternative, false, "同地区替代节点"); !decision.Accepted {
+			return fmt.Errorf("切换被策略拒绝: %s", decision.Reason)
 		}
+		newNode = alternative
 	}
 
-	if err != nil {
-		fmt.Printf("❌ 读取新配置失败: %v\n", err)
-		return
+	if err := ps.applySwitch(currentNode, newNode); err != nil {
+		ps.governor.Failed(newNode)
+		return err
 	}
+	ps.governor.Activated(newNode)
+	return nil
+}
 
-	var state MVPState
-	if err := json.Unmarshal(data, &state); err != nil {
-		fmt.Printf("❌ 解析新配置失败: %v\n", err)
-		return
+// freshCurrent 返回带有最新候选分数的当前节点副本，当前节点不在候选列表中时原样返回
+func (ps *ProxyServer) freshCurrent(currentNode *types.ValidNode) *types.ValidNode {
+	if currentNode == nil {
+		return nil
 	}
 
-	if state.BestNode == nil {
-		fmt.Printf("❌ 新配置中没有最佳节点信息\n")
-		return
+	fingerprint := currentNode.Node.Fingerprint()
+	for _, candidate := range ps.Candidates() {
+		if candidate.Node != nil && candidate.Node.Fingerprint() == fingerprint {
+			fresh := *currentNode
+			fresh.Score = candidate.Score
+			return &fresh
+		}
 	}
+	return currentNode
+}
 
-	// 检查是否需要切换
-	ps.mutex.RLock()
-	currentNode := ps.currentNode
-	ps.mutex.RUnlock()
-
-	newNode := state.BestNode
+// sameRegionAlternative 跨地区的目标被拒绝时，在候选节点中寻找与当前节点同地区且满足策略的节点
+func (ps *ProxyServer) sameRegionAlternative(current, rejected *types.ValidNode) *types.ValidNode {
+	if current == nil || !ps.governor.Policy().PreferSameRegion {
+		return nil
+	}
+	region := filter.DetectRegion(current.Node.Name)
+	targetRegion := filter.DetectRegion(rejected.Node.Name)
+	if region == "" || targetRegion == "" || region == targetRegion {
+		return nil
+	}
 
-	// 如果是同一个节点，不需要切换
-	if currentNode != nil &&
-		currentNode.Node.Name == newNode.Node.Name &&
-		currentNode.Node.Server == newNode.Node.Server &&
-		currentNode.Node.Port == newNode.Node.Port {
-		fmt.Printf("📊 节点未变化，无需切换\n")
-		return
+	currentFingerprint := current.Node.Fingerprint()
+	for _, candidate := range ps.Candidates() {
+		if candidate.Node == nil || candidate.Node.Fingerprint() == currentFingerprint {
+			continue
+		}
+		if filter.DetectRegion(candidate.Node.Name) != region || ps.blacklist.Blocked(candidate.Node) {
+			continue
+		}
+		if ps.governor.Accepts(current, &candidate) {
+			found := candidate
+			return &found
+		}
 	}
+	return nil
+}
 
+// applySwitch 切换到新节点，失败时回滚到原节点
+func (ps *ProxyServer) applySwitch(currentNode, newNode *types.ValidNode) error {
 	fmt.Printf("🔍 发现新节点，开始切换...\n")
 	fmt.Printf("📡 新节点: %s (分数: %.2f)\n", newNode.Node.Name, newNode.Score)
 	if currentNode != nil {
 		fmt.Printf("📡 当前节点: %s (分数: %.2f)\n", currentNode.Node.Name, currentNode.Score)
 	}
 
-	// Windows 下直接应用新节点，跳过测试以避免复杂性
-	if runtime.GOOS == "windows" {
-		fmt.Printf("🪟 Windows 环境：直接应用新节点...\n")
-
-		// 先停止现有代理
-		fmt.Printf("🛑 停止现有代理...\n")
-		ps.stopProxy()
-
-		// 等待代理完全停止
-		time.Sleep(3 * time.Second)
-
-		ps.mutex.Lock()
-		ps.currentNode = newNode
-		ps.mutex.Unlock()
-
-		if err := ps.startProxy(); err != nil {
-			fmt.Printf("❌ 切换到新节点失败: %v\n", err)
-			// 回滚到原节点
-			if currentNode != nil {
-				fmt.Printf("🔄 回滚到原节点...\n")
-				ps.mutex.Lock()
-				ps.currentNode = currentNode
-				ps.mutex.Unlock()
-				if rollbackErr := ps.startProxy(); rollbackErr != nil {
-					fmt.Printf("❌ 回滚失败: %v\n", rollbackErr)
-				}
-			}
-		} else {
-			fmt.Printf("🎉 成功切换到新节点: %s\n", newNode.Node.Name)
-			fmt.Printf("🌐 HTTP代理: http://127.0.0.1:%d\n", ps.httpPort)
-			fmt.Printf("🧦 SOCKS代理: socks5://127.0.0.1:%d\n", ps.socksPort)
+	// Windows 下直接应用新节点，跳过测试以避免复杂性；代理未运行时也无需预先测试
+	if runtime.GOOS != "windows" && currentNode != nil {
+		if !ps.testNode(newNode.Node) {
+			fmt.Printf("❌ 新节点测试失败，保持当前节点\n")
+			return fmt.Errorf("新节点测试失败: %s", newNode.Node.Name)
 		}
-		return
+		fmt.Printf("✅ 新节点测试通过，开始切换...\n")
 	}
 
-	// 非 Windows 环境继续使用测试机制
-	if ps.testNode(newNode.Node) {
-		fmt.Printf("✅ 新节点测试通过，开始切换...\n")
+	ps.mutex.Lock()
+	ps.currentNode = newNode
+	ps.mutex.Unlock()
 
+	if err := ps.startProxy(); err != nil {
+		fmt.Printf("❌ 切换到新节点失败: %v\n", err)
 		ps.mutex.Lock()
-		ps.currentNode = newNode
+		ps.currentNode = currentNode
 		ps.mutex.Unlock()
 
-		if err := ps.startProxy(); err != nil {
-			fmt.Printf("❌ 切换到新节点失败: %v\n", err)
-			// 回滚到原节点
-			ps.mutex.Lock()
-			ps.currentNode = currentNode
-			ps.mutex.Unlock()
-			ps.startProxy()
-		} else {
-			fmt.Printf("🎉 成功切换到新节点: %s\n", newNode.Node.Name)
+		// 回滚到原节点
+		if currentNode != nil {
+			fmt.Printf("🔄 回滚到原节点...\n")
+			if rollbackErr := ps.startProxy(); rollbackErr != nil {
+				fmt.Printf("❌ 回滚失败: %v\n", rollbackErr)
+			}
 		}
-	} else {
-		fmt.Printf("❌ 新节点测试失败，保持当前节点\n")
+		return fmt.Errorf("切换到新节点失败: %v", err)
 	}
+
+	fmt.Printf("🎉 成功切换到新节点: %s\n", newNode.Node.Name)
+	fmt.Printf("🌐 HTTP代理: http://127.0.0.1:%d\n", ps.httpPort)
+	fmt.Printf("🧦 SOCKS代理: socks5://127.0.0.1:%d\n", ps.socksPort)
+	return nil
 }
 
 // testNode 测试节点连通性
 func (ps *ProxyServer) testNode(node *types.Node) bool {
 	fmt.Printf("🧪 测试节点: %s (%s)\n", node.Name, node.Protocol)
 
-	// 使用临时端口测试
-	testHTTPPort := ps.httpPort + 1000
-	testSOCKSPort := ps.socksPort + 1000
+	// 从共享端口分配器租用临时测试端口，代理进程退出时租约自动释放
+	httpLease, socksLease, err := proxy.Ports().AcquirePair(portOwnerSwitchTest, 0)
+	if err != nil {
+		fmt.Printf("❌ 分配测试端口失败: %v\n", err)
+		return false
+	}
+	defer proxy.Ports().Release(httpLease)
+	defer proxy.Ports().Release(socksLease)
+	testHTTPPort := httpLease.Port
+	testSOCKSPort := socksLease.Port
 
-	var err error
+	switch {
+	case proxy.UsesHysteria2Client(node):
+		hysteria2Mgr := proxy.NewHysteria2ProxyManager()
+		hysteria2Mgr.HTTPPort = testHTTPPort
+		hysteria2Mgr.SOCKSPort = testSOCKSPort
+
+		err = hysteria2Mgr.StartHysteria2ProxyWithContext(ps.ctx, node)
+		defer hysteria2Mgr.StopHysteria2Proxy()
 
-	switch node.Protocol {
-	case "vmess", "vless", "trojan", "ss":
+	case proxy.UnsupportedReason(node) == "":
 		v2rayMgr := proxy.NewProxyManager()
 		v2rayMgr.HTTPPort = testHTTPPort
 		v2rayMgr.SOCKSPort = testSOCKSPort
-		v2rayMgr.ConfigPath = fmt.Sprintf("test_proxy_%d.json", time.Now().UnixNano())
 
-		err = v2rayMgr.StartProxy(node)
+		err = v2rayMgr.StartProxyWithContext(ps.ctx, node)
 		defer v2rayMgr.StopProxy()
 
-	case "hysteria2":
-		hysteria2Mgr := proxy.NewHysteria2ProxyManager()
-		hysteria2Mgr.HTTPPort = testHTTPPort
-		hysteria2Mgr.SOCKSPort = testSOCKSPort
-		hysteria2Mgr.SetConfigPath(fmt.Sprintf("./hysteria2/test_proxy_%d.yaml", time.Now().UnixNano()))
-
-		err = hysteria2Mgr.StartHysteria2Proxy(node)
-		defer hysteria2Mgr.StopHysteria2Proxy()
-
 	default:
-		fmt.Printf("❌ 不支持的协议: %s\n", node.Protocol)
+		fmt.Printf("❌ %s\n", proxy.UnsupportedReason(node))
 		return false
 	}
 
@@ -716,9 +597,6 @@ func (ps *ProxyServer) testNode(node *types.Node) bool {
 		return false
 	}
 
-	// 等待代理启动
-	time.Sleep(3 * time.Second)
-
 	// 执行详细的连通性测试
 	success := ps.detailedConnectivityTest(testHTTPPort)
 
@@ -796,151 +674,18 @@ func (ps *ProxyServer) setupSignalHandler() {
 		<-c
 		fmt.Printf("\n🛑 接收到退出信号，正在停止服务...\n")
 		ps.Stop()
+		rundir.CleanupSession()
 		os.Exit(0)
 	}()
 }
 
-// cleanupTempFiles 清理临时文件
-func (ps *ProxyServer) cleanupTempFiles() {
-	patterns := []string{
-		"proxy_server_v2ray_*.json",
-		"proxy_server_hysteria2_*.json",
-		"temp_v2ray_config_*.json",
-		"temp_hysteria2_config_*.json",
-		"test_proxy_*.json",
-		"*.tmp",
-		"*.temp",
-	}
-
-	cleanedCount := 0
-	for _, pattern := range patterns {
-		matches, err := filepath.Glob(pattern)
-		if err != nil {
-			continue
-		}
-
-		for _, file := range matches {
-			if err := os.Remove(file); err == nil {
-				fmt.Printf("    🗑️  已删除: %s\n", file)
-				cleanedCount++
-			}
-		}
-	}
-
-	// 清理hysteria2目录下的临时文件
-	hysteria2Patterns := []string{
-		"./hysteria2/proxy_server_*.yaml",
-		"./hysteria2/temp_*.yaml",
-		"./hysteria2/test_proxy_*.yaml",
-	}
-
-	for _, pattern := range hysteria2Patterns {
-		matches, err := filepath.Glob(pattern)
-		if err != nil {
-			continue
-		}
-
-		for _, file := range matches {
-			if err := os.Remove(file); err == nil {
-				fmt.Printf("    🗑️  已删除: %s\n", file)
-				cleanedCount++
-			}
-		}
-	}
-
-	if cleanedCount > 0 {
-		fmt.Printf("    ✅ 共清理了 %d 个临时文件\n", cleanedCount)
-	}
-}
-
-// killRelatedProcesses 杀死相关进程
+// killRelatedProcesses 强制终止本进程启动且仍在运行的核心进程
+// 只处理进程登记表中属于本进程的记录，不会波及其他程序或其他实例的进程
 func (ps *ProxyServer) killRelatedProcesses() {
-	fmt.Printf("    💀 终止相关进程...\n")
-
-	// 首先尝试通过端口清理
-	ports := []int{ps.httpPort, ps.socksPort}
-	for _, port := range ports {
-		if err := platform.KillProcessByPort(port); err == nil {
-			fmt.Printf("      🔧 已终止占用端口 %d 的进程\n", port)
-		}
+	fmt.Printf("    💀 终止本进程启动的核心进程...\n")
+	if killed := lifecycle.DefaultRegistry().KillOwned(); killed == 0 {
+		fmt.Printf("      ✅ 没有残留的核心进程\n")
 	}
-
-	// 然后按进程名清理
-	processNames := []string{"v2ray", "xray", "hysteria2", "hysteria"}
-
-	if runtime.GOOS == "windows" {
-		// Windows 使用taskkill
-		for _, processName := range processNames {
-			cmd := exec.Command("taskkill", "/F", "/IM", processName+".exe")
-			if err := cmd.Run(); err == nil {
-				fmt.Printf("      🔧 已终止 %s 进程\n", processName)
-			}
-		}
-	} else {
-		// Unix 使用pkill
-		for _, processName := range processNames {
-			cmd := exec.Command("pkill", "-f", processName)
-			if err := cmd.Run(); err == nil {
-				fmt.Printf("      🔧 已终止 %s 进程\n", processName)
-			}
-		}
-	}
-
-	// 特别处理占用端口的进程（备用方案）
-	for _, port := range ports {
-		if pid := ps.getProcessByPort(port); pid > 0 {
-			if runtime.GOOS == "windows" {
-				cmd := exec.Command("taskkill", "/F", "/PID", fmt.Sprintf("%d", pid))
-				if err := cmd.Run(); err == nil {
-					fmt.Printf("      🔧 已终止占用端口 %d 的进程 (PID: %d)\n", port, pid)
-				}
-			} else {
-				cmd := exec.Command("kill", "-9", fmt.Sprintf("%d", pid))
-				if err := cmd.Run(); err == nil {
-					fmt.Printf("      🔧 已终止占用端口 %d 的进程 (PID: %d)\n", port, pid)
-				}
-			}
-		}
-	}
-}
-
-// getProcessByPort 获取占用指定端口的进程ID
-func (ps *ProxyServer) getProcessByPort(port int) int {
-	if runtime.GOOS == "windows" {
-		// Windows 使用 netstat
-		cmd := exec.Command("netstat", "-ano", "-p", "tcp")
-		output, err := cmd.Output()
-		if err != nil {
-			return 0
-		}
-
-		lines := strings.Split(string(output), "\n")
-		for _, line := range lines {
-			if strings.Contains(line, fmt.Sprintf(":%d", port)) && strings.Contains(line, "LISTENING") {
-				fields := strings.Fields(line)
-				if len(fields) >= 5 {
-					var pid int
-					if _, err := fmt.Sscanf(fields[4], "%d", &pid); err == nil {
-						return pid
-					}
-				}
-			}
-		}
-	} else {
-		// Unix 使用 lsof
-		cmd := exec.Command("lsof", "-ti", fmt.Sprintf(":%d", port))
-		output, err := cmd.Output()
-		if err != nil {
-			return 0
-		}
-
-		var pid int
-		if _, err := fmt.Sscanf(string(output), "%d", &pid); err == nil {
-			return pid
-		}
-	}
-
-	return 0
 }
 
 // RunProxyServer 运行代理服务器
@@ -950,14 +695,14 @@ func RunProxyServer(configFile string, httpPort, socksPort int) error {
 }
 
 // RunDualProxySystem 运行双进程代理系统
-func RunDualProxySystem(subscriptionURL string, httpPort, socksPort int) error {
+func RunDualProxySystem(subscriptionURL string, httpPort, socksPort int, nodeFilter *filter.Filter) error {
 	fmt.Printf("🚀 启动双进程代理系统...\n")
 	fmt.Printf("📡 订阅链接: %s\n", subscriptionURL)
 	fmt.Printf("🌐 HTTP端口: %d\n", httpPort)
 	fmt.Printf("🧦 SOCKS端口: %d\n", socksPort)
 
-	// 状态文件路径
-	stateFile := "mvp_best_node.json"
+	// 状态文件路径（重启时恢复用的快照，放在持久状态目录）
+	stateFile := statedir.File("mvp_best_node.json")
 
 	// 创建MVP测试器
 	tester := NewMVPTester(subscriptionURL)
@@ -965,28 +710,32 @@ func RunDualProxySystem(subscriptionURL string, httpPort, socksPort int) error {
 	tester.SetInterval(5 * time.Minute) // 每5分钟测试一次
 	tester.SetMaxNodes(50)              // 最多测试50个节点
 	tester.SetConcurrency(5)            // 并发数为5
+	tester.SetFilter(nodeFilter)
 
 	// 创建代理服务器
 	server := NewProxyServer(stateFile, httpPort, socksPort)
 
-	// 设置信号处理
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// 启动MVP测试器
+	// 先启动代理服务器：测试器通过它的控制通道推送节点
 	go func() {
-		if err := tester.Start(); err != nil {
-			fmt.Printf("❌ MVP测试器启动失败: %v\n", err)
+		if err := server.Run(ctx); err != nil {
+			fmt.Printf("❌ 代理服务器启动失败: %v\n", err)
 		}
 	}()
+	readyCtx, cancelReady := context.WithTimeout(ctx, 10*time.Second)
+	err := server.status.WaitReady(readyCtx, "代理服务器")
+	cancelReady()
+	if err != nil {
+		server.Stop()
+		return err
+	}
 
-	// 等待一下，让测试器先运行
-	time.Sleep(2 * time.Second)
-
-	// 启动代理服务器
+	// 代理服务器就绪后启动MVP测试器
 	go func() {
-		if err := server.Start(); err != nil {
-			fmt.Printf("❌ 代理服务器启动失败: %v\n", err)
+		if err := tester.Run(ctx); err != nil {
+			fmt.Printf("❌ MVP测试器启动失败: %v\n", err)
 		}
 	}()
 
@@ -994,15 +743,16 @@ func RunDualProxySystem(subscriptionURL string, httpPort, socksPort int) error {
 	fmt.Printf("📝 按 Ctrl+C 停止服务\n")
 
 	// 等待停止信号
-	<-c
+	<-ctx.Done()
 	fmt.Printf("\n🛑 接收到停止信号，正在停止系统...\n")
 
-	// 停止所有服务
-	fmt.Printf("  🛑 停止MVP测试器...\n")
-	tester.Stop()
-
-	fmt.Printf("  🛑 停止代理服务器...\n")
-	server.Stop()
+	// 按依赖顺序停止：测试器依赖代理服务器的控制通道，先停止
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), mvpTesterStopTimeout+proxyServerStopTimeout)
+	defer cancel()
+	lifecycle.Shutdown(shutdownCtx,
+		lifecycle.Step{Name: "MVP测试器", Timeout: mvpTesterStopTimeout, Stop: tester.Shutdown},
+		lifecycle.Step{Name: "代理服务器", Timeout: proxyServerStopTimeout, Stop: server.Shutdown},
+	)
 
 	fmt.Printf("✅ 双进程代理系统已完全停止\n")
 	return nil
@@ -1,6 +1,7 @@
 package workflow
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -8,19 +9,23 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
-	"runtime"
 	"sort"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/blacklist"
 	"github.com/yxhpy/v2ray-subscription-manager/internal/core/downloader"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/filter"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/history"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/lifecycle"
 	"github.com/yxhpy/v2ray-subscription-manager/internal/core/parser"
 	"github.com/yxhpy/v2ray-subscription-manager/internal/core/proxy"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/report"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/rundir"
 	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
 )
 
@@ -32,18 +37,27 @@ type SpeedTestResult struct {
 	Error    string      `json:"error,omitempty"`
 	TestTime time.Time   `json:"test_time"`
 	Speed    float64     `json:"speed_mbps"` // 速度 Mbps
+
+	Preflight *types.PreflightResult `json:"preflight,omitempty"` // 预检结果
+	Hops      []types.HopResult      `json:"hops,omitempty"`      // 代理链节点每一跳的状态
 }
 
 // WorkflowConfig 工作流配置
 type WorkflowConfig struct {
-	SubscriptionURL string `json:"subscription_url"`
-	MaxConcurrency  int    `json:"max_concurrency"`
-	TestTimeout     int    `json:"test_timeout_seconds"`
-	OutputFile      string `json:"output_file"`
-	TestURL         string `json:"test_url"`
-	MaxNodes        int    `json:"max_nodes"` // 最大测试节点数
+	SubscriptionURL string   `json:"subscription_url"`
+	MaxConcurrency  int      `json:"max_concurrency"`
+	TestTimeout     int      `json:"test_timeout_seconds"`
+	OutputFile      string   `json:"output_file"`
+	TestURL         string   `json:"test_url"`
+	MaxNodes        int      `json:"max_nodes"`        // 最大测试节点数
+	EnablePreflight bool     `json:"enable_preflight"` // 代理测试前是否直连预检
+	Formats         []string `json:"formats"`          // 额外输出的报告格式（csv/junit/markdown/html/json）
+	HistoryFile     string   `json:"history_file"`     // 节点测试历史文件，为空时不记录
 }
 
+// portOwnerSpeedTest 测速时测试端口租约的占用者名称
+const portOwnerSpeedTest = "speedtest"
+
 // SpeedTestWorkflow 测速工作流
 type SpeedTestWorkflow struct {
 	config         WorkflowConfig
@@ -51,6 +65,12 @@ type SpeedTestWorkflow struct {
 	mutex          sync.Mutex
 	activeManagers []ProxyManagerInterface // 跟踪活跃的代理管理器
 	managerMutex   sync.Mutex
+
+	preflightResults map[*types.Node]*types.PreflightResult
+	startTime        time.Time
+	filter           *filter.Filter
+	blacklist        *blacklist.Blacklist
+	frontProbe       *FrontProbe
 }
 
 // ProxyManagerInterface 代理管理器接口
@@ -86,9 +106,13 @@ func NewSpeedTestWorkflow(subscriptionURL string) *SpeedTestWorkflow {
 			OutputFile:      "speed_test_results.txt",
 			TestURL:         "http://www.baidu.com", // 默认使用百度
 			MaxNodes:        0,                      // 0表示不限制
+			EnablePreflight: true,
+			HistoryFile:     history.DefaultFile(),
 		},
 		results:        make([]SpeedTestResult, 0),
 		activeManagers: make([]ProxyManagerInterface, 0),
+		blacklist:      blacklist.New(blacklist.DefaultFile()),
+		frontProbe:     NewFrontProbe(),
 	}
 }
 
@@ -117,8 +141,34 @@ func (w *SpeedTestWorkflow) SetMaxNodes(maxNodes int) {
 	w.config.MaxNodes = maxNodes
 }
 
+// SetPreflight 设置是否在代理测试前进行直连预检
+func (w *SpeedTestWorkflow) SetPreflight(enabled bool) {
+	w.config.EnablePreflight = enabled
+}
+
+// SetFormats 设置额外输出的报告格式
+func (w *SpeedTestWorkflow) SetFormats(formats []string) {
+	w.config.Formats = formats
+}
+
+// SetFilter 设置节点过滤与重命名规则
+func (w *SpeedTestWorkflow) SetFilter(f *filter.Filter) {
+	w.filter = f
+}
+
+// SetBlacklist 设置节点黑名单，为nil时不过滤
+func (w *SpeedTestWorkflow) SetBlacklist(b *blacklist.Blacklist) {
+	w.blacklist = b
+}
+
+// SetHistoryFile 设置节点测试历史文件，为空时不记录
+func (w *SpeedTestWorkflow) SetHistoryFile(path string) {
+	w.config.HistoryFile = path
+}
+
 // Run 运行工作流
 func (w *SpeedTestWorkflow) Run() error {
+	w.startTime = time.Now()
 	fmt.Printf("🚀 开始执行测速工作流...\n")
 	fmt.Printf("📡 订阅链接: %s\n", w.config.SubscriptionURL)
 	fmt.Printf("⚡ 并发数: %d\n", w.config.MaxConcurrency)
@@ -145,14 +195,26 @@ func (w *SpeedTestWorkflow) Run() error {
 	}
 	fmt.Printf("✅ 成功解析 %d 个节点\n", len(nodes))
 
+	// 协议或插件不受支持的节点不测试，直接记为失败
+	nodes = w.skipUnsupportedNodes(nodes)
+
+	// 预检：直连淘汰死节点，未通过的节点直接记为失败
+	if w.config.EnablePreflight {
+		fmt.Printf("\n🩺 正在预检节点可达性...\n")
+		nodes = w.preflightNodes(nodes)
+	}
+
 	// 步骤2: 多线程测试所有节点
 	fmt.Printf("\n🧪 开始多线程测试节点...\n")
-	fmt.Printf("💪 使用 %d 个线程并发测试，榨干CPU性能！\n", w.config.MaxConcurrency)
+	fmt.Printf("💪 初始并发 %d，将根据延迟和超时自适应调整\n", w.config.MaxConcurrency)
 	err = w.testAllNodes(nodes)
 	if err != nil {
 		return fmt.Errorf("测试节点失败: %v", err)
 	}
 
+	// 记录测试历史
+	w.recordHistory()
+
 	// 步骤3: 按速度排序
 	fmt.Printf("\n📊 按速度排序结果...\n")
 	w.sortResultsBySpeed()
@@ -186,6 +248,7 @@ func (w *SpeedTestWorkflow) setupSignalHandler() {
 		<-c
 		fmt.Printf("\n🛑 接收到退出信号，正在清理资源...\n")
 		w.cleanupAllResources()
+		rundir.CleanupSession()
 		os.Exit(1)
 	}()
 }
@@ -222,159 +285,26 @@ func (w *SpeedTestWorkflow) cleanupAllResources() {
 	}
 	w.activeManagers = nil
 
-	// 强制杀掉所有可能的残留进程
-	exec.Command("pkill", "-f", "v2ray").Run()
-	exec.Command("pkill", "-f", "hysteria").Run()
+	// 兜底：只终止本进程启动且仍在运行的核心进程
+	lifecycle.DefaultRegistry().KillOwned()
 	fmt.Printf("✅ 资源清理完成\n")
 }
 
-// deepCleanup 深度清理资源
+// deepCleanup 深度清理资源：删除本进程会话目录中的临时配置文件，并兜底终止本进程启动的核心进程
 func (w *SpeedTestWorkflow) deepCleanup() {
 	fmt.Printf("🧹 执行深度资源清理...\n")
 
-	// 清理所有可能的临时配置文件
-	if runtime.GOOS != "windows" {
-		// Unix/Linux/macOS环境下的清理
-		exec.Command("find", ".", "-name", "temp_config_*.json", "-delete").Run()
-		exec.Command("find", ".", "-name", "config_*.yaml", "-delete").Run()
-		exec.Command("rm", "-f", "hysteria2/config.yaml.tmp*").Run()
-		exec.Command("rm", "-f", "hysteria2/config_*.yaml").Run()
-
-		// 强制清理所有可能占用的端口（轻量级检查）
-		for port := 10000; port < 20000; port += 100 {
-			// 只检查主要端口，不执行kill操作避免影响其他进程
-			exec.Command("lsof", "-ti", fmt.Sprintf(":%d", port)).Run()
-		}
-
-		// 最后一次强制清理进程
-		exec.Command("pkill", "-f", "v2ray").Run()
-		exec.Command("pkill", "-f", "hysteria").Run()
-
-		fmt.Printf("🧹 Unix环境清理完成\n")
-	} else {
-		// Windows环境下的清理
-		w.cleanupTempFilesWindows()
+	// 所有临时配置文件都在本进程的会话目录中，整个删除即可
+	if err := rundir.CleanupSession(); err != nil {
+		fmt.Printf("⚠️  清理会话目录失败: %v\n", err)
 	}
 
-	// 跨平台通用清理
-	w.cleanupAdditionalTempFiles()
-
-	// 等待一下让进程完全退出
-	time.Sleep(2 * time.Second)
+	// 最后一次兜底清理本进程启动的核心进程
+	lifecycle.DefaultRegistry().KillOwned()
 
 	fmt.Printf("✅ 深度清理完成\n")
 }
 
-// cleanupTempFilesWindows Windows环境下的临时文件清理
-func (w *SpeedTestWorkflow) cleanupTempFilesWindows() {
-	fmt.Printf("🧹 Windows环境临时文件清理...\n")
-
-	// 清理V2Ray临时配置文件
-	files, err := filepath.Glob("temp_config_*.json")
-	if err == nil {
-		for _, file := range files {
-			if err := os.Remove(file); err == nil {
-				fmt.Printf("🧹 已清理V2Ray配置: %s\n", file)
-			}
-		}
-	}
-
-	// 调用专门的Hysteria2清理方法
-	w.cleanupWindowsHysteria2Files()
-
-	// 额外清理可能遗留的文件
-	w.cleanupAdditionalTempFiles()
-}
-
-// cleanupAdditionalTempFiles 清理额外的临时文件
-func (w *SpeedTestWorkflow) cleanupAdditionalTempFiles() {
-	// 清理可能的其他临时文件模式
-	patterns := []string{
-		"*.tmp",
-		"*.temp",
-		"config_*.json",
-		"temp_*.yaml",
-		"test_proxy_*.json", // 添加test_proxy_开头的JSON文件
-		"test_proxy_*.yaml", // 添加test_proxy_开头的YAML文件
-	}
-
-	for _, pattern := range patterns {
-		if files, err := filepath.Glob(pattern); err == nil {
-			for _, file := range files {
-				// 只删除明显是临时文件的
-				if strings.Contains(file, "temp") || strings.Contains(file, "tmp") || strings.Contains(file, "test_proxy") {
-					if err := os.Remove(file); err == nil {
-						fmt.Printf("🧹 已清理临时文件: %s\n", file)
-					}
-				}
-			}
-		}
-	}
-}
-
-// cleanupHysteria2TempFiles 清理Hysteria2临时文件
-func (w *SpeedTestWorkflow) cleanupHysteria2TempFiles(manager *proxy.Hysteria2ProxyManager) {
-	if manager == nil {
-		return
-	}
-
-	// 由于downloader字段是私有的，我们直接清理可能的临时配置文件
-
-	// 清理可能的临时配置文件（使用多种模式匹配）
-	patterns := []string{
-		"./hysteria2/config_*.yaml",    // 新的命名模式
-		"./hysteria2/config.yaml.tmp*", // 可能的临时文件
-		"hysteria2/config_*.yaml",      // 无./前缀的模式
-		"hysteria2/config.yaml.tmp*",   // 无./前缀的临时文件
-	}
-
-	for _, pattern := range patterns {
-		files, err := filepath.Glob(pattern)
-		if err == nil {
-			for _, file := range files {
-				if err := os.Remove(file); err == nil {
-					fmt.Printf("🧹 已清理临时文件: %s\n", file)
-				}
-			}
-		}
-	}
-
-	// Windows特殊处理：强制清理可能被锁定的文件
-	if runtime.GOOS == "windows" {
-		w.cleanupWindowsHysteria2Files()
-	}
-}
-
-// cleanupWindowsHysteria2Files Windows下的特殊清理方法
-func (w *SpeedTestWorkflow) cleanupWindowsHysteria2Files() {
-	// 等待一小段时间，让文件句柄释放
-	time.Sleep(100 * time.Millisecond)
-
-	// 尝试清理hysteria2目录下的所有yaml文件
-	hysteria2Dir := "./hysteria2"
-	if _, err := os.Stat(hysteria2Dir); err == nil {
-		files, err := filepath.Glob(filepath.Join(hysteria2Dir, "*.yaml"))
-		if err == nil {
-			for _, file := range files {
-				// 检查文件是否包含临时标识
-				if strings.Contains(file, "config_") || strings.Contains(file, ".tmp") {
-					// 多次尝试删除，因为Windows可能有文件锁
-					for i := 0; i < 3; i++ {
-						if err := os.Remove(file); err == nil {
-							fmt.Printf("🧹 Windows清理成功: %s\n", file)
-							break
-						} else if i == 2 {
-							fmt.Printf("⚠️  Windows清理失败 %s: %v\n", file, err)
-						} else {
-							time.Sleep(50 * time.Millisecond)
-						}
-					}
-				}
-			}
-		}
-	}
-}
-
 // checkAndInstallDependencies 检查和安装必要依赖
 func (w *SpeedTestWorkflow) checkAndInstallDependencies() error {
 	fmt.Printf("🔍 检查V2Ray核心...\n")
@@ -424,6 +354,19 @@ func (w *SpeedTestWorkflow) parseSubscription() ([]*types.Node, error) {
 		return nil, err
 	}
 
+	// 应用过滤与重命名规则
+	if !w.filter.Empty() {
+		total := len(nodes)
+		nodes = w.filter.Apply(nodes)
+		fmt.Printf("🔎 过滤规则保留 %d/%d 个节点\n", len(nodes), total)
+	}
+
+	// 跳过黑名单中的节点
+	var blocked int
+	if nodes, blocked = w.blacklist.Filter(nodes); blocked > 0 {
+		fmt.Printf("🚫 跳过 %d 个黑名单中的节点\n", blocked)
+	}
+
 	if len(nodes) == 0 {
 		return nil, fmt.Errorf("未找到有效节点")
 	}
@@ -437,6 +380,56 @@ func (w *SpeedTestWorkflow) parseSubscription() ([]*types.Node, error) {
 	return nodes, nil
 }
 
+// preflightNodes 预检节点，返回通过预检的节点，未通过的节点直接写入结果
+func (w *SpeedTestWorkflow) preflightNodes(nodes []*types.Node) []*types.Node {
+	passed, results := NewPreflightChecker().Filter(context.Background(), nodes)
+	w.preflightResults = results
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, node := range nodes {
+		result := results[node]
+		if result.Passed() {
+			continue
+		}
+		w.results = append(w.results, SpeedTestResult{
+			Node:      node,
+			Success:   false,
+			Error:     fmt.Sprintf("预检失败(%s): %s", result.Status, result.Error),
+			TestTime:  result.CheckTime,
+			Preflight: result,
+		})
+	}
+
+	return passed
+}
+
+// skipUnsupportedNodes 返回可以运行的节点，协议或 Shadowsocks 插件不受支持的节点直接写入结果
+func (w *SpeedTestWorkflow) skipUnsupportedNodes(nodes []*types.Node) []*types.Node {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	supported := nodes[:0:0]
+	for _, node := range nodes {
+		reason := proxy.UnsupportedReason(node)
+		if reason == "" {
+			supported = append(supported, node)
+			continue
+		}
+		w.results = append(w.results, SpeedTestResult{
+			Node:     node,
+			Success:  false,
+			Error:    reason,
+			TestTime: time.Now(),
+		})
+	}
+
+	if skipped := len(nodes) - len(supported); skipped > 0 {
+		fmt.Printf("⚠️ 跳过 %d 个无法运行的节点（不支持的协议或插件）\n", skipped)
+	}
+	return supported
+}
+
 // testAllNodes 多线程测试所有节点
 func (w *SpeedTestWorkflow) testAllNodes(nodes []*types.Node) error {
 	// 创建工作队列
@@ -449,13 +442,14 @@ func (w *SpeedTestWorkflow) testAllNodes(nodes []*types.Node) error {
 	}
 	close(nodeQueue)
 
-	// 创建工作协程，为每个协程分配不同的端口范围
+	// 自适应并发：按上限创建工作协程，实际并行数由控制器决定
+	limiter := NewAdaptiveLimiter(0, w.config.MaxConcurrency)
+
+	// 创建工作协程，测试端口由共享端口分配器按需租用
 	var wg sync.WaitGroup
-	for i := 0; i < w.config.MaxConcurrency; i++ {
+	for i := 0; i < limiter.Max(); i++ {
 		wg.Add(1)
-		// 为每个worker分配不同的端口基数，避免端口冲突
-		portBase := 10000 + i*100 // worker 0: 10000-10099, worker 1: 10100-10199, 等等
-		go w.worker(nodeQueue, resultQueue, &wg, portBase)
+		go w.worker(nodeQueue, resultQueue, &wg, limiter)
 	}
 
 	// 等待所有工作完成
@@ -478,45 +472,69 @@ func (w *SpeedTestWorkflow) testAllNodes(nodes []*types.Node) error {
 			completed, totalNodes, float64(completed)/float64(totalNodes)*100, result.Node.Name)
 	}
 
-	fmt.Printf("\n✅ 测试完成，共测试 %d 个节点\n", len(w.results))
+	fmt.Printf("\n✅ 测试完成，共测试 %d 个节点 (最终并发: %d)\n", len(w.results), limiter.Limit())
 	return nil
 }
 
 // worker 工作协程
-func (w *SpeedTestWorkflow) worker(nodeQueue <-chan *types.Node, resultQueue chan<- SpeedTestResult, wg *sync.WaitGroup, portBase int) {
+func (w *SpeedTestWorkflow) worker(nodeQueue <-chan *types.Node, resultQueue chan<- SpeedTestResult, wg *sync.WaitGroup, limiter *AdaptiveLimiter) {
 	defer wg.Done()
 
 	for node := range nodeQueue {
-		result := w.testSingleNode(node, portBase)
+		limiter.Acquire(context.Background())
+		result := w.testSingleNode(node)
+
+		var latencySample time.Duration
+		if result.Success {
+			latencySample = time.Duration(result.Latency) * time.Millisecond
+		}
+		limiter.Release(latencySample, !result.Success && IsTimeoutMessage(result.Error))
+
 		resultQueue <- result
 	}
 }
 
 // testSingleNode 测试单个节点
-func (w *SpeedTestWorkflow) testSingleNode(node *types.Node, portBase int) SpeedTestResult {
+func (w *SpeedTestWorkflow) testSingleNode(node *types.Node) SpeedTestResult {
 	result := SpeedTestResult{
-		Node:     node,
-		Success:  false,
-		TestTime: time.Now(),
+		Node:      node,
+		Success:   false,
+		TestTime:  time.Now(),
+		Preflight: w.preflightResults[node],
 	}
 
-	// 根据协议选择不同的代理方式
-	if node.Protocol == "hysteria2" {
-		return w.testHysteria2Node(node, result, portBase)
+	// 根据节点使用的核心选择不同的代理方式
+	if proxy.UsesHysteria2Client(node) {
+		result = w.testHysteria2Node(node, result)
 	} else {
-		return w.testV2RayNode(node, result, portBase)
+		result = w.testV2RayNode(node, result)
 	}
+
+	result.Hops, result.Error = w.frontProbe.Hops(node, result.Success, result.Latency, result.Error, func(front *types.Node) (int64, error) {
+		frontResult := w.testSingleNode(front)
+		if !frontResult.Success {
+			return 0, fmt.Errorf("%s", frontResult.Error)
+		}
+		return frontResult.Latency, nil
+	})
+	return result
 }
 
 // testV2RayNode 使用V2Ray测试节点
-func (w *SpeedTestWorkflow) testV2RayNode(node *types.Node, result SpeedTestResult, portBase int) SpeedTestResult {
+func (w *SpeedTestWorkflow) testV2RayNode(node *types.Node, result SpeedTestResult) SpeedTestResult {
 	// 创建临时V2Ray代理管理器
 	tempManager := proxy.NewProxyManager()
-	tempManager.ConfigPath = fmt.Sprintf("temp_config_%s_%d.json", node.Protocol, time.Now().UnixNano())
 
-	// 设置专用端口，避免冲突
-	tempManager.HTTPPort = portBase + 1  // HTTP代理端口
-	tempManager.SOCKSPort = portBase + 2 // SOCKS代理端口
+	// 从共享端口分配器租用测试端口，代理进程退出时租约自动释放
+	httpLease, socksLease, err := proxy.Ports().AcquirePair(portOwnerSpeedTest, 0)
+	if err != nil {
+		result.Error = fmt.Sprintf("分配测试端口失败: %v", err)
+		return result
+	}
+	defer proxy.Ports().Release(httpLease)
+	defer proxy.Ports().Release(socksLease)
+	tempManager.HTTPPort = httpLease.Port
+	tempManager.SOCKSPort = socksLease.Port
 
 	// 添加到活跃管理器列表（使用包装器）
 	wrapper := &ProxyManagerWrapper{tempManager}
@@ -528,27 +546,16 @@ func (w *SpeedTestWorkflow) testV2RayNode(node *types.Node, result SpeedTestResu
 		tempManager.StopProxy()
 		// 从活跃管理器列表中移除
 		w.removeActiveManager(wrapper)
-		// 清理临时配置文件
-		os.Remove(tempManager.ConfigPath)
-		// 强制清理可能的残留进程
-		exec.Command("pkill", "-f", fmt.Sprintf(":%d", tempManager.HTTPPort)).Run()
-		exec.Command("pkill", "-f", fmt.Sprintf(":%d", tempManager.SOCKSPort)).Run()
 	}()
 
 	// 启动V2Ray代理
-	err := tempManager.StartProxy(node)
+	err = tempManager.StartProxy(node)
 	if err != nil {
 		result.Error = fmt.Sprintf("启动V2Ray代理失败: %v", err)
 		return result
 	}
 
-	// Windows环境需要更长的启动时间
-	waitTime := 2 * time.Second
-	if runtime.GOOS == "windows" {
-		waitTime = 5 * time.Second
-	}
-	time.Sleep(waitTime)
-
+	// 启动时已主动探测代理端口就绪，直接开始测试
 	// 测试连接和速度
 	latency, speed, err := w.testProxySpeed(tempManager.HTTPPort)
 	if err != nil {
@@ -564,13 +571,20 @@ func (w *SpeedTestWorkflow) testV2RayNode(node *types.Node, result SpeedTestResu
 }
 
 // testHysteria2Node 使用Hysteria2客户端测试节点
-func (w *SpeedTestWorkflow) testHysteria2Node(node *types.Node, result SpeedTestResult, portBase int) SpeedTestResult {
+func (w *SpeedTestWorkflow) testHysteria2Node(node *types.Node, result SpeedTestResult) SpeedTestResult {
 	// 创建临时Hysteria2代理管理器
 	tempHysteria2Manager := proxy.NewHysteria2ProxyManager()
 
-	// 设置专用端口，避免冲突
-	tempHysteria2Manager.HTTPPort = portBase + 3  // HTTP代理端口
-	tempHysteria2Manager.SOCKSPort = portBase + 4 // SOCKS代理端口
+	// 从共享端口分配器租用测试端口，代理进程退出时租约自动释放
+	httpLease, socksLease, err := proxy.Ports().AcquirePair(portOwnerSpeedTest, 0)
+	if err != nil {
+		result.Error = fmt.Sprintf("分配测试端口失败: %v", err)
+		return result
+	}
+	defer proxy.Ports().Release(httpLease)
+	defer proxy.Ports().Release(socksLease)
+	tempHysteria2Manager.HTTPPort = httpLease.Port
+	tempHysteria2Manager.SOCKSPort = socksLease.Port
 
 	// 添加到活跃管理器列表（使用包装器）
 	wrapper := &Hysteria2ProxyManagerWrapper{tempHysteria2Manager}
@@ -582,29 +596,16 @@ func (w *SpeedTestWorkflow) testHysteria2Node(node *types.Node, result SpeedTest
 		tempHysteria2Manager.StopHysteria2Proxy()
 		// 从活跃管理器列表中移除
 		w.removeActiveManager(wrapper)
-		// 强制清理可能的残留进程
-		if runtime.GOOS != "windows" {
-			exec.Command("pkill", "-f", fmt.Sprintf(":%d", tempHysteria2Manager.HTTPPort)).Run()
-			exec.Command("pkill", "-f", fmt.Sprintf(":%d", tempHysteria2Manager.SOCKSPort)).Run()
-		}
-		// 清理临时配置文件
-		w.cleanupHysteria2TempFiles(tempHysteria2Manager)
 	}()
 
 	// 启动Hysteria2代理
-	err := tempHysteria2Manager.StartHysteria2Proxy(node)
+	err = tempHysteria2Manager.StartHysteria2Proxy(node)
 	if err != nil {
 		result.Error = fmt.Sprintf("启动Hysteria2代理失败: %v", err)
 		return result
 	}
 
-	// Windows环境需要更长的启动时间
-	waitTime := 2 * time.Second
-	if runtime.GOOS == "windows" {
-		waitTime = 5 * time.Second
-	}
-	time.Sleep(waitTime)
-
+	// 启动时已主动探测代理端口就绪，直接开始测试
 	// 测试连接和速度
 	latency, speed, err := w.testProxySpeed(tempHysteria2Manager.HTTPPort)
 	if err != nil {
@@ -753,6 +754,42 @@ func (w *SpeedTestWorkflow) isProxyReady(proxyURL string, timeout time.Duration)
 	return true
 }
 
+// recordHistory 将本次测试结果追加到节点测试历史并清理过期记录
+func (w *SpeedTestWorkflow) recordHistory() {
+	if w.config.HistoryFile == "" {
+		return
+	}
+
+	store := history.NewStore(w.config.HistoryFile)
+	records := make([]history.Record, 0, len(w.results))
+	for _, result := range w.results {
+		if result.Node == nil {
+			continue
+		}
+		record := history.NewRecord(result.Node, history.SourceSpeedTest)
+		record.Success = result.Success
+		record.LatencyMs = result.Latency
+		record.SpeedMbps = result.Speed
+		record.Error = result.Error
+		if !result.TestTime.IsZero() {
+			record.TestTime = result.TestTime
+		}
+		records = append(records, record)
+	}
+
+	if err := store.Append(records...); err != nil {
+		fmt.Printf("⚠️ 写入测试历史失败: %v\n", err)
+		return
+	}
+	fmt.Printf("📚 已记录 %d 条测试历史到 %s\n", len(records), store.Path())
+
+	if removed, err := store.Prune(); err != nil {
+		fmt.Printf("⚠️ 清理测试历史失败: %v\n", err)
+	} else if removed > 0 {
+		fmt.Printf("🧹 已清理 %d 条过期测试历史\n", removed)
+	}
+}
+
 // sortResultsBySpeed 按速度排序结果
 func (w *SpeedTestWorkflow) sortResultsBySpeed() {
 	sort.Slice(w.results, func(i, j int) bool {
@@ -777,8 +814,61 @@ func (w *SpeedTestWorkflow) sortResultsBySpeed() {
 	fmt.Printf("📈 结果已按速度排序（从快到慢）\n")
 }
 
+// buildReport 将测试结果转换为报告数据
+func (w *SpeedTestWorkflow) buildReport() *report.Report {
+	rep := &report.Report{
+		Title:           "V2Ray代理节点测速结果",
+		SubscriptionURL: w.config.SubscriptionURL,
+		TestURL:         w.config.TestURL,
+		StartTime:       w.startTime,
+		EndTime:         time.Now(),
+	}
+	for _, result := range w.results {
+		rep.Entries = append(rep.Entries, report.Entry{
+			Name:      result.Node.Name,
+			Protocol:  result.Node.Protocol,
+			Server:    result.Node.Server,
+			Port:      result.Node.Port,
+			Success:   result.Success,
+			LatencyMs: result.Latency,
+			SpeedMbps: result.Speed,
+			Error:     result.Error,
+			TestTime:  result.TestTime,
+			Hops:      result.Hops,
+		})
+	}
+	return rep
+}
+
+// saveFormattedReports 按配置的格式输出报告，文件名与输出文件同名、扩展名按格式区分
+func (w *SpeedTestWorkflow) saveFormattedReports() map[string]bool {
+	written := make(map[string]bool)
+	if len(w.config.Formats) == 0 {
+		return written
+	}
+
+	rep := w.buildReport()
+	base := strings.TrimSuffix(w.config.OutputFile, filepath.Ext(w.config.OutputFile))
+	for _, format := range w.config.Formats {
+		path := base + report.Extension(format)
+		if err := report.WriteFile(path, format, rep); err != nil {
+			fmt.Printf("⚠️  %v\n", err)
+			continue
+		}
+		written[path] = true
+		fmt.Printf("📑 %s报告: %s\n", format, path)
+	}
+	return written
+}
+
 // saveResults 保存结果到文件
 func (w *SpeedTestWorkflow) saveResults() error {
+	// 先输出指定格式的报告；若某个格式与输出文件同名，则不再写入文本报告
+	written := w.saveFormattedReports()
+	if written[w.config.OutputFile] {
+		return nil
+	}
+
 	file, err := os.Create(w.config.OutputFile)
 	if err != nil {
 		return err
@@ -838,16 +928,19 @@ func (w *SpeedTestWorkflow) saveResults() error {
 		}
 	}
 
-	// 同时保存JSON格式的详细结果
+	// 同时保存JSON格式的详细结果（已按json格式输出报告时不再覆盖）
 	jsonFile := strings.TrimSuffix(w.config.OutputFile, filepath.Ext(w.config.OutputFile)) + ".json"
-	jsonData, err := json.MarshalIndent(w.results, "", "  ")
-	if err == nil {
-		os.WriteFile(jsonFile, jsonData, 0644)
-		fmt.Fprintf(file, "\n💾 详细JSON结果已保存到: %s\n", jsonFile)
+	jsonSaved := false
+	if !written[jsonFile] {
+		if jsonData, err := json.MarshalIndent(w.results, "", "  "); err == nil {
+			os.WriteFile(jsonFile, jsonData, 0644)
+			fmt.Fprintf(file, "\n💾 详细JSON结果已保存到: %s\n", jsonFile)
+			jsonSaved = true
+		}
 	}
 
 	fmt.Printf("✅ 结果已保存到: %s\n", w.config.OutputFile)
-	if err == nil {
+	if jsonSaved {
 		fmt.Printf("📊 JSON详细结果: %s\n", jsonFile)
 	}
 
@@ -904,7 +997,7 @@ func RunSpeedTestWorkflow(subscriptionURL string) error {
 }
 
 // RunCustomSpeedTestWorkflow 运行自定义配置的测速工作流
-func RunCustomSpeedTestWorkflow(subscriptionURL string, concurrency int, timeout int, outputFile string, testURL string, maxNodes int) error {
+func RunCustomSpeedTestWorkflow(subscriptionURL string, concurrency int, timeout int, outputFile string, testURL string, maxNodes int, formats []string, historyFile string, nodeFilter *filter.Filter) error {
 	workflow := NewSpeedTestWorkflow(subscriptionURL)
 
 	if concurrency > 0 {
@@ -922,6 +1015,13 @@ func RunCustomSpeedTestWorkflow(subscriptionURL string, concurrency int, timeout
 	if maxNodes > 0 {
 		workflow.SetMaxNodes(maxNodes)
 	}
+	if len(formats) > 0 {
+		workflow.SetFormats(formats)
+	}
+	if historyFile != "" {
+		workflow.SetHistoryFile(historyFile)
+	}
+	workflow.SetFilter(nodeFilter)
 
 	return workflow.Run()
 }
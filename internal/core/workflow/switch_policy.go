@@ -0,0 +1,263 @@
+package workflow
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/filter"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/schedule"
+	"github.com/yxhpy/v2ray-subscription-manager/pkg/types"
+)
+
+// maxSwitchDecisions 保留的最近切换决策数量
+const maxSwitchDecisions = 100
+
+// SwitchPolicy 节点切换策略，避免单次测试分数的波动导致出口频繁切换
+type SwitchPolicy struct {
+	MinImprovement     float64       `json:"min_improvement"`      // 新节点分数至少比当前节点高出的比例，如 0.2 表示 20%
+	MinScoreDelta      float64       `json:"min_score_delta"`      // 新节点分数至少高出的绝对值
+	MinDwell           time.Duration `json:"min_dwell"`            // 切换到一个节点后至少使用的时长
+	RevertCooldown     time.Duration `json:"revert_cooldown"`      // 离开一个节点后，该时长内不再切换回去
+	FailureCooldown    time.Duration `json:"failure_cooldown"`     // 切换失败的节点在该时长内不再尝试
+	PreferSameRegion   bool          `json:"prefer_same_region"`   // 优先保持同一出口地区
+	CrossRegionPenalty float64       `json:"cross_region_penalty"` // 更换出口地区时额外要求的分数提升比例
+
+	QuietHours schedule.Windows `json:"quiet_hours,omitempty"` // 静默时段内只执行强制切换
+}
+
+// DefaultSwitchPolicy 默认切换策略
+func DefaultSwitchPolicy() SwitchPolicy {
+	return SwitchPolicy{
+		MinImprovement:     0.2,
+		MinScoreDelta:      1.0,
+		MinDwell:           10 * time.Minute,
+		RevertCooldown:     30 * time.Minute,
+		FailureCooldown:    10 * time.Minute,
+		PreferSameRegion:   true,
+		CrossRegionPenalty: 0.3,
+	}
+}
+
+// SwitchPolicyFromConfig 根据自动代理配置生成切换策略，未设置的项使用默认值
+func SwitchPolicyFromConfig(config types.AutoProxyConfig) SwitchPolicy {
+	policy := DefaultSwitchPolicy()
+	if config.SwitchThreshold > 0 {
+		policy.MinImprovement = config.SwitchThreshold
+	}
+	if config.MinDwell > 0 {
+		policy.MinDwell = config.MinDwell
+	}
+	if quiet, err := schedule.ParseWindows(config.QuietHours); err != nil {
+		fmt.Printf("⚠️ 忽略无效的静默时段: %v\n", err)
+	} else {
+		policy.QuietHours = quiet
+	}
+	return policy
+}
+
+// requiredImprovement 从当前节点切换到目标节点需要的最小分数提升
+func (p SwitchPolicy) requiredImprovement(currentScore float64, crossRegion bool) float64 {
+	ratio := p.MinImprovement
+	if crossRegion && p.PreferSameRegion {
+		ratio += p.CrossRegionPenalty
+	}
+	required := currentScore * ratio
+	if required < p.MinScoreDelta {
+		required = p.MinScoreDelta
+	}
+	return required
+}
+
+// SwitchDecision 一次切换决策，无论接受还是拒绝都会记录
+type SwitchDecision struct {
+	Time       time.Time `json:"time"`
+	From       string    `json:"from,omitempty"`
+	To         string    `json:"to"`
+	FromScore  float64   `json:"from_score,omitempty"`
+	ToScore    float64   `json:"to_score"`
+	FromRegion string    `json:"from_region,omitempty"`
+	ToRegion   string    `json:"to_region,omitempty"`
+	Accepted   bool      `json:"accepted"`
+	Forced     bool      `json:"forced,omitempty"`
+	Reason     string    `json:"reason"`
+}
+
+// SwitchGovernor 按切换策略决定是否切换节点，并记录节点的使用和失败时间
+type SwitchGovernor struct {
+	policy      SwitchPolicy
+	active      string               // 当前节点指纹
+	activeSince time.Time            // 当前节点开始使用的时间
+	left        map[string]time.Time // 指纹 -> 离开该节点的时间
+	failed      map[string]time.Time // 指纹 -> 切换失败的时间
+	decisions   []SwitchDecision
+	mutex       sync.Mutex
+}
+
+// NewSwitchGovernor 创建切换决策器
+func NewSwitchGovernor(policy SwitchPolicy) *SwitchGovernor {
+	return &SwitchGovernor{
+		policy: policy,
+		left:   make(map[string]time.Time),
+		failed: make(map[string]time.Time),
+	}
+}
+
+// SetPolicy 更新切换策略
+func (g *SwitchGovernor) SetPolicy(policy SwitchPolicy) {
+	g.mutex.Lock()
+	g.policy = policy
+	g.mutex.Unlock()
+}
+
+// Policy 返回当前切换策略
+func (g *SwitchGovernor) Policy() SwitchPolicy {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.policy
+}
+
+// Evaluate 判断是否应从当前节点切换到目标节点，force 为 true 时跳过停留时长、冷却和分数要求
+func (g *SwitchGovernor) Evaluate(current, target *types.ValidNode, force bool, reason string) SwitchDecision {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := time.Now()
+	decision := SwitchDecision{
+		Time:     now,
+		To:       target.Node.Name,
+		ToScore:  target.Score,
+		ToRegion: filter.DetectRegion(target.Node.Name),
+		Forced:   force,
+	}
+	if current != nil {
+		decision.From = current.Node.Name
+		decision.FromScore = current.Score
+		decision.FromRegion = filter.DetectRegion(current.Node.Name)
+	}
+
+	decision.Accepted, decision.Reason = true, "当前没有运行的节点"
+	if current != nil && force {
+		if reason == "" {
+			reason = "强制切换"
+		}
+		decision.Reason = reason
+	} else if current != nil {
+		decision.Accepted, decision.Reason = g.check(current, target, decision.FromRegion, decision.ToRegion, now)
+		if decision.Accepted {
+			if reason == "" {
+				reason = "分数提升"
+			}
+			decision.Reason = fmt.Sprintf("%s: %.2f → %.2f", reason, current.Score, target.Score)
+		}
+	}
+	return g.record(decision)
+}
+
+// Accepts 判断切换是否会被接受，不记录决策（用于挑选替代节点）
+func (g *SwitchGovernor) Accepts(current, target *types.ValidNode) bool {
+	if current == nil {
+		return true
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	accepted, _ := g.check(current, target, filter.DetectRegion(current.Node.Name), filter.DetectRegion(target.Node.Name), time.Now())
+	return accepted
+}
+
+// check 依次检查静默时段、失败冷却、停留时长、回切冷却和分数提升，返回是否允许及原因
+func (g *SwitchGovernor) check(current, target *types.ValidNode, fromRegion, toRegion string, now time.Time) (bool, string) {
+	if g.policy.QuietHours.Contains(now) {
+		return false, fmt.Sprintf("静默时段 %s 内不自动切换", g.policy.QuietHours)
+	}
+
+	fingerprint := target.Node.Fingerprint()
+	if failedAt, ok := g.failed[fingerprint]; ok && now.Sub(failedAt) < g.policy.FailureCooldown {
+		return false, fmt.Sprintf("目标节点 %s 前切换失败，冷却中", formatAgo(now.Sub(failedAt)))
+	}
+	if dwell := now.Sub(g.activeSince); dwell < g.policy.MinDwell {
+		return false, fmt.Sprintf("当前节点仅使用了 %s，最短停留 %s", formatAgo(dwell), g.policy.MinDwell)
+	}
+	if leftAt, ok := g.left[fingerprint]; ok && now.Sub(leftAt) < g.policy.RevertCooldown {
+		return false, fmt.Sprintf("%s 前刚离开目标节点，%s 内不切换回去", formatAgo(now.Sub(leftAt)), g.policy.RevertCooldown)
+	}
+
+	crossRegion := fromRegion != "" && toRegion != "" && fromRegion != toRegion
+	required := g.policy.requiredImprovement(current.Score, crossRegion)
+	if improvement := target.Score - current.Score; improvement < required {
+		why := fmt.Sprintf("分数提升 %.2f 未达到要求的 %.2f", improvement, required)
+		if crossRegion && g.policy.PreferSameRegion {
+			why += fmt.Sprintf("（跨地区 %s→%s）", fromRegion, toRegion)
+		}
+		return false, why
+	}
+	return true, ""
+}
+
+// Activated 记录节点开始使用
+func (g *SwitchGovernor) Activated(node *types.ValidNode) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := time.Now()
+	fingerprint := node.Node.Fingerprint()
+	if g.active != "" && g.active != fingerprint {
+		g.left[g.active] = now
+	}
+	g.active = fingerprint
+	g.activeSince = now
+	delete(g.failed, fingerprint)
+}
+
+// Failed 记录切换失败的节点
+func (g *SwitchGovernor) Failed(node *types.ValidNode) {
+	g.mutex.Lock()
+	g.failed[node.Node.Fingerprint()] = time.Now()
+	g.mutex.Unlock()
+}
+
+// Decisions 返回最近的切换决策（按时间先后）
+func (g *SwitchGovernor) Decisions() []SwitchDecision {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return append([]SwitchDecision(nil), g.decisions...)
+}
+
+// record 记录并打印决策
+func (g *SwitchGovernor) record(decision SwitchDecision) SwitchDecision {
+	g.decisions = append(g.decisions, decision)
+	if len(g.decisions) > maxSwitchDecisions {
+		g.decisions = g.decisions[len(g.decisions)-maxSwitchDecisions:]
+	}
+
+	from := decision.From
+	if from == "" {
+		from = "无"
+	}
+	if decision.Accepted {
+		fmt.Printf("🔀 切换决策: 接受 %s → %s (%s)\n", from, decision.To, decision.Reason)
+	} else {
+		fmt.Printf("🛑 切换决策: 拒绝 %s → %s (%s)\n", from, decision.To, decision.Reason)
+	}
+
+	// 清理过期的冷却记录
+	now := decision.Time
+	for fingerprint, leftAt := range g.left {
+		if now.Sub(leftAt) >= g.policy.RevertCooldown {
+			delete(g.left, fingerprint)
+		}
+	}
+	for fingerprint, failedAt := range g.failed {
+		if now.Sub(failedAt) >= g.policy.FailureCooldown {
+			delete(g.failed, fingerprint)
+		}
+	}
+	return decision
+}
+
+// formatAgo 将时长格式化为便于阅读的形式
+func formatAgo(d time.Duration) string {
+	return d.Round(time.Second).String()
+}
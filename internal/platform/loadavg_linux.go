@@ -0,0 +1,24 @@
+package platform
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LoadAverage 获取1分钟平均负载（Linux平台，读取 /proc/loadavg，不可用时返回false）
+func LoadAverage() (float64, bool) {
+	data, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, false
+	}
+	load, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, false
+	}
+	return load, true
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package platform
+
+// LoadAverage 非Linux平台不读取平均负载，始终返回false（Windows没有负载概念，其他Unix平台没有 /proc/loadavg）；
+// 自适应并发此时只按超时率、延迟和空闲端口调整
+func LoadAverage() (float64, bool) {
+	return 0, false
+}
@@ -0,0 +1,35 @@
+package platform
+
+import (
+	"encoding/binary"
+	"syscall"
+	"unsafe"
+)
+
+// kinfoProcSize macOS 64位 struct kinfo_proc 的大小；p_starttime (struct timeval) 位于开头
+const kinfoProcSize = 648
+
+// ProcessStartTime 进程启动时间标识，用于识别PID复用（macOS平台，通过 sysctl kern.proc.pid 读取 p_starttime，单位微秒）
+func ProcessStartTime(pid int) (uint64, bool) {
+	if pid <= 0 {
+		return 0, false
+	}
+	mib := [4]int32{1 /* CTL_KERN */, 14 /* KERN_PROC */, 1 /* KERN_PROC_PID */, int32(pid)}
+	var buf [kinfoProcSize]byte
+	size := uintptr(len(buf))
+	_, _, errno := syscall.Syscall6(syscall.SYS___SYSCTL,
+		uintptr(unsafe.Pointer(&mib[0])), uintptr(len(mib)),
+		uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)), 0, 0)
+	// 进程不存在时 sysctl 成功但返回长度为0
+	if errno != 0 || size != kinfoProcSize {
+		return 0, false
+	}
+
+	sec := binary.LittleEndian.Uint64(buf[0:8])
+	usec := binary.LittleEndian.Uint32(buf[8:12])
+	start := sec*1000000 + uint64(usec)
+	if start == 0 {
+		return 0, false
+	}
+	return start, true
+}
@@ -0,0 +1,9 @@
+//go:build !windows && !linux && !darwin
+
+package platform
+
+// ProcessStartTime 其他Unix平台无法读取进程启动时间，始终返回false；
+// 调用方无法确认PID未被复用时不应向该PID发送信号
+func ProcessStartTime(pid int) (uint64, bool) {
+	return 0, false
+}
@@ -4,50 +4,74 @@ package platform
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
-	"strconv"
-	"strings"
 	"syscall"
 )
 
-// SetProcAttributes 设置进程属性（Unix平台）
+// SetProcAttributes 设置进程属性（Unix平台）：子进程作为新进程组的组长，便于按进程组终止
 func SetProcAttributes(cmd *exec.Cmd) {
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 }
 
-// KillProcessByPort 通过端口杀死进程（Unix平台）
-func KillProcessByPort(port int) error {
-	// 使用lsof查找占用端口的进程
-	cmd := exec.Command("lsof", "-t", "-i", fmt.Sprintf(":%d", port))
-	output, err := cmd.Output()
-	if err != nil {
-		return nil // 端口未被占用或lsof不可用
+// ProcessAlive 进程是否存在（Unix平台，发送信号0探测）
+func ProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
 	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
 
-	pids := strings.Fields(string(output))
-	for _, pidStr := range pids {
-		if pid, err := strconv.Atoi(strings.TrimSpace(pidStr)); err == nil {
-			KillProcessByPID(pid)
-		}
-	}
-	return nil
+// TerminateGroup 向进程所在的进程组发送终止信号，连同核心派生的子进程一起退出
+func TerminateGroup(pid int) error {
+	return syscall.Kill(-pid, syscall.SIGTERM)
 }
 
-// KillProcessByPID 通过PID杀死进程（Unix平台）
-func KillProcessByPID(pid int) error {
-	cmd := exec.Command("kill", "-9", strconv.Itoa(pid))
-	return cmd.Run()
+// KillGroup 强制杀死进程所在的进程组
+func KillGroup(pid int) error {
+	return syscall.Kill(-pid, syscall.SIGKILL)
 }
 
-// KillProcessByName 通过进程名杀死进程（Unix平台）
-func KillProcessByName(name string) error {
-	cmd := exec.Command("pkill", "-f", name)
-	return cmd.Run()
+// CheckPrivateDir 确认目录只属于当前用户：不是符号链接，属主为当前用户，权限恰好为 0700。
+// 防止其他本地用户预先创建（或用符号链接指向）可预测的目录，窃取其中的配置和控制通道
+func CheckPrivateDir(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("%s 是符号链接，拒绝使用", path)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s 不是目录", path)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("无法读取 %s 的属主", path)
+	}
+	if uid := os.Getuid(); int(stat.Uid) != uid {
+		return fmt.Errorf("%s 的属主 (uid %d) 不是当前用户 (uid %d)", path, stat.Uid, uid)
+	}
+	if perm := info.Mode().Perm(); perm != 0700 {
+		return fmt.Errorf("%s 的权限为 %04o，必须为 0700（可执行 chmod 700 %s）", path, perm, path)
+	}
+	return nil
 }
 
-// IsProcessRunning 检查进程是否在运行（Unix平台）
-func IsProcessRunning(processName string) bool {
-	cmd := exec.Command("pgrep", "-f", processName)
-	err := cmd.Run()
-	return err == nil
+// LockFile 打开（必要时创建）锁文件并加排他锁，阻塞直到获得锁；返回的 unlock 释放锁并关闭文件
+// 用于多个管理程序进程之间串行化对同一数据文件的读-改-写（Unix平台，flock）
+func LockFile(path string) (unlock func(), err error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("打开锁文件失败: %v", err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("锁定 %s 失败: %v", path, err)
+	}
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
 }
@@ -4,10 +4,12 @@ package platform
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
 	"strconv"
 	"strings"
 	"syscall"
+	"unsafe"
 )
 
 // SetProcAttributes 设置进程属性（Windows平台）
@@ -18,48 +20,109 @@ func SetProcAttributes(cmd *exec.Cmd) {
 	}
 }
 
-// KillProcessByPort 通过端口杀死进程（Windows特有）
-func KillProcessByPort(port int) error {
-	// 使用netstat查找占用端口的进程
-	cmd := exec.Command("netstat", "-ano", "-p", "tcp")
-	output, err := cmd.Output()
+// processQueryLimitedInformation 查询进程基本信息所需的访问权限
+const processQueryLimitedInformation = 0x1000
+
+// ProcessAlive 进程是否存在（Windows平台，能打开进程句柄且尚未退出）
+func ProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	handle, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
 	if err != nil {
-		return fmt.Errorf("执行netstat失败: %v", err)
+		return false
 	}
+	defer syscall.CloseHandle(handle)
 
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, fmt.Sprintf(":%d", port)) && strings.Contains(line, "LISTENING") {
-			fields := strings.Fields(line)
-			if len(fields) >= 5 {
-				pidStr := fields[len(fields)-1]
-				if pid, err := strconv.Atoi(pidStr); err == nil {
-					return KillProcessByPID(pid)
-				}
-			}
-		}
+	var code uint32
+	if err := syscall.GetExitCodeProcess(handle, &code); err != nil {
+		return false
 	}
-	return nil
+	const stillActive = 259
+	return code == stillActive
 }
 
-// KillProcessByPID 通过PID杀死进程（Windows特有）
-func KillProcessByPID(pid int) error {
-	cmd := exec.Command("taskkill", "/F", "/PID", strconv.Itoa(pid))
-	return cmd.Run()
+// ProcessStartTime 进程创建时间，用于识别PID复用（Windows平台）
+func ProcessStartTime(pid int) (uint64, bool) {
+	handle, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return 0, false
+	}
+	defer syscall.CloseHandle(handle)
+
+	var creation, exit, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(handle, &creation, &exit, &kernel, &user); err != nil {
+		return 0, false
+	}
+	return uint64(creation.Nanoseconds()), true
 }
 
-// KillProcessByName 通过进程名杀死进程（Windows特有）
-func KillProcessByName(name string) error {
-	cmd := exec.Command("taskkill", "/F", "/IM", name)
-	return cmd.Run()
+// TerminateGroup Windows平台不支持终止信号，直接结束进程树
+func TerminateGroup(pid int) error {
+	return KillGroup(pid)
 }
 
-// IsProcessRunning 检查进程是否在运行（Windows特有）
-func IsProcessRunning(processName string) bool {
-	cmd := exec.Command("tasklist", "/FI", fmt.Sprintf("IMAGENAME eq %s", processName))
-	output, err := cmd.Output()
+// KillGroup 强制结束进程及其派生的所有子进程（Windows平台，taskkill /T /F）。
+// Windows没有进程组信号，CREATE_NEW_PROCESS_GROUP 只影响控制台事件，只结束一个PID会留下核心派生的子进程；
+// taskkill 不可用时退回到只结束进程本身
+func KillGroup(pid int) error {
+	cmd := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(pid))
+	cmd.SysProcAttr = &syscall.SysProcAttr{HideWindow: true}
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+
+	process, findErr := os.FindProcess(pid)
+	if findErr != nil {
+		return findErr
+	}
+	if killErr := process.Kill(); killErr != nil {
+		return fmt.Errorf("结束进程树失败: %v (%s)", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// CheckPrivateDir 确认目录不是符号链接（Windows平台）。
+// 默认目录位于用户自己的缓存目录下，访问控制由其继承的ACL保证
+func CheckPrivateDir(path string) error {
+	info, err := os.Lstat(path)
 	if err != nil {
-		return false
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("%s 是符号链接，拒绝使用", path)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s 不是目录", path)
+	}
+	return nil
+}
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+// lockfileExclusiveLock LockFileEx 的排他锁标志
+const lockfileExclusiveLock = 0x2
+
+// LockFile 打开（必要时创建）锁文件并加排他锁，阻塞直到获得锁；返回的 unlock 释放锁并关闭文件
+// 用于多个管理程序进程之间串行化对同一数据文件的读-改-写（Windows平台，LockFileEx）
+func LockFile(path string) (unlock func(), err error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("打开锁文件失败: %v", err)
+	}
+	handle := file.Fd()
+	var overlapped syscall.Overlapped
+	if r, _, e := procLockFileEx.Call(handle, lockfileExclusiveLock, 0, 1, 0, uintptr(unsafe.Pointer(&overlapped))); r == 0 {
+		file.Close()
+		return nil, fmt.Errorf("锁定 %s 失败: %v", path, e)
 	}
-	return strings.Contains(string(output), processName)
+	return func() {
+		procUnlockFileEx.Call(handle, 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+		file.Close()
+	}, nil
 }
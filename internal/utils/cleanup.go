@@ -1,76 +1,44 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"time"
+
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/lifecycle"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/rundir"
+	"github.com/yxhpy/v2ray-subscription-manager/internal/core/statedir"
 )
 
-// CleanupTempFiles 清理临时文件的通用函数
+// autoProxyFiles auto-proxy默认使用的状态文件：持久状态目录中的快照和运行目录中的后台代理状态
+// （用户通过参数指定的状态文件不在此列，不会被删除）
+func autoProxyFiles() []string {
+	return []string{
+		statedir.File("auto_proxy_best_node.json"),
+		statedir.File("auto_proxy_state.json"),
+		statedir.File("valid_nodes.json"),
+		statedir.File("mvp_best_node.json"),
+		rundir.File("proxy_state.json"),
+	}
+}
+
+// CleanupTempFiles 清理临时文件：删除本进程的会话目录，以及已退出的管理程序遗留的会话目录
+// 临时文件只会写入会话目录，因此不需要在工作目录中按通配符查找
 func CleanupTempFiles() {
 	fmt.Printf("🧹 开始清理临时文件...\n")
 
-	// 所有可能的临时文件模式
-	patterns := []string{
-		// V2Ray相关临时文件
-		"temp_v2ray_config_*.json",
-		"test_v2ray_config_*.json",
-		"proxy_server_v2ray_*.json",
-		"temp_config_*.json",
-		"test_proxy_*.json",
-
-		// 智能代理测试文件
-		"temp_config_test_*.json",
-		"intelligent_proxy_config_*.json",
-		"intelligent_proxy_test_*.json",
-		"intelligent_proxy_*.json",
-
-		// 通用临时文件
-		"*.tmp",
-		"*.temp",
-
-		// Auto-proxy相关文件
-		"auto_proxy_best_node.json",
-		"auto_proxy_state.json",
-		"valid_nodes.json",
-		"mvp_best_node.json",
-		"proxy_state.json",
-	}
-
-	cleanedCount := 0
-	for _, pattern := range patterns {
-		cleanedCount += cleanupPattern(pattern)
+	if err := rundir.CleanupSession(); err != nil {
+		fmt.Printf("    ❌ 删除会话目录失败: %v\n", err)
 	}
 
-	// 清理hysteria2目录下的临时文件
-	CleanupHysteria2TempFiles()
-
-	if cleanedCount > 0 {
-		fmt.Printf("✅ 共清理了 %d 个临时文件\n", cleanedCount)
+	if reaped := rundir.ReapSessions(); reaped > 0 {
+		fmt.Printf("✅ 清理了 %d 个遗留的会话目录\n", reaped)
 	} else {
 		fmt.Printf("✅ 没有发现需要清理的临时文件\n")
 	}
 }
 
-// cleanupPattern 清理指定模式的文件，支持重试
-func cleanupPattern(pattern string) int {
-	cleanedCount := 0
-	matches, err := filepath.Glob(pattern)
-	if err != nil {
-		return 0
-	}
-
-	for _, file := range matches {
-		if cleanupFileWithRetry(file) {
-			cleanedCount++
-		}
-	}
-
-	return cleanedCount
-}
-
 // cleanupFileWithRetry 删除文件，支持重试机制
 func cleanupFileWithRetry(file string) bool {
 	// 首先检查文件是否存在
@@ -100,57 +68,12 @@ func cleanupFileWithRetry(file string) bool {
 	return false
 }
 
-// CleanupHysteria2TempFiles 清理Hysteria2相关的临时文件
-func CleanupHysteria2TempFiles() {
-	hysteria2Patterns := []string{
-		"./hysteria2/test_config_*.yaml",
-		"./hysteria2/temp_*.yaml",
-		"./hysteria2/config_*.yaml",
-		"./hysteria2/test_proxy_*.yaml",
-		"./hysteria2/proxy_server_*.yaml",
-		"./hysteria2/intelligent_proxy_*.yaml",
-		"hysteria2/test_config_*.yaml", // 无./前缀的版本
-		"hysteria2/temp_*.yaml",
-		"hysteria2/config_*.yaml",
-		"hysteria2/test_proxy_*.yaml",
-		"hysteria2/proxy_server_*.yaml",
-		"hysteria2/intelligent_proxy_*.yaml",
-	}
-
-	cleanedCount := 0
-	for _, pattern := range hysteria2Patterns {
-		matches, err := filepath.Glob(pattern)
-		if err != nil {
-			continue
-		}
-
-		for _, file := range matches {
-			// 跳过主配置文件
-			if file == "./hysteria2/config.yaml" || file == "hysteria2/config.yaml" {
-				continue
-			}
-
-			if cleanupFileWithRetry(file) {
-				cleanedCount++
-			}
-		}
-	}
-}
-
-// CleanupAutoProxyFiles 清理Auto-proxy相关的文件
+// CleanupAutoProxyFiles 清理Auto-proxy相关的状态文件（只在强制清理时调用，正常退出时保留供重启恢复）
 func CleanupAutoProxyFiles() {
 	fmt.Printf("🧹 清理Auto-proxy相关文件...\n")
 
-	files := []string{
-		"auto_proxy_best_node.json",
-		"auto_proxy_state.json",
-		"valid_nodes.json",
-		"mvp_best_node.json",
-		"proxy_state.json",
-	}
-
 	cleanedCount := 0
-	for _, file := range files {
+	for _, file := range autoProxyFiles() {
 		if cleanupFileWithRetry(file) {
 			cleanedCount++
 		}
@@ -165,47 +88,30 @@ func CleanupAutoProxyFiles() {
 func ForceCleanupAll() {
 	fmt.Printf("🧹 执行强制清理...\n")
 
-	// 第一步：终止相关进程
+	// 第一步：回收已崩溃的管理程序遗留的核心进程
 	KillRelatedProcesses()
 
-	// 第二步：等待进程完全终止
-	time.Sleep(2 * time.Second)
-
-	// 第三步：清理临时文件
+	// 第二步：清理临时文件
 	CleanupTempFiles()
 
-	// 第四步：清理Auto-proxy文件
+	// 第三步：清理Auto-proxy文件
 	CleanupAutoProxyFiles()
 
-	// 第五步：验证清理结果
+	// 第四步：验证清理结果
 	VerifyCleanup()
 
 	fmt.Printf("✅ 强制清理完成\n")
 }
 
-// KillRelatedProcesses 终止相关进程
+// KillRelatedProcesses 回收孤儿核心进程
+// 只处理进程登记表中启动者已退出的记录，仍在运行的管理程序启动的进程以及其他程序的进程都不会被触碰
 func KillRelatedProcesses() {
-	fmt.Printf("💀 终止相关进程...\n")
+	fmt.Printf("💀 回收孤儿核心进程...\n")
 
-	processNames := []string{"v2ray", "xray", "hysteria2", "hysteria"}
-
-	for _, processName := range processNames {
-		// 首先尝试温和终止
-		cmd := exec.Command("pkill", "-TERM", "-f", processName)
-		if err := cmd.Run(); err == nil {
-			fmt.Printf("    📤 发送终止信号给 %s 进程\n", processName)
-		}
-	}
-
-	// 等待一段时间让进程优雅退出
-	time.Sleep(3 * time.Second)
-
-	// 强制终止仍在运行的进程
-	for _, processName := range processNames {
-		cmd := exec.Command("pkill", "-KILL", "-f", processName)
-		if err := cmd.Run(); err == nil {
-			fmt.Printf("    💀 强制终止 %s 进程\n", processName)
-		}
+	if reaped := lifecycle.DefaultRegistry().ReapOrphans(context.Background()); reaped > 0 {
+		fmt.Printf("    💀 已回收 %d 个孤儿进程\n", reaped)
+	} else {
+		fmt.Printf("    ✅ 没有孤儿进程\n")
 	}
 }
 
@@ -213,30 +119,27 @@ func KillRelatedProcesses() {
 func VerifyCleanup() {
 	fmt.Printf("🔍 验证清理结果...\n")
 
-	// 检查关键文件是否仍存在
-	criticalFiles := []string{
-		"auto_proxy_best_node.json",
-		"auto_proxy_state.json",
-		"valid_nodes.json",
-		"mvp_best_node.json",
-	}
-
+	// 检查运行目录中的状态文件是否仍存在
 	remainingFiles := 0
-	for _, file := range criticalFiles {
+	for _, file := range autoProxyFiles() {
 		if _, err := os.Stat(file); err == nil {
 			fmt.Printf("    ⚠️ 文件仍存在: %s\n", file)
 			remainingFiles++
 		}
 	}
 
-	// 检查是否有进程仍在运行
-	processNames := []string{"v2ray", "xray", "hysteria2"}
+	// 检查登记的核心进程是否仍在运行（属于仍在运行的管理程序的进程不算残留）
 	runningProcesses := 0
-	for _, processName := range processNames {
-		if isProcessRunning(processName) {
-			fmt.Printf("    ⚠️ 进程仍在运行: %s\n", processName)
-			runningProcesses++
+	for _, record := range lifecycle.DefaultRegistry().Records() {
+		if !record.Alive() {
+			continue
+		}
+		if record.OwnerAlive() {
+			fmt.Printf("    ℹ️ 进程由运行中的管理程序 (PID: %d) 持有: %s\n", record.OwnerPID, record)
+			continue
 		}
+		fmt.Printf("    ⚠️ 进程仍在运行: %s\n", record)
+		runningProcesses++
 	}
 
 	if remainingFiles == 0 && runningProcesses == 0 {
@@ -245,10 +148,3 @@ func VerifyCleanup() {
 		fmt.Printf("    ⚠️ 发现 %d 个残留文件，%d 个残留进程\n", remainingFiles, runningProcesses)
 	}
 }
-
-// isProcessRunning 检查进程是否仍在运行
-func isProcessRunning(processName string) bool {
-	cmd := exec.Command("pgrep", "-f", processName)
-	output, err := cmd.Output()
-	return err == nil && len(output) > 0
-}
@@ -16,6 +16,16 @@ type AutoProxyConfig struct {
 	StateFile        string        `json:"state_file"`         // 状态文件路径
 	ValidNodesFile   string        `json:"valid_nodes_file"`   // 有效节点中间文件
 	EnableAutoSwitch bool          `json:"enable_auto_switch"` // 是否启用自动切换
+	HistoryFile      string        `json:"history_file"`       // 节点测试历史文件
+	ControlSocket    string        `json:"control_socket"`     // 控制API的Unix socket路径
+	ControlAddr      string        `json:"control_addr"`       // 控制API的TCP监听地址（可选，如127.0.0.1:7899）
+	ControlToken     string        `json:"-"`                  // 控制API TCP监听的令牌，监听非本机地址时必须设置（不写入状态文件）
+	BlacklistFile    string        `json:"blacklist_file"`     // 节点黑名单文件
+	SwitchThreshold  float64       `json:"switch_threshold"`   // 切换所需的最小分数提升比例（如0.2），0使用默认值
+	MinDwell         time.Duration `json:"min_dwell"`          // 切换后在节点上的最短停留时长，0使用默认值
+	TestSchedule     string        `json:"test_schedule"`      // 完整测试的调度表达式（如 0 3 * * *），为空时按更新间隔执行
+	HealthSchedule   string        `json:"health_schedule"`    // 当前节点健康检查的调度表达式，为空时不检查
+	QuietHours       string        `json:"quiet_hours"`        // 静默时段（如 23:00-07:00），期间不自动切换节点
 }
 
 // ValidNode 有效节点信息
@@ -27,6 +37,12 @@ type ValidNode struct {
 	SuccessCount int       `json:"success_count"`
 	FailCount    int       `json:"fail_count"`
 	Score        float64   `json:"score"` // 综合评分
+
+	Preflight *PreflightResult `json:"preflight,omitempty"` // 预检结果
+	Hops      []HopResult      `json:"hops,omitempty"`      // 代理链节点每一跳的状态
+
+	// HardFailure 测试失败已确认是节点的问题（代理返回错误或协议错误），超时和本机启动失败不算
+	HardFailure bool `json:"-"`
 }
 
 // AutoProxyState 自动代理状态
@@ -35,11 +51,14 @@ type AutoProxyState struct {
 	StartTime       time.Time       `json:"start_time"`
 	LastUpdate      time.Time       `json:"last_update"`
 	CurrentNode     *Node           `json:"current_node"`
+	CurrentCore     string          `json:"current_core,omitempty"` // 当前节点使用的核心
 	ValidNodes      []ValidNode     `json:"valid_nodes"`
 	TotalTests      int             `json:"total_tests"`
 	SuccessfulTests int             `json:"successful_tests"`
 	LastError       string          `json:"last_error,omitempty"`
 	Config          AutoProxyConfig `json:"config"`
+	AutoSwitch      bool            `json:"auto_switch"`           // 自动切换是否启用（可通过控制API暂停）
+	PinnedNode      *Node           `json:"pinned_node,omitempty"` // 手动固定的节点
 }
 
 // TestTask 测试任务
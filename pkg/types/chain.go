@@ -0,0 +1,19 @@
+package types
+
+import "time"
+
+// 代理链中一跳的角色
+const (
+	HopFront = "front" // 前置节点（中转）
+	HopExit  = "exit"  // 出口节点
+)
+
+// HopResult 代理链中一跳的测试结果
+type HopResult struct {
+	Role     string    `json:"role"`
+	Name     string    `json:"name"`
+	Success  bool      `json:"success"`
+	Latency  int64     `json:"latency_ms,omitempty"` // 出口一跳为完整路径与前置节点延迟之差
+	Error    string    `json:"error,omitempty"`
+	TestTime time.Time `json:"test_time"`
+}
@@ -1,5 +1,12 @@
 package types
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+)
+
 // Node 表示一个V2Ray节点
 type Node struct {
 	Name       string            `json:"name"`
@@ -12,6 +19,24 @@ type Node struct {
 	Parameters map[string]string `json:"parameters"`
 }
 
+// Fingerprint 节点指纹：由协议、地址、凭据和参数计算，不包含节点名称，
+// 订阅改名或调整顺序后同一节点的指纹保持不变
+func (n *Node) Fingerprint() string {
+	keys := make([]string, 0, len(n.Parameters))
+	for key := range n.Parameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := []string{n.Protocol, strings.ToLower(n.Server), n.Port, n.UUID, n.Method, n.Password}
+	for _, key := range keys {
+		parts = append(parts, key+"="+n.Parameters[key])
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return hex.EncodeToString(sum[:8])
+}
+
 // NodeList 节点列表类型
 type NodeList []*Node
 
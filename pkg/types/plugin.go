@@ -0,0 +1,143 @@
+package types
+
+import "strings"
+
+// Shadowsocks 插件名（同一插件的别名统一为以下名称）
+const (
+	PluginObfs        = "obfs-local"   // simple-obfs，别名 simple-obfs、obfs
+	PluginV2Ray       = "v2ray-plugin" // 别名 xray-plugin
+	PluginShadowTLS   = "shadow-tls"
+	pluginOptionsFlag = "true" // 只有名称、没有值的选项（如 v2ray-plugin 的 tls）
+)
+
+// SSPlugin SIP002 链接中的插件，如 plugin=obfs-local;obfs=http;obfs-host=example.com
+type SSPlugin struct {
+	Name    string            `json:"name"`
+	Options map[string]string `json:"options,omitempty"`
+	Raw     string            `json:"raw"` // 插件名之后的原始选项字符串
+}
+
+// ParseSSPlugin 解析 SIP003 插件字符串：插件名和选项以分号分隔，选项为 key=value 或单独的 key，
+// 分号、等号和反斜杠可以用反斜杠转义。字符串为空时返回 nil
+func ParseSSPlugin(value string) *SSPlugin {
+	fields := splitPluginOptions(value)
+	if len(fields) == 0 || strings.TrimSpace(fields[0]) == "" {
+		return nil
+	}
+
+	plugin := &SSPlugin{
+		Name:    normalizePluginName(strings.TrimSpace(fields[0])),
+		Options: make(map[string]string),
+	}
+	if i := strings.Index(value, ";"); i >= 0 {
+		plugin.Raw = value[i+1:]
+	}
+	for _, field := range fields[1:] {
+		key, val, found := cutUnescaped(field, '=')
+		key = strings.TrimSpace(unescapePluginOption(key))
+		if key == "" {
+			continue
+		}
+		if found {
+			plugin.Options[key] = unescapePluginOption(val)
+		} else {
+			plugin.Options[key] = pluginOptionsFlag
+		}
+	}
+	return plugin
+}
+
+// SSPlugin 返回 ss 节点的插件，没有插件时返回 nil
+func (n *Node) SSPlugin() *SSPlugin {
+	if n.Protocol != "ss" {
+		return nil
+	}
+	return ParseSSPlugin(n.Parameters["plugin"])
+}
+
+// Option 返回第一个存在的选项值
+func (p *SSPlugin) Option(keys ...string) string {
+	for _, key := range keys {
+		if value, ok := p.Options[key]; ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// Flag 选项是否开启：只写选项名，或值为 1/true
+func (p *SSPlugin) Flag(key string) bool {
+	switch strings.ToLower(p.Options[key]) {
+	case pluginOptionsFlag, "1":
+		return true
+	}
+	return false
+}
+
+// Mode 插件的工作模式：obfs-local 为 http/tls，v2ray-plugin 为 websocket/quic（默认 websocket）
+func (p *SSPlugin) Mode() string {
+	switch p.Name {
+	case PluginObfs:
+		return strings.ToLower(p.Option("obfs", "mode"))
+	case PluginV2Ray:
+		if mode := strings.ToLower(p.Option("mode")); mode != "" {
+			return mode
+		}
+		return "websocket"
+	}
+	return ""
+}
+
+// normalizePluginName 统一插件别名
+func normalizePluginName(name string) string {
+	switch strings.ToLower(name) {
+	case "obfs-local", "simple-obfs", "obfs":
+		return PluginObfs
+	case "v2ray-plugin", "xray-plugin":
+		return PluginV2Ray
+	case "shadow-tls", "shadowtls":
+		return PluginShadowTLS
+	}
+	return name
+}
+
+// splitPluginOptions 按未转义的分号拆分
+func splitPluginOptions(value string) []string {
+	var fields []string
+	for {
+		field, rest, found := cutUnescaped(value, ';')
+		fields = append(fields, field)
+		if !found {
+			return fields
+		}
+		value = rest
+	}
+}
+
+// cutUnescaped 在第一个未转义的 sep 处拆分
+func cutUnescaped(s string, sep byte) (string, string, bool) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			return s[:i], s[i+1:], true
+		}
+	}
+	return s, "", false
+}
+
+// unescapePluginOption 去掉转义用的反斜杠
+func unescapePluginOption(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,43 @@
+package types
+
+import "time"
+
+// 预检结果状态
+const (
+	PreflightOK          = "ok"          // 连接/握手成功
+	PreflightUnconfirmed = "unconfirmed" // UDP无应答，无法确认但不排除
+	PreflightDNSFailed   = "dns_failed"  // 域名解析失败
+	PreflightRefused     = "refused"     // 连接被拒绝
+	PreflightUnreachable = "unreachable" // 网络或主机不可达
+	PreflightTimeout     = "timeout"     // 连接超时，可能只是直连受限，不排除
+	PreflightTLSFailed   = "tls_failed"  // TLS握手失败，可能是SNI或伪装参数不匹配，不排除
+	PreflightError       = "error"       // 其他错误，不排除
+)
+
+// PreflightResult 节点预检结果（直连探测，不经过代理核心）
+type PreflightResult struct {
+	Status    string    `json:"status"`
+	Method    string    `json:"method"` // tcp / tls / quic
+	Address   string    `json:"address"`
+	Latency   int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+	CheckTime time.Time `json:"check_time"`
+}
+
+// Passed 预检是否通过（通过的节点才进入代理测试）。
+// 只淘汰确认不可用的节点，超时、TLS握手失败等无法确认的结果交给代理核心测试判断
+func (p *PreflightResult) Passed() bool {
+	return p != nil && !p.HardFailure()
+}
+
+// HardFailure 预检是否确认节点不可用（DNS失败、拒绝连接、不可达），只有这类失败计入黑名单
+func (p *PreflightResult) HardFailure() bool {
+	if p == nil {
+		return false
+	}
+	switch p.Status {
+	case PreflightDNSFailed, PreflightRefused, PreflightUnreachable:
+		return true
+	}
+	return false
+}
@@ -1,3 +1,18 @@
+// 订阅过滤规则语法说明（与 internal/core/filter.Syntax 保持一致）
+const FILTER_RULES_SYNTAX = String.raw`# 每行一条规则，# 开头的行为注释
+# 只保留匹配的节点（多条include为"或"关系）
+include name ~ 香港|HK
+# 排除匹配的节点
+exclude name ~ 剩余流量|官网|到期
+# 字段: name/server/protocol/port/param.<参数名>，!~ 表示不匹配
+exclude param.type !~ ^(ws|tcp)$
+# 用正则重命名，模板支持 $1 分组和 {protocol}/{server}/{port}
+rename ^\[(.+?)\]\s*(.+)$ => $2 ($1)
+# 根据名称识别地区并添加国旗
+flag
+# 按顺序编号，模板可省略（默认 {name} {index}）
+number {name} {index}`;
+
 // V2Ray UI 应用程序
 class V2RayUI {
     constructor() {
@@ -96,6 +111,10 @@ class V2RayUI {
             this.deleteSelectedNodes();
         });
 
+        document.getElementById('refreshBatchTestRuns')?.addEventListener('click', () => {
+            this.loadBatchTestRuns();
+        });
+
         // 代理控制
         document.getElementById('startV2ray')?.addEventListener('click', () => {
             this.toggleProxy('v2ray', 'start');
@@ -261,6 +280,7 @@ class V2RayUI {
                     }
                 }
                 this.renderNodes();
+                this.loadBatchTestRuns();
                 break;
             case 'proxy':
                 this.loadProxyStatus();
@@ -468,6 +488,7 @@ class V2RayUI {
                 </div>
                 <div class="subscription-actions">
                     <button class="btn btn-info btn-sm" onclick="event.stopPropagation(); app.parseSubscription('${sub.id}')">解析</button>
+                    <button class="btn btn-secondary btn-sm" onclick="event.stopPropagation(); app.editFilterRules('${sub.id}')">过滤规则${sub.filter_rules ? ' ✓' : ''}</button>
                     <button class="btn btn-danger btn-sm" onclick="event.stopPropagation(); app.deleteSubscription('${sub.id}')">删除</button>
                 </div>
             </div>
@@ -548,6 +569,63 @@ class V2RayUI {
         }
     }
 
+    // 编辑订阅过滤规则
+    editFilterRules(subscriptionId) {
+        const sub = this.subscriptions.find(s => s.id === subscriptionId);
+        if (!sub) return;
+
+        const filterModal = document.createElement('div');
+        filterModal.className = 'modal active';
+        filterModal.innerHTML = `
+            <div class="modal-content" style="max-width: 700px;">
+                <div class="modal-header">
+                    <h3>过滤规则 - ${this.escapeHtml(sub.name)}</h3>
+                    <button class="close-btn" onclick="this.closest('.modal').remove()">&times;</button>
+                </div>
+                <div class="modal-body">
+                    <textarea id="filterRulesInput" class="filter-rules-input" spellcheck="false">${this.escapeHtml(sub.filter_rules || '')}</textarea>
+                    <details class="filter-rules-help">
+                        <summary>规则语法</summary>
+                        <pre>${this.escapeHtml(FILTER_RULES_SYNTAX)}</pre>
+                    </details>
+                </div>
+                <div class="modal-footer">
+                    <button onclick="this.closest('.modal').remove()">取消</button>
+                    <button id="saveFilterRules">保存</button>
+                </div>
+            </div>
+        `;
+        document.body.appendChild(filterModal);
+
+        filterModal.querySelector('#saveFilterRules').addEventListener('click', async () => {
+            const rules = filterModal.querySelector('#filterRulesInput').value;
+            try {
+                const response = await fetch('/api/subscriptions/filter', {
+                    method: 'POST',
+                    headers: {
+                        'Content-Type': 'application/json',
+                    },
+                    body: JSON.stringify({
+                        id: subscriptionId,
+                        rules: rules
+                    })
+                });
+
+                const data = await response.json();
+                if (data.success) {
+                    filterModal.remove();
+                    await this.loadSubscriptions();
+                    this.showNotification('过滤规则已保存，重新解析订阅后生效', 'success');
+                } else {
+                    this.showNotification(`保存过滤规则失败: ${data.error || data.message}`, 'error');
+                }
+            } catch (error) {
+                console.error('保存过滤规则失败:', error);
+                this.showNotification('保存过滤规则失败', 'error');
+            }
+        });
+    }
+
     // 测试订阅（已移除，因为没有实际用途）
 
     // 渲染节点列表
@@ -627,6 +705,22 @@ class V2RayUI {
         return ports.length > 0 ? `<span class="ports">${ports.join(' | ')}</span>` : '';
     }
 
+    // 渲染代理链节点每一跳的状态，如 "前置 中转 ✓35ms → 出口 US-1 ✗"
+    renderHops(hops) {
+        if (!hops || hops.length === 0) {
+            return '';
+        }
+        const parts = hops.map(hop => {
+            const role = hop.role === 'front' ? '前置' : '出口';
+            const status = hop.success ?
+                `✓${hop.latency_ms ? hop.latency_ms + 'ms' : ''}` :
+                `✗`;
+            const title = hop.error ? ` title="${this.escapeHtml(hop.error)}"` : '';
+            return `<span class="hop ${hop.success ? 'success' : 'error'}"${title}>${role} ${this.escapeHtml(hop.name)} ${status}</span>`;
+        });
+        return `<span class="hops">${parts.join(' → ')}</span>`;
+    }
+
     // 渲染测试结果
     renderTestResults(node) {
         let html = '';
@@ -643,6 +737,7 @@ class V2RayUI {
                         `<span class="latency">${result.latency}</span>` : 
                         `<span class="error">${result.error || '测试失败'}</span>`
                     }
+                    ${this.renderHops(result.hops)}
                     <span class="test-time">${testTime}</span>
                 </div>
             `;
@@ -1153,9 +1248,68 @@ class V2RayUI {
         setTimeout(async () => {
             await this.loadSubscriptions();
             this.renderNodes();
+            this.loadBatchTestRuns();
         }, 1000);
     }
 
+    // 加载批量测试记录（可下载报告）
+    async loadBatchTestRuns() {
+        const container = document.getElementById('batchTestRunList');
+        if (!container) return;
+
+        try {
+            const response = await fetch('/api/nodes/batch-test-runs?limit=20');
+            const data = await response.json();
+            if (!data.success) {
+                container.innerHTML = '<div class="placeholder">加载批量测试记录失败</div>';
+                return;
+            }
+
+            const runs = data.data || [];
+            if (runs.length === 0) {
+                container.innerHTML = '<div class="placeholder">暂无批量测试记录</div>';
+                return;
+            }
+
+            const formats = [
+                { key: 'html', label: 'HTML' },
+                { key: 'csv', label: 'CSV' },
+                { key: 'junit', label: 'JUnit' },
+                { key: 'markdown', label: 'Markdown' },
+                { key: 'json', label: 'JSON' }
+            ];
+
+            container.innerHTML = runs.map(run => {
+                const startTime = run.start_time ? new Date(run.start_time).toLocaleString() : '';
+                const status = run.status === 'cancelled' ? '已取消' : '已完成';
+                const links = formats.map(format =>
+                    `<a class="btn btn-secondary btn-sm" href="/api/nodes/batch-test-report?id=${encodeURIComponent(run.id)}&format=${format.key}">${format.label}</a>`
+                ).join(' ');
+                return `
+                    <div class="batch-test-run-item">
+                        <div class="batch-test-run-info">
+                            <strong>${this.escapeHtml(run.subscription_name || run.subscription_id)}</strong>
+                            <span>${startTime}</span>
+                            <span>${status}</span>
+                            <span>总数 ${run.total_count}，成功 ${run.success_count}，失败 ${run.failure_count}</span>
+                        </div>
+                        <div class="batch-test-run-actions">${links}</div>
+                    </div>
+                `;
+            }).join('');
+        } catch (error) {
+            console.error('加载批量测试记录失败:', error);
+            container.innerHTML = '<div class="placeholder">加载批量测试记录失败</div>';
+        }
+    }
+
+    // 转义HTML特殊字符
+    escapeHtml(text) {
+        const div = document.createElement('div');
+        div.textContent = text == null ? '' : String(text);
+        return div.innerHTML;
+    }
+
     // 取消批量测试
     async cancelBatchTest() {
         try {
@@ -1579,9 +1733,14 @@ class V2RayUI {
             
             // 订阅设置
             update_interval: parseInt(document.getElementById('updateIntervalSetting')?.value || 24),
+            update_schedule: (document.getElementById('updateScheduleSetting')?.value || '').trim(),
             user_agent: document.getElementById('userAgentSetting')?.value || 'V2Ray/1.0',
             auto_test_nodes: document.getElementById('autoTestNewNodesSetting')?.checked || true,
             
+            // 代理链设置
+            front_node: (document.getElementById('frontNodeSetting')?.value || '').trim(),
+            chain_nodes: document.getElementById('chainNodesSetting')?.value || '',
+            
             // 安全设置
             enable_logs: document.getElementById('enableLogsSetting')?.checked || true,
             log_level: document.getElementById('logLevelSetting')?.value || 'info',
@@ -1639,6 +1798,8 @@ class V2RayUI {
             const updateInterval = settings.update_interval || settings.updateInterval;
             document.getElementById('updateIntervalSetting').value = updateInterval;
         }
+        const updateSchedule = settings.update_schedule || settings.updateSchedule || '';
+        document.getElementById('updateScheduleSetting').value = updateSchedule;
         if (settings.user_agent || settings.userAgent) {
             const userAgent = settings.user_agent || settings.userAgent;
             document.getElementById('userAgentSetting').value = userAgent;
@@ -1646,6 +1807,10 @@ class V2RayUI {
         const autoTestNewNodes = 'auto_test_nodes' in settings ? settings.auto_test_nodes : ('autoTestNewNodes' in settings ? settings.autoTestNewNodes : true);
         document.getElementById('autoTestNewNodesSetting').checked = autoTestNewNodes;
         
+        // 代理链设置
+        document.getElementById('frontNodeSetting').value = settings.front_node || '';
+        document.getElementById('chainNodesSetting').value = settings.chain_nodes || '';
+        
         // 安全设置
         const enableLogs = 'enable_logs' in settings ? settings.enable_logs : ('enableLogs' in settings ? settings.enableLogs : true);
         document.getElementById('enableLogsSetting').checked = enableLogs;
@@ -1673,8 +1838,11 @@ class V2RayUI {
             max_concurrent: 3,
             retry_count: 2,
             update_interval: 24,
+            update_schedule: '',
             user_agent: 'V2Ray/1.0',
             auto_test_nodes: true,
+            front_node: '',
+            chain_nodes: '',
             enable_logs: true,
             log_level: 'info',
             data_retention: 30